@@ -0,0 +1,183 @@
+package addresses
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	big "math/big"
+	"sort"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+)
+
+// an account's balance on one chain
+type Balance struct {
+	Address string
+	Balance string
+}
+
+// the same account on two chains. Either side is nil if the account only exists on the other chain
+type JoinedBalance struct {
+	AccountHex string
+	Left       *Balance
+	Right      *Balance
+}
+
+// parses a comma separated list of bech32 prefixes such as "cosmos,juno"
+func ParsePrefixes(value string) []string {
+	prefixes := make([]string, 0)
+
+	for _, prefix := range strings.Split(value, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
+
+// reads the addresses of an address file, one per line. Only the first comma separated column is read so a csv
+// export can be used, a first line that isn't an address is taken as its header. Blank lines and lines starting with
+// # are skipped, and so are repeated addresses
+func ReadAddresses(reader io.Reader) ([]string, error) {
+	addresses := make([]string, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+
+	for line := 1; scanner.Scan(); line++ {
+		address := strings.TrimSpace(strings.SplitN(scanner.Text(), ",", 2)[0])
+		if address == "" || strings.HasPrefix(address, "#") {
+			continue
+		}
+
+		if _, _, err := bech32.DecodeAndConvert(address); err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid address %s: %w", line, address, err)
+		}
+
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+// re-encodes a bech32 address with another prefix, e.g. osmo1... to cosmos1...
+func Convert(address string, prefix string) (string, error) {
+	_, accountBytes, err := bech32.DecodeAndConvert(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %w", address, err)
+	}
+
+	return bech32.ConvertAndEncode(prefix, accountBytes)
+}
+
+// re-encodes an address with every prefix. Addresses that can't be decoded get empty columns so one bad row doesn't
+// fail a whole export
+func ConvertAll(address string, prefixes []string) []string {
+	converted := make([]string, len(prefixes))
+
+	for i, prefix := range prefixes {
+		if convertedAddress, err := Convert(address, prefix); err == nil {
+			converted[i] = convertedAddress
+		}
+	}
+
+	return converted
+}
+
+// the hex encoded account bytes of a bech32 address, the same for every chain
+func AccountHex(address string) (string, error) {
+	_, accountBytes, err := bech32.DecodeAndConvert(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %w", address, err)
+	}
+
+	return strings.ToUpper(hex.EncodeToString(accountBytes)), nil
+}
+
+// joins balances from two chains on the underlying account bytes. Only accounts on both chains are returned unless
+// outer is set. The balances of an account listed more than once on one side are summed. The result is sorted by
+// account
+func Join(left []Balance, right []Balance, outer bool) ([]JoinedBalance, error) {
+	joined := make(map[string]*JoinedBalance)
+
+	for _, balance := range left {
+		accountHex, err := AccountHex(balance.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		joinedBalance, ok := joined[accountHex]
+		if !ok {
+			joinedBalance = &JoinedBalance{AccountHex: accountHex}
+			joined[accountHex] = joinedBalance
+		}
+
+		if joinedBalance.Left, err = addBalance(joinedBalance.Left, balance); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, balance := range right {
+		accountHex, err := AccountHex(balance.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		joinedBalance, ok := joined[accountHex]
+		if !ok {
+			joinedBalance = &JoinedBalance{AccountHex: accountHex}
+			joined[accountHex] = joinedBalance
+		}
+
+		if joinedBalance.Right, err = addBalance(joinedBalance.Right, balance); err != nil {
+			return nil, err
+		}
+	}
+
+	joinedBalances := make([]JoinedBalance, 0, len(joined))
+	for _, joinedBalance := range joined {
+		if !outer && (joinedBalance.Left == nil || joinedBalance.Right == nil) {
+			continue
+		}
+
+		joinedBalances = append(joinedBalances, *joinedBalance)
+	}
+
+	sort.Slice(joinedBalances, func(i, j int) bool {
+		return joinedBalances[i].AccountHex < joinedBalances[j].AccountHex
+	})
+
+	return joinedBalances, nil
+}
+
+// a copy of balance, or the sum of both for an account seen before under the address it was first seen with
+func addBalance(sum *Balance, balance Balance) (*Balance, error) {
+	if sum == nil {
+		return &balance, nil
+	}
+
+	amount, ok := new(big.Int).SetString(sum.Balance, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid balance %q for %s", sum.Balance, sum.Address)
+	}
+
+	other, ok := new(big.Int).SetString(balance.Balance, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid balance %q for %s", balance.Balance, balance.Address)
+	}
+
+	return &Balance{Address: sum.Address, Balance: amount.Add(amount, other).String()}, nil
+}
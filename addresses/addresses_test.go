@@ -0,0 +1,125 @@
+package addresses
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/stretchr/testify/assert"
+)
+
+func encode(t *testing.T, prefix string, accountBytes []byte) string {
+	address, err := bech32.ConvertAndEncode(prefix, accountBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return address
+}
+
+func TestParsePrefixes(t *testing.T) {
+	assert.Equal(t, []string{"cosmos", "juno"}, ParsePrefixes(" cosmos, ,juno"))
+	assert.Equal(t, []string{}, ParsePrefixes(""))
+}
+
+func TestReadAddresses(t *testing.T) {
+	first := encode(t, "osmo", []byte("01234567890123456789"))
+	second := encode(t, "osmo", []byte("98765432109876543210"))
+
+	addresses, err := ReadAddresses(strings.NewReader("delegator,voting_power\n" + first + ",10\n\n# a comment\n  " +
+		second + "  \n" + first + "\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{first, second}, addresses)
+
+	_, err = ReadAddresses(strings.NewReader(first + "\nnot-an-address\n"))
+	assert.Contains(t, err.Error(), "line 2: invalid address not-an-address")
+}
+
+func TestConvert(t *testing.T) {
+	accountBytes := []byte("01234567890123456789")
+	osmoAddress := encode(t, "osmo", accountBytes)
+
+	cosmosAddress, err := Convert(osmoAddress, "cosmos")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, encode(t, "cosmos", accountBytes), cosmosAddress)
+
+	_, err = Convert("osmo1notanaddress", "cosmos")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{encode(t, "juno", accountBytes), encode(t, "cosmos", accountBytes)},
+		ConvertAll(osmoAddress, []string{"juno", "cosmos"}))
+	assert.Equal(t, []string{""}, ConvertAll("osmo1notanaddress", []string{"juno"}))
+}
+
+func TestJoin(t *testing.T) {
+	shared := []byte("01234567890123456789")
+	leftOnly := []byte("aaaaaaaaaaaaaaaaaaaa")
+	rightOnly := []byte("bbbbbbbbbbbbbbbbbbbb")
+
+	left := []Balance{
+		{Address: encode(t, "osmo", shared), Balance: "10"},
+		{Address: encode(t, "osmo", leftOnly), Balance: "20"},
+	}
+	right := []Balance{
+		{Address: encode(t, "cosmos", shared), Balance: "30"},
+		{Address: encode(t, "cosmos", rightOnly), Balance: "40"},
+	}
+
+	joined, err := Join(left, right, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(joined))
+	assert.Equal(t, "3031323334353637383930313233343536373839", joined[0].AccountHex)
+	assert.Equal(t, "10", joined[0].Left.Balance)
+	assert.Equal(t, "30", joined[0].Right.Balance)
+
+	joined, err = Join(left, right, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, len(joined))
+	assert.Nil(t, joined[1].Right)
+	assert.Equal(t, "20", joined[1].Left.Balance)
+	assert.Nil(t, joined[2].Left)
+	assert.Equal(t, "40", joined[2].Right.Balance)
+
+	_, err = Join([]Balance{{Address: "bad"}}, right, false)
+	assert.Error(t, err)
+}
+
+func TestJoinDuplicates(t *testing.T) {
+	shared := []byte("01234567890123456789")
+
+	// the same account twice on the left, under two prefixes, and twice on the right
+	left := []Balance{
+		{Address: encode(t, "osmo", shared), Balance: "10"},
+		{Address: encode(t, "cosmos", shared), Balance: "5"},
+	}
+	right := []Balance{
+		{Address: encode(t, "juno", shared), Balance: "30"},
+		{Address: encode(t, "juno", shared), Balance: "1"},
+	}
+
+	joined, err := Join(left, right, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(joined))
+	assert.Equal(t, encode(t, "osmo", shared), joined[0].Left.Address)
+	assert.Equal(t, "15", joined[0].Left.Balance)
+	assert.Equal(t, "31", joined[0].Right.Balance)
+
+	// the inputs aren't changed
+	assert.Equal(t, "10", left[0].Balance)
+	assert.Equal(t, "30", right[0].Balance)
+
+	_, err = Join(left, append(right, Balance{Address: encode(t, "juno", shared), Balance: "ten"}), false)
+	assert.ErrorContains(t, err, "invalid balance")
+}
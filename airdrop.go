@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+
+	airdropModule "github.com/brianosaurus/challenge1/airdrop"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+)
+
+// writes the airdrop allocations, largest first, to a csv file
+func WriteAirdropAllocations(allocations []airdropModule.Allocation, writer *csv.Writer) error {
+	if err := writer.Write([]string{"address", "stake", "eligible_stake", "weighted_stake", "weight", "allocation", "capped"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, allocation := range allocations {
+		if err := writer.Write([]string{
+			allocation.Address,
+			allocation.Stake.String(),
+			allocation.EligibleStake.String(),
+			allocation.WeightedStake.String(),
+			allocation.Weight.String(),
+			allocation.Amount.String(),
+			fmt.Sprint(allocation.Capped),
+		}); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// writes the airdrop totals as metric,value rows to a csv file
+func WriteAirdropSummary(summary *airdropModule.Summary, writer *csv.Writer) error {
+	rows := [][]string{
+		{"metric", "value"},
+		{"height", fmt.Sprint(summary.Height)},
+		{"delegators", fmt.Sprint(summary.Delegators)},
+		{"eligible_delegators", fmt.Sprint(summary.EligibleDelegators)},
+		{"below_min_stake", fmt.Sprint(summary.BelowMinStake)},
+		{"capped_delegators", fmt.Sprint(summary.CappedDelegators)},
+		{"total_stake", summary.TotalStake.String()},
+		{"eligible_stake", summary.EligibleStake.String()},
+		{"total_allocation", summary.TotalAllocation.String()},
+		{"allocated", summary.Allocated.String()},
+	}
+
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// the airdrop subcommand builds an allocation list from the delegations at a pinned height
+func airdropCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var connection connectionOptions
+	var rulesFile string
+	var allocationsOutputFile string
+	var summaryOutputFile string
+
+	addConnectionFlags(flags, &connection)
+	flags.StringVar(&rulesFile, "rules", "airdrop.json", "the json rule file for the airdrop")
+	flags.StringVar(&allocationsOutputFile, "allocationFile", "airdrop.csv", "the output file for the allocations csv")
+	flags.StringVar(&summaryOutputFile, "summaryFile", "airdropSummary.csv", "the output file for the summary csv")
+
+	return func(ctx context.Context) error {
+		if connection.height <= 0 {
+			return failuresModule.Errorf(failuresModule.Config, "airdrop: -height is required so the snapshot can be reproduced")
+		}
+
+		rules, err := airdropModule.LoadRules(rulesFile)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.Config, err)
+		}
+
+		ctx, err = connection.withSource(ctx)
+		if err != nil {
+			return err
+		}
+
+		snapshot, err := snapshotModule.Fetch(ctx, snapshotModule.Options{
+			Node:   connection.node,
+			Height: connection.height,
+		})
+		if err != nil {
+			return err
+		}
+
+		allocations, summary, err := airdropModule.GetAllocations(rules, snapshot.Validators, snapshot.Delegations)
+		if err != nil {
+			return err
+		}
+		summary.Height = connection.height
+
+		logger := loggingModule.FromContext(ctx)
+		logger.Info("writing airdrop allocations", "file", allocationsOutputFile, "rows", len(allocations))
+
+		allocationsFile, err := os.OpenFile(allocationsOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer allocationsFile.Close()
+		if err := WriteAirdropAllocations(allocations, csv.NewWriter(allocationsFile)); err != nil {
+			return err
+		}
+
+		logger.Info("writing airdrop summary", "file", summaryOutputFile)
+
+		summaryFile, err := os.OpenFile(summaryOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer summaryFile.Close()
+
+		return WriteAirdropSummary(summary, csv.NewWriter(summaryFile))
+	}
+}
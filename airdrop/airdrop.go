@@ -0,0 +1,314 @@
+package airdrop
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// how an eligible stake is turned into an allocation weight
+const (
+	WeightingLinear    = "linear"
+	WeightingSqrt      = "sqrt"
+	WeightingQuadratic = "quadratic"
+)
+
+// stake delegated outside of the TopN validators by voting power counts Multiplier times
+type Bonus struct {
+	TopN       int     `json:"top_n"`
+	Multiplier sdk.Dec `json:"multiplier"`
+}
+
+// the rules for an airdrop. Amounts are in the base denom, e.g. uosmo for stake
+type Rules struct {
+	// the amount distributed between all eligible delegators
+	TotalAllocation sdk.Int `json:"total_allocation"`
+
+	// delegators with less eligible stake than this get nothing
+	MinStake sdk.Int `json:"min_stake"`
+
+	// stake delegated to these validators is ignored
+	ExcludedValidators []string `json:"excluded_validators"`
+
+	// the most any single address can receive. Anything above the cap is shared between the other addresses
+	MaxAllocation sdk.Int `json:"max_allocation"`
+
+	// per address caps, overriding MaxAllocation
+	AddressCaps map[string]sdk.Int `json:"address_caps"`
+
+	// linear, sqrt or quadratic. Defaults to linear
+	Weighting string `json:"weighting"`
+
+	Bonus *Bonus `json:"bonus"`
+}
+
+// the allocation for one delegator
+type Allocation struct {
+	Address       string  `json:"address"`
+	Stake         sdk.Int `json:"stake"`
+	EligibleStake sdk.Int `json:"eligible_stake"`
+	WeightedStake sdk.Dec `json:"weighted_stake"`
+	Weight        sdk.Dec `json:"weight"`
+	Amount        sdk.Int `json:"amount"`
+	Capped        bool    `json:"capped"`
+}
+
+// totals for an airdrop
+type Summary struct {
+	Height             int64   `json:"height"`
+	Delegators         int     `json:"delegators"`
+	EligibleDelegators int     `json:"eligible_delegators"`
+	BelowMinStake      int     `json:"below_min_stake"`
+	CappedDelegators   int     `json:"capped_delegators"`
+	TotalStake         sdk.Int `json:"total_stake"`
+	EligibleStake      sdk.Int `json:"eligible_stake"`
+	TotalAllocation    sdk.Int `json:"total_allocation"`
+	Allocated          sdk.Int `json:"allocated"`
+}
+
+// reads and validates a json rule file
+func LoadRules(path string) (*Rules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := Rules{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("invalid rule file %s: %w", path, err)
+	}
+
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rule file %s: %w", path, err)
+	}
+
+	return &rules, nil
+}
+
+// checks the rules and fills in defaults
+func (rules *Rules) Validate() error {
+	if rules.TotalAllocation.IsNil() || !rules.TotalAllocation.IsPositive() {
+		return fmt.Errorf("total_allocation must be positive")
+	}
+
+	if rules.MinStake.IsNil() {
+		rules.MinStake = sdk.ZeroInt()
+	}
+
+	if rules.MinStake.IsNegative() {
+		return fmt.Errorf("min_stake can not be negative")
+	}
+
+	if !rules.MaxAllocation.IsNil() && !rules.MaxAllocation.IsPositive() {
+		return fmt.Errorf("max_allocation must be positive")
+	}
+
+	for address, addressCap := range rules.AddressCaps {
+		if addressCap.IsNil() || addressCap.IsNegative() {
+			return fmt.Errorf("cap for %s can not be negative", address)
+		}
+	}
+
+	switch rules.Weighting {
+	case "":
+		rules.Weighting = WeightingLinear
+	case WeightingLinear, WeightingSqrt, WeightingQuadratic:
+	default:
+		return fmt.Errorf("unknown weighting %q, expected %s, %s or %s",
+			rules.Weighting, WeightingLinear, WeightingSqrt, WeightingQuadratic)
+	}
+
+	if rules.Bonus != nil {
+		if rules.Bonus.TopN < 0 {
+			return fmt.Errorf("bonus top_n can not be negative")
+		}
+
+		if rules.Bonus.Multiplier.IsNil() || rules.Bonus.Multiplier.IsNegative() {
+			return fmt.Errorf("bonus multiplier must be set and can not be negative")
+		}
+	}
+
+	return nil
+}
+
+// the cap for an address, or a nil Int if it is uncapped
+func (rules *Rules) capFor(address string) sdk.Int {
+	if addressCap, ok := rules.AddressCaps[address]; ok {
+		return addressCap
+	}
+
+	return rules.MaxAllocation
+}
+
+// computes every delegator's allocation, largest first
+func GetAllocations(rules *Rules, validators *validatorTypes.Validators,
+	delegationsMap *delegationsModule.DelegationsWithTotalBalance,
+) ([]Allocation, *Summary, error) {
+	excluded := make(map[string]bool)
+	for _, validator := range rules.ExcludedValidators {
+		excluded[validator] = true
+	}
+
+	topValidators := getTopValidators(rules, validators)
+
+	summary := &Summary{
+		TotalStake:      sdk.ZeroInt(),
+		EligibleStake:   sdk.ZeroInt(),
+		TotalAllocation: rules.TotalAllocation,
+		Allocated:       sdk.ZeroInt(),
+	}
+
+	allocations := make([]Allocation, 0, len(*delegationsMap))
+
+	for address, delegationWithTotalBalance := range *delegationsMap {
+		allocation := Allocation{
+			Address:       address,
+			Stake:         sdk.ZeroInt(),
+			EligibleStake: sdk.ZeroInt(),
+			WeightedStake: sdk.ZeroDec(),
+			Weight:        sdk.ZeroDec(),
+			Amount:        sdk.ZeroInt(),
+		}
+
+		for _, delegationResponse := range delegationWithTotalBalance.DelegationResponses {
+			amount := delegationResponse.Balance.Amount
+			allocation.Stake = allocation.Stake.Add(amount)
+
+			validatorAddress := delegationResponse.Delegation.ValidatorAddress
+			if excluded[validatorAddress] {
+				continue
+			}
+
+			allocation.EligibleStake = allocation.EligibleStake.Add(amount)
+
+			if rules.Bonus != nil && !topValidators[validatorAddress] {
+				allocation.WeightedStake = allocation.WeightedStake.Add(sdk.NewDecFromInt(amount).Mul(rules.Bonus.Multiplier))
+			} else {
+				allocation.WeightedStake = allocation.WeightedStake.Add(sdk.NewDecFromInt(amount))
+			}
+		}
+
+		summary.Delegators++
+		summary.TotalStake = summary.TotalStake.Add(allocation.Stake)
+
+		if allocation.EligibleStake.IsZero() || allocation.EligibleStake.LT(rules.MinStake) {
+			summary.BelowMinStake++
+			continue
+		}
+
+		weight, err := weigh(rules.Weighting, allocation.WeightedStake)
+		if err != nil {
+			return nil, nil, fmt.Errorf("weighing %s: %w", address, err)
+		}
+		allocation.Weight = weight
+
+		summary.EligibleDelegators++
+		summary.EligibleStake = summary.EligibleStake.Add(allocation.EligibleStake)
+		allocations = append(allocations, allocation)
+	}
+
+	allocate(rules, allocations)
+
+	for _, allocation := range allocations {
+		summary.Allocated = summary.Allocated.Add(allocation.Amount)
+		if allocation.Capped {
+			summary.CappedDelegators++
+		}
+	}
+
+	sort.Slice(allocations, func(i, j int) bool {
+		if !allocations[i].Amount.Equal(allocations[j].Amount) {
+			return allocations[i].Amount.GT(allocations[j].Amount)
+		}
+		return allocations[i].Address < allocations[j].Address
+	})
+
+	return allocations, summary, nil
+}
+
+// the operator addresses of the bonus TopN validators by voting power
+func getTopValidators(rules *Rules, validators *validatorTypes.Validators) map[string]bool {
+	topValidators := make(map[string]bool)
+	if rules.Bonus == nil {
+		return topValidators
+	}
+
+	bonded := make(validatorTypes.Validators, 0, len(*validators))
+	for _, validator := range *validators {
+		if validator.Status == validatorTypes.Bonded {
+			bonded = append(bonded, validator)
+		}
+	}
+
+	sort.SliceStable(bonded, func(i, j int) bool {
+		return validatorTypes.ValidatorsByVotingPower(bonded).Less(i, j, sdk.DefaultPowerReduction)
+	})
+
+	for i := 0; i < len(bonded) && i < rules.Bonus.TopN; i++ {
+		topValidators[bonded[i].OperatorAddress] = true
+	}
+
+	return topValidators
+}
+
+func weigh(weighting string, stake sdk.Dec) (sdk.Dec, error) {
+	switch weighting {
+	case WeightingSqrt:
+		return stake.ApproxSqrt()
+	case WeightingQuadratic:
+		return stake.Mul(stake), nil
+	default:
+		return stake, nil
+	}
+}
+
+// shares the total allocation by weight. Addresses reaching their cap are fixed at it and the rest is shared again
+// between the uncapped addresses until no address is above its cap. Amounts are rounded down
+func allocate(rules *Rules, allocations []Allocation) {
+	remaining := rules.TotalAllocation
+
+	for {
+		totalWeight := sdk.ZeroDec()
+		for _, allocation := range allocations {
+			if !allocation.Capped {
+				totalWeight = totalWeight.Add(allocation.Weight)
+			}
+		}
+
+		if totalWeight.IsZero() {
+			return
+		}
+
+		newlyCapped := false
+		for i := range allocations {
+			allocation := &allocations[i]
+			if allocation.Capped {
+				continue
+			}
+
+			allocation.Amount = sdk.NewDecFromInt(remaining).Mul(allocation.Weight).Quo(totalWeight).TruncateInt()
+
+			addressCap := rules.capFor(allocation.Address)
+			if !addressCap.IsNil() && allocation.Amount.GTE(addressCap) {
+				allocation.Amount = addressCap
+				allocation.Capped = true
+				remaining = remaining.Sub(addressCap)
+				newlyCapped = true
+			}
+		}
+
+		if !newlyCapped {
+			return
+		}
+	}
+}
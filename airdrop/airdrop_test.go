@@ -0,0 +1,202 @@
+package airdrop
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	DELEGATION_RESPONSES =
+`[
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+			"shares":"100.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"100"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
+			"shares":"300.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"300"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69m",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+			"shares":"5.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"5"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69n",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyc",
+			"shares":"1000.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"1000"
+		}
+	}
+]`
+
+	VALIDATORS =
+`[
+	{
+		"operator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+		"status":3,
+		"tokens":"5000000000",
+		"delegator_shares":"5000000000.000000000000000000",
+		"description":{"moniker":"Big"},
+		"min_self_delegation":"1"
+	},
+	{
+		"operator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
+		"status":3,
+		"tokens":"1000000000",
+		"delegator_shares":"1000000000.000000000000000000",
+		"description":{"moniker":"Small"},
+		"min_self_delegation":"1"
+	},
+	{
+		"operator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyc",
+		"status":3,
+		"tokens":"9000000000",
+		"delegator_shares":"9000000000.000000000000000000",
+		"description":{"moniker":"Excluded"},
+		"min_self_delegation":"1"
+	}
+]`
+	)
+
+func getFixtures(t *testing.T) (*delegationTypes.Validators, *delegationsModule.DelegationsWithTotalBalance) {
+	var validators delegationTypes.Validators
+	if err := json.Unmarshal([]byte(VALIDATORS), &validators); err != nil {
+		t.Fatal(err)
+	}
+
+	var delegationResponses delegationTypes.DelegationResponses
+	if err := json.Unmarshal([]byte(DELEGATION_RESPONSES), &delegationResponses); err != nil {
+		t.Fatal(err)
+	}
+
+	return &validators, delegationsModule.GetDelegationsWithTotalBalance(&delegationResponses)
+}
+
+func TestLoadRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rulesFile, []byte(`{
+		"total_allocation": "1000",
+		"min_stake": "10",
+		"weighting": "sqrt",
+		"bonus": {"top_n": 1, "multiplier": "1.5"}
+	}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := LoadRules(rulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "1000", rules.TotalAllocation.String())
+	assert.Equal(t, WeightingSqrt, rules.Weighting)
+	assert.Equal(t, 1, rules.Bonus.TopN)
+	assert.Equal(t, sdk.MustNewDecFromStr("1.5"), rules.Bonus.Multiplier)
+	assert.True(t, rules.MaxAllocation.IsNil())
+
+	err = os.WriteFile(rulesFile, []byte(`{"total_allocation": "1000", "weighting": "cubic"}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadRules(rulesFile)
+	assert.Error(t, err)
+}
+
+func TestGetAllocations(t *testing.T) {
+	validators, delegationsMap := getFixtures(t)
+
+	rules := &Rules{
+		TotalAllocation:    sdk.NewInt(1000),
+		MinStake:           sdk.NewInt(10),
+		ExcludedValidators: []string{"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyc"},
+		Bonus:              &Bonus{TopN: 2, Multiplier: sdk.NewDec(3)},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	allocations, summary, err := GetAllocations(rules, validators, delegationsMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...69l delegates 300 outside the top two validators so it weighs 900 against 100 for ...69a
+	assert.Equal(t, 2, len(allocations))
+	assert.Equal(t, "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l", allocations[0].Address)
+	assert.Equal(t, "900", allocations[0].Amount.String())
+	assert.Equal(t, "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a", allocations[1].Address)
+	assert.Equal(t, "100", allocations[1].Amount.String())
+
+	assert.Equal(t, 4, summary.Delegators)
+	assert.Equal(t, 2, summary.EligibleDelegators)
+	assert.Equal(t, 2, summary.BelowMinStake)
+	assert.Equal(t, "1405", summary.TotalStake.String())
+	assert.Equal(t, "400", summary.EligibleStake.String())
+	assert.Equal(t, "1000", summary.Allocated.String())
+}
+
+func TestGetAllocationsWithCaps(t *testing.T) {
+	validators, delegationsMap := getFixtures(t)
+
+	rules := &Rules{
+		TotalAllocation: sdk.NewInt(1000),
+		MaxAllocation:   sdk.NewInt(500),
+		AddressCaps:     map[string]sdk.Int{"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69m": sdk.NewInt(0)},
+		Weighting:       WeightingQuadratic,
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	allocations, summary, err := GetAllocations(rules, validators, delegationsMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amounts := make(map[string]string)
+	for _, allocation := range allocations {
+		amounts[allocation.Address] = allocation.Amount.String()
+	}
+
+	// the whale is capped at 500 and the rest is shared by weight between the others
+	assert.Equal(t, "500", amounts["osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69n"])
+	assert.Equal(t, "450", amounts["osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"])
+	assert.Equal(t, "50", amounts["osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a"])
+	assert.Equal(t, "0", amounts["osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69m"])
+	assert.Equal(t, 2, summary.CappedDelegators)
+}
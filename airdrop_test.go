@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+func TestAirdropCommand(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(dir, "airdrop.json")
+	os.WriteFile(rulesFile, []byte(`{"total_allocation": "1000", "min_stake": "30"}`), 0o644)
+
+	args := func(dir string) []string {
+		return []string{"-height", "7000000", "-rules", rulesFile,
+			"-allocationFile", filepath.Join(dir, "airdrop.csv"),
+			"-summaryFile", filepath.Join(dir, "airdropSummary.csv")}
+	}
+
+	fixture, err := live(t, context.Background(), &stubSource{}, "airdrop", args(dir)...)
+	assert.Nil(t, err)
+
+	replayDir := t.TempDir()
+	assert.Nil(t, replay(fixture, "airdrop", args(replayDir)...))
+
+	for _, file := range []string{"airdrop.csv", "airdropSummary.csv"} {
+		assert.Equal(t, readFile(t, filepath.Join(dir, file)), readFile(t, filepath.Join(replayDir, file)), file)
+	}
+
+	// the second delegator is below the minimum stake
+	assert.Equal(t, `address,stake,eligible_stake,weighted_stake,weight,allocation,capped
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,40,40,40.000000000000000000,40.000000000000000000,1000,false
+`, readFile(t, filepath.Join(replayDir, "airdrop.csv")))
+
+	assert.Equal(t, `metric,value
+height,7000000
+delegators,2
+eligible_delegators,1
+below_min_stake,1
+capped_delegators,0
+total_stake,60
+eligible_stake,40
+total_allocation,1000
+allocated,1000
+`, readFile(t, filepath.Join(replayDir, "airdropSummary.csv")))
+
+	// an airdrop has to be reproducible
+	err = replay(fixture, "airdrop", "-rules", rulesFile)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
@@ -0,0 +1,463 @@
+// Package api serves the latest snapshot of a chain over http. The snapshot is refreshed periodically and every
+// response carries an ETag derived from its block height so clients can poll cheaply
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	big "math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	metricsModule "github.com/brianosaurus/challenge1/metrics"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+	statsModule "github.com/brianosaurus/challenge1/stats"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the page size of the delegators of a validator when none is asked for, and the largest one allowed
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// a validator as listed by /validators
+type Validator struct {
+	OperatorAddress   string `json:"operator_address"`
+	Moniker           string `json:"moniker"`
+	Status            string `json:"status"`
+	Jailed            bool   `json:"jailed"`
+	VotingPower       int64  `json:"voting_power"`
+	Tokens            string `json:"tokens"`
+	DelegatorShares   string `json:"delegator_shares"`
+	MinSelfDelegation string `json:"min_self_delegation"`
+}
+
+// a delegation of a delegator to one validator
+type Delegation struct {
+	ValidatorAddress string   `json:"validator_address"`
+	Amount           *big.Int `json:"amount"`
+}
+
+// a delegator with its delegations and their total
+type Delegator struct {
+	DelegatorAddress string       `json:"delegator_address"`
+	TotalBalance     *big.Int     `json:"total_balance"`
+	Delegations      []Delegation `json:"delegations"`
+}
+
+// a page of the delegators of a validator, largest stake first
+type DelegatorsPage struct {
+	Validator  string                       `json:"validator"`
+	Total      int                          `json:"total"`
+	Offset     int                          `json:"offset"`
+	Limit      int                          `json:"limit"`
+	Delegators []statsModule.DelegatorStake `json:"delegators"`
+}
+
+// what /status reports about the snapshot being served
+type Status struct {
+	Node        string    `json:"node"`
+	Height      int64     `json:"height"`
+	BlockHeight int64     `json:"block_height"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	Validators  int       `json:"validators"`
+	Delegators  int       `json:"delegators"`
+	Delegations int       `json:"delegations"`
+	TotalStake  *big.Int  `json:"total_stake"`
+	Exports     []string  `json:"exports"`
+}
+
+// everything served for one snapshot. It is built once per refresh and never changed after, so requests can read it
+// without locking while the next snapshot is fetched
+type view struct {
+	etag       string
+	status     Status
+	validators []Validator
+	delegators map[string]Delegator
+	// the stakes of the delegators of every validator, largest first
+	validatorDelegators map[string][]statsModule.DelegatorStake
+	validatorStake      map[string]*big.Int
+	exports             map[string][]byte
+}
+
+// serves the latest snapshot of a chain
+type Server struct {
+	options  snapshotModule.Options
+	tiers    []statsModule.Tier
+	prefixes []string
+	// so tests can fetch without a node
+	fetch func(ctx context.Context, options snapshotModule.Options) (*snapshotModule.Snapshot, error)
+
+	mutex sync.RWMutex
+	view  *view
+}
+
+// a server for the snapshots fetched with options. The distribution exports use tiers and the delegation exports
+// get a column for every prefix
+func NewServer(options snapshotModule.Options, tiers []statsModule.Tier, prefixes []string) *Server {
+	options.SkipDelegations = false
+
+	return &Server{options: options, tiers: tiers, prefixes: prefixes, fetch: snapshotModule.Fetch}
+}
+
+// fetches a new snapshot and serves it. The previous snapshot is kept if the fetch fails or is interrupted
+func (server *Server) Refresh(ctx context.Context) error {
+	snapshot, err := server.fetch(ctx, server.options)
+	if err != nil {
+		return err
+	}
+
+	return server.SetSnapshot(ctx, snapshot)
+}
+
+// refreshes the snapshot now and then every interval until ctx is done. Failed refreshes are logged and retried at
+// the next interval
+func (server *Server) Run(ctx context.Context, interval time.Duration) {
+	logger := loggingModule.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if err := server.Refresh(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("refresh failed", "class", failuresModule.ClassOf(err).String(), "err", err)
+		} else {
+			status := server.current().status
+			logger.Info("refreshed snapshot", "block_height", status.BlockHeight, "validators", status.Validators,
+				"delegators", status.Delegators, "elapsed", loggingModule.Since(start))
+			metricsModule.FromContext(ctx).Succeeded(status.BlockHeight)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serves snapshot from now on. Every export is rendered up front
+func (server *Server) SetSnapshot(ctx context.Context, snapshot *snapshotModule.Snapshot) error {
+	view, err := server.newView(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.view = view
+
+	return nil
+}
+
+func (server *Server) current() *view {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	return server.view
+}
+
+// the exports that can be downloaded from /exports, by file name
+func (server *Server) exportWriters() map[string]snapshotModule.Writer {
+	return map[string]snapshotModule.Writer{
+		"validators.csv":          snapshotModule.ValidatorsCSV(),
+		"delegations.csv":         snapshotModule.DelegationsCSV(server.prefixes...),
+		"multipleDelegations.csv": snapshotModule.MultipleDelegationsCSV(server.prefixes...),
+		"distribution.csv":        snapshotModule.DistributionCSV(server.tiers),
+		"distribution.json":       snapshotModule.DistributionJSON(server.tiers),
+		"validatorBreakdown.csv":  snapshotModule.ValidatorBreakdownsCSV(),
+		"validatorBreakdown.json": snapshotModule.ValidatorBreakdownsJSON(),
+	}
+}
+
+func (server *Server) newView(ctx context.Context, snapshot *snapshotModule.Snapshot) (*view, error) {
+	if !snapshot.HasDelegations() {
+		return nil, failuresModule.Errorf(failuresModule.Config, "the snapshot was fetched without delegations")
+	}
+
+	totals := snapshot.Totals()
+	view := &view{
+		etag: etag(snapshot),
+		status: Status{
+			Node:        snapshot.Node,
+			Height:      snapshot.Height,
+			BlockHeight: snapshot.BlockHeight,
+			FetchedAt:   snapshot.FetchedAt,
+			Validators:  totals.Validators,
+			Delegators:  totals.Delegators,
+			Delegations: totals.Delegations,
+			TotalStake:  totals.TotalStake,
+		},
+		delegators:          make(map[string]Delegator),
+		validatorDelegators: make(map[string][]statsModule.DelegatorStake),
+		validatorStake:      make(map[string]*big.Int),
+		exports:             make(map[string][]byte),
+	}
+
+	// the writers sort the validators in place, which is only safe before the view is served
+	for name, writer := range server.exportWriters() {
+		var buf bytes.Buffer
+		if err := writer.WriteSnapshot(ctx, snapshot, &buf); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		view.exports[name] = buf.Bytes()
+	}
+
+	for _, validator := range *snapshot.Validators {
+		view.validators = append(view.validators, newValidator(validator))
+	}
+
+	for address, delegationsWithTotalBalance := range *snapshot.Delegations {
+		delegator := Delegator{DelegatorAddress: address, TotalBalance: delegationsWithTotalBalance.TotalBalance}
+
+		for _, delegationResponse := range delegationsWithTotalBalance.DelegationResponses {
+			validatorAddress := delegationResponse.Delegation.ValidatorAddress
+			amount := delegationResponse.Balance.Amount.BigInt()
+
+			delegator.Delegations = append(delegator.Delegations,
+				Delegation{ValidatorAddress: validatorAddress, Amount: amount})
+
+			view.validatorDelegators[validatorAddress] = append(view.validatorDelegators[validatorAddress],
+				statsModule.DelegatorStake{Delegator: address, Stake: amount})
+
+			if _, ok := view.validatorStake[validatorAddress]; !ok {
+				view.validatorStake[validatorAddress] = new(big.Int)
+			}
+			view.validatorStake[validatorAddress].Add(view.validatorStake[validatorAddress], amount)
+		}
+
+		view.delegators[address] = delegator
+	}
+
+	for validatorAddress, stakes := range view.validatorDelegators {
+		total := view.validatorStake[validatorAddress]
+
+		// ties are broken by address so pages are stable
+		sort.Slice(stakes, func(i, j int) bool {
+			if cmp := stakes[i].Stake.Cmp(stakes[j].Stake); cmp != 0 {
+				return cmp == 1
+			}
+			return stakes[i].Delegator < stakes[j].Delegator
+		})
+
+		for i := range stakes {
+			stakes[i].StakeShare = statsModule.Share(stakes[i].Stake, total)
+		}
+	}
+
+	view.exports["validators.json"], _ = json.MarshalIndent(view.validators, "", "  ")
+
+	for name := range view.exports {
+		view.status.Exports = append(view.status.Exports, name)
+	}
+	sort.Strings(view.status.Exports)
+
+	return view, nil
+}
+
+func newValidator(validator validatorTypes.Validator) Validator {
+	return Validator{
+		OperatorAddress:   validator.OperatorAddress,
+		Moniker:           validator.Description.Moniker,
+		Status:            validator.Status.String(),
+		Jailed:            validator.Jailed,
+		VotingPower:       validator.ConsensusPower(sdk.DefaultPowerReduction),
+		Tokens:            validator.Tokens.String(),
+		DelegatorShares:   validator.DelegatorShares.String(),
+		MinSelfDelegation: validator.MinSelfDelegation.String(),
+	}
+}
+
+// the ETag of everything served for a snapshot. Two snapshots at the same block height hold the same data. If the
+// node didn't report a height the fetch time stands in for it
+func etag(snapshot *snapshotModule.Snapshot) string {
+	switch {
+	case snapshot.BlockHeight > 0:
+		return strconv.Quote(strconv.FormatInt(snapshot.BlockHeight, 10))
+	case snapshot.Height > 0:
+		return strconv.Quote(strconv.FormatInt(snapshot.Height, 10))
+	default:
+		return strconv.Quote("t" + strconv.FormatInt(snapshot.FetchedAt.UnixNano(), 10))
+	}
+}
+
+// the routes of the api
+//
+//	GET /status                                    the snapshot being served
+//	GET /validators                                every validator, by voting power
+//	GET /validators/{operator}/delegators          the delegators of a validator, ?offset=0&limit=100
+//	GET /delegators/{address}                      the delegations of a delegator and their total
+//	GET /exports/{name}                            an export such as delegations.csv or distribution.json
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", server.handle(func(view *view, w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, view.status)
+	}))
+
+	mux.HandleFunc("/validators", server.handle(func(view *view, w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, view.validators)
+	}))
+
+	mux.HandleFunc("/validators/", server.handle(func(view *view, w http.ResponseWriter, r *http.Request) {
+		operator := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/validators/"), "/delegators")
+		if !strings.HasSuffix(r.URL.Path, "/delegators") || operator == "" || strings.Contains(operator, "/") {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		offset, limit, err := pagination(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		stakes, ok := view.validatorDelegators[operator]
+		if !ok && !view.hasValidator(operator) {
+			writeError(w, http.StatusNotFound, "unknown validator "+operator)
+			return
+		}
+
+		page := DelegatorsPage{Validator: operator, Total: len(stakes), Offset: offset, Limit: limit,
+			Delegators: []statsModule.DelegatorStake{}}
+		if offset < len(stakes) {
+			end := offset + limit
+			if end > len(stakes) {
+				end = len(stakes)
+			}
+			page.Delegators = stakes[offset:end]
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}))
+
+	mux.HandleFunc("/delegators/", server.handle(func(view *view, w http.ResponseWriter, r *http.Request) {
+		delegator, ok := view.delegators[strings.TrimPrefix(r.URL.Path, "/delegators/")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown delegator")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, delegator)
+	}))
+
+	mux.HandleFunc("/exports/", server.handle(func(view *view, w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/exports/")
+		export, ok := view.exports[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown export "+name)
+			return
+		}
+
+		contentType := "text/csv; charset=utf-8"
+		if strings.HasSuffix(name, ".json") {
+			contentType = "application/json"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.Write(export)
+	}))
+
+	return mux
+}
+
+func (view *view) hasValidator(operator string) bool {
+	for _, validator := range view.validators {
+		if validator.OperatorAddress == operator {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wraps a handler of the current view. GET and HEAD only, 503 until the first snapshot is in and 304 if the
+// client already has the snapshot's data
+func (server *Server) handle(handler func(view *view, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		view := server.current()
+		if view == nil {
+			w.Header().Set("Retry-After", "30")
+			writeError(w, http.StatusServiceUnavailable, "the first snapshot is still being fetched")
+			return
+		}
+
+		w.Header().Set("ETag", view.etag)
+		if matchesETag(r.Header.Get("If-None-Match"), view.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		handler(view, w, r)
+	}
+}
+
+// true if an If-None-Match header lists etag, weak or not
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the offset and limit query parameters
+func pagination(r *http.Request) (int, int, error) {
+	offset, limit := 0, DefaultLimit
+
+	if value := r.URL.Query().Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("offset must be a number of at least 0, got %q", value)
+		}
+		offset = parsed
+	}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > MaxLimit {
+			return 0, 0, fmt.Errorf("limit must be a number from 1 to %d, got %q", MaxLimit, value)
+		}
+		limit = parsed
+	}
+
+	return offset, limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+	statsModule "github.com/brianosaurus/challenge1/stats"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	validatorA = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	validatorB = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb"
+	delegator1 = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a"
+	delegator2 = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"
+)
+
+func validator(operatorAddress string, moniker string, tokens int64) stakingTypes.Validator {
+	return stakingTypes.Validator{
+		OperatorAddress:   operatorAddress,
+		Description:       stakingTypes.Description{Moniker: moniker},
+		Status:            stakingTypes.Bonded,
+		Tokens:            sdk.NewInt(tokens),
+		DelegatorShares:   sdk.NewDec(tokens),
+		MinSelfDelegation: sdk.OneInt(),
+	}
+}
+
+func delegation(delegatorAddress string, validatorAddress string, amount int64) stakingTypes.DelegationResponse {
+	return stakingTypes.DelegationResponse{
+		Delegation: stakingTypes.Delegation{
+			DelegatorAddress: delegatorAddress,
+			ValidatorAddress: validatorAddress,
+			Shares:           sdk.NewDec(amount),
+		},
+		Balance: sdk.NewInt64Coin("uosmo", amount),
+	}
+}
+
+func testSnapshot(blockHeight int64) *snapshotModule.Snapshot {
+	validators := stakingTypes.Validators{
+		validator(validatorB, "Second", 1_000_000),
+		validator(validatorA, "First", 5_000_000),
+	}
+	delegationResponses := stakingTypes.DelegationResponses{
+		delegation(delegator1, validatorA, 20),
+		delegation(delegator1, validatorB, 20),
+		delegation(delegator2, validatorA, 30),
+	}
+
+	return &snapshotModule.Snapshot{
+		Node:                "node",
+		BlockHeight:         blockHeight,
+		FetchedAt:           time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC),
+		Validators:          &validators,
+		DelegationResponses: &delegationResponses,
+		Delegations:         delegationsModule.GetDelegationsWithTotalBalance(&delegationResponses),
+	}
+}
+
+func get(t *testing.T, handler http.Handler, path string, header ...string) (*http.Response, []byte) {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		request.Header.Set(header[i], header[i+1])
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response, body
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer(snapshotModule.Options{Node: "node"}, statsModule.DefaultTiers, nil)
+	handler := server.Handler()
+
+	response, _ := get(t, handler, "/validators")
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+
+	if err := server.SetSnapshot(context.Background(), testSnapshot(7000000)); err != nil {
+		t.Fatal(err)
+	}
+
+	response, body := get(t, handler, "/validators")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, `"7000000"`, response.Header.Get("ETag"))
+
+	var validators []Validator
+	assert.Nil(t, json.Unmarshal(body, &validators))
+	assert.Equal(t, 2, len(validators))
+	assert.Equal(t, "First", validators[0].Moniker)
+	assert.Equal(t, int64(5), validators[0].VotingPower)
+	assert.Equal(t, "BOND_STATUS_BONDED", validators[0].Status)
+
+	response, body = get(t, handler, "/delegators/"+delegator1)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var delegator Delegator
+	assert.Nil(t, json.Unmarshal(body, &delegator))
+	assert.Equal(t, "40", delegator.TotalBalance.String())
+	assert.Equal(t, 2, len(delegator.Delegations))
+
+	response, _ = get(t, handler, "/delegators/osmo1unknown")
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	response, body = get(t, handler, "/validators/"+validatorA+"/delegators?limit=1")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var page DelegatorsPage
+	assert.Nil(t, json.Unmarshal(body, &page))
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, 1, len(page.Delegators))
+	assert.Equal(t, delegator2, page.Delegators[0].Delegator)
+	assert.Equal(t, "0.600000", page.Delegators[0].StakeShare)
+
+	_, body = get(t, handler, "/validators/"+validatorA+"/delegators?offset=1&limit=1")
+	assert.Nil(t, json.Unmarshal(body, &page))
+	assert.Equal(t, delegator1, page.Delegators[0].Delegator)
+
+	_, body = get(t, handler, "/validators/"+validatorA+"/delegators?offset=5")
+	assert.Nil(t, json.Unmarshal(body, &page))
+	assert.Equal(t, 0, len(page.Delegators))
+
+	response, _ = get(t, handler, "/validators/"+validatorA+"/delegators?limit=5000")
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	response, _ = get(t, handler, "/validators/osmovaloper1unknown/delegators")
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	response, body = get(t, handler, "/exports/delegations.csv")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", response.Header.Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(string(body), "delegator,voting_power\n"))
+
+	response, body = get(t, handler, "/exports/distribution.json")
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), `"delegators": 2`)
+
+	response, _ = get(t, handler, "/exports/secrets.txt")
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	var status Status
+	_, body = get(t, handler, "/status")
+	assert.Nil(t, json.Unmarshal(body, &status))
+	assert.Equal(t, int64(7000000), status.BlockHeight)
+	assert.Equal(t, "70", status.TotalStake.String())
+	assert.Contains(t, status.Exports, "validators.json")
+}
+
+func TestETag(t *testing.T) {
+	server := NewServer(snapshotModule.Options{Node: "node"}, statsModule.DefaultTiers, nil)
+	handler := server.Handler()
+
+	if err := server.SetSnapshot(context.Background(), testSnapshot(7000000)); err != nil {
+		t.Fatal(err)
+	}
+
+	response, body := get(t, handler, "/exports/validators.csv", "If-None-Match", `"7000000"`)
+	assert.Equal(t, http.StatusNotModified, response.StatusCode)
+	assert.Equal(t, 0, len(body))
+
+	response, _ = get(t, handler, "/exports/validators.csv", "If-None-Match", `W/"6999999", W/"7000000"`)
+	assert.Equal(t, http.StatusNotModified, response.StatusCode)
+
+	if err := server.SetSnapshot(context.Background(), testSnapshot(7000100)); err != nil {
+		t.Fatal(err)
+	}
+
+	response, _ = get(t, handler, "/exports/validators.csv", "If-None-Match", `"7000000"`)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, `"7000100"`, response.Header.Get("ETag"))
+
+	// without a block height the fetch time stands in
+	snapshot := testSnapshot(0)
+	assert.Equal(t, `"t1667260800000000000"`, etag(snapshot))
+	snapshot.Height = 42
+	assert.Equal(t, `"42"`, etag(snapshot))
+}
+
+func TestRefresh(t *testing.T) {
+	server := NewServer(snapshotModule.Options{Node: "node"}, statsModule.DefaultTiers, nil)
+
+	server.fetch = func(ctx context.Context, options snapshotModule.Options) (*snapshotModule.Snapshot, error) {
+		assert.False(t, options.SkipDelegations)
+		return testSnapshot(100), nil
+	}
+	assert.Nil(t, server.Refresh(context.Background()))
+	assert.Equal(t, `"100"`, server.current().etag)
+
+	// a failed refresh keeps serving the previous snapshot
+	server.fetch = func(ctx context.Context, options snapshotModule.Options) (*snapshotModule.Snapshot, error) {
+		return nil, errors.New("connection refused")
+	}
+	assert.Error(t, server.Refresh(context.Background()))
+	assert.Equal(t, `"100"`, server.current().etag)
+
+	response, _ := get(t, server.Handler(), "/status")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
@@ -0,0 +1,331 @@
+// Package appdb reads the staking state straight from the application database of a stopped node, for chains too big
+// to crawl over grpc. The staking store's IAVL tree is opened read-only at the version of the height asked for and its
+// validators and delegations are iterated, with the balances worked out from the shares as the node would
+package appdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cosmos/iavl"
+	gogotypes "github.com/gogo/protobuf/types"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	dbm "github.com/tendermint/tm-db"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	"github.com/brianosaurus/challenge1/failures"
+	genesisModule "github.com/brianosaurus/challenge1/genesis"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	paramsTypes "github.com/cosmos/cosmos-sdk/x/params/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// where the multistore keeps its stores and its latest version in the application database
+const (
+	applicationDB       = "application"
+	latestVersionKey    = "s/latest"
+	storePrefixFormat   = "s/k:%s/"
+	applicationDBSuffix = ".db"
+)
+
+// the key of the bond denom in the params store, under the staking subspace
+var bondDenomKey = []byte(stakingTypes.ModuleName + "/" + string(stakingTypes.KeyBondDenom))
+
+// a source reading the application database of a node. The node is the node's data directory, e.g.
+// ~/.osmosisd/data, or its application.db. Only the goleveldb backend, the default, is supported
+type Source struct {
+	mutex sync.Mutex
+	// the last state read. A snapshot's queries all ask for the same version so only it is kept
+	state    *genesisModule.State
+	stateKey string
+}
+
+func NewSource() *Source {
+	return &Source{}
+}
+
+func (source *Source) Staking(ctx context.Context, node string) (clientModule.StakingQuerier, func(), error) {
+	if _, _, err := dbPath(node); err != nil {
+		return nil, nil, err
+	}
+
+	return &querier{source: source, node: node}, func() {}, nil
+}
+
+// the rewards aren't read from the distribution store, every query fails with codes.Unimplemented
+func (source *Source) Distribution(ctx context.Context, node string) (clientModule.DistributionQuerier, func(), error) {
+	return genesisModule.Unrewarded{}, func() {}, nil
+}
+
+func (source *Source) LatestHeight(ctx context.Context, node string) (int64, error) {
+	db, err := open(node)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return latestVersion(db)
+}
+
+// the directory and name of the application database of node
+func dbPath(node string) (string, string, error) {
+	dir, name := node, applicationDB
+	if strings.HasSuffix(filepath.Clean(node), applicationDB+applicationDBSuffix) {
+		dir = filepath.Dir(filepath.Clean(node))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, name+applicationDBSuffix)); err != nil {
+		return "", "", failures.Errorf(failures.Config, "%s is not the data directory of a node: %w", node, err)
+	}
+
+	return dir, name, nil
+}
+
+// opens the application database of node read-only, so it is never changed even by accident
+func open(node string) (dbm.DB, error) {
+	dir, name, err := dbPath(node)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := dbm.NewGoLevelDBWithOpts(name, dir, &opt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, failures.Errorf(failures.IO, "opening the application database of %s: %w", node, err)
+	}
+
+	return db, nil
+}
+
+// the version of the last block committed to db
+func latestVersion(db dbm.DB) (int64, error) {
+	value, err := db.Get([]byte(latestVersionKey))
+	if err != nil {
+		return 0, failures.Wrap(failures.IO, err)
+	}
+	if value == nil {
+		return 0, failures.Errorf(failures.IO, "the application database has no committed version")
+	}
+
+	var version int64
+	if err := gogotypes.StdInt64Unmarshal(&version, value); err != nil {
+		return 0, failures.Wrap(failures.IO, err)
+	}
+
+	return version, nil
+}
+
+// the staking state of node at height, 0 for the latest version. It is read unless it was the last one read
+func (source *Source) State(ctx context.Context, node string, height int64) (*genesisModule.State, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	if source.state != nil && source.stateKey == fmt.Sprint(node, "@", height) {
+		return source.state, nil
+	}
+
+	db, err := open(node)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	version := height
+	if version == 0 {
+		if version, err = latestVersion(db); err != nil {
+			return nil, err
+		}
+	}
+
+	logger := loggingModule.FromContext(ctx)
+	logger.Info("reading staking store", "node", node, "version", version)
+	start := time.Now()
+
+	state, err := readState(db, version)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("read staking store", "version", version, "validators", len(state.Validators),
+		"delegations", len(state.Delegations), "elapsed", loggingModule.Since(start))
+
+	source.state, source.stateKey = state, fmt.Sprint(node, "@", height)
+
+	return state, nil
+}
+
+// reads the validators, delegations and unbonding delegations of the staking store and the bond denom at version
+func readState(db dbm.DB, version int64) (*genesisModule.State, error) {
+	staking, err := tree(db, stakingTypes.StoreKey, version)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := tree(db, paramsTypes.StoreKey, version)
+	if err != nil {
+		return nil, err
+	}
+
+	bondDenomValue, err := params.Get(bondDenomKey)
+	if err != nil {
+		return nil, failures.Wrap(failures.IO, err)
+	}
+
+	// params are stored as amino json, which for a string is a json string
+	var bondDenom string
+	if err := json.Unmarshal(bondDenomValue, &bondDenom); err != nil {
+		return nil, failures.Errorf(failures.IO, "decoding the bond denom: %w", err)
+	}
+
+	validators := stakingTypes.Validators{}
+	delegations := []stakingTypes.Delegation{}
+	unbondingDelegations := []stakingTypes.UnbondingDelegation{}
+
+	err = iterate(staking, stakingTypes.ValidatorsKey, func(value []byte) error {
+		var validator stakingTypes.Validator
+		if err := validator.Unmarshal(value); err != nil {
+			return err
+		}
+
+		validators = append(validators, validator)
+		return nil
+	})
+	if err != nil {
+		return nil, failures.Errorf(failures.IO, "reading validators: %w", err)
+	}
+
+	err = iterate(staking, stakingTypes.DelegationKey, func(value []byte) error {
+		var delegation stakingTypes.Delegation
+		if err := delegation.Unmarshal(value); err != nil {
+			return err
+		}
+
+		delegations = append(delegations, delegation)
+		return nil
+	})
+	if err != nil {
+		return nil, failures.Errorf(failures.IO, "reading delegations: %w", err)
+	}
+
+	err = iterate(staking, stakingTypes.UnbondingDelegationKey, func(value []byte) error {
+		var unbondingDelegation stakingTypes.UnbondingDelegation
+		if err := unbondingDelegation.Unmarshal(value); err != nil {
+			return err
+		}
+
+		unbondingDelegations = append(unbondingDelegations, unbondingDelegation)
+		return nil
+	})
+	if err != nil {
+		return nil, failures.Errorf(failures.IO, "reading unbonding delegations: %w", err)
+	}
+
+	state, err := genesisModule.NewState(version, bondDenom, validators, delegations, unbondingDelegations)
+	if err != nil {
+		return nil, failures.Wrap(failures.IO, err)
+	}
+
+	return state, nil
+}
+
+// the IAVL tree of the store named storeKey at version
+func tree(db dbm.DB, storeKey string, version int64) (*iavl.ImmutableTree, error) {
+	// the fast storage upgrade writes to the database, which is read-only
+	mutableTree, err := iavl.NewMutableTree(dbm.NewPrefixDB(db, []byte(fmt.Sprintf(storePrefixFormat, storeKey))), 0, true)
+	if err != nil {
+		return nil, failures.Wrap(failures.IO, err)
+	}
+
+	immutableTree, err := mutableTree.GetImmutable(version)
+	if err == iavl.ErrVersionDoesNotExist {
+		return nil, failures.Errorf(failures.Config, "version %d of the %s store isn't in the database, it was pruned or "+
+			"is after the last block", version, storeKey)
+	}
+	if err != nil {
+		return nil, failures.Wrap(failures.IO, err)
+	}
+
+	return immutableTree, nil
+}
+
+// calls decode with the value of every key of tree starting with prefix, in key order
+func iterate(tree *iavl.ImmutableTree, prefix []byte, decode func(value []byte) error) error {
+	var err error
+
+	tree.IterateRange(prefix, sdk.PrefixEndBytes(prefix), true, func(key []byte, value []byte) bool {
+		err = decode(value)
+		return err != nil
+	})
+
+	return err
+}
+
+type querier struct {
+	source *Source
+	node   string
+}
+
+func (querier *querier) Validators(ctx context.Context, in *stakingTypes.QueryValidatorsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorsResponse, error) {
+	state, err := querier.state(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Querier().Validators(ctx, in, opts...)
+}
+
+func (querier *querier) ValidatorDelegations(ctx context.Context, in *stakingTypes.QueryValidatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorDelegationsResponse, error) {
+	state, err := querier.state(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Querier().ValidatorDelegations(ctx, in, opts...)
+}
+
+func (querier *querier) DelegatorDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorDelegationsResponse, error) {
+	state, err := querier.state(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Querier().DelegatorDelegations(ctx, in, opts...)
+}
+
+func (querier *querier) DelegatorUnbondingDelegations(ctx context.Context,
+	in *stakingTypes.QueryDelegatorUnbondingDelegationsRequest, opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorUnbondingDelegationsResponse, error) {
+	state, err := querier.state(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Querier().DelegatorUnbondingDelegations(ctx, in, opts...)
+}
+
+// the state at the height carried by ctx. Reading it is local, so a failure is never the network's
+func (querier *querier) state(ctx context.Context) (*genesisModule.State, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	state, err := querier.source.State(ctx, querier.node, clientModule.BlockHeight(md))
+	if err != nil {
+		return nil, failures.Wrap(failures.IO, err)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,151 @@
+package appdb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/cosmos/iavl"
+	gogotypes "github.com/gogo/protobuf/types"
+	"github.com/stretchr/testify/assert"
+	dbm "github.com/tendermint/tm-db"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	"github.com/brianosaurus/challenge1/failures"
+	validatorsModule "github.com/brianosaurus/challenge1/validators"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/ed25519"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	paramsTypes "github.com/cosmos/cosmos-sdk/x/params/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// writes the application database of a node at height 2 with a validator, slashed so a share is worth half a token,
+// and a delegation made at each height
+func writeApplicationDB(t *testing.T, dir string) (sdk.ValAddress, []sdk.AccAddress) {
+	db, err := dbm.NewGoLevelDB(applicationDB, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	newTree := func(storeKey string) *iavl.MutableTree {
+		tree, err := iavl.NewMutableTree(dbm.NewPrefixDB(db, []byte(fmt.Sprintf(storePrefixFormat, storeKey))), 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	staking, params := newTree(stakingTypes.StoreKey), newTree(paramsTypes.StoreKey)
+
+	operator := sdk.ValAddress([]byte("validator-operator-1"))
+	delegators := []sdk.AccAddress{sdk.AccAddress([]byte("delegator-address-01")), sdk.AccAddress([]byte("delegator-address-02"))}
+
+	validator, err := stakingTypes.NewValidator(operator, ed25519.GenPrivKey().PubKey(), stakingTypes.Description{Moniker: "Inotel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator.Status = stakingTypes.Bonded
+	validator.Tokens = sdk.NewInt(1000)
+	validator.DelegatorShares = sdk.NewDec(2000)
+
+	set := func(tree *iavl.MutableTree, key []byte, value []byte) {
+		if _, err := tree.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	validatorValue, _ := validator.Marshal()
+	set(staking, stakingTypes.GetValidatorKey(operator), validatorValue)
+	set(params, bondDenomKey, []byte(`"uosmo"`))
+
+	for height, delegator := range delegators {
+		delegation := stakingTypes.NewDelegation(delegator, operator, sdk.NewDec(int64(1000*(height+1))))
+		delegationValue, _ := delegation.Marshal()
+		set(staking, stakingTypes.GetDelegationKey(delegator, operator), delegationValue)
+
+		for _, tree := range []*iavl.MutableTree{staking, params} {
+			if _, _, err := tree.SaveVersion(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	latest, _ := gogotypes.StdInt64Marshal(int64(len(delegators)))
+	if err := db.Set([]byte(latestVersionKey), latest); err != nil {
+		t.Fatal(err)
+	}
+
+	return operator, delegators
+}
+
+func TestSource(t *testing.T) {
+	dir := t.TempDir()
+	operator, delegators := writeApplicationDB(t, dir)
+
+	var heights []int64
+	ctx := clientModule.WithSource(context.Background(), NewSource())
+	ctx = clientModule.WithInterceptors(ctx, clientModule.HeightInterceptor(func(height int64) {
+		heights = append(heights, height)
+	}))
+
+	validators, err := validatorsModule.GetValidatorsAtHeight(ctx, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(*validators))
+	assert.Equal(t, operator.String(), (*validators)[0].OperatorAddress)
+	assert.Equal(t, "Inotel", (*validators)[0].Description.Moniker)
+
+	pubKey, err := (*validators)[0].ConsPubKey()
+	assert.Nil(t, err)
+	assert.NotNil(t, pubKey)
+
+	// the latest version, with the balances at the validator's exchange rate
+	delegationResponses, err := delegationsModule.GetDelegationResponsesAtHeight(ctx, dir, validators, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, len(*delegationResponses))
+	assert.Equal(t, delegators[0].String(), (*delegationResponses)[0].Delegation.DelegatorAddress)
+	assert.Equal(t, "500", (*delegationResponses)[0].Balance.Amount.String())
+	assert.Equal(t, "1000", (*delegationResponses)[1].Balance.Amount.String())
+	assert.Equal(t, "uosmo", (*delegationResponses)[1].Balance.Denom)
+
+	// an older version, given as the application.db itself
+	delegationResponses, err = delegationsModule.GetDelegationResponsesAtHeight(ctx,
+		filepath.Join(dir, applicationDB+applicationDBSuffix), validators, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(*delegationResponses))
+	assert.Equal(t, []int64{2, 2, 1}, heights)
+
+	_, err = delegationsModule.GetDelegationResponsesAtHeight(ctx, dir, validators, 3)
+	// a pruned or future version is a bad -height, not a network failure
+	assert.Equal(t, failures.Config, failures.ClassOf(err))
+	assert.Contains(t, err.Error(), "it was pruned or is after the last block")
+
+	height, err := NewSource().LatestHeight(context.Background(), dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), height)
+
+	_, _, err = NewSource().Staking(context.Background(), t.TempDir())
+	assert.Contains(t, err.Error(), "is not the data directory of a node")
+
+	// a database the node never committed to is a local failure too
+	emptyDir := t.TempDir()
+	emptyDB, err := dbm.NewGoLevelDB(applicationDB, emptyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyDB.Close()
+
+	_, err = validatorsModule.GetValidatorsAtHeight(ctx, emptyDir, 0)
+	assert.Equal(t, failures.IO, failures.ClassOf(err))
+}
@@ -0,0 +1,156 @@
+package blocks
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	"github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+
+	"github.com/cosmos/cosmos-sdk/client/grpc/tmservice"
+)
+
+// these are so we can mock out the grpc connection for testing
+var (
+	GrpcDial                  = grpc.Dial
+	TmServiceNewServiceClient = tmservice.NewServiceClient
+)
+
+// get the height of the latest block of the node
+func GetLatestHeight(ctx context.Context, node string) (int64, error) {
+	if source := clientModule.SourceFrom(ctx); source != nil {
+		height, err := source.LatestHeight(ctx, node)
+		return height, failures.Wrap(failures.Network, err)
+	}
+
+	grpcConn, err := GrpcDial(node, clientModule.DialOptions(ctx)...)
+	if err != nil {
+		return 0, failures.Wrap(failures.Network, err)
+	}
+
+	// tests dial nothing
+	if grpcConn != nil {
+		defer grpcConn.Close()
+	}
+
+	latest, err := TmServiceNewServiceClient(grpcConn).GetLatestBlock(ctx, &tmservice.GetLatestBlockRequest{})
+	if err != nil {
+		return 0, failures.Wrap(failures.Network, err)
+	}
+
+	// newer nodes only fill in the sdk block
+	if latest.SdkBlock != nil {
+		return latest.SdkBlock.Header.Height, nil
+	}
+
+	if latest.Block != nil {
+		return latest.Block.Header.Height, nil
+	}
+
+	return 0, failures.Errorf(failures.Network, "the node returned no latest block")
+}
+
+// get the chain id of the node, from its latest block. Empty for the sources that can't tell
+func GetChainID(ctx context.Context, node string) (string, error) {
+	if source := clientModule.SourceFrom(ctx); source != nil {
+		chainIDSource, ok := source.(clientModule.ChainIDSource)
+		if !ok {
+			return "", nil
+		}
+
+		chainID, err := chainIDSource.ChainID(ctx, node)
+		return chainID, failures.Wrap(failures.Network, err)
+	}
+
+	grpcConn, err := GrpcDial(node, clientModule.DialOptions(ctx)...)
+	if err != nil {
+		return "", failures.Wrap(failures.Network, err)
+	}
+
+	// tests dial nothing
+	if grpcConn != nil {
+		defer grpcConn.Close()
+	}
+
+	latest, err := TmServiceNewServiceClient(grpcConn).GetLatestBlock(ctx, &tmservice.GetLatestBlockRequest{})
+	if err != nil {
+		return "", failures.Wrap(failures.Network, err)
+	}
+
+	// newer nodes only fill in the sdk block
+	switch {
+	case latest.SdkBlock != nil && latest.SdkBlock.Header.ChainID != "":
+		return latest.SdkBlock.Header.ChainID, nil
+	case latest.Block != nil && latest.Block.Header.ChainID != "":
+		return latest.Block.Header.ChainID, nil
+	default:
+		return "", failures.Errorf(failures.Network, "the node returned no chain id")
+	}
+}
+
+// get the time of the block at height. Only the grpc endpoint and the sources implementing
+// clientModule.BlockTimeSource can tell. With a query cache and the chain id in ctx the time is answered from the
+// cache, and cached once the node told it
+func GetBlockTime(ctx context.Context, node string, height int64) (time.Time, error) {
+	responseCache, chainID := cacheModule.FromContext(ctx), cacheModule.ChainIDFromContext(ctx)
+	if responseCache == nil || chainID == "" || height <= 0 {
+		return getBlockTime(ctx, node, height)
+	}
+
+	if blockTime, ok := responseCache.BlockTime(chainID, height); ok {
+		return blockTime, nil
+	}
+
+	blockTime, err := getBlockTime(ctx, node, height)
+	if err != nil {
+		return blockTime, err
+	}
+
+	if err := responseCache.SaveBlockTime(chainID, height, blockTime); err != nil {
+		loggingModule.FromContext(ctx).Error("caching a block time failed", "height", height, "err", err)
+	}
+
+	return blockTime, nil
+}
+
+func getBlockTime(ctx context.Context, node string, height int64) (time.Time, error) {
+	if source := clientModule.SourceFrom(ctx); source != nil {
+		blockTimeSource, ok := source.(clientModule.BlockTimeSource)
+		if !ok {
+			return time.Time{}, failures.Errorf(failures.Config, "the source can't tell when a block was made")
+		}
+
+		blockTime, err := blockTimeSource.BlockTime(ctx, node, height)
+		return blockTime, failures.Wrap(failures.Network, err)
+	}
+
+	grpcConn, err := GrpcDial(node, clientModule.DialOptions(ctx)...)
+	if err != nil {
+		return time.Time{}, failures.Wrap(failures.Network, err)
+	}
+
+	// tests dial nothing
+	if grpcConn != nil {
+		defer grpcConn.Close()
+	}
+
+	block, err := TmServiceNewServiceClient(grpcConn).GetBlockByHeight(ctx, &tmservice.GetBlockByHeightRequest{Height: height})
+	if err != nil {
+		return time.Time{}, failures.Wrap(failures.Network, err)
+	}
+
+	// newer nodes only fill in the sdk block
+	if block.SdkBlock != nil {
+		return block.SdkBlock.Header.Time, nil
+	}
+
+	if block.Block != nil {
+		return block.Block.Header.Time, nil
+	}
+
+	return time.Time{}, failures.Errorf(failures.Network, "the node returned no block at height %d", height)
+}
@@ -0,0 +1,84 @@
+package blocks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	grpc1 "github.com/gogo/protobuf/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/brianosaurus/challenge1/failures"
+
+	"github.com/cosmos/cosmos-sdk/client/grpc/tmservice"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+type serviceClient struct {
+	tmservice.ServiceClient
+	response *tmservice.GetLatestBlockResponse
+	block    *tmservice.GetBlockByHeightResponse
+	err      error
+}
+
+func (client *serviceClient) GetLatestBlock(ctx context.Context, in *tmservice.GetLatestBlockRequest,
+	opts ...grpc.CallOption,
+) (*tmservice.GetLatestBlockResponse, error) {
+	return client.response, client.err
+}
+
+func (client *serviceClient) GetBlockByHeight(ctx context.Context, in *tmservice.GetBlockByHeightRequest,
+	opts ...grpc.CallOption,
+) (*tmservice.GetBlockByHeightResponse, error) {
+	return client.block, client.err
+}
+
+func stub(response *tmservice.GetLatestBlockResponse, err error) {
+	GrpcDial = func(node string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		return nil, nil
+	}
+
+	TmServiceNewServiceClient = func(conn grpc1.ClientConn) tmservice.ServiceClient {
+		return &serviceClient{response: response, err: err}
+	}
+}
+
+func TestGetLatestHeight(t *testing.T) {
+	stub(&tmservice.GetLatestBlockResponse{Block: &tmproto.Block{Header: tmproto.Header{Height: 7000000}}}, nil)
+
+	height, err := GetLatestHeight(context.Background(), "node value not needed")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7000000), height)
+
+	stub(&tmservice.GetLatestBlockResponse{SdkBlock: &tmservice.Block{Header: tmservice.Header{Height: 7000001}}}, nil)
+
+	height, err = GetLatestHeight(context.Background(), "node value not needed")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7000001), height)
+
+	stub(nil, errors.New("connection refused"))
+
+	_, err = GetLatestHeight(context.Background(), "node value not needed")
+	assert.Equal(t, failures.Network, failures.ClassOf(err))
+}
+
+func TestGetBlockTime(t *testing.T) {
+	made := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	stub(nil, nil)
+	TmServiceNewServiceClient = func(conn grpc1.ClientConn) tmservice.ServiceClient {
+		return &serviceClient{block: &tmservice.GetBlockByHeightResponse{
+			SdkBlock: &tmservice.Block{Header: tmservice.Header{Height: 7000000, Time: made}},
+		}}
+	}
+
+	blockTime, err := GetBlockTime(context.Background(), "node value not needed", 7000000)
+	assert.Nil(t, err)
+	assert.Equal(t, made, blockTime)
+
+	stub(nil, errors.New("connection refused"))
+
+	_, err = GetBlockTime(context.Background(), "node value not needed", 7000000)
+	assert.Equal(t, failures.Network, failures.ClassOf(err))
+}
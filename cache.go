@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+)
+
+// the purgeCache subcommand removes the responses of the -queryCacheDir query cache, all of them or only those of the
+// -chainId chain or not used for a while
+func purgeCacheCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	options := cacheModule.PurgeOptions{}
+
+	flags.DurationVar(&options.OlderThan, "olderThan", 0, "only remove the responses not used for this long (e.g. 720h)")
+
+	return func(ctx context.Context) error {
+		responseCache := cacheModule.FromContext(ctx)
+		if responseCache == nil {
+			return failuresModule.Errorf(failuresModule.Config, "purgeCache: -queryCacheDir is required")
+		}
+
+		options.ChainID = cacheModule.ChainIDFromContext(ctx)
+
+		start := time.Now()
+		removed, removedBytes, err := responseCache.Purge(options)
+		if err != nil {
+			return err
+		}
+
+		loggingModule.FromContext(ctx).Info("purged the query cache", "responses", removed, "bytes", removedBytes,
+			"remaining_bytes", responseCache.Size(), "elapsed", loggingModule.Since(start))
+
+		return nil
+	}
+}
@@ -0,0 +1,381 @@
+// Package cache keeps the responses of height pinned queries on disk, keyed by chain id, height, method and request,
+// so running again at the same height answers from disk instead of the node. The state at a height never changes so
+// the responses never go stale, the cache is only bounded by its size
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	gogotypes "github.com/gogo/protobuf/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+)
+
+// once over its limit the cache evicts down to this share of it, so it doesn't evict on every write
+const evictTo = 0.9
+
+// the responses cached in a directory, one file per response under <chain id>/<height>/
+type Cache struct {
+	dir string
+	// 0 for no limit
+	maxBytes int64
+
+	mutex sync.Mutex
+	size  int64
+}
+
+// opens the cache in dir, creating it if needed. maxBytes bounds the size of the responses, the least recently used
+// are evicted past it. 0 is no limit
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	cache := &Cache{dir: dir, maxBytes: maxBytes}
+
+	entries, err := cache.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		cache.size += entry.size
+	}
+
+	return cache, nil
+}
+
+// the size of the cached responses in bytes
+func (cache *Cache) Size() int64 {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.size
+}
+
+// an interceptor answering the height pinned queries of a chain from the cache, and caching the responses of the
+// ones it can't. Queries of the latest block are passed through. It should be the last interceptor so the ones
+// before it see the response height of cached responses too. Answered queries are marked with
+// clientModule.MarkCached so the metrics don't count them as requests to the node
+func (cache *Cache) Interceptor(chainID string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		outgoing, _ := metadata.FromOutgoingContext(ctx)
+		height := clientModule.BlockHeight(outgoing)
+		request, isRequest := req.(proto.Message)
+		response, isResponse := reply.(proto.Message)
+
+		if chainID == "" || height <= 0 || !isRequest || !isResponse {
+			return invoker(ctx, method, req, reply, conn, opts...)
+		}
+
+		requestBytes, err := proto.Marshal(request)
+		if err != nil {
+			return invoker(ctx, method, req, reply, conn, opts...)
+		}
+
+		logger := loggingModule.FromContext(ctx)
+		path := cache.path(chainID, height, method, requestBytes)
+
+		if answeredAt, ok := cache.read(path, response); ok {
+			logger.Debug("cached response", "method", method, "height", height)
+			clientModule.MarkCached(ctx)
+			setHeight(opts, answeredAt)
+			return nil
+		}
+
+		var header metadata.MD
+		if err := invoker(ctx, method, req, reply, conn, append(opts, grpc.Header(&header))...); err != nil {
+			return err
+		}
+
+		if err := cache.write(path, clientModule.BlockHeight(header), response); err != nil {
+			logger.Error("caching a response failed", "method", method, "height", height, "err", err)
+		}
+
+		return nil
+	}
+}
+
+// the key the time of a block is cached under, next to the responses of its height
+const blockTimeKey = "blockTime"
+
+// the time of the block at height of a chain, if it was cached
+func (cache *Cache) BlockTime(chainID string, height int64) (time.Time, bool) {
+	var timestamp gogotypes.Timestamp
+	if _, ok := cache.read(cache.path(chainID, height, blockTimeKey, nil), &timestamp); !ok {
+		return time.Time{}, false
+	}
+
+	blockTime, err := gogotypes.TimestampFromProto(&timestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return blockTime, true
+}
+
+// caches the time of the block at height of a chain. Block times never change either, so the history of a chain
+// can be run again without asking the node when its heights were made
+func (cache *Cache) SaveBlockTime(chainID string, height int64, blockTime time.Time) error {
+	timestamp, err := gogotypes.TimestampProto(blockTime)
+	if err != nil {
+		return err
+	}
+
+	return cache.write(cache.path(chainID, height, blockTimeKey, nil), height, timestamp)
+}
+
+// the file of a response. The method and request are hashed so any request makes a valid file name
+func (cache *Cache) path(chainID string, height int64, method string, request []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write(request)
+
+	return filepath.Join(cache.dir, chainDir(chainID), strconv.FormatInt(height, 10), hex.EncodeToString(hash.Sum(nil)))
+}
+
+// a chain id as a directory name
+func chainDir(chainID string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(chainID)
+}
+
+// reads a cached response into response, with the height the node answered at. A hit counts as a use for eviction
+func (cache *Cache) read(path string, response proto.Message) (int64, bool) {
+	contents, err := os.ReadFile(path)
+	if err != nil || len(contents) < 8 {
+		return 0, false
+	}
+
+	response.Reset()
+	if err := proto.Unmarshal(contents[8:], response); err != nil {
+		response.Reset()
+		return 0, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return int64(binary.BigEndian.Uint64(contents[:8])), true
+}
+
+// writes a response after the height the node answered at, under a temporary name that is renamed so a cached
+// response is always whole
+func (cache *Cache) write(path string, answeredAt int64, response proto.Message) error {
+	responseBytes, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	contents := make([]byte, 8, 8+len(responseBytes))
+	binary.BigEndian.PutUint64(contents, uint64(answeredAt))
+	contents = append(contents, responseBytes...)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+	if err := os.WriteFile(path+".tmp", contents, 0o644); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.size += int64(len(contents))
+	if cache.maxBytes > 0 && cache.size > cache.maxBytes {
+		return cache.evict(int64(float64(cache.maxBytes) * evictTo))
+	}
+
+	return nil
+}
+
+// answers the header call options with the height a cached response was answered at
+func setHeight(opts []grpc.CallOption, height int64) {
+	for _, opt := range opts {
+		if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+			*headerOption.HeaderAddr = metadata.MD{}
+			if height > 0 {
+				*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader, strconv.FormatInt(height, 10))
+			}
+		}
+	}
+}
+
+// a cached response
+type entry struct {
+	path    string
+	chainID string
+	size    int64
+	used    time.Time
+}
+
+// every cached response
+func (cache *Cache) entries() ([]entry, error) {
+	var entries []entry
+
+	err := filepath.WalkDir(cache.dir, func(path string, file fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(cache.dir, path)
+		if err != nil {
+			return err
+		}
+		chainID, _, _ := strings.Cut(filepath.ToSlash(relative), "/")
+
+		entries = append(entries, entry{path: path, chainID: chainID, size: info.Size(), used: info.ModTime()})
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	return entries, nil
+}
+
+// removes the least recently used responses until the cache is at most target bytes. The caller holds the mutex
+func (cache *Cache) evict(target int64) error {
+	entries, err := cache.entries()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+
+	cache.size = 0
+	for _, entry := range entries {
+		cache.size += entry.size
+	}
+
+	for _, entry := range entries {
+		if cache.size <= target {
+			break
+		}
+
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		cache.size -= entry.size
+	}
+
+	return nil
+}
+
+// which responses to purge. The zero value purges everything
+type PurgeOptions struct {
+	// only the responses of this chain
+	ChainID string
+	// only the responses not used since this long ago
+	OlderThan time.Duration
+}
+
+// removes the cached responses matching options and returns how many there were and their size. Emptied
+// directories are removed too
+func (cache *Cache) Purge(options PurgeOptions) (int, int64, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entries, err := cache.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cutoff := time.Now().Add(-options.OlderThan)
+	removed, removedBytes := 0, int64(0)
+	cache.size = 0
+
+	for _, entry := range entries {
+		matches := (options.ChainID == "" || entry.chainID == chainDir(options.ChainID)) &&
+			(options.OlderThan <= 0 || entry.used.Before(cutoff))
+		if !matches {
+			cache.size += entry.size
+			continue
+		}
+
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, removedBytes, failuresModule.Wrap(failuresModule.IO, err)
+		}
+		removed++
+		removedBytes += entry.size
+	}
+
+	removeEmptyDirs(cache.dir)
+
+	return removed, removedBytes, nil
+}
+
+// removes the empty directories under dir, deepest first
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	filepath.WalkDir(dir, func(path string, file fs.DirEntry, err error) error {
+		if err == nil && file.IsDir() && path != dir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// only succeeds when the directory is empty
+		os.Remove(dirs[i])
+	}
+}
+
+type cacheKey struct{}
+
+// a copy of ctx carrying cache, for the fetches pinned to a height to use
+func NewContext(ctx context.Context, cache *Cache) context.Context {
+	return context.WithValue(ctx, cacheKey{}, cache)
+}
+
+// the cache carried by ctx, nil if there is none
+func FromContext(ctx context.Context) *Cache {
+	cache, _ := ctx.Value(cacheKey{}).(*Cache)
+	return cache
+}
+
+type chainIDKey struct{}
+
+// a copy of ctx carrying the chain id of the node, so the fetches using the cache don't have to ask the node for it
+func WithChainID(ctx context.Context, chainID string) context.Context {
+	return context.WithValue(ctx, chainIDKey{}, chainID)
+}
+
+// the chain id carried by ctx, empty if the node has to be asked
+func ChainIDFromContext(ctx context.Context) string {
+	chainID, _ := ctx.Value(chainIDKey{}).(string)
+	return chainID
+}
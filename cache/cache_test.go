@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	METHOD    = "/cosmos.staking.v1beta1.Query/ValidatorDelegations"
+	VALIDATOR = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	DELEGATOR = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"
+)
+
+// a node answering at the height it was asked for, counting its calls
+func node(calls *int) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		opts ...grpc.CallOption,
+	) error {
+		*calls++
+		outgoing, _ := metadata.FromOutgoingContext(ctx)
+
+		for _, opt := range opts {
+			if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+				*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader,
+					outgoing.Get(grpcTypes.GRPCBlockHeightHeader)[0])
+			}
+		}
+
+		*reply.(*stakingTypes.QueryValidatorDelegationsResponse) = stakingTypes.QueryValidatorDelegationsResponse{
+			DelegationResponses: stakingTypes.DelegationResponses{{
+				Delegation: stakingTypes.Delegation{DelegatorAddress: DELEGATOR, ValidatorAddress: VALIDATOR,
+					Shares: sdk.NewDec(10)},
+				Balance: sdk.NewInt64Coin("uosmo", 10),
+			}},
+			Pagination: &queryTypes.PageResponse{Total: 1},
+		}
+		return nil
+	}
+}
+
+func atHeight(ctx context.Context, height string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, grpcTypes.GRPCBlockHeightHeader, height)
+}
+
+func query(ctx context.Context, invoker grpc.UnaryInvoker, validator string,
+) (*stakingTypes.QueryValidatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryValidatorDelegationsResponse{}
+	err := clientModule.Invoke(ctx, METHOD, &stakingTypes.QueryValidatorDelegationsRequest{ValidatorAddr: validator},
+		response, invoker)
+	return response, err
+}
+
+func TestInterceptor(t *testing.T) {
+	cache, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var heights []int64
+	ctx := clientModule.WithInterceptors(context.Background(),
+		clientModule.HeightInterceptor(func(height int64) { heights = append(heights, height) }),
+		cache.Interceptor("osmosis-1"))
+
+	calls := 0
+	response, err := query(atHeight(ctx, "7000000"), node(&calls), VALIDATOR)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.True(t, cache.Size() > 8)
+
+	cached, err := query(atHeight(ctx, "7000000"), node(&calls), VALIDATOR)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, response.String(), cached.String())
+	assert.Equal(t, "10uosmo", cached.DelegationResponses[0].Balance.String())
+
+	// the height of the cached response is recorded as if the node answered
+	assert.Equal(t, []int64{7000000, 7000000}, heights)
+
+	// another height, request or chain and the latest block aren't cached
+	query(atHeight(ctx, "7000001"), node(&calls), VALIDATOR)
+	query(atHeight(ctx, "7000000"), node(&calls), "osmovaloper1other")
+	query(atHeight(clientModule.WithInterceptors(context.Background(), cache.Interceptor("cosmoshub-4")), "7000000"),
+		node(&calls), VALIDATOR)
+	assert.Equal(t, 4, calls)
+
+	latest := clientModule.WithInterceptors(context.Background(), cache.Interceptor("osmosis-1"))
+	query(latest, func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		opts ...grpc.CallOption,
+	) error {
+		calls++
+		return nil
+	}, VALIDATOR)
+	assert.Equal(t, 5, calls)
+}
+
+func TestEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := clientModule.WithInterceptors(context.Background(), cache.Interceptor("osmosis-1"))
+	calls := 0
+	query(atHeight(ctx, "1"), node(&calls), VALIDATOR)
+	entrySize := cache.Size()
+
+	// reopened with room for two and a half responses, the least recently used goes on the third
+	cache, err = Open(dir, 2*entrySize+entrySize/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, entrySize, cache.Size())
+
+	ctx = clientModule.WithInterceptors(context.Background(), cache.Interceptor("osmosis-1"))
+	query(atHeight(ctx, "2"), node(&calls), VALIDATOR)
+
+	old := time.Now().Add(-time.Hour)
+	entries, _ := cache.entries()
+	for _, entry := range entries {
+		if filepath.Base(filepath.Dir(entry.path)) == "2" {
+			os.Chtimes(entry.path, old, old)
+		}
+	}
+
+	query(atHeight(ctx, "3"), node(&calls), VALIDATOR)
+	assert.Equal(t, 2*entrySize, cache.Size())
+
+	calls = 0
+	query(atHeight(ctx, "1"), node(&calls), VALIDATOR)
+	query(atHeight(ctx, "2"), node(&calls), VALIDATOR)
+	assert.Equal(t, 1, calls)
+}
+
+func TestPurge(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	for _, chainID := range []string{"osmosis-1", "cosmoshub-4"} {
+		ctx := clientModule.WithInterceptors(context.Background(), cache.Interceptor(chainID))
+		query(atHeight(ctx, "1"), node(&calls), VALIDATOR)
+		query(atHeight(ctx, "2"), node(&calls), VALIDATOR)
+	}
+	entrySize := cache.Size() / 4
+
+	// nothing was unused for a day
+	removed, _, err := cache.Purge(PurgeOptions{OlderThan: 24 * time.Hour})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, removed)
+
+	removed, removedBytes, err := cache.Purge(PurgeOptions{ChainID: "cosmoshub-4"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, 2*entrySize, removedBytes)
+	assert.Equal(t, 2*entrySize, cache.Size())
+
+	_, err = os.Stat(filepath.Join(dir, "cosmoshub-4"))
+	assert.True(t, os.IsNotExist(err))
+
+	removed, _, err = cache.Purge(PurgeOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, int64(0), cache.Size())
+}
+
+func TestBlockTime(t *testing.T) {
+	cache, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := cache.BlockTime("osmosis-1", 7000000)
+	assert.False(t, ok)
+
+	blockTime := time.Date(2023, 9, 30, 2, 39, 54, 0, time.UTC)
+	assert.Nil(t, cache.SaveBlockTime("osmosis-1", 7000000, blockTime))
+
+	cached, ok := cache.BlockTime("osmosis-1", 7000000)
+	assert.True(t, ok)
+	assert.True(t, blockTime.Equal(cached))
+
+	// kept by chain like the responses
+	_, ok = cache.BlockTime("cosmoshub-4", 7000000)
+	assert.False(t, ok)
+
+	removed, _, err := cache.Purge(PurgeOptions{ChainID: "osmosis-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+}
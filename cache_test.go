@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+func TestQueryCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	responseCache, err := cacheModule.Open(cacheDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	liveDir := t.TempDir()
+	_, err = live(t, cacheModule.NewContext(context.Background(), responseCache), &stubSource{}, "snapshot",
+		append(exportFlags(liveDir), "-height", "7000000")...)
+	assert.Nil(t, err)
+
+	// with the chain id given nothing is asked of the node, there is none listening
+	cachedDir := t.TempDir()
+	_, err = runCommand(findCommand("snapshot"), append([]string{"-quiet", "-node", "127.0.0.1:1",
+		"-queryCacheDir", cacheDir, "-chainId", "osmosis-1", "-height", "7000000"}, exportFlags(cachedDir)...))
+	assert.Nil(t, err)
+
+	for _, file := range exportFiles {
+		assert.Equal(t, readFile(t, filepath.Join(liveDir, file)), readFile(t, filepath.Join(cachedDir, file)), file)
+	}
+
+	// the chains of multi can't share one id
+	_, err = runCommand(findCommand("multi"), []string{"-quiet", "-queryCacheDir", cacheDir, "-chainId", "osmosis-1"})
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+
+	// only the responses of the -chainId chain are purged
+	_, err = runCommand(findCommand("purgeCache"), []string{"-quiet", "-queryCacheDir", cacheDir, "-chainId", "juno-1"})
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(cacheDir, "osmosis-1"))
+	assert.Nil(t, err)
+
+	_, err = runCommand(findCommand("purgeCache"), []string{"-quiet", "-queryCacheDir", cacheDir, "-chainId", "osmosis-1"})
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(cacheDir, "osmosis-1"))
+	assert.True(t, os.IsNotExist(err))
+}
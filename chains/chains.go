@@ -0,0 +1,160 @@
+package chains
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// a chain to snapshot
+type Chain struct {
+	Name         string `json:"name"`
+	Node         string `json:"node"`
+	Bech32Prefix string `json:"bech32_prefix"`
+	Denom        string `json:"denom"`
+	OutputDir    string `json:"output_dir"`
+	// how the node is queried, as with -source. Empty for grpc
+	Source string `json:"source"`
+}
+
+// the chains config file. Chains missing a node, prefix or denom are looked up by name in Registry, a local
+// checkout of https://github.com/cosmos/chain-registry
+type Config struct {
+	Registry string  `json:"registry"`
+	Chains   []Chain `json:"chains"`
+}
+
+// the parts of a chain-registry chain.json we use
+type RegistryChain struct {
+	ChainName    string `json:"chain_name"`
+	Bech32Prefix string `json:"bech32_prefix"`
+	Staking      struct {
+		StakingTokens []struct {
+			Denom string `json:"denom"`
+		} `json:"staking_tokens"`
+	} `json:"staking"`
+	Apis struct {
+		Grpc []struct {
+			Address string `json:"address"`
+		} `json:"grpc"`
+	} `json:"apis"`
+}
+
+// reads a chains config file. The file is either a Config or a single chain-registry chain.json
+func LoadChains(path string) ([]Chain, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &fields); err != nil {
+		return nil, fmt.Errorf("invalid chains file %s: %w", path, err)
+	}
+
+	// a chain-registry chain.json always has a chain_name
+	if _, ok := fields["chain_name"]; ok {
+		var registryChain RegistryChain
+		if err := json.Unmarshal(contents, &registryChain); err != nil {
+			return nil, fmt.Errorf("invalid chain registry file %s: %w", path, err)
+		}
+
+		chain := Chain{}
+		mergeRegistryChain(&chain, &registryChain)
+
+		if err := chain.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid chain registry file %s: %w", path, err)
+		}
+
+		return []Chain{chain}, nil
+	}
+
+	config := Config{}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("invalid chains file %s: %w", path, err)
+	}
+
+	if len(config.Chains) == 0 {
+		return nil, fmt.Errorf("no chains in %s", path)
+	}
+
+	names := make(map[string]bool)
+
+	for i := range config.Chains {
+		chain := &config.Chains[i]
+
+		if config.Registry != "" && chain.Name != "" && (chain.Node == "" || chain.Bech32Prefix == "" || chain.Denom == "") {
+			registryChain, err := LoadRegistryChain(config.Registry, chain.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			mergeRegistryChain(chain, registryChain)
+		}
+
+		if err := chain.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid chain %d in %s: %w", i, path, err)
+		}
+
+		if names[chain.Name] {
+			return nil, fmt.Errorf("chain %s is listed more than once in %s", chain.Name, path)
+		}
+		names[chain.Name] = true
+	}
+
+	return config.Chains, nil
+}
+
+// reads <registry>/<name>/chain.json from a local chain-registry checkout
+func LoadRegistryChain(registry string, name string) (*RegistryChain, error) {
+	path := filepath.Join(registry, name, "chain.json")
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var registryChain RegistryChain
+	if err := json.Unmarshal(contents, &registryChain); err != nil {
+		return nil, fmt.Errorf("invalid chain registry file %s: %w", path, err)
+	}
+
+	return &registryChain, nil
+}
+
+// checks a chain has everything needed for a snapshot and defaults the output directory to the chain name
+func (chain *Chain) Validate() error {
+	if chain.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+
+	if chain.Node == "" {
+		return fmt.Errorf("chain %s: missing node", chain.Name)
+	}
+
+	if chain.OutputDir == "" {
+		chain.OutputDir = chain.Name
+	}
+
+	return nil
+}
+
+// fills in the fields of chain that are not set from the registry
+func mergeRegistryChain(chain *Chain, registryChain *RegistryChain) {
+	if chain.Name == "" {
+		chain.Name = registryChain.ChainName
+	}
+
+	if chain.Node == "" && len(registryChain.Apis.Grpc) > 0 {
+		chain.Node = registryChain.Apis.Grpc[0].Address
+	}
+
+	if chain.Bech32Prefix == "" {
+		chain.Bech32Prefix = registryChain.Bech32Prefix
+	}
+
+	if chain.Denom == "" && len(registryChain.Staking.StakingTokens) > 0 {
+		chain.Denom = registryChain.Staking.StakingTokens[0].Denom
+	}
+}
@@ -0,0 +1,88 @@
+package chains
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	REGISTRY_CHAIN =
+`{
+	"chain_name": "osmosis",
+	"bech32_prefix": "osmo",
+	"staking": {
+		"staking_tokens": [{"denom": "uosmo"}]
+	},
+	"apis": {
+		"rpc": [{"address": "https://rpc.osmosis.zone"}],
+		"grpc": [{"address": "grpc.osmosis.zone:9090"}, {"address": "osmosis-grpc.polkachu.com:12590"}]
+	}
+}`
+	)
+
+func writeFile(t *testing.T, path string, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadChains(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "registry", "osmosis", "chain.json"), REGISTRY_CHAIN)
+	writeFile(t, filepath.Join(dir, "chains.json"), `{
+		"registry": "`+filepath.Join(dir, "registry")+`",
+		"chains": [
+			{"name": "osmosis", "output_dir": "out/osmosis"},
+			{"name": "juno", "node": "https://lcd.juno.example", "bech32_prefix": "juno", "denom": "ujuno", "source": "rest"}
+		]
+	}`)
+
+	chains, err := LoadChains(filepath.Join(dir, "chains.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []Chain{
+		{Name: "osmosis", Node: "grpc.osmosis.zone:9090", Bech32Prefix: "osmo", Denom: "uosmo", OutputDir: "out/osmosis"},
+		{Name: "juno", Node: "https://lcd.juno.example", Bech32Prefix: "juno", Denom: "ujuno", OutputDir: "juno",
+			Source: "rest"},
+	}, chains)
+}
+
+func TestLoadChainsFromRegistryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.json")
+	writeFile(t, path, REGISTRY_CHAIN)
+
+	chains, err := LoadChains(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []Chain{
+		{Name: "osmosis", Node: "grpc.osmosis.zone:9090", Bech32Prefix: "osmo", Denom: "uosmo", OutputDir: "osmosis"},
+	}, chains)
+}
+
+func TestLoadChainsErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "missingNode.json"), `{"chains": [{"name": "juno"}]}`)
+	_, err := LoadChains(filepath.Join(dir, "missingNode.json"))
+	assert.Error(t, err)
+
+	writeFile(t, filepath.Join(dir, "duplicate.json"),
+		`{"chains": [{"name": "juno", "node": "a:9090"}, {"name": "juno", "node": "b:9090"}]}`)
+	_, err = LoadChains(filepath.Join(dir, "duplicate.json"))
+	assert.Error(t, err)
+
+	writeFile(t, filepath.Join(dir, "empty.json"), `{"chains": []}`)
+	_, err = LoadChains(filepath.Join(dir, "empty.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+)
+
+type interceptorsKey struct{}
+
+// a copy of ctx whose queries also go through interceptors. Interceptors added earlier wrap the ones added later
+func WithInterceptors(ctx context.Context, interceptors ...grpc.UnaryClientInterceptor) context.Context {
+	existing, _ := ctx.Value(interceptorsKey{}).([]grpc.UnaryClientInterceptor)
+
+	chain := make([]grpc.UnaryClientInterceptor, 0, len(existing)+len(interceptors))
+	chain = append(chain, existing...)
+	chain = append(chain, interceptors...)
+
+	return context.WithValue(ctx, interceptorsKey{}, chain)
+}
+
+// the options for dialing a node, with the interceptors carried by ctx
+func DialOptions(ctx context.Context) []grpc.DialOption {
+	options := []grpc.DialOption{
+		// The Cosmos SDK doesn't support any transport security mechanism.
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// This instantiates a general gRPC codec which handles proto bytes. We pass in a nil interface registry
+		// if the request/response types contain interface instead of 'nil' you should pass the application specific codec.
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec.NewProtoCodec(nil).GRPCCodec())),
+	}
+
+	if interceptors, ok := ctx.Value(interceptorsKey{}).([]grpc.UnaryClientInterceptor); ok && len(interceptors) > 0 {
+		options = append(options, grpc.WithChainUnaryInterceptor(interceptors...))
+	}
+
+	return options
+}
+
+// the first wait between retries, doubled for every further attempt
+var RetryBackoff = 500 * time.Millisecond
+
+// retries a call up to retries times when the node is unavailable or overloaded, waiting longer between every
+// attempt. onRetry (if not nil) is called before each retry
+func RetryInterceptor(retries int, onRetry func(method string, attempt int, err error)) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		backoff := RetryBackoff
+
+		for attempt := 0; ; attempt++ {
+			err := invoker(ctx, method, req, reply, conn, opts...)
+			if err == nil || attempt >= retries || !retryable(err) || ctx.Err() != nil {
+				return err
+			}
+
+			if onRetry != nil {
+				onRetry(method, attempt+1, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+}
+
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// the block height a node answered at, from the headers of its response. 0 if it didn't say
+func BlockHeight(header metadata.MD) int64 {
+	heights := header.Get(grpcTypes.GRPCBlockHeightHeader)
+	if len(heights) == 0 {
+		return 0
+	}
+
+	height, err := strconv.ParseInt(heights[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return height
+}
+
+// calls record with the block height of every successful response that has one
+func HeightInterceptor(record func(height int64)) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		var header metadata.MD
+
+		if err := invoker(ctx, method, req, reply, conn, append(opts, grpc.Header(&header))...); err != nil {
+			return err
+		}
+
+		if height := BlockHeight(header); height > 0 {
+			record(height)
+		}
+
+		return nil
+	}
+}
+
+type cachedKey struct{}
+
+// a copy of ctx in which an interceptor answering a query from a cache instead of the node can say so with
+// MarkCached. The returned func tells whether one did
+func WithCachedMarker(ctx context.Context) (context.Context, func() bool) {
+	cached := new(int32)
+	return context.WithValue(ctx, cachedKey{}, cached), func() bool { return atomic.LoadInt32(cached) == 1 }
+}
+
+// marks the query of ctx as answered from a cache, if an interceptor before asked with WithCachedMarker
+func MarkCached(ctx context.Context) {
+	if cached, ok := ctx.Value(cachedKey{}).(*int32); ok {
+		atomic.StoreInt32(cached, 1)
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fails with each of errs in turn and then succeeds
+func failing(calls *int, errs ...error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		opts ...grpc.CallOption,
+	) error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+
+		return nil
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	RetryBackoff = time.Millisecond
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	notFound := status.Error(codes.NotFound, "no validator")
+
+	var retried []int
+	interceptor := RetryInterceptor(2, func(method string, attempt int, err error) {
+		retried = append(retried, attempt)
+	})
+
+	calls := 0
+	assert.Nil(t, interceptor(context.Background(), "/m", nil, nil, nil, failing(&calls, unavailable, unavailable)))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []int{1, 2}, retried)
+
+	// out of retries
+	calls = 0
+	err := interceptor(context.Background(), "/m", nil, nil, nil, failing(&calls, unavailable, unavailable, unavailable))
+	assert.Equal(t, unavailable, err)
+	assert.Equal(t, 3, calls)
+
+	// only transient failures are retried
+	calls = 0
+	assert.Equal(t, notFound, interceptor(context.Background(), "/m", nil, nil, nil, failing(&calls, notFound)))
+	assert.Equal(t, 1, calls)
+
+	// nor after the run is interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	assert.Equal(t, unavailable, interceptor(ctx, "/m", nil, nil, nil, failing(&calls, unavailable)))
+	assert.Equal(t, 1, calls)
+}
+
+func TestWithInterceptors(t *testing.T) {
+	noop := func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		return invoker(ctx, method, req, reply, conn, opts...)
+	}
+
+	assert.Equal(t, 2, len(DialOptions(context.Background())))
+
+	ctx := WithInterceptors(context.Background(), noop)
+	ctx = WithInterceptors(ctx, noop)
+	assert.Equal(t, 2, len(ctx.Value(interceptorsKey{}).([]grpc.UnaryClientInterceptor)))
+	assert.Equal(t, 3, len(DialOptions(ctx)))
+}
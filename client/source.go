@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	gogogrpc "github.com/gogo/protobuf/grpc"
+	"google.golang.org/grpc"
+
+	codecTypes "github.com/cosmos/cosmos-sdk/codec/types"
+	cryptoCodec "github.com/cosmos/cosmos-sdk/crypto/codec"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// resolves the public keys of the validators decoded by sources
+var Registry = newRegistry()
+
+func newRegistry() codecTypes.InterfaceRegistry {
+	registry := codecTypes.NewInterfaceRegistry()
+	cryptoCodec.RegisterInterfaces(registry)
+
+	return registry
+}
+
+// the staking queries getData makes. A staking query client satisfies it. The height to query at is carried by the
+// context as the x-cosmos-block-height metadata, as for grpc
+type StakingQuerier interface {
+	Validators(ctx context.Context, in *stakingTypes.QueryValidatorsRequest, opts ...grpc.CallOption,
+	) (*stakingTypes.QueryValidatorsResponse, error)
+	ValidatorDelegations(ctx context.Context, in *stakingTypes.QueryValidatorDelegationsRequest,
+		opts ...grpc.CallOption) (*stakingTypes.QueryValidatorDelegationsResponse, error)
+	DelegatorDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorDelegationsRequest,
+		opts ...grpc.CallOption) (*stakingTypes.QueryDelegatorDelegationsResponse, error)
+	DelegatorUnbondingDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorUnbondingDelegationsRequest,
+		opts ...grpc.CallOption) (*stakingTypes.QueryDelegatorUnbondingDelegationsResponse, error)
+}
+
+// the distribution queries getData makes. A distribution query client satisfies it
+type DistributionQuerier interface {
+	DelegationTotalRewards(ctx context.Context, in *distributionTypes.QueryDelegationTotalRewardsRequest,
+		opts ...grpc.CallOption) (*distributionTypes.QueryDelegationTotalRewardsResponse, error)
+}
+
+// where the staking state of a node is read from when it isn't its grpc endpoint
+type Source interface {
+	// the staking queries of node. close releases whatever they hold
+	Staking(ctx context.Context, node string) (querier StakingQuerier, close func(), err error)
+	// the distribution queries of node. Sources that can't answer them return codes.Unimplemented from every query
+	Distribution(ctx context.Context, node string) (querier DistributionQuerier, close func(), err error)
+	// the height of the latest block of node
+	LatestHeight(ctx context.Context, node string) (int64, error)
+}
+
+// a source that can tell when a block was made. The offline sources can't
+type BlockTimeSource interface {
+	// the time of the block at height on node
+	BlockTime(ctx context.Context, node string, height int64) (time.Time, error)
+}
+
+// a source that can tell the chain id of a node
+type ChainIDSource interface {
+	ChainID(ctx context.Context, node string) (string, error)
+}
+
+type sourceKey struct{}
+
+// a copy of ctx whose queries are made through source instead of grpc
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// the source carried by ctx, nil for grpc
+func SourceFrom(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceKey{}).(Source)
+	return source
+}
+
+// the staking queries of node, through the source carried by ctx or else through a grpc connection made with dial
+// and newQueryClient. The modules pass their own dial and newQueryClient so tests can mock them
+func Staking(ctx context.Context, node string, dial func(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error),
+	newQueryClient func(conn gogogrpc.ClientConn) stakingTypes.QueryClient,
+) (StakingQuerier, func(), error) {
+	if source := SourceFrom(ctx); source != nil {
+		return source.Staking(ctx, node)
+	}
+
+	grpcConn, err := dial(node, DialOptions(ctx)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newQueryClient(grpcConn), func() {
+		// this is a hack to make testing work. I'm sure there is a better solution but I had to punt due to time
+		if grpcConn != nil {
+			grpcConn.Close()
+		}
+	}, nil
+}
+
+// the distribution queries of node, through the source carried by ctx or else through a grpc connection made with
+// dial and newQueryClient
+func Distribution(ctx context.Context, node string,
+	dial func(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error),
+	newQueryClient func(conn gogogrpc.ClientConn) distributionTypes.QueryClient,
+) (DistributionQuerier, func(), error) {
+	if source := SourceFrom(ctx); source != nil {
+		return source.Distribution(ctx, node)
+	}
+
+	grpcConn, err := dial(node, DialOptions(ctx)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newQueryClient(grpcConn), func() {
+		if grpcConn != nil {
+			grpcConn.Close()
+		}
+	}, nil
+}
+
+// makes a call through the interceptors carried by ctx, with invoker doing the actual call. This is how sources
+// that don't use a grpc connection still get retries, metrics and the rest
+func Invoke(ctx context.Context, method string, req, reply interface{}, invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	interceptors, _ := ctx.Value(interceptorsKey{}).([]grpc.UnaryClientInterceptor)
+
+	var chain func(i int) grpc.UnaryInvoker
+	chain = func(i int) grpc.UnaryInvoker {
+		if i == len(interceptors) {
+			return invoker
+		}
+
+		return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+			opts ...grpc.CallOption,
+		) error {
+			return interceptors[i](ctx, method, req, reply, conn, chain(i+1), opts...)
+		}
+	}
+
+	return chain(0)(ctx, method, req, reply, nil, opts...)
+}
+
+// resolves the public keys of validators decoded outside of a grpc connection, which are only known once unpacked
+func UnpackValidators(validators []stakingTypes.Validator) error {
+	for i := range validators {
+		if err := validators[i].UnpackInterfaces(Registry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	toml "github.com/pelletier/go-toml"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// environment variables overriding flags start with this, e.g. GETDATA_NODE or GETDATA_DELEGATIONS_FILE
+const EnvPrefix = "GETDATA_"
+
+// the key holding the named profiles in a config file
+const ProfilesKey = "profiles"
+
+// a config file. Values are keyed by flag name. Top level values apply to every command defining the flag and a
+// section named after a command only applies to that command. A profile has the same layout and is laid over the
+// top level values when selected
+type Config struct {
+	Values   map[string]string
+	Commands map[string]map[string]string
+	Profiles map[string]*Config
+}
+
+// reads a yaml, toml or json config file, picked by the file extension. flagNames holds the flags of every
+// subcommand by command name. Unknown keys are reported so typos don't go unnoticed
+func Load(path string, flagNames map[string]map[string]bool) (*Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		tree, err := toml.LoadBytes(contents)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		raw = tree.ToMap()
+	case ".json":
+		if err := json.Unmarshal(contents, &raw); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	default:
+		if err := yaml.Unmarshal(contents, &raw); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	config, err := parse(raw, flagNames, true)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+func parse(raw map[string]interface{}, flagNames map[string]map[string]bool, allowProfiles bool) (*Config, error) {
+	config := &Config{
+		Values:   make(map[string]string),
+		Commands: make(map[string]map[string]string),
+		Profiles: make(map[string]*Config),
+	}
+
+	allFlags := make(map[string]bool)
+	for _, names := range flagNames {
+		for name := range names {
+			allFlags[name] = true
+		}
+	}
+
+	for _, key := range sortedKeys(raw) {
+		value := raw[key]
+
+		if key == ProfilesKey && allowProfiles {
+			profiles, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s must be a map of profile names to settings", ProfilesKey)
+			}
+
+			for _, name := range sortedKeys(profiles) {
+				rawProfile, ok := profiles[name].(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("profile %s must be a map of settings", name)
+				}
+
+				profile, err := parse(rawProfile, flagNames, false)
+				if err != nil {
+					return nil, fmt.Errorf("profile %s: %w", name, err)
+				}
+
+				config.Profiles[name] = profile
+			}
+
+			continue
+		}
+
+		if names, ok := flagNames[key]; ok {
+			rawSection, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s must be a map of settings for the %s command", key, key)
+			}
+
+			section := make(map[string]string)
+			for _, name := range sortedKeys(rawSection) {
+				if !names[name] {
+					return nil, fmt.Errorf("%s.%s: the %s command has no such flag", key, name, key)
+				}
+
+				stringValue, err := toString(rawSection[name])
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", key, name, err)
+				}
+
+				section[name] = stringValue
+			}
+
+			config.Commands[key] = section
+			continue
+		}
+
+		if !allFlags[key] {
+			return nil, fmt.Errorf("%s: unknown setting", key)
+		}
+
+		stringValue, err := toString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		config.Values[key] = stringValue
+	}
+
+	return config, nil
+}
+
+// the values for a command with the profile applied. Later sources win: top level values, the command section, the
+// profile's top level values and then the profile's command section
+func (config *Config) Resolve(command string, profile string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	merge := func(source *Config) {
+		for name, value := range source.Values {
+			values[name] = value
+		}
+		for name, value := range source.Commands[command] {
+			values[name] = value
+		}
+	}
+
+	merge(config)
+
+	if profile != "" {
+		profileConfig, ok := config.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", profile)
+		}
+
+		merge(profileConfig)
+	}
+
+	return values, nil
+}
+
+// the environment variable for a flag, e.g. delegationsFile is GETDATA_DELEGATIONS_FILE. A run of capitals is one
+// word, so queryCacheMaxMB is GETDATA_QUERY_CACHE_MAX_MB
+func EnvName(flagName string) string {
+	var name strings.Builder
+	name.WriteString(EnvPrefix)
+
+	runes := []rune(flagName)
+	for i, r := range runes {
+		// a capital starts a word after a lower case letter, or ends a run of capitals when a lower case letter follows
+		if i > 0 && unicode.IsUpper(r) &&
+			(!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			name.WriteRune('_')
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+
+	return name.String()
+}
+
+// sets every flag that wasn't given on the command line from its environment variable or else the config values.
+// Invalid values are returned as errors before anything else runs
+func Apply(flags *flag.FlagSet, values map[string]string) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+
+		source := "config"
+		value, ok := values[f.Name]
+
+		if envValue, envOk := os.LookupEnv(EnvName(f.Name)); envOk {
+			source = EnvName(f.Name)
+			value, ok = envValue, true
+		}
+
+		if !ok {
+			return
+		}
+
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: invalid value %q for -%s: %w", source, value, f.Name, setErr)
+		}
+	})
+
+	return err
+}
+
+// config values are flag values so scalars are formatted and lists are joined with commas
+func toString(value interface{}) (string, error) {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue, nil
+	case []interface{}:
+		parts := make([]string, 0, len(typedValue))
+		for _, part := range typedValue {
+			stringPart, err := toString(part)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, stringPart)
+		}
+		return strings.Join(parts, ","), nil
+	case map[string]interface{}:
+		return "", fmt.Errorf("expected a value but got a map")
+	case nil:
+		return "", nil
+	case float64:
+		// json numbers
+		return strconv.FormatFloat(typedValue, 'f', -1, 64), nil
+	default:
+		return fmt.Sprint(typedValue), nil
+	}
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var flagNames = map[string]map[string]bool{
+	"snapshot":   {"node": true, "height": true, "prefixes": true},
+	"validators": {"node": true, "height": true, "validatorFile": true},
+}
+
+func writeConfig(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+node: grpc.osmosis.zone:9090
+height: 100
+snapshot:
+  prefixes: [cosmos, juno]
+profiles:
+  juno:
+    node: grpc.juno.example:9090
+    validators:
+      validatorFile: juno.csv
+`)
+
+	config, err := Load(path, flagNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := config.Resolve("snapshot", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]string{"node": "grpc.osmosis.zone:9090", "height": "100", "prefixes": "cosmos,juno"}, values)
+
+	values, err = config.Resolve("validators", "juno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]string{"node": "grpc.juno.example:9090", "height": "100", "validatorFile": "juno.csv"}, values)
+
+	_, err = config.Resolve("validators", "missing")
+	assert.Error(t, err)
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeConfig(t, "config.toml", `
+node = "grpc.osmosis.zone:9090"
+
+[validators]
+height = 5
+
+[profiles.juno]
+node = "grpc.juno.example:9090"
+`)
+
+	config, err := Load(path, flagNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := config.Resolve("validators", "juno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]string{"node": "grpc.juno.example:9090", "height": "5"}, values)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(writeConfig(t, "unknown.yaml", "nodes: grpc.osmosis.zone:9090\n"), flagNames)
+	assert.Error(t, err)
+
+	_, err = Load(writeConfig(t, "section.yaml", "validators:\n  prefixes: cosmos\n"), flagNames)
+	assert.Error(t, err)
+
+	_, err = Load(writeConfig(t, "profile.json", `{"profiles": {"juno": {"bogus": 1}}}`), flagNames)
+	assert.Error(t, err)
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "GETDATA_NODE", EnvName("node"))
+	assert.Equal(t, "GETDATA_DELEGATIONS_FILE", EnvName("delegationsFile"))
+	assert.Equal(t, "GETDATA_DISTRIBUTION_JSON_FILE", EnvName("distributionJsonFile"))
+	assert.Equal(t, "GETDATA_CHAIN_ID", EnvName("chainId"))
+	assert.Equal(t, "GETDATA_QUERY_CACHE_MAX_MB", EnvName("queryCacheMaxMB"))
+	assert.Equal(t, "GETDATA_GRPC_NODE", EnvName("GRPCNode"))
+}
+
+func TestApply(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	node := flags.String("node", "default", "")
+	height := flags.Int64("height", 0, "")
+	prefixes := flags.String("prefixes", "", "")
+
+	if err := flags.Parse([]string{"-node", "command-line"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GETDATA_HEIGHT", "42")
+
+	err := Apply(flags, map[string]string{"node": "config", "height": "1", "prefixes": "cosmos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the command line beats the environment which beats the config file
+	assert.Equal(t, "command-line", *node)
+	assert.Equal(t, int64(42), *height)
+	assert.Equal(t, "cosmos", *prefixes)
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int64("height", 0, "")
+
+	t.Setenv("GETDATA_HEIGHT", "forty two")
+	assert.Error(t, Apply(flags, map[string]string{}))
+}
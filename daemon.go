@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	blocksModule "github.com/brianosaurus/challenge1/blocks"
+	daemonModule "github.com/brianosaurus/challenge1/daemon"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	metricsModule "github.com/brianosaurus/challenge1/metrics"
+)
+
+// the daemon subcommand keeps running and takes a snapshot on a schedule or every few blocks, each into its own
+// directory under -dir. Old snapshots are pruned by the retention flags and failed ones are recorded in
+// failures.jsonl without stopping the daemon
+func daemonCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var dir string
+	var every time.Duration
+	var cron string
+	var everyBlocks int64
+	var pollInterval time.Duration
+	var retention daemonModule.Retention
+	options := snapshotOptions{}
+
+	addConnectionFlags(flags, &options.connectionOptions)
+	addSnapshotFlags(flags, &options)
+	flags.StringVar(&dir, "dir", "snapshots", "the directory every snapshot gets a directory in")
+	flags.DurationVar(&every, "every", 0, "take a snapshot this often (e.g. 6h), aligned to the clock")
+	flags.StringVar(&cron, "cron", "", "take a snapshot on this UTC cron schedule (e.g. \"0 */6 * * *\")")
+	flags.Int64Var(&everyBlocks, "everyBlocks", 0, "take a snapshot at every block height divisible by this")
+	flags.DurationVar(&pollInterval, "pollInterval", 30*time.Second,
+		"how often the latest height is checked with -everyBlocks")
+	flags.IntVar(&retention.KeepLast, "keep", 0, "keep the newest this many snapshots, 0 with -keepDaily 0 keeps all")
+	flags.IntVar(&retention.KeepDaily, "keepDaily", 0, "also keep the newest snapshot of each of the last this many days")
+
+	return func(ctx context.Context) error {
+		daemonOptions := daemonModule.Options{
+			Dir:          dir,
+			EveryBlocks:  everyBlocks,
+			PollInterval: pollInterval,
+			Retention:    retention,
+			LatestHeight: func(ctx context.Context) (int64, error) {
+				return blocksModule.GetLatestHeight(ctx, options.node)
+			},
+			Snapshot: func(ctx context.Context, height int64, dir string) error {
+				snapshotOptions := options.inDir(dir)
+				snapshotOptions.height = height
+				// an interrupted snapshot is thrown away, the next one starts over
+				snapshotOptions.checkpointFile = ""
+				snapshotOptions.resume = false
+
+				if _, err := runSnapshot(ctx, snapshotOptions); err != nil {
+					return err
+				}
+
+				metricsModule.FromContext(ctx).Succeeded(height)
+				return nil
+			},
+		}
+
+		if options.height > 0 {
+			return failuresModule.Errorf(failuresModule.Config, "-height can't be used with daemon, every snapshot is taken at the latest height")
+		}
+
+		switch {
+		case every > 0 && cron != "":
+			return failuresModule.Errorf(failuresModule.Config, "-every and -cron can't be used together")
+		case every > 0:
+			daemonOptions.Schedule = daemonModule.Every(every)
+		case cron != "":
+			schedule, err := daemonModule.ParseCron(cron)
+			if err != nil {
+				return failuresModule.Wrap(failuresModule.Config, err)
+			}
+			daemonOptions.Schedule = schedule
+		}
+
+		if err := daemonOptions.Validate(); err != nil {
+			return failuresModule.Wrap(failuresModule.Config, err)
+		}
+
+		// so the latest height is read through the source too
+		ctx, err := options.withSource(ctx)
+		if err != nil {
+			return err
+		}
+
+		loggingModule.FromContext(ctx).Info("starting daemon", "node", options.node, "dir", dir)
+
+		return daemonModule.Run(ctx, daemonOptions)
+	}
+}
@@ -0,0 +1,254 @@
+// Package daemon takes snapshots on a schedule, each into its own directory named after its time and block height,
+// and prunes the old ones. A failed snapshot is recorded and the daemon carries on with the next one
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+)
+
+// the file in the daemon's directory every failed snapshot is appended to, one json object per line
+const FailuresFile = "failures.jsonl"
+
+// added to the directory of a snapshot until it is complete
+const inProgressSuffix = ".inprogress"
+
+// the options of a daemon. Either Schedule or EveryBlocks is set
+type Options struct {
+	// where the snapshot directories are written
+	Dir string
+	// when to take snapshots
+	Schedule Schedule
+	// take a snapshot at every height divisible by EveryBlocks
+	EveryBlocks int64
+	// how often the latest height is checked when taking snapshots every few blocks
+	PollInterval time.Duration
+	Retention    Retention
+
+	// the height of the latest block
+	LatestHeight func(ctx context.Context) (int64, error)
+	// takes a snapshot at height and writes it into dir, which already exists
+	Snapshot func(ctx context.Context, height int64, dir string) error
+
+	// so tests can move the clock
+	now func() time.Time
+}
+
+// a failed snapshot as recorded in FailuresFile
+type Failure struct {
+	Time    time.Time `json:"time"`
+	Height  int64     `json:"height"`
+	Class   string    `json:"class"`
+	Error   string    `json:"error"`
+	Elapsed string    `json:"elapsed"`
+}
+
+// takes snapshots until ctx is done. Only errors setting up the directory are returned, failed snapshots are
+// recorded and logged
+func Run(ctx context.Context, options Options) error {
+	if options.now == nil {
+		options.now = time.Now
+	}
+
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	if options.EveryBlocks > 0 {
+		runEveryBlocks(ctx, options)
+	} else {
+		runOnSchedule(ctx, options)
+	}
+
+	return nil
+}
+
+func runOnSchedule(ctx context.Context, options Options) {
+	logger := loggingModule.FromContext(ctx)
+
+	for {
+		next := options.Schedule.Next(options.now())
+		logger.Info("next snapshot", "at", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// the snapshot is pinned to the latest height so all of its queries see the same block
+		height, err := options.LatestHeight(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			options.recordFailure(ctx, Failure{Time: options.now(), Class: failuresModule.ClassOf(err).String(),
+				Error: err.Error(), Elapsed: "0s"})
+			continue
+		}
+
+		options.takeSnapshot(ctx, height)
+	}
+}
+
+func runEveryBlocks(ctx context.Context, options Options) {
+	logger := loggingModule.FromContext(ctx)
+	ticker := time.NewTicker(options.PollInterval)
+	defer ticker.Stop()
+
+	var target int64
+
+	for {
+		latest, err := options.LatestHeight(ctx)
+
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Error("getting the latest height failed", "err", err)
+		case err != nil:
+		case target == 0:
+			target = (latest/options.EveryBlocks + 1) * options.EveryBlocks
+			logger.Info("next snapshot", "height", target)
+		case latest >= target:
+			// if blocks were missed while the last snapshot ran only the latest of them is taken
+			height := latest / options.EveryBlocks * options.EveryBlocks
+			if height > target {
+				logger.Info("skipping missed snapshots", "from", target, "to", height-options.EveryBlocks)
+			}
+
+			options.takeSnapshot(ctx, height)
+
+			target = height + options.EveryBlocks
+			logger.Info("next snapshot", "height", target)
+		default:
+			logger.Debug("waiting for the next snapshot", "latest", latest, "height", target)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// takes a snapshot into a directory of its own. It is written under a temporary name and renamed once complete so
+// a directory with a snapshot name is always whole
+func (options Options) takeSnapshot(ctx context.Context, height int64) {
+	logger := loggingModule.FromContext(ctx)
+	start := options.now()
+	name := Name(start, height)
+	dir := filepath.Join(options.Dir, name)
+	inProgress := dir + inProgressSuffix
+
+	logger.Info("taking snapshot", "height", height, "dir", dir)
+
+	err := os.Mkdir(inProgress, 0o755)
+	if err == nil {
+		err = options.Snapshot(ctx, height, inProgress)
+	} else {
+		err = failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	if err == nil {
+		err = failuresModule.Wrap(failuresModule.IO, os.Rename(inProgress, dir))
+	}
+
+	if err != nil {
+		os.RemoveAll(inProgress)
+
+		// stopping the daemon is not a failure of the snapshot
+		if ctx.Err() == nil {
+			options.recordFailure(ctx, Failure{Time: start, Height: height, Class: failuresModule.ClassOf(err).String(),
+				Error: err.Error(), Elapsed: loggingModule.Since(start).String()})
+		}
+
+		return
+	}
+
+	logger.Info("snapshot done", "height", height, "dir", dir, "elapsed", loggingModule.Since(start))
+
+	if err := options.prune(ctx); err != nil {
+		logger.Error("pruning old snapshots failed", "err", err)
+	}
+}
+
+// logs a failure and appends it to the failures file
+func (options Options) recordFailure(ctx context.Context, failure Failure) {
+	logger := loggingModule.FromContext(ctx)
+	logger.Error("snapshot failed", "height", failure.Height, "class", failure.Class, "err", failure.Error)
+
+	line, err := json.Marshal(failure)
+	if err != nil {
+		logger.Error("recording the failure failed", "err", err)
+		return
+	}
+
+	file, err := os.OpenFile(filepath.Join(options.Dir, FailuresFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logger.Error("recording the failure failed", "err", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		logger.Error("recording the failure failed", "err", err)
+	}
+}
+
+// removes the snapshot directories the retention doesn't keep. Only directories named by Name are touched
+func (options Options) prune(ctx context.Context) error {
+	entries, err := Entries(options.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range options.Retention.Expired(entries, options.now()) {
+		loggingModule.FromContext(ctx).Info("removing old snapshot", "dir", entry.Name, "height", entry.Height)
+
+		if err := os.RemoveAll(filepath.Join(options.Dir, entry.Name)); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	return nil
+}
+
+// the snapshot directories in dir
+func Entries(dir string) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		if entry, ok := ParseName(file.Name()); ok && file.IsDir() {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// checks that exactly one of a schedule or a number of blocks is set
+func (options Options) Validate() error {
+	switch {
+	case options.Schedule == nil && options.EveryBlocks <= 0:
+		return fmt.Errorf("the daemon needs a schedule or a number of blocks between snapshots")
+	case options.Schedule != nil && options.EveryBlocks > 0:
+		return fmt.Errorf("the daemon takes either a schedule or a number of blocks between snapshots, not both")
+	case options.EveryBlocks > 0 && options.PollInterval <= 0:
+		return fmt.Errorf("the poll interval must be positive")
+	default:
+		return nil
+	}
+}
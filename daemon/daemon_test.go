@@ -0,0 +1,210 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+func utc(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestEvery(t *testing.T) {
+	schedule := Every(6 * time.Hour)
+	assert.Equal(t, utc("2022-11-01T06:00:00Z"), schedule.Next(utc("2022-11-01T05:59:59Z")))
+	assert.Equal(t, utc("2022-11-01T12:00:00Z"), schedule.Next(utc("2022-11-01T06:00:00Z")))
+}
+
+func TestParseCron(t *testing.T) {
+	cases := []struct {
+		expression string
+		after      string
+		next       string
+	}{
+		{"*/15 * * * *", "2022-11-01T10:07:30Z", "2022-11-01T10:15:00Z"},
+		{"0 */6 * * *", "2022-11-01T10:07:00Z", "2022-11-01T12:00:00Z"},
+		{"30 2 * * *", "2022-11-01T02:30:00Z", "2022-11-02T02:30:00Z"},
+		{"0 0 1 * *", "2022-12-15T00:00:00Z", "2023-01-01T00:00:00Z"},
+		// 2022-11-06 is a sunday, 7 is sunday too
+		{"0 12 * * 0", "2022-11-01T00:00:00Z", "2022-11-06T12:00:00Z"},
+		{"0 12 * * 7", "2022-11-01T00:00:00Z", "2022-11-06T12:00:00Z"},
+		{"0 9 * * 1-5", "2022-11-04T10:00:00Z", "2022-11-07T09:00:00Z"},
+		// either day matches when both are restricted
+		{"0 0 15 * 0", "2022-11-01T00:00:00Z", "2022-11-06T00:00:00Z"},
+		{"0 0 29 2 *", "2022-03-01T00:00:00Z", "2024-02-29T00:00:00Z"},
+		{"5,35 1 * * *", "2022-11-01T01:06:00Z", "2022-11-01T01:35:00Z"},
+	}
+
+	for _, c := range cases {
+		schedule, err := ParseCron(c.expression)
+		if err != nil {
+			t.Fatal(c.expression, err)
+		}
+		assert.Equal(t, utc(c.next), schedule.Next(utc(c.after)), c.expression)
+	}
+
+	for _, expression := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *",
+		"5-1 * * * *", "0 0 31 2 *"} {
+		_, err := ParseCron(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestName(t *testing.T) {
+	name := Name(utc("2022-11-01T12:00:05Z"), 7000000)
+	assert.Equal(t, "20221101T120005Z-h7000000", name)
+
+	entry, ok := ParseName(name)
+	assert.True(t, ok)
+	assert.Equal(t, Entry{Name: name, Time: utc("2022-11-01T12:00:05Z"), Height: 7000000}, entry)
+
+	for _, name := range []string{"failures.jsonl", "20221101T120005Z-h7000000.inprogress", "latest", "x-h1"} {
+		_, ok := ParseName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := utc("2022-11-03T18:00:00Z")
+	var entries []Entry
+	for _, at := range []string{
+		"2022-10-30T06:00:00Z",
+		"2022-11-01T06:00:00Z", "2022-11-01T18:00:00Z",
+		"2022-11-02T06:00:00Z", "2022-11-02T18:00:00Z",
+		"2022-11-03T06:00:00Z", "2022-11-03T12:00:00Z",
+	} {
+		entries = append(entries, Entry{Name: at, Time: utc(at)})
+	}
+
+	names := func(entries []Entry) []string {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	assert.Nil(t, Retention{}.Expired(entries, now))
+
+	assert.Equal(t, []string{"2022-10-30T06:00:00Z", "2022-11-01T06:00:00Z", "2022-11-01T18:00:00Z",
+		"2022-11-02T06:00:00Z", "2022-11-02T18:00:00Z"}, names(Retention{KeepLast: 2}.Expired(entries, now)))
+
+	// the newest of today, yesterday and the day before
+	assert.Equal(t, []string{"2022-10-30T06:00:00Z", "2022-11-01T06:00:00Z", "2022-11-02T06:00:00Z",
+		"2022-11-03T06:00:00Z"}, names(Retention{KeepDaily: 3}.Expired(entries, now)))
+
+	assert.Equal(t, []string{"2022-10-30T06:00:00Z", "2022-11-01T06:00:00Z", "2022-11-02T06:00:00Z"},
+		names(Retention{KeepLast: 2, KeepDaily: 3}.Expired(entries, now)))
+}
+
+func TestTakeSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	now := utc("2022-11-03T12:00:00Z")
+
+	options := Options{
+		Dir:       dir,
+		Retention: Retention{KeepLast: 1},
+		now:       func() time.Time { return now },
+		Snapshot: func(ctx context.Context, height int64, dir string) error {
+			if height == 13 {
+				return failuresModule.Errorf(failuresModule.Network, "connection refused")
+			}
+			return os.WriteFile(filepath.Join(dir, "validators.csv"), []byte("moniker\n"), 0o644)
+		},
+	}
+
+	options.takeSnapshot(context.Background(), 11)
+	now = now.Add(time.Hour)
+	options.takeSnapshot(context.Background(), 12)
+	now = now.Add(time.Hour)
+	options.takeSnapshot(context.Background(), 13)
+
+	entries, err := Entries(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "20221103T130000Z-h12", entries[0].Name)
+
+	_, err = os.Stat(filepath.Join(dir, entries[0].Name, "validators.csv"))
+	assert.Nil(t, err)
+
+	// the failed snapshot leaves nothing but its record
+	files, _ := os.ReadDir(dir)
+	assert.Equal(t, 2, len(files))
+
+	file, err := os.Open(filepath.Join(dir, FailuresFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	assert.True(t, scanner.Scan())
+
+	var failure Failure
+	assert.Nil(t, json.Unmarshal(scanner.Bytes(), &failure))
+	assert.Equal(t, int64(13), failure.Height)
+	assert.Equal(t, "network", failure.Class)
+	assert.Equal(t, "connection refused", failure.Error)
+	assert.False(t, scanner.Scan())
+}
+
+func TestRunEveryBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	latest := []int64{95, 99, 100, 101, 250, 260}
+	var taken []int64
+
+	options := Options{
+		Dir:          t.TempDir(),
+		EveryBlocks:  50,
+		PollInterval: time.Millisecond,
+		LatestHeight: func(ctx context.Context) (int64, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if len(latest) == 0 {
+				cancel()
+				return 0, errors.New("done")
+			}
+			height := latest[0]
+			latest = latest[1:]
+			return height, nil
+		},
+		Snapshot: func(ctx context.Context, height int64, dir string) error {
+			taken = append(taken, height)
+			return nil
+		},
+	}
+
+	assert.Nil(t, options.Validate())
+	assert.Nil(t, Run(ctx, options))
+
+	// 150 and 200 were missed while waiting
+	assert.Equal(t, []int64{100, 250}, taken)
+
+	_, err := os.Stat(filepath.Join(options.Dir, FailuresFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestValidate(t *testing.T) {
+	assert.Error(t, Options{}.Validate())
+	assert.Error(t, Options{Schedule: Every(time.Hour), EveryBlocks: 10, PollInterval: time.Second}.Validate())
+	assert.Nil(t, Options{Schedule: Every(time.Hour)}.Validate())
+}
@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the layout of the time in the name of a snapshot directory
+const nameTimeLayout = "20060102T150405Z"
+
+// a snapshot directory written by the daemon
+type Entry struct {
+	Name   string
+	Time   time.Time
+	Height int64
+}
+
+// the name of the directory of a snapshot taken at t and height, e.g. 20221101T120000Z-h7000000. Names sort by time
+func Name(t time.Time, height int64) string {
+	return fmt.Sprintf("%s-h%d", t.UTC().Format(nameTimeLayout), height)
+}
+
+// the entry of a snapshot directory name, false if the name wasn't made by Name
+func ParseName(name string) (Entry, bool) {
+	timePart, heightPart, ok := strings.Cut(name, "-h")
+	if !ok {
+		return Entry{}, false
+	}
+
+	t, err := time.Parse(nameTimeLayout, timePart)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	height, err := strconv.ParseInt(heightPart, 10, 64)
+	if err != nil || height < 0 {
+		return Entry{}, false
+	}
+
+	return Entry{Name: name, Time: t, Height: height}, true
+}
+
+// which snapshots are kept. A snapshot is kept if it is one of the KeepLast newest or the newest of one of the last
+// KeepDaily days, today included. If both are 0 every snapshot is kept
+type Retention struct {
+	KeepLast  int
+	KeepDaily int
+}
+
+// the entries the retention doesn't keep, oldest first
+func (retention Retention) Expired(entries []Entry, now time.Time) []Entry {
+	if retention.KeepLast <= 0 && retention.KeepDaily <= 0 {
+		return nil
+	}
+
+	sorted := append([]Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	keep := make(map[string]bool)
+
+	for i := 0; i < retention.KeepLast && i < len(sorted); i++ {
+		keep[sorted[i].Name] = true
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	oldestDay := today.AddDate(0, 0, -(retention.KeepDaily - 1))
+	days := make(map[time.Time]bool)
+
+	for _, entry := range sorted {
+		day := entry.Time.UTC().Truncate(24 * time.Hour)
+		if retention.KeepDaily > 0 && !day.Before(oldestDay) && !days[day] {
+			days[day] = true
+			keep[entry.Name] = true
+		}
+	}
+
+	var expired []Entry
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if !keep[sorted[i].Name] {
+			expired = append(expired, sorted[i])
+		}
+	}
+
+	return expired
+}
@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// when the snapshots of a daemon are taken. Times are in UTC
+type Schedule interface {
+	// the first time after after
+	Next(after time.Time) time.Time
+}
+
+type every time.Duration
+
+// a snapshot every interval, aligned to multiples of it since the unix epoch so 1h runs on the hour
+func Every(interval time.Duration) Schedule {
+	return every(interval)
+}
+
+func (interval every) Next(after time.Time) time.Time {
+	return after.UTC().Truncate(time.Duration(interval)).Add(time.Duration(interval))
+}
+
+// a cron expression: minute, hour, day of month, month and day of week. Every field takes *, a number, a range
+// (1-5), a step (*/15 or 0-30/10) or a comma separated list of those. As in cron, if both days are restricted a
+// time matches either of them
+type cron struct {
+	minutes, hours, days, months, weekdays []bool
+	anyDay, anyWeekday                     bool
+}
+
+// the longest cron looks ahead for a matching time before giving up, e.g. for 0 0 30 2 *
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// parses a cron expression such as "0 */6 * * *"
+func ParseCron(expression string) (Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields (minute hour day month weekday), got %d",
+			expression, len(fields))
+	}
+
+	schedule := &cron{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron minute: %w", err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron hour: %w", err)
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron day of month: %w", err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron month: %w", err)
+	}
+	// 7 is sunday too
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron day of week: %w", err)
+	}
+	schedule.weekdays[0] = schedule.weekdays[0] || schedule.weekdays[7]
+
+	if schedule.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", expression)
+	}
+
+	return schedule, nil
+}
+
+// the values a field matches, indexed by value
+func parseCronField(field string, min int, max int) ([]bool, error) {
+	values := make([]bool, max+1)
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			parsed, err := strconv.Atoi(part[i+1:])
+			if err != nil || parsed < 1 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			rangePart, step = part[:i], parsed
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 to the end in steps of 15
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func (schedule *cron) Next(after time.Time) time.Time {
+	next := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	horizon := next.Add(cronHorizon)
+
+	for next.Before(horizon) {
+		switch {
+		case !schedule.months[next.Month()]:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !schedule.matchesDay(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !schedule.hours[next.Hour()]:
+			next = next.Truncate(time.Hour).Add(time.Hour)
+		case !schedule.minutes[next.Minute()]:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+
+	// never matches, e.g. the 31st of february
+	return time.Time{}
+}
+
+func (schedule *cron) matchesDay(t time.Time) bool {
+	day, weekday := schedule.days[t.Day()], schedule.weekdays[t.Weekday()]
+
+	switch {
+	case schedule.anyDay && schedule.anyWeekday:
+		return true
+	case schedule.anyDay:
+		return weekday
+	case schedule.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+func TestDaemonCommand(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-dir", dir, "-everyBlocks", "1", "-pollInterval", "10ms", "-progress", "off",
+		"-multipleDelegationsFile", ""}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	fixture, err := live(t, ctx, &stubSource{moving: true}, "daemon", args...)
+	assert.Nil(t, err)
+
+	snapshots, _ := filepath.Glob(filepath.Join(dir, "*", "delegations.csv"))
+	assert.NotEmpty(t, snapshots)
+
+	replayDir := t.TempDir()
+	assert.Nil(t, replay(fixture, "daemon", "-timeout", "500ms", "-dir", replayDir, "-everyBlocks", "1",
+		"-pollInterval", "10ms", "-progress", "off", "-multipleDelegationsFile", ""))
+
+	// the replayed latest block stops moving at the last recorded one
+	replayed, _ := filepath.Glob(filepath.Join(replayDir, "*", "delegations.csv"))
+	assert.NotEmpty(t, replayed)
+	if len(snapshots) > 0 && len(replayed) > 0 {
+		assert.Equal(t, readFile(t, snapshots[0]), readFile(t, replayed[0]))
+	}
+
+	err = replay(fixture, "daemon", "-height", "7000000", "-everyBlocks", "1")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+	err = replay(fixture, "daemon", "-every", "1h", "-cron", "0 * * * *")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
@@ -2,14 +2,18 @@ package delegations
 
 import (
 	"context"
-	"fmt"
 	big "math/big"
+	"strconv"
+	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 
-	"github.com/cosmos/cosmos-sdk/codec"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	"github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
 
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
 	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
 	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
 )
@@ -34,64 +38,119 @@ type DelegationsWithTotalBalance map[string]DelegationResponsesWithTotalBalance
 // or batching. This is a known issue and will be fixed in the future.
 // See: https://github.com/cosmos/cosmos-sdk/issues/8591 and
 // https://github.com/terra-money/classic-core/issues/694 for discussions on this issue
-func GetDelegationResponses(node string, validators *delegationTypes.Validators) (*delegationTypes.DelegationResponses, error) {
+func GetDelegationResponses(ctx context.Context, node string, validators *delegationTypes.Validators,
+) (*delegationTypes.DelegationResponses, error) {
+	return GetDelegationResponsesAtHeight(ctx, node, validators, 0)
+}
+
+// collect all delegation responses for all validators at the given block height. A height of 0 queries the latest block
+func GetDelegationResponsesAtHeight(ctx context.Context, node string, validators *delegationTypes.Validators, height int64,
+) (*delegationTypes.DelegationResponses, error) {
+	return GetDelegationResponsesWithProgress(ctx, node, validators, height, Progress{})
+}
+
+// the callbacks of a crawl, either can be nil
+type Progress struct {
+	// called after every page of delegations with the number of delegations on it
+	Page func(validator delegationTypes.Validator, page int, rows int)
+	// called once all the delegations of a validator have been fetched
+	Validator func(validator delegationTypes.Validator, delegationResponses delegationTypes.DelegationResponses)
+}
+
+func (progress Progress) page(validator delegationTypes.Validator, page int, rows int) {
+	if progress.Page != nil {
+		progress.Page(validator, page, rows)
+	}
+}
+
+// collect all delegation responses for all validators at the given block height, calling the progress callbacks
+// along the way. The crawl stops when ctx is done and the responses collected so far are returned with the error
+func GetDelegationResponsesWithProgress(ctx context.Context, node string, validators *delegationTypes.Validators,
+	height int64, progress Progress,
+) (*delegationTypes.DelegationResponses, error) {
 	delegationResponses := delegationTypes.DelegationResponses{}
+	logger := loggingModule.FromContext(ctx)
+	start := time.Now()
 
-	// Create a connection to the gRPC server.
-	grpcConn, err := GrpcDial(
-		node, // your gRPC server address.
-		grpc.WithTransportCredentials(insecure.NewCredentials()), // The Cosmos SDK doesn't support any transport security mechanism.
-		// This instantiates a general gRPC codec which handles proto bytes. We pass in a nil interface registry
-		// if the request/response types contain interface instead of 'nil' you should pass the application specific codec.
-		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec.NewProtoCodec(nil).GRPCCodec())),
-	)
+	// Create a connection to the gRPC server, or whichever source ctx carries.
+	delegationResponsesClient, closeClient, err := clientModule.Staking(ctx, node, GrpcDial, DelegationTypesNewQueryClient)
 	if err != nil {
-		return &delegationResponses, err
+		return &delegationResponses, failures.Wrap(failures.Network, err)
 	}
+	defer closeClient()
 
-	// this is a hack to make testing work. I'm sure there is a better solution but I had to punt due to time
-	if grpcConn != nil {
-		defer grpcConn.Close()
+	if height > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, grpcTypes.GRPCBlockHeightHeader, strconv.FormatInt(height, 10))
 	}
-	delegationResponsesClient := DelegationTypesNewQueryClient(grpcConn)
 
 	for _, validator := range *validators {
-		fmt.Println("Getting delegation responses for validator:", validator.Description.Moniker)
+		if err := ctx.Err(); err != nil {
+			return &delegationResponses, queryError(delegationResponses, err)
+		}
+
+		validatorLogger := logger.With("validator", validator.OperatorAddress, "moniker", validator.Description.Moniker)
+		validatorLogger.Debug("getting delegations")
+		validatorStart := len(delegationResponses)
+		validatorStartTime := time.Now()
+		page := 1
 
 		delegationResponsesResult, err := delegationResponsesClient.ValidatorDelegations(
-			context.Background(),
+			ctx,
 			&delegationTypes.QueryValidatorDelegationsRequest{
 				ValidatorAddr: validator.OperatorAddress,
 				Pagination:    &queryTypes.PageRequest{Limit: 10000},
 			},
 		)
 		if err != nil {
-			return &delegationResponses, err
+			return &delegationResponses, queryError(delegationResponses, err)
 		}
 
 		delegationResponses = append(delegationResponses, delegationResponsesResult.DelegationResponses...)
+		validatorLogger.Debug("fetched delegations page", "page", page, "rows", len(delegationResponsesResult.DelegationResponses))
+		progress.page(validator, page, len(delegationResponsesResult.DelegationResponses))
 
 		for delegationResponsesResult.Pagination != nil && delegationResponsesResult.Pagination.NextKey != nil {
 			delegationResponsesResult, err = delegationResponsesClient.ValidatorDelegations(
-				context.Background(),
+				ctx,
 				&delegationTypes.QueryValidatorDelegationsRequest{
 					ValidatorAddr: validator.OperatorAddress,
 					Pagination:    &queryTypes.PageRequest{Limit: 10000, Key: delegationResponsesResult.Pagination.NextKey},
 				},
 			)
 			if err != nil {
-				return &delegationResponses, err
+				return &delegationResponses, queryError(delegationResponses, err)
 			}
 
+			page++
 			delegationResponses = append(delegationResponses, delegationResponsesResult.DelegationResponses...)
+			validatorLogger.Debug("fetched delegations page", "page", page, "rows", len(delegationResponsesResult.DelegationResponses))
+			progress.page(validator, page, len(delegationResponsesResult.DelegationResponses))
+		}
+
+		validatorLogger.Info("fetched delegations", "pages", page, "rows", len(delegationResponses)-validatorStart,
+			"elapsed", loggingModule.Since(validatorStartTime))
+
+		if progress.Validator != nil {
+			progress.Validator(validator, delegationResponses[validatorStart:])
 		}
 	}
 
+	logger.Info("fetched all delegations", "validators", len(*validators), "rows", len(delegationResponses),
+		"elapsed", loggingModule.Since(start))
+
 	return &delegationResponses, nil
 }
 
+// a failed query only loses part of the data once some delegations were collected
+func queryError(delegationResponses delegationTypes.DelegationResponses, err error) error {
+	if len(delegationResponses) > 0 {
+		return failures.Wrap(failures.PartialData, err)
+	}
+
+	return failures.Wrap(failures.Network, err)
+}
+
 func GetDelegationsWithTotalBalance(delegationResponses *delegationTypes.DelegationResponses) *DelegationsWithTotalBalance {
-	fmt.Println("Collecting delegations")
 	delegationsMap := make(DelegationsWithTotalBalance)
 
 	for _, delegationResponse := range *delegationResponses {
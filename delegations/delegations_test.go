@@ -108,7 +108,7 @@ func TestGetDelegationResponses(t *testing.T) {
 		t.Error(err)
 	}
 
-	delegationResponses, err := GetDelegationResponses("node value not needed", &validators)
+	delegationResponses, err := GetDelegationResponses(context.Background(), "node value not needed", &validators)
 	if err != nil {
 		t.Error(err)
 	}
@@ -135,7 +135,7 @@ func TestGetDelegationsWithTotalBalance(t *testing.T) {
 		t.Error(err)
 	}
 
-	delegationResponses, err := GetDelegationResponses("node value not needed", &validators)
+	delegationResponses, err := GetDelegationResponses(context.Background(), "node value not needed", &validators)
 	if err != nil {
 		t.Error(err)
 	}
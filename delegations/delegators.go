@@ -0,0 +1,169 @@
+package delegations
+
+import (
+	"context"
+	big "math/big"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	"github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// this is so we can mock out the distribution grpc client for testing
+var DistributionTypesNewQueryClient = distributionTypes.NewQueryClient
+
+// what a delegator has staked, is unbonding and has earned
+type DelegatorHoldings struct {
+	DelegatorAddress     string
+	DelegationResponses  delegationTypes.DelegationResponses
+	UnbondingDelegations delegationTypes.UnbondingDelegations
+	// the pending rewards of every delegation, nil if the node can't tell
+	Rewards sdk.DecCoins
+}
+
+// the total balance of every unbonding entry of the delegator
+func (holdings DelegatorHoldings) Unbonding() *big.Int {
+	total := new(big.Int)
+
+	for _, unbondingDelegation := range holdings.UnbondingDelegations {
+		for _, entry := range unbondingDelegation.Entries {
+			total.Add(total, entry.Balance.BigInt())
+		}
+	}
+
+	return total
+}
+
+// fetches the delegations, unbonding delegations and rewards of each delegator at the given block height, 0 for the
+// latest block. Sources that can't tell the rewards leave them nil. progress, if not nil, is called after every
+// delegator. The crawl stops when ctx is done and the holdings collected so far are returned with the error
+func GetDelegatorHoldings(ctx context.Context, node string, delegators []string, height int64,
+	progress func(holdings DelegatorHoldings),
+) ([]DelegatorHoldings, error) {
+	holdings := make([]DelegatorHoldings, 0, len(delegators))
+	logger := loggingModule.FromContext(ctx)
+	start := time.Now()
+
+	stakingClient, closeStaking, err := clientModule.Staking(ctx, node, GrpcDial, DelegationTypesNewQueryClient)
+	if err != nil {
+		return holdings, failures.Wrap(failures.Network, err)
+	}
+	defer closeStaking()
+
+	distributionClient, closeDistribution, err := clientModule.Distribution(ctx, node, GrpcDial,
+		DistributionTypesNewQueryClient)
+	if err != nil {
+		return holdings, failures.Wrap(failures.Network, err)
+	}
+	defer closeDistribution()
+
+	if height > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, grpcTypes.GRPCBlockHeightHeader, strconv.FormatInt(height, 10))
+	}
+
+	rewardsAvailable := true
+
+	for _, delegator := range delegators {
+		if err := ctx.Err(); err != nil {
+			return holdings, holdingsError(holdings, err)
+		}
+
+		delegatorHoldings := DelegatorHoldings{DelegatorAddress: delegator}
+
+		var nextKey []byte
+		for page := 1; page == 1 || len(nextKey) > 0; page++ {
+			result, err := stakingClient.DelegatorDelegations(ctx, &delegationTypes.QueryDelegatorDelegationsRequest{
+				DelegatorAddr: delegator,
+				Pagination:    &queryTypes.PageRequest{Limit: 1000, Key: nextKey},
+			})
+			if err != nil {
+				return holdings, holdingsError(holdings, err)
+			}
+
+			delegatorHoldings.DelegationResponses = append(delegatorHoldings.DelegationResponses,
+				result.DelegationResponses...)
+			nextKey = nil
+			if result.Pagination != nil {
+				nextKey = result.Pagination.NextKey
+			}
+		}
+
+		for page := 1; page == 1 || len(nextKey) > 0; page++ {
+			result, err := stakingClient.DelegatorUnbondingDelegations(ctx,
+				&delegationTypes.QueryDelegatorUnbondingDelegationsRequest{
+					DelegatorAddr: delegator,
+					Pagination:    &queryTypes.PageRequest{Limit: 1000, Key: nextKey},
+				})
+			if err != nil {
+				return holdings, holdingsError(holdings, err)
+			}
+
+			delegatorHoldings.UnbondingDelegations = append(delegatorHoldings.UnbondingDelegations,
+				result.UnbondingResponses...)
+			nextKey = nil
+			if result.Pagination != nil {
+				nextKey = result.Pagination.NextKey
+			}
+		}
+
+		if rewardsAvailable {
+			result, err := distributionClient.DelegationTotalRewards(ctx,
+				&distributionTypes.QueryDelegationTotalRewardsRequest{DelegatorAddress: delegator})
+
+			switch {
+			case status.Code(err) == codes.Unimplemented:
+				logger.Info("the rewards aren't available from this node, they are left out", "err", err)
+				rewardsAvailable = false
+			case err != nil:
+				return holdings, holdingsError(holdings, err)
+			default:
+				delegatorHoldings.Rewards = result.Total
+			}
+		}
+
+		logger.Debug("fetched delegator", "delegator", delegator,
+			"delegations", len(delegatorHoldings.DelegationResponses),
+			"unbonding_delegations", len(delegatorHoldings.UnbondingDelegations))
+
+		holdings = append(holdings, delegatorHoldings)
+		if progress != nil {
+			progress(delegatorHoldings)
+		}
+	}
+
+	logger.Info("fetched all delegators", "delegators", len(holdings), "elapsed", loggingModule.Since(start))
+
+	return holdings, nil
+}
+
+// a failed query only loses part of the data once some delegators were collected
+func holdingsError(holdings []DelegatorHoldings, err error) error {
+	if len(holdings) > 0 {
+		return failures.Wrap(failures.PartialData, err)
+	}
+
+	return failures.Wrap(failures.Network, err)
+}
+
+// the delegation responses of every delegator, in order
+func HoldingsDelegationResponses(holdings []DelegatorHoldings) *delegationTypes.DelegationResponses {
+	delegationResponses := delegationTypes.DelegationResponses{}
+
+	for _, delegatorHoldings := range holdings {
+		delegationResponses = append(delegationResponses, delegatorHoldings.DelegationResponses...)
+	}
+
+	return &delegationResponses
+}
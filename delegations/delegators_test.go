@@ -0,0 +1,161 @@
+package delegations
+
+import (
+	"context"
+	"testing"
+
+	grpc1 "github.com/gogo/protobuf/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/brianosaurus/challenge1/failures"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	query "github.com/cosmos/cosmos-sdk/types/query"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	DELEGATOR          = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"
+	OTHER_VALIDATOR    = "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v"
+	FAILING_DELEGATOR  = "osmo1failing"
+	VALIDATOR_ADDRESS  = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	UNBONDING_BALANCE  = 5
+	DELEGATION_BALANCE = 10
+)
+
+type delegatorQueryClient struct {
+	delegationTypes.QueryClient
+}
+
+// two pages of one delegation each for DELEGATOR, nothing for anyone else. The last page has an empty but not nil
+// next key, as some nodes answer
+func (q *delegatorQueryClient) DelegatorDelegations(ctx context.Context, in *delegationTypes.QueryDelegatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*delegationTypes.QueryDelegatorDelegationsResponse, error) {
+	if in.DelegatorAddr == FAILING_DELEGATOR {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	if in.DelegatorAddr != DELEGATOR {
+		return &delegationTypes.QueryDelegatorDelegationsResponse{}, nil
+	}
+
+	validator, nextKey := VALIDATOR_ADDRESS, []byte{1}
+	if in.Pagination.Key != nil {
+		validator, nextKey = OTHER_VALIDATOR, []byte{}
+	}
+
+	return &delegationTypes.QueryDelegatorDelegationsResponse{
+		DelegationResponses: delegationTypes.DelegationResponses{{
+			Delegation: delegationTypes.Delegation{DelegatorAddress: in.DelegatorAddr, ValidatorAddress: validator,
+				Shares: sdk.NewDec(DELEGATION_BALANCE)},
+			Balance: sdk.NewInt64Coin("uosmo", DELEGATION_BALANCE),
+		}},
+		Pagination: &query.PageResponse{NextKey: nextKey},
+	}, nil
+}
+
+func (q *delegatorQueryClient) DelegatorUnbondingDelegations(ctx context.Context,
+	in *delegationTypes.QueryDelegatorUnbondingDelegationsRequest, opts ...grpc.CallOption,
+) (*delegationTypes.QueryDelegatorUnbondingDelegationsResponse, error) {
+	if in.DelegatorAddr != DELEGATOR {
+		return &delegationTypes.QueryDelegatorUnbondingDelegationsResponse{}, nil
+	}
+
+	return &delegationTypes.QueryDelegatorUnbondingDelegationsResponse{
+		UnbondingResponses: delegationTypes.UnbondingDelegations{{
+			DelegatorAddress: in.DelegatorAddr,
+			ValidatorAddress: VALIDATOR_ADDRESS,
+			Entries: []delegationTypes.UnbondingDelegationEntry{
+				{Balance: sdk.NewInt(UNBONDING_BALANCE)}, {Balance: sdk.NewInt(UNBONDING_BALANCE)},
+			},
+		}},
+	}, nil
+}
+
+type distributionQueryClient struct {
+	distributionTypes.QueryClient
+	unimplemented bool
+}
+
+func (q *distributionQueryClient) DelegationTotalRewards(ctx context.Context,
+	in *distributionTypes.QueryDelegationTotalRewardsRequest, opts ...grpc.CallOption,
+) (*distributionTypes.QueryDelegationTotalRewardsResponse, error) {
+	if q.unimplemented {
+		return nil, status.Error(codes.Unimplemented, "unimplemented")
+	}
+
+	return &distributionTypes.QueryDelegationTotalRewardsResponse{
+		Total: sdk.NewDecCoins(sdk.NewDecCoinFromDec("uosmo", sdk.NewDecWithPrec(125, 1))),
+	}, nil
+}
+
+func stubDelegatorHoldings(unimplemented bool) {
+	GrpcDial = func(node string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		return nil, nil
+	}
+
+	DelegationTypesNewQueryClient = func(conn grpc1.ClientConn) delegationTypes.QueryClient {
+		return &delegatorQueryClient{}
+	}
+
+	DistributionTypesNewQueryClient = func(conn grpc1.ClientConn) distributionTypes.QueryClient {
+		return &distributionQueryClient{unimplemented: unimplemented}
+	}
+}
+
+func TestGetDelegatorHoldings(t *testing.T) {
+	stubDelegatorHoldings(false)
+
+	var progressed []string
+	holdings, err := GetDelegatorHoldings(context.Background(), "node value not needed",
+		[]string{DELEGATOR, "osmo1nodelegations"}, 0, func(holdings DelegatorHoldings) {
+			progressed = append(progressed, holdings.DelegatorAddress)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{DELEGATOR, "osmo1nodelegations"}, progressed)
+	assert.Equal(t, 2, len(holdings))
+	assert.Equal(t, 2, len(holdings[0].DelegationResponses))
+	assert.Equal(t, OTHER_VALIDATOR, holdings[0].DelegationResponses[1].Delegation.ValidatorAddress)
+	assert.Equal(t, "10", holdings[0].Unbonding().String())
+	assert.Equal(t, "12.500000000000000000uosmo", holdings[0].Rewards.String())
+	assert.Equal(t, 0, len(holdings[1].DelegationResponses))
+	assert.Equal(t, "0", holdings[1].Unbonding().String())
+
+	delegationResponses := HoldingsDelegationResponses(holdings)
+	assert.Equal(t, 2, len(*delegationResponses))
+	assert.Equal(t, "20", (*GetDelegationsWithTotalBalance(delegationResponses))[DELEGATOR].TotalBalance.String())
+}
+
+func TestGetDelegatorHoldingsWithoutRewards(t *testing.T) {
+	stubDelegatorHoldings(true)
+
+	holdings, err := GetDelegatorHoldings(context.Background(), "node value not needed",
+		[]string{DELEGATOR, DELEGATOR}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, len(holdings))
+	assert.Nil(t, holdings[0].Rewards)
+	assert.Nil(t, holdings[1].Rewards)
+}
+
+func TestGetDelegatorHoldingsFailure(t *testing.T) {
+	stubDelegatorHoldings(false)
+
+	holdings, err := GetDelegatorHoldings(context.Background(), "node value not needed", []string{FAILING_DELEGATOR}, 0, nil)
+	assert.Equal(t, 0, len(holdings))
+	assert.Equal(t, failures.Network, failures.ClassOf(err))
+
+	holdings, err = GetDelegatorHoldings(context.Background(), "node value not needed",
+		[]string{DELEGATOR, FAILING_DELEGATOR}, 0, nil)
+	assert.Equal(t, 1, len(holdings))
+	assert.Equal(t, failures.PartialData, failures.ClassOf(err))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	big "math/big"
+	"os"
+
+	diffModule "github.com/brianosaurus/challenge1/diff"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+)
+
+// writes the delegators whose voting power changed between two snapshots to a csv file
+func WriteDelegationChanges(changes []diffModule.Change, writer *csv.Writer) error {
+	if err := writer.Write([]string{"delegator", "status", "old_voting_power", "new_voting_power", "change"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, change := range changes {
+		if err := writer.Write([]string{
+			change.Delegator,
+			change.Status,
+			change.Old.String(),
+			change.New.String(),
+			change.Change.String(),
+		}); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// the diff subcommand compares the delegations csv files of two snapshots of the same chain
+func diffCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var oldFile string
+	var newFile string
+	var outputFile string
+
+	flags.StringVar(&oldFile, "old", "", "the delegations csv of the older snapshot (required)")
+	flags.StringVar(&newFile, "new", "", "the delegations csv of the newer snapshot (required)")
+	flags.StringVar(&outputFile, "output", "delegationChanges.csv", "the output file for the changes csv")
+
+	return func(ctx context.Context) error {
+		if oldFile == "" || newFile == "" {
+			return failuresModule.Errorf(failuresModule.Config, "diff: -old and -new are required")
+		}
+
+		oldAmounts, err := readDelegationAmountsFile(oldFile)
+		if err != nil {
+			return err
+		}
+
+		newAmounts, err := readDelegationAmountsFile(newFile)
+		if err != nil {
+			return err
+		}
+
+		loggingModule.FromContext(ctx).Info("writing delegation changes", "file", outputFile)
+
+		changesFile, err := os.OpenFile(outputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer changesFile.Close()
+
+		return WriteDelegationChanges(diffModule.Diff(oldAmounts, newAmounts), csv.NewWriter(changesFile))
+	}
+}
+
+func readDelegationAmountsFile(path string) (map[string]*big.Int, error) {
+	balances, err := readDelegationBalancesFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	amounts, err := diffModule.ParseBalances(balances)
+	if err != nil {
+		return nil, failuresModule.Errorf(failuresModule.IO, "%s: %w", path, err)
+	}
+
+	return amounts, nil
+}
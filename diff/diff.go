@@ -0,0 +1,92 @@
+package diff
+
+import (
+	"fmt"
+	big "math/big"
+	"sort"
+
+	addressesModule "github.com/brianosaurus/challenge1/addresses"
+)
+
+// the kinds of change between two snapshots
+const (
+	StatusAdded   = "added"
+	StatusRemoved = "removed"
+	StatusChanged = "changed"
+)
+
+// a delegator whose voting power differs between two snapshots
+type Change struct {
+	Delegator string   `json:"delegator"`
+	Old       *big.Int `json:"old"`
+	New       *big.Int `json:"new"`
+	Change    *big.Int `json:"change"`
+	Status    string   `json:"status"`
+}
+
+// turns the balances read from a delegations csv into amounts by delegator
+func ParseBalances(balances []addressesModule.Balance) (map[string]*big.Int, error) {
+	amounts := make(map[string]*big.Int, len(balances))
+
+	for _, balance := range balances {
+		amount, ok := new(big.Int).SetString(balance.Balance, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid voting power %q for %s", balance.Balance, balance.Address)
+		}
+
+		amounts[balance.Address] = amount
+	}
+
+	return amounts, nil
+}
+
+// lists every delegator whose voting power changed, largest absolute change first. Delegators missing from a
+// snapshot count as zero there
+func Diff(oldAmounts map[string]*big.Int, newAmounts map[string]*big.Int) []Change {
+	changes := make([]Change, 0)
+
+	for delegator, oldAmount := range oldAmounts {
+		newAmount, ok := newAmounts[delegator]
+		if !ok {
+			changes = append(changes, Change{
+				Delegator: delegator,
+				Old:       oldAmount,
+				New:       new(big.Int),
+				Change:    new(big.Int).Neg(oldAmount),
+				Status:    StatusRemoved,
+			})
+			continue
+		}
+
+		if oldAmount.Cmp(newAmount) != 0 {
+			changes = append(changes, Change{
+				Delegator: delegator,
+				Old:       oldAmount,
+				New:       newAmount,
+				Change:    new(big.Int).Sub(newAmount, oldAmount),
+				Status:    StatusChanged,
+			})
+		}
+	}
+
+	for delegator, newAmount := range newAmounts {
+		if _, ok := oldAmounts[delegator]; !ok {
+			changes = append(changes, Change{
+				Delegator: delegator,
+				Old:       new(big.Int),
+				New:       newAmount,
+				Change:    new(big.Int).Set(newAmount),
+				Status:    StatusAdded,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if cmp := new(big.Int).Abs(changes[i].Change).Cmp(new(big.Int).Abs(changes[j].Change)); cmp != 0 {
+			return cmp > 0
+		}
+		return changes[i].Delegator < changes[j].Delegator
+	})
+
+	return changes
+}
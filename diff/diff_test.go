@@ -0,0 +1,51 @@
+package diff
+
+import (
+	big "math/big"
+	"testing"
+
+	addressesModule "github.com/brianosaurus/challenge1/addresses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBalances(t *testing.T) {
+	amounts, err := ParseBalances([]addressesModule.Balance{{Address: "osmo1a", Balance: "10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "10", amounts["osmo1a"].String())
+
+	_, err = ParseBalances([]addressesModule.Balance{{Address: "osmo1a", Balance: "ten"}})
+	assert.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	oldAmounts := map[string]*big.Int{
+		"osmo1same":    big.NewInt(10),
+		"osmo1up":      big.NewInt(10),
+		"osmo1removed": big.NewInt(5),
+	}
+	newAmounts := map[string]*big.Int{
+		"osmo1same":  big.NewInt(10),
+		"osmo1up":    big.NewInt(30),
+		"osmo1added": big.NewInt(20),
+	}
+
+	changes := Diff(oldAmounts, newAmounts)
+
+	assert.Equal(t, 3, len(changes))
+
+	assert.Equal(t, "osmo1added", changes[0].Delegator)
+	assert.Equal(t, StatusAdded, changes[0].Status)
+	assert.Equal(t, "20", changes[0].Change.String())
+
+	assert.Equal(t, "osmo1up", changes[1].Delegator)
+	assert.Equal(t, StatusChanged, changes[1].Status)
+	assert.Equal(t, "20", changes[1].Change.String())
+
+	assert.Equal(t, "osmo1removed", changes[2].Delegator)
+	assert.Equal(t, StatusRemoved, changes[2].Status)
+	assert.Equal(t, "-5", changes[2].Change.String())
+	assert.Equal(t, "0", changes[2].New.String())
+}
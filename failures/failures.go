@@ -0,0 +1,97 @@
+package failures
+
+import (
+	"errors"
+	"fmt"
+)
+
+// what kind of failure an error is, so callers such as cron alerting can tell them apart
+type Class int
+
+const (
+	Unknown Class = iota
+	// bad flags, config files or rule files, found before any work is done
+	Config
+	// a node could not be reached or a query failed before any data came back
+	Network
+	// some data was fetched but the run could not finish
+	PartialData
+	// reading or writing a file failed
+	IO
+)
+
+// the exit code for each class. 1 is left for unknown errors and 2 matches the flag package's usage errors
+var exitCodes = map[Class]int{
+	Unknown:     1,
+	Config:      2,
+	Network:     3,
+	PartialData: 4,
+	IO:          5,
+}
+
+func (class Class) String() string {
+	switch class {
+	case Config:
+		return "config"
+	case Network:
+		return "network"
+	case PartialData:
+		return "partial data"
+	case IO:
+		return "io"
+	default:
+		return "unknown"
+	}
+}
+
+// an error tagged with its class
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// tags err with a class. nil stays nil and an error that already has a class keeps it
+func Wrap(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	return &Error{Class: class, Err: err}
+}
+
+// formats a new error of the given class
+func Errorf(class Class, format string, args ...interface{}) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, args...)}
+}
+
+// the class of the first classified error in err's chain
+func ClassOf(err error) Class {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+
+	return Unknown
+}
+
+// the process exit code for err, 0 for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	return exitCodes[ClassOf(err)]
+}
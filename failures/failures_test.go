@@ -0,0 +1,34 @@
+package failures
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrap(t *testing.T) {
+	assert.Nil(t, Wrap(IO, nil))
+
+	base := errors.New("connection refused")
+	err := Wrap(Network, base)
+	assert.Equal(t, Network, ClassOf(err))
+	assert.True(t, errors.Is(err, base))
+	assert.Equal(t, "connection refused", err.Error())
+
+	// an error keeps the class it was first given
+	assert.Equal(t, Network, ClassOf(Wrap(IO, err)))
+	assert.Equal(t, Network, ClassOf(fmt.Errorf("validators: %w", err)))
+
+	assert.Equal(t, Unknown, ClassOf(base))
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, 1, ExitCode(errors.New("unclassified")))
+	assert.Equal(t, 2, ExitCode(Errorf(Config, "bad flag")))
+	assert.Equal(t, 3, ExitCode(Errorf(Network, "unreachable")))
+	assert.Equal(t, 4, ExitCode(Errorf(PartialData, "%d of %d chains failed", 1, 2)))
+	assert.Equal(t, 5, ExitCode(Errorf(IO, "disk full")))
+}
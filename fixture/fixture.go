@@ -0,0 +1,156 @@
+// Package fixture records the queries of a run and their responses into a fixture file, and replays them as a source
+// so the same run can be repeated offline. Writers and aggregation can then be tested against the data of a real
+// chain instead of hand written json
+package fixture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+)
+
+// requests and responses are kept as proto json so fixtures can be read and edited
+var jsonCodec = codec.NewProtoCodec(clientModule.Registry)
+
+// a query and what the node answered
+type Call struct {
+	Method string `json:"method"`
+	// the height the query was pinned to, 0 for the latest block
+	Height  int64           `json:"height"`
+	Request json.RawMessage `json:"request"`
+	// the response and the height the node answered at, unless the query failed
+	Response   json.RawMessage `json:"response,omitempty"`
+	AnsweredAt int64           `json:"answered_at,omitempty"`
+	// the grpc status code and message of a failed query
+	Code    uint32 `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// the contents of a fixture file
+type Fixture struct {
+	Calls []Call `json:"calls"`
+}
+
+// reads a fixture file
+func Load(path string) (*Fixture, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	fixture := &Fixture{}
+	if err := json.Unmarshal(contents, fixture); err != nil {
+		return nil, failuresModule.Errorf(failuresModule.Config, "fixture %s: %w", path, err)
+	}
+
+	return fixture, nil
+}
+
+// writes the fixture to path, ordered by method, height and request so recordings of the same run diff cleanly
+func (fixture *Fixture) Save(path string) error {
+	calls := append([]Call(nil), fixture.Calls...)
+	sort.SliceStable(calls, func(i, j int) bool {
+		if calls[i].Method != calls[j].Method {
+			return calls[i].Method < calls[j].Method
+		}
+		if calls[i].Height != calls[j].Height {
+			return calls[i].Height < calls[j].Height
+		}
+		return bytes.Compare(calls[i].Request, calls[j].Request) < 0
+	})
+
+	contents, err := json.MarshalIndent(Fixture{Calls: calls}, "", "  ")
+	if err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	if err := os.WriteFile(path+".tmp", append(contents, '\n'), 0o644); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	return failuresModule.Wrap(failuresModule.IO, os.Rename(path+".tmp", path))
+}
+
+// records the queries made through its interceptor
+type Recorder struct {
+	mutex sync.Mutex
+	calls []Call
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// an interceptor recording every query with a protobuf request and response, and what the node answered. It should
+// be the first interceptor so retried queries are recorded once, with their final outcome
+func (recorder *Recorder) Interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		var header metadata.MD
+		err := invoker(ctx, method, req, reply, conn, append(opts, grpc.Header(&header))...)
+
+		request, isRequest := req.(proto.Message)
+		response, isResponse := reply.(proto.Message)
+
+		// a query cut short by the run ending says nothing about the node
+		if !isRequest || !isResponse || ctx.Err() != nil {
+			return err
+		}
+
+		outgoing, _ := metadata.FromOutgoingContext(ctx)
+		call := Call{Method: method, Height: clientModule.BlockHeight(outgoing)}
+
+		var marshalErr error
+		if call.Request, marshalErr = jsonCodec.MarshalJSON(request); marshalErr != nil {
+			return err
+		}
+
+		if err != nil {
+			call.Code = uint32(status.Code(err))
+			call.Message = status.Convert(err).Message()
+		} else {
+			if call.Response, marshalErr = jsonCodec.MarshalJSON(response); marshalErr != nil {
+				return err
+			}
+			call.AnsweredAt = clientModule.BlockHeight(header)
+		}
+
+		recorder.mutex.Lock()
+		recorder.calls = append(recorder.calls, call)
+		recorder.mutex.Unlock()
+
+		return err
+	}
+}
+
+// the queries recorded so far
+func (recorder *Recorder) Fixture() *Fixture {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return &Fixture{Calls: append([]Call(nil), recorder.calls...)}
+}
+
+// the error a recorded query failed with, nil if it succeeded
+func (call *Call) err() error {
+	if call.Code == uint32(codes.OK) {
+		return nil
+	}
+
+	return status.Error(codes.Code(call.Code), call.Message)
+}
@@ -0,0 +1,221 @@
+package fixture
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+
+	"github.com/cosmos/cosmos-sdk/client/grpc/tmservice"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	VALIDATOR = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	DELEGATOR = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"
+)
+
+// a node answering at the height it was asked for, or 7000000 for the latest block
+func liveNode(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+	opts ...grpc.CallOption,
+) error {
+	height := "7000000"
+	if outgoing, _ := metadata.FromOutgoingContext(ctx); len(outgoing.Get(grpcTypes.GRPCBlockHeightHeader)) > 0 {
+		height = outgoing.Get(grpcTypes.GRPCBlockHeightHeader)[0]
+	}
+
+	for _, opt := range opts {
+		if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+			*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader, height)
+		}
+	}
+
+	switch reply := reply.(type) {
+	case *stakingTypes.QueryValidatorsResponse:
+		*reply = stakingTypes.QueryValidatorsResponse{
+			Validators: stakingTypes.Validators{{OperatorAddress: VALIDATOR, Tokens: sdk.NewInt(30),
+				DelegatorShares: sdk.NewDec(30), Description: stakingTypes.Description{Moniker: "Inotel"}}},
+			Pagination: &queryTypes.PageResponse{Total: 1},
+		}
+	case *stakingTypes.QueryValidatorDelegationsResponse:
+		*reply = stakingTypes.QueryValidatorDelegationsResponse{
+			DelegationResponses: stakingTypes.DelegationResponses{
+				{
+					Delegation: stakingTypes.Delegation{DelegatorAddress: DELEGATOR, ValidatorAddress: VALIDATOR,
+						Shares: sdk.NewDec(10)},
+					Balance: sdk.NewInt64Coin("uosmo", 10),
+				},
+				{
+					Delegation: stakingTypes.Delegation{DelegatorAddress: "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+						ValidatorAddress: VALIDATOR, Shares: sdk.NewDec(20)},
+					Balance: sdk.NewInt64Coin("uosmo", 20),
+				},
+			},
+			Pagination: &queryTypes.PageResponse{Total: 2},
+		}
+	case *tmservice.GetLatestBlockResponse:
+		*reply = tmservice.GetLatestBlockResponse{
+			SdkBlock: &tmservice.Block{Header: tmservice.Header{Height: 7000000, ChainID: "osmosis-1"}},
+		}
+	case *distributionTypes.QueryDelegationTotalRewardsResponse:
+		return status.Error(codes.Unimplemented, "unknown service cosmos.distribution.v1beta1.Query")
+	}
+
+	return nil
+}
+
+// a source answering through liveNode, as a live node would
+type liveSource struct{}
+
+func (liveSource) Staking(ctx context.Context, node string) (clientModule.StakingQuerier, func(), error) {
+	return &querier{invoke: liveNode}, func() {}, nil
+}
+
+func (liveSource) Distribution(ctx context.Context, node string) (clientModule.DistributionQuerier, func(), error) {
+	return &querier{invoke: liveNode}, func() {}, nil
+}
+
+func (liveSource) LatestHeight(ctx context.Context, node string) (int64, error) {
+	return 7000000, nil
+}
+
+func atHeight(ctx context.Context, height string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, grpcTypes.GRPCBlockHeightHeader, height)
+}
+
+func record(t *testing.T, calls func(ctx context.Context)) *Source {
+	recorder := NewRecorder()
+	calls(clientModule.WithInterceptors(context.Background(), recorder.Interceptor()))
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := recorder.Fixture().Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewSource(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return source
+}
+
+func TestRecordReplay(t *testing.T) {
+	request := &stakingTypes.QueryValidatorDelegationsRequest{ValidatorAddr: VALIDATOR}
+	recorded := &stakingTypes.QueryValidatorDelegationsResponse{}
+
+	source := record(t, func(ctx context.Context) {
+		clientModule.Invoke(atHeight(ctx, "6000000"), validatorDelegationsMethod, request, recorded, liveNode)
+		clientModule.Invoke(ctx, latestBlockMethod, &tmservice.GetLatestBlockRequest{},
+			&tmservice.GetLatestBlockResponse{}, liveNode)
+		clientModule.Invoke(ctx, totalRewardsMethod,
+			&distributionTypes.QueryDelegationTotalRewardsRequest{DelegatorAddress: DELEGATOR},
+			&distributionTypes.QueryDelegationTotalRewardsResponse{}, liveNode)
+
+		// the queries without a protobuf request and those cut short by the run ending aren't recorded
+		clientModule.Invoke(ctx, "/tendermint.rpc/status", nil, nil, liveNode)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		clientModule.Invoke(canceled, validatorsMethod, &stakingTypes.QueryValidatorsRequest{},
+			&stakingTypes.QueryValidatorsResponse{}, liveNode)
+	})
+	assert.Equal(t, 3, len(source.calls))
+
+	var heights []int64
+	ctx := clientModule.WithInterceptors(context.Background(),
+		clientModule.HeightInterceptor(func(height int64) { heights = append(heights, height) }))
+
+	staking, _, _ := source.Staking(ctx, "anywhere")
+	replayed, err := staking.ValidatorDelegations(atHeight(ctx, "6000000"), request)
+	assert.Nil(t, err)
+	assert.Equal(t, recorded.String(), replayed.String())
+	assert.Equal(t, []int64{6000000}, heights)
+
+	// asked again it is answered again
+	_, err = staking.ValidatorDelegations(atHeight(ctx, "6000000"), request)
+	assert.Nil(t, err)
+
+	// another height or request wasn't recorded
+	_, err = staking.ValidatorDelegations(atHeight(ctx, "6000001"), request)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = staking.ValidatorDelegations(atHeight(ctx, "6000000"),
+		&stakingTypes.QueryValidatorDelegationsRequest{ValidatorAddr: VALIDATOR, Pagination: &queryTypes.PageRequest{Limit: 1}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// a failed query fails the same way
+	distribution, _, _ := source.Distribution(ctx, "anywhere")
+	_, err = distribution.DelegationTotalRewards(ctx,
+		&distributionTypes.QueryDelegationTotalRewardsRequest{DelegatorAddress: DELEGATOR})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	height, err := source.LatestHeight(ctx, "anywhere")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7000000), height)
+
+	chainID, err := source.ChainID(ctx, "anywhere")
+	assert.Nil(t, err)
+	assert.Equal(t, "osmosis-1", chainID)
+
+	_, err = source.BlockTime(ctx, "anywhere", 7000000)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestReplayOrder(t *testing.T) {
+	source, err := NewSource(&Fixture{Calls: []Call{
+		{Method: latestBlockMethod, Request: []byte("{}"), Response: []byte(`{"sdk_block":{"header":{"height":"10"}}}`)},
+		{Method: latestBlockMethod, Request: []byte(" { } "), Response: []byte(`{"sdk_block":{"header":{"height":"11"}}}`)},
+	}})
+	assert.Nil(t, err)
+
+	// the recorded answers in order, then the last one again
+	for _, expected := range []int64{10, 11, 11} {
+		height, err := source.LatestHeight(context.Background(), "anywhere")
+		assert.Nil(t, err)
+		assert.Equal(t, expected, height)
+	}
+
+	_, err = NewSource(&Fixture{Calls: []Call{{Method: latestBlockMethod, Request: []byte("{")}}})
+	assert.Error(t, err)
+}
+
+func TestReplaySnapshot(t *testing.T) {
+	var live *snapshotModule.Snapshot
+
+	source := record(t, func(ctx context.Context) {
+		var err error
+		live, err = snapshotModule.Fetch(clientModule.WithSource(ctx, liveSource{}),
+			snapshotModule.Options{Node: "grpc.osmosis.zone:9090", Height: 6000000})
+		assert.Nil(t, err)
+	})
+
+	replayed, err := snapshotModule.Fetch(clientModule.WithSource(context.Background(), source),
+		snapshotModule.Options{Node: "offline", Height: 6000000})
+	assert.Nil(t, err)
+
+	assert.Equal(t, live.Totals(), replayed.Totals())
+	assert.Equal(t, int64(6000000), replayed.BlockHeight)
+	assert.Equal(t, "Inotel", (*replayed.Validators)[0].Description.Moniker)
+	assert.Equal(t, live.DelegationResponses.String(), replayed.DelegationResponses.String())
+
+	// a height that wasn't recorded can't be replayed
+	_, err = snapshotModule.Fetch(clientModule.WithSource(context.Background(), source),
+		snapshotModule.Options{Node: "offline", Height: 6000001})
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package fixture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+
+	"github.com/cosmos/cosmos-sdk/client/grpc/tmservice"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the methods a source answers, as the grpc endpoint names them
+const (
+	validatorsMethod           = "/cosmos.staking.v1beta1.Query/Validators"
+	validatorDelegationsMethod = "/cosmos.staking.v1beta1.Query/ValidatorDelegations"
+	delegatorDelegationsMethod = "/cosmos.staking.v1beta1.Query/DelegatorDelegations"
+	unbondingDelegationsMethod = "/cosmos.staking.v1beta1.Query/DelegatorUnbondingDelegations"
+	totalRewardsMethod         = "/cosmos.distribution.v1beta1.Query/DelegationTotalRewards"
+	latestBlockMethod          = "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock"
+	blockByHeightMethod        = "/cosmos.base.tendermint.v1beta1.Service/GetBlockByHeight"
+)
+
+// a source answering every query from a fixture, whatever the node. A query asked more often than it was recorded
+// gets the last recorded answer again, one that wasn't recorded fails with codes.NotFound
+type Source struct {
+	mutex sync.Mutex
+	calls map[string][]Call
+	next  map[string]int
+}
+
+func NewSource(fixture *Fixture) (*Source, error) {
+	source := &Source{calls: make(map[string][]Call), next: make(map[string]int)}
+
+	for _, call := range fixture.Calls {
+		key, err := callKey(call.Method, call.Height, call.Request)
+		if err != nil {
+			return nil, err
+		}
+		source.calls[key] = append(source.calls[key], call)
+	}
+
+	return source, nil
+}
+
+// the key of a query. The request is compacted so an indented fixture matches
+func callKey(method string, height int64, request []byte) (string, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, request); err != nil {
+		return "", err
+	}
+
+	return method + "\x00" + strconv.FormatInt(height, 10) + "\x00" + compact.String(), nil
+}
+
+func (source *Source) Staking(ctx context.Context, node string) (clientModule.StakingQuerier, func(), error) {
+	return &querier{invoke: source.invoke}, func() {}, nil
+}
+
+func (source *Source) Distribution(ctx context.Context, node string) (clientModule.DistributionQuerier, func(), error) {
+	return &querier{invoke: source.invoke}, func() {}, nil
+}
+
+func (source *Source) LatestHeight(ctx context.Context, node string) (int64, error) {
+	latest := &tmservice.GetLatestBlockResponse{}
+	if err := clientModule.Invoke(ctx, latestBlockMethod, &tmservice.GetLatestBlockRequest{}, latest,
+		source.invoke); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case latest.SdkBlock != nil:
+		return latest.SdkBlock.Header.Height, nil
+	case latest.Block != nil:
+		return latest.Block.Header.Height, nil
+	default:
+		return 0, status.Errorf(codes.NotFound, "the recorded latest block is empty")
+	}
+}
+
+func (source *Source) ChainID(ctx context.Context, node string) (string, error) {
+	latest := &tmservice.GetLatestBlockResponse{}
+	if err := clientModule.Invoke(ctx, latestBlockMethod, &tmservice.GetLatestBlockRequest{}, latest,
+		source.invoke); err != nil {
+		return "", err
+	}
+
+	switch {
+	case latest.SdkBlock != nil:
+		return latest.SdkBlock.Header.ChainID, nil
+	case latest.Block != nil:
+		return latest.Block.Header.ChainID, nil
+	default:
+		return "", nil
+	}
+}
+
+func (source *Source) BlockTime(ctx context.Context, node string, height int64) (time.Time, error) {
+	block := &tmservice.GetBlockByHeightResponse{}
+	if err := clientModule.Invoke(ctx, blockByHeightMethod, &tmservice.GetBlockByHeightRequest{Height: height}, block,
+		source.invoke); err != nil {
+		return time.Time{}, err
+	}
+
+	switch {
+	case block.SdkBlock != nil:
+		return block.SdkBlock.Header.Time, nil
+	case block.Block != nil:
+		return block.Block.Header.Time, nil
+	default:
+		return time.Time{}, status.Errorf(codes.NotFound, "the recorded block at height %d is empty", height)
+	}
+}
+
+// answers a query with its recorded response. The height it was answered at is handed to grpc.Header call options
+// as a grpc response's would be
+func (source *Source) invoke(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+	opts ...grpc.CallOption,
+) error {
+	outgoing, _ := metadata.FromOutgoingContext(ctx)
+	height := clientModule.BlockHeight(outgoing)
+
+	request, err := jsonCodec.MarshalJSON(req.(proto.Message))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "encoding %s: %v", method, err)
+	}
+
+	key, err := callKey(method, height, request)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "encoding %s: %v", method, err)
+	}
+
+	source.mutex.Lock()
+	calls := source.calls[key]
+	if len(calls) == 0 {
+		source.mutex.Unlock()
+		return status.Errorf(codes.NotFound, "%s %s at height %d wasn't recorded", method, request, height)
+	}
+	call := calls[source.next[key]]
+	if source.next[key] < len(calls)-1 {
+		source.next[key]++
+	}
+	source.mutex.Unlock()
+
+	if err := call.err(); err != nil {
+		return err
+	}
+
+	if err := jsonCodec.UnmarshalJSON(call.Response, reply.(proto.Message)); err != nil {
+		return status.Errorf(codes.Internal, "decoding the recorded %s: %v", method, err)
+	}
+
+	for _, opt := range opts {
+		if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+			*headerOption.HeaderAddr = metadata.MD{}
+			if call.AnsweredAt > 0 {
+				*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader,
+					strconv.FormatInt(call.AnsweredAt, 10))
+			}
+		}
+	}
+
+	return nil
+}
+
+type querier struct {
+	invoke grpc.UnaryInvoker
+}
+
+func (querier *querier) Validators(ctx context.Context, in *stakingTypes.QueryValidatorsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorsResponse, error) {
+	response := &stakingTypes.QueryValidatorsResponse{}
+	if err := clientModule.Invoke(ctx, validatorsMethod, in, response, querier.invoke, opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (querier *querier) ValidatorDelegations(ctx context.Context, in *stakingTypes.QueryValidatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryValidatorDelegationsResponse{}
+	if err := clientModule.Invoke(ctx, validatorDelegationsMethod, in, response, querier.invoke,
+		opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (querier *querier) DelegatorDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorDelegationsResponse{}
+	if err := clientModule.Invoke(ctx, delegatorDelegationsMethod, in, response, querier.invoke,
+		opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (querier *querier) DelegatorUnbondingDelegations(ctx context.Context,
+	in *stakingTypes.QueryDelegatorUnbondingDelegationsRequest, opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorUnbondingDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorUnbondingDelegationsResponse{}
+	if err := clientModule.Invoke(ctx, unbondingDelegationsMethod, in, response, querier.invoke,
+		opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (querier *querier) DelegationTotalRewards(ctx context.Context,
+	in *distributionTypes.QueryDelegationTotalRewardsRequest, opts ...grpc.CallOption,
+) (*distributionTypes.QueryDelegationTotalRewardsResponse, error) {
+	response := &distributionTypes.QueryDelegationTotalRewardsResponse{}
+	if err := clientModule.Invoke(ctx, totalRewardsMethod, in, response, querier.invoke, opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
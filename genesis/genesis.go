@@ -0,0 +1,536 @@
+// Package genesis reads the staking state from a genesis file or the output of `appd export`, without any node. Only
+// the app_state.staking section is decoded, the rest of the file is skipped token by token so files of several GB
+// don't have to fit in memory
+package genesis
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	"github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the grpc methods the file stands in for, so metrics and the rest of the client layer see the same names
+const (
+	validatorsMethod           = "/cosmos.staking.v1beta1.Query/Validators"
+	validatorDelegationsMethod = "/cosmos.staking.v1beta1.Query/ValidatorDelegations"
+	delegatorDelegationsMethod = "/cosmos.staking.v1beta1.Query/DelegatorDelegations"
+	unbondingDelegationsMethod = "/cosmos.staking.v1beta1.Query/DelegatorUnbondingDelegations"
+	totalRewardsMethod         = "/cosmos.distribution.v1beta1.Query/DelegationTotalRewards"
+)
+
+// the staking state of a genesis or exported state file
+type State struct {
+	ChainID string
+	// the height the state is at: the exported height for an export, 0 for the genesis of a new chain
+	Height    int64
+	BondDenom string
+
+	Validators           stakingTypes.Validators
+	Delegations          []stakingTypes.Delegation
+	UnbondingDelegations []stakingTypes.UnbondingDelegation
+	Redelegations        []stakingTypes.Redelegation
+
+	// the delegations of every validator and of every delegator with their balances, in the order of the file
+	delegationResponses          map[string]stakingTypes.DelegationResponses
+	delegatorDelegationResponses map[string]stakingTypes.DelegationResponses
+	// the unbonding delegations of every delegator
+	delegatorUnbondingDelegations map[string]stakingTypes.UnbondingDelegations
+}
+
+// the staking state of validators, delegations and unbonding delegations at height, with the balances of the
+// delegations worked out
+func NewState(height int64, bondDenom string, validators stakingTypes.Validators,
+	delegations []stakingTypes.Delegation, unbondingDelegations []stakingTypes.UnbondingDelegation,
+) (*State, error) {
+	state := &State{
+		Height:               height,
+		BondDenom:            bondDenom,
+		Validators:           validators,
+		Delegations:          delegations,
+		UnbondingDelegations: unbondingDelegations,
+	}
+
+	return state, state.index()
+}
+
+// reads the staking state of the file at path
+func Load(path string) (*State, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, failures.Wrap(failures.IO, err)
+	}
+	defer file.Close()
+
+	state, err := Decode(file)
+	if err != nil {
+		return nil, failures.Errorf(failures.IO, "%s: %w", path, err)
+	}
+
+	return state, nil
+}
+
+// reads the staking state of a genesis or exported state
+func Decode(reader io.Reader) (*State, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	state := &State{}
+
+	err := walkObject(decoder, func(key string) error {
+		switch key {
+		case "chain_id":
+			return decoder.Decode(&state.ChainID)
+		case "initial_height":
+			var initialHeight json.Number
+			if err := decoder.Decode(&initialHeight); err != nil {
+				return err
+			}
+
+			// an export at height h starts the new chain at h+1
+			height, err := strconv.ParseInt(initialHeight.String(), 10, 64)
+			if err != nil {
+				return fmt.Errorf("initial_height: %w", err)
+			}
+			if height > 1 {
+				state.Height = height - 1
+			}
+
+			return nil
+		case "app_state":
+			return walkObject(decoder, func(module string) error {
+				if module != "staking" {
+					return skip(decoder)
+				}
+				return state.decodeStaking(decoder)
+			})
+		default:
+			return skip(decoder)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Validators == nil {
+		return nil, fmt.Errorf("no app_state.staking.validators in the file")
+	}
+
+	return state, state.index()
+}
+
+func (state *State) decodeStaking(decoder *json.Decoder) error {
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true, AnyResolver: clientModule.Registry}
+
+	// every element is decoded on its own so only one is held as json at a time
+	decodeEach := func(newMessage func() proto.Message) error {
+		return walkArray(decoder, func() error {
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return err
+			}
+			return unmarshaler.Unmarshal(bytes.NewReader(raw), newMessage())
+		})
+	}
+
+	return walkObject(decoder, func(key string) error {
+		switch key {
+		case "params":
+			var params struct {
+				BondDenom string `json:"bond_denom"`
+			}
+			if err := decoder.Decode(&params); err != nil {
+				return err
+			}
+			state.BondDenom = params.BondDenom
+			return nil
+		case "validators":
+			state.Validators = stakingTypes.Validators{}
+			return decodeEach(func() proto.Message {
+				state.Validators = append(state.Validators, stakingTypes.Validator{})
+				return &state.Validators[len(state.Validators)-1]
+			})
+		case "delegations":
+			return decodeEach(func() proto.Message {
+				state.Delegations = append(state.Delegations, stakingTypes.Delegation{})
+				return &state.Delegations[len(state.Delegations)-1]
+			})
+		case "unbonding_delegations":
+			return decodeEach(func() proto.Message {
+				state.UnbondingDelegations = append(state.UnbondingDelegations, stakingTypes.UnbondingDelegation{})
+				return &state.UnbondingDelegations[len(state.UnbondingDelegations)-1]
+			})
+		case "redelegations":
+			return decodeEach(func() proto.Message {
+				state.Redelegations = append(state.Redelegations, stakingTypes.Redelegation{})
+				return &state.Redelegations[len(state.Redelegations)-1]
+			})
+		default:
+			return skip(decoder)
+		}
+	})
+}
+
+// resolves the validators' public keys and turns the delegations' shares into balances with the exchange rate of
+// their validator, as the staking queries of a node do
+func (state *State) index() error {
+	if err := clientModule.UnpackValidators(state.Validators); err != nil {
+		return err
+	}
+
+	validators := make(map[string]stakingTypes.Validator, len(state.Validators))
+	for _, validator := range state.Validators {
+		validators[validator.OperatorAddress] = validator
+	}
+
+	state.delegationResponses = make(map[string]stakingTypes.DelegationResponses)
+	state.delegatorDelegationResponses = make(map[string]stakingTypes.DelegationResponses)
+	for _, delegation := range state.Delegations {
+		validator, ok := validators[delegation.ValidatorAddress]
+		if !ok {
+			return fmt.Errorf("delegation of %s to unknown validator %s", delegation.DelegatorAddress,
+				delegation.ValidatorAddress)
+		}
+
+		balance := sdk.NewCoin(state.BondDenom, validator.TokensFromShares(delegation.Shares).TruncateInt())
+		delegationResponse := stakingTypes.DelegationResponse{Delegation: delegation, Balance: balance}
+		state.delegationResponses[delegation.ValidatorAddress] = append(
+			state.delegationResponses[delegation.ValidatorAddress], delegationResponse)
+		state.delegatorDelegationResponses[delegation.DelegatorAddress] = append(
+			state.delegatorDelegationResponses[delegation.DelegatorAddress], delegationResponse)
+	}
+
+	state.delegatorUnbondingDelegations = make(map[string]stakingTypes.UnbondingDelegations)
+	for _, unbondingDelegation := range state.UnbondingDelegations {
+		state.delegatorUnbondingDelegations[unbondingDelegation.DelegatorAddress] = append(
+			state.delegatorUnbondingDelegations[unbondingDelegation.DelegatorAddress], unbondingDelegation)
+	}
+
+	return nil
+}
+
+// calls value for the key of every member of the next json object, which must consume the member's value
+func walkObject(decoder *json.Decoder, value func(key string) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		if err := value(token.(string)); err != nil {
+			return fmt.Errorf("%s: %w", token, err)
+		}
+	}
+
+	_, err := decoder.Token()
+	return err
+}
+
+// calls element for every element of the next json array, which must consume it
+func walkArray(decoder *json.Decoder, element func() error) error {
+	if err := expectDelim(decoder, '['); err != nil {
+		return err
+	}
+
+	for i := 0; decoder.More(); i++ {
+		if err := element(); err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+	}
+
+	_, err := decoder.Token()
+	return err
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return fmt.Errorf("expected %s, got %v", delim, token)
+	}
+
+	return nil
+}
+
+// skips the next json value without holding it in memory
+func skip(decoder *json.Decoder) error {
+	depth := 0
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// a source reading a genesis or exported state file. The node is the path of the file, which is read once
+type Source struct {
+	mutex  sync.Mutex
+	states map[string]*State
+}
+
+func NewSource() *Source {
+	return &Source{states: make(map[string]*State)}
+}
+
+// the state of the file at path, read the first time it is asked for
+func (source *Source) State(ctx context.Context, path string) (*State, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	if state, ok := source.states[path]; ok {
+		return state, nil
+	}
+
+	logger := loggingModule.FromContext(ctx)
+	logger.Info("reading staking state", "file", path)
+	start := time.Now()
+
+	state, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("read staking state", "chain_id", state.ChainID, "height", state.Height,
+		"validators", len(state.Validators), "delegations", len(state.Delegations),
+		"unbonding_delegations", len(state.UnbondingDelegations), "redelegations", len(state.Redelegations),
+		"elapsed", loggingModule.Since(start))
+
+	source.states[path] = state
+
+	return state, nil
+}
+
+func (source *Source) Staking(ctx context.Context, path string) (clientModule.StakingQuerier, func(), error) {
+	state, err := source.State(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return state.Querier(), func() {}, nil
+}
+
+// the rewards can't be worked out from the staking state, every query fails with codes.Unimplemented
+func (source *Source) Distribution(ctx context.Context, path string) (clientModule.DistributionQuerier, func(), error) {
+	return Unrewarded{}, func() {}, nil
+}
+
+func (source *Source) LatestHeight(ctx context.Context, path string) (int64, error) {
+	state, err := source.State(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+
+	return state.Height, nil
+}
+
+// the staking queries, answered from the state. Queries at any height but the state's fail
+func (state *State) Querier() clientModule.StakingQuerier {
+	return &querier{state: state}
+}
+
+type querier struct {
+	state *State
+}
+
+func (querier *querier) Validators(ctx context.Context, in *stakingTypes.QueryValidatorsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorsResponse, error) {
+	response := &stakingTypes.QueryValidatorsResponse{}
+
+	err := clientModule.Invoke(ctx, validatorsMethod, in, response,
+		querier.invoker(func() error {
+			validators := querier.state.Validators
+			if in.Status != "" {
+				validators = nil
+				for _, validator := range querier.state.Validators {
+					if validator.Status.String() == in.Status {
+						validators = append(validators, validator)
+					}
+				}
+			}
+
+			start, end, pagination, err := page(in.Pagination, len(validators))
+			response.Validators, response.Pagination = validators[start:end], pagination
+			return err
+		}), opts...)
+
+	return response, err
+}
+
+func (querier *querier) ValidatorDelegations(ctx context.Context, in *stakingTypes.QueryValidatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryValidatorDelegationsResponse{}
+
+	err := clientModule.Invoke(ctx, validatorDelegationsMethod, in, response,
+		querier.invoker(func() error {
+			delegationResponses := querier.state.delegationResponses[in.ValidatorAddr]
+
+			start, end, pagination, err := page(in.Pagination, len(delegationResponses))
+			response.DelegationResponses, response.Pagination = delegationResponses[start:end], pagination
+			return err
+		}), opts...)
+
+	return response, err
+}
+
+func (querier *querier) DelegatorDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorDelegationsResponse{}
+
+	err := clientModule.Invoke(ctx, delegatorDelegationsMethod, in, response,
+		querier.invoker(func() error {
+			delegationResponses := querier.state.delegatorDelegationResponses[in.DelegatorAddr]
+
+			start, end, pagination, err := page(in.Pagination, len(delegationResponses))
+			response.DelegationResponses, response.Pagination = delegationResponses[start:end], pagination
+			return err
+		}), opts...)
+
+	return response, err
+}
+
+func (querier *querier) DelegatorUnbondingDelegations(ctx context.Context,
+	in *stakingTypes.QueryDelegatorUnbondingDelegationsRequest, opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorUnbondingDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorUnbondingDelegationsResponse{}
+
+	err := clientModule.Invoke(ctx, unbondingDelegationsMethod, in, response,
+		querier.invoker(func() error {
+			unbondingDelegations := querier.state.delegatorUnbondingDelegations[in.DelegatorAddr]
+
+			start, end, pagination, err := page(in.Pagination, len(unbondingDelegations))
+			response.UnbondingResponses, response.Pagination = unbondingDelegations[start:end], pagination
+			return err
+		}), opts...)
+
+	return response, err
+}
+
+// the distribution queries of a source without the distribution state, which fail with codes.Unimplemented
+type Unrewarded struct{}
+
+func (Unrewarded) DelegationTotalRewards(ctx context.Context, in *distributionTypes.QueryDelegationTotalRewardsRequest,
+	opts ...grpc.CallOption,
+) (*distributionTypes.QueryDelegationTotalRewardsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "%s can't be answered from the staking state", totalRewardsMethod)
+}
+
+// answers a query at the height carried by ctx, which must be the state's if there is one. The state's height is
+// handed to grpc.Header call options as a node's would be
+func (querier *querier) invoker(answer func() error) grpc.UnaryInvoker {
+	state := querier.state
+
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		opts ...grpc.CallOption,
+	) error {
+		if md, ok := metadata.FromOutgoingContext(ctx); ok {
+			for _, height := range md.Get(grpcTypes.GRPCBlockHeightHeader) {
+				if height != strconv.FormatInt(state.Height, 10) {
+					return status.Errorf(codes.OutOfRange, "the file holds the state at height %d, not %s",
+						state.Height, height)
+				}
+			}
+		}
+
+		if err := answer(); err != nil {
+			return err
+		}
+
+		if state.Height > 0 {
+			for _, opt := range opts {
+				if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+					*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader,
+						strconv.FormatInt(state.Height, 10))
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// the bounds of a page of total rows. The key of the next page is its offset, as big endian bytes. An offset past
+// the rows gives an empty page
+func page(request *queryTypes.PageRequest, total int) (int, int, *queryTypes.PageResponse, error) {
+	// compared as uint64 so a key or offset too big for an int can't wrap around to a negative start
+	start, limit := uint64(0), uint64(total)
+
+	if request != nil {
+		switch {
+		case len(request.Key) == 8:
+			start = binary.BigEndian.Uint64(request.Key)
+		case len(request.Key) > 0:
+			return 0, 0, nil, status.Error(codes.InvalidArgument, "invalid pagination key")
+		default:
+			start = request.Offset
+		}
+
+		if request.Limit > 0 && request.Limit < limit {
+			limit = request.Limit
+		}
+	}
+
+	if start > uint64(total) {
+		start = uint64(total)
+	}
+
+	end := start + limit
+	if end > uint64(total) {
+		end = uint64(total)
+	}
+
+	pagination := &queryTypes.PageResponse{}
+	if end < uint64(total) {
+		pagination.NextKey = make([]byte, 8)
+		binary.BigEndian.PutUint64(pagination.NextKey, end)
+	}
+	if request != nil && request.CountTotal {
+		pagination.Total = uint64(total)
+	}
+
+	return int(start), int(end), pagination, nil
+}
@@ -0,0 +1,284 @@
+package genesis
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	validatorsModule "github.com/brianosaurus/challenge1/validators"
+
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// an export at height 7000000 whose first validator was slashed, so a share is worth half a token
+const EXPORT = `{
+  "app_hash": "",
+  "app_state": {
+    "bank": {
+      "balances": [{"address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a", "coins": [{"denom": "uosmo", "amount": "1"}]}]
+    },
+    "staking": {
+      "params": {
+        "unbonding_time": "1209600s",
+        "max_validators": 100,
+        "max_entries": 7,
+        "historical_entries": 10000,
+        "bond_denom": "uosmo",
+        "min_commission_rate": "0.050000000000000000"
+      },
+      "last_total_power": "3",
+      "last_validator_powers": [{"address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya", "power": "1"}],
+      "validators": [
+        {
+          "operator_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+          "consensus_pubkey": {
+            "@type": "/cosmos.crypto.ed25519.PubKey",
+            "key": "Y2h1Y2tldC1wdWJrZXktY2h1Y2tldC1wdWJrZXk="
+          },
+          "jailed": false,
+          "status": "BOND_STATUS_BONDED",
+          "tokens": "1000",
+          "delegator_shares": "2000.000000000000000000",
+          "description": {"moniker": "Inotel"},
+          "unbonding_height": "0",
+          "unbonding_time": "1970-01-01T00:00:00Z",
+          "commission": {
+            "commission_rates": {"rate": "0.05", "max_rate": "0.3", "max_change_rate": "0.3"},
+            "update_time": "2022-03-29T11:54:26.447424547Z"
+          },
+          "min_self_delegation": "1"
+        },
+        {
+          "operator_address": "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v",
+          "consensus_pubkey": {
+            "@type": "/cosmos.crypto.ed25519.PubKey",
+            "key": "cHVia2V5LXB1YmtleS1wdWJrZXktcHVia2V5LXB1Yms="
+          },
+          "jailed": true,
+          "status": "BOND_STATUS_UNBONDED",
+          "tokens": "7",
+          "delegator_shares": "7.000000000000000000",
+          "description": {"moniker": "Jailed"},
+          "unbonding_time": "1970-01-01T00:00:00Z",
+          "commission": {"commission_rates": {"rate": "0.1", "max_rate": "0.2", "max_change_rate": "0.01"}},
+          "min_self_delegation": "1"
+        }
+      ],
+      "delegations": [
+        {
+          "delegator_address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+          "validator_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+          "shares": "1999.000000000000000000"
+        },
+        {
+          "delegator_address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+          "validator_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+          "shares": "1.000000000000000000"
+        },
+        {
+          "delegator_address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+          "validator_address": "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v",
+          "shares": "7.000000000000000000"
+        }
+      ],
+      "unbonding_delegations": [
+        {
+          "delegator_address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+          "validator_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+          "entries": [
+            {"creation_height": "6999000", "completion_time": "2022-11-20T00:00:00Z", "initial_balance": "5", "balance": "5"}
+          ]
+        }
+      ],
+      "redelegations": [
+        {
+          "delegator_address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+          "validator_src_address": "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v",
+          "validator_dst_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+          "entries": [
+            {"creation_height": "6999500", "completion_time": "2022-11-21T00:00:00Z", "initial_balance": "3", "shares_dst": "6.000000000000000000"}
+          ]
+        }
+      ],
+      "exported": true
+    },
+    "wasm": {
+      "contracts": [{"contract_state": [{"key": "AA==", "value": "[{\"nested\": [1, 2, {}]}]"}]}]
+    }
+  },
+  "chain_id": "osmosis-1",
+  "consensus_params": {"block": {"max_bytes": "5242880"}},
+  "genesis_time": "2021-06-18T17:00:00Z",
+  "initial_height": "7000001",
+  "validators": []
+}`
+
+func TestDecode(t *testing.T) {
+	state, err := Decode(strings.NewReader(EXPORT))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "osmosis-1", state.ChainID)
+	assert.Equal(t, int64(7000000), state.Height)
+	assert.Equal(t, "uosmo", state.BondDenom)
+	assert.Equal(t, 2, len(state.Validators))
+	assert.Equal(t, 3, len(state.Delegations))
+	assert.Equal(t, 1, len(state.UnbondingDelegations))
+	assert.Equal(t, "5", state.UnbondingDelegations[0].Entries[0].Balance.String())
+
+	// redelegations are kept so an offline snapshot has everything a node would answer
+	assert.Equal(t, 1, len(state.Redelegations))
+	assert.Equal(t, "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v", state.Redelegations[0].ValidatorSrcAddress)
+	assert.Equal(t, "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya", state.Redelegations[0].ValidatorDstAddress)
+	assert.Equal(t, "3", state.Redelegations[0].Entries[0].InitialBalance.String())
+	assert.Equal(t, "6.000000000000000000", state.Redelegations[0].Entries[0].SharesDst.String())
+
+	// a genesis starting at height 1 is the state before any block
+	state, err = Decode(strings.NewReader(strings.Replace(EXPORT, `"initial_height": "7000001"`, `"initial_height": 1`, 1)))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), state.Height)
+
+	_, err = Decode(strings.NewReader(`{"app_state": {"bank": {}}}`))
+	assert.EqualError(t, err, "no app_state.staking.validators in the file")
+
+	_, err = Decode(strings.NewReader(`{"app_state": {"staking": {"validators": {}}}}`))
+	assert.EqualError(t, err, "app_state: staking: validators: expected [, got {")
+
+	_, err = Decode(strings.NewReader(strings.Replace(EXPORT, "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v\",\n          \"consensus_pubkey",
+		"osmovaloper1missing\",\n          \"consensus_pubkey", 1)))
+	assert.Contains(t, err.Error(), "to unknown validator osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v")
+}
+
+func TestSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(EXPORT), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var heights []int64
+	ctx := clientModule.WithSource(context.Background(), NewSource())
+	ctx = clientModule.WithInterceptors(ctx, clientModule.HeightInterceptor(func(height int64) {
+		heights = append(heights, height)
+	}))
+
+	validators, err := validatorsModule.GetValidatorsAtHeight(ctx, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, len(*validators))
+	assert.Equal(t, "Inotel", (*validators)[0].Description.Moniker)
+
+	pubKey, err := (*validators)[0].ConsPubKey()
+	assert.Nil(t, err)
+	assert.NotNil(t, pubKey)
+
+	delegationResponses, err := delegationsModule.GetDelegationResponsesAtHeight(ctx, path, validators, 7000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the balances are the shares at the validator's exchange rate, truncated
+	assert.Equal(t, 3, len(*delegationResponses))
+	assert.Equal(t, "999", (*delegationResponses)[0].Balance.Amount.String())
+	assert.Equal(t, "0", (*delegationResponses)[1].Balance.Amount.String())
+	assert.Equal(t, "7", (*delegationResponses)[2].Balance.Amount.String())
+	assert.Equal(t, "uosmo", (*delegationResponses)[2].Balance.Denom)
+
+	// every response reported the height of the export through the client layer
+	assert.Equal(t, []int64{7000000, 7000000, 7000000}, heights)
+
+	// the file only holds one height
+	_, err = delegationsModule.GetDelegationResponsesAtHeight(ctx, path, validators, 6000000)
+	assert.Equal(t, codes.OutOfRange, status.Code(unwrapAll(err)))
+
+	height, err := NewSource().LatestHeight(context.Background(), path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7000000), height)
+
+	_, _, err = NewSource().Staking(context.Background(), filepath.Join(t.TempDir(), "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestDelegatorQueries(t *testing.T) {
+	state, err := Decode(strings.NewReader(EXPORT))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delegations, err := state.Querier().DelegatorDelegations(context.Background(),
+		&stakingTypes.QueryDelegatorDelegationsRequest{DelegatorAddr: "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(delegations.DelegationResponses))
+	assert.Equal(t, "7", delegations.DelegationResponses[1].Balance.Amount.String())
+
+	unbonding, err := state.Querier().DelegatorUnbondingDelegations(context.Background(),
+		&stakingTypes.QueryDelegatorUnbondingDelegationsRequest{DelegatorAddr: "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(unbonding.UnbondingResponses))
+
+	distribution, _, _ := NewSource().Distribution(context.Background(), "")
+	_, err = distribution.DelegationTotalRewards(context.Background(), &distributionTypes.QueryDelegationTotalRewardsRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestPage(t *testing.T) {
+	start, end, pagination, err := page(&queryTypes.PageRequest{Limit: 2}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 2}, []int{start, end})
+
+	start, end, pagination, err = page(&queryTypes.PageRequest{Key: pagination.NextKey, Limit: 2, CountTotal: true}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 4}, []int{start, end})
+	assert.Equal(t, uint64(5), pagination.Total)
+
+	start, end, pagination, err = page(&queryTypes.PageRequest{Key: pagination.NextKey, Limit: 2}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4, 5}, []int{start, end})
+	assert.Nil(t, pagination.NextKey)
+
+	start, end, _, err = page(nil, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 5}, []int{start, end})
+
+	_, _, _, err = page(&queryTypes.PageRequest{Key: []byte{1}}, 5)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// keys and offsets too big for an int give an empty page instead of a negative start
+	start, end, pagination, err = page(&queryTypes.PageRequest{Key: []byte{0xff, 0, 0, 0, 0, 0, 0, 0}, Limit: 2}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5, 5}, []int{start, end})
+	assert.Nil(t, pagination.NextKey)
+
+	start, end, _, err = page(&queryTypes.PageRequest{Offset: math.MaxUint64, Limit: math.MaxUint64}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5, 5}, []int{start, end})
+
+	start, end, _, err = page(&queryTypes.PageRequest{Offset: 3, Limit: math.MaxUint64}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 5}, []int{start, end})
+}
+
+// the innermost error, which is the grpc status the modules wrapped
+func unwrapAll(err error) error {
+	for {
+		unwrapped := errors.Unwrap(err)
+		if unwrapped == nil {
+			return err
+		}
+		err = unwrapped
+	}
+}
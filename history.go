@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	blocksModule "github.com/brianosaurus/challenge1/blocks"
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	historyModule "github.com/brianosaurus/challenge1/history"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+)
+
+// a date flag, either a day or an RFC3339 time
+func parseDate(name string, value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-%s %q is neither a date like 2022-11-01 nor an RFC3339 time", name, value)
+	}
+
+	return t, nil
+}
+
+// the history subcommand snapshots an archive node at every height of a range, or at the first block of every step
+// between two dates, and writes the stake of every validator and delegator over time. With -queryCacheDir the
+// queries and block times of every height are cached so running it again only fetches the heights it doesn't have
+func historyCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var from, to, step int64
+	var fromDate, toDate string
+	var every time.Duration
+	var validatorHistoryOutputFile string
+	var delegatorHistoryOutputFile string
+	options := connectionOptions{}
+
+	addConnectionFlags(flags, &options)
+	flags.Int64Var(&from, "from", 0, "the first height, or with -fromDate the earliest height the node has (default 1)")
+	flags.Int64Var(&to, "to", 0, "the last height, 0 for the latest block")
+	flags.Int64Var(&step, "step", 100000, "the blocks between two heights")
+	flags.StringVar(&fromDate, "fromDate", "", "the first date (e.g. 2022-06-01), resolved to the first block made at or after it")
+	flags.StringVar(&toDate, "toDate", "", "the last date, required with -fromDate")
+	flags.DurationVar(&every, "every", 24*time.Hour, "the time between two dates")
+	flags.StringVar(&validatorHistoryOutputFile, "validatorHistoryFile", "validatorHistory.csv",
+		"the output file for the validators' time series csv")
+	flags.StringVar(&delegatorHistoryOutputFile, "delegatorHistoryFile", "delegatorHistory.csv",
+		"the output file for the delegators' time series csv")
+
+	return func(ctx context.Context) error {
+		if options.height > 0 {
+			return failuresModule.Errorf(failuresModule.Config, "-height can't be used with history, use -from and -to")
+		}
+		if (fromDate == "") != (toDate == "") {
+			return failuresModule.Errorf(failuresModule.Config, "history: -fromDate and -toDate go together")
+		}
+		if fromDate == "" && from <= 0 {
+			return failuresModule.Errorf(failuresModule.Config, "history: -from or -fromDate is required")
+		}
+
+		ctx, err := options.withSource(ctx)
+		if err != nil {
+			return err
+		}
+
+		logger := loggingModule.FromContext(ctx)
+
+		// asked once so the snapshots and block times of every height are answered from the query cache
+		if cacheModule.FromContext(ctx) != nil && cacheModule.ChainIDFromContext(ctx) == "" {
+			chainID, err := blocksModule.GetChainID(ctx, options.node)
+			if err != nil {
+				return err
+			}
+			ctx = cacheModule.WithChainID(ctx, chainID)
+		}
+
+		latest := to
+		if latest == 0 {
+			latest, err = blocksModule.GetLatestHeight(ctx, options.node)
+			if err != nil {
+				return err
+			}
+		}
+
+		var heights []int64
+		if fromDate != "" {
+			heights, err = dateHeights(ctx, options.node, fromDate, toDate, every, from, latest)
+		} else {
+			heights, err = historyModule.Heights(from, latest, step)
+			err = failuresModule.Wrap(failuresModule.Config, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		// the offline sources can't tell block times, their points have none
+		source := clientModule.SourceFrom(ctx)
+		_, timed := source.(clientModule.BlockTimeSource)
+		timed = timed || source == nil
+
+		logger.Info("collecting history", "node", options.node, "heights", len(heights), "from", heights[0],
+			"to", heights[len(heights)-1])
+
+		points, collectErr := historyModule.Collect(ctx, historyModule.Options{
+			Heights: heights,
+			Fetch: func(ctx context.Context, height int64) (*historyModule.Point, error) {
+				var blockTime time.Time
+				if timed {
+					var err error
+					blockTime, err = blocksModule.GetBlockTime(ctx, options.node, height)
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				snapshot, err := snapshotModule.Fetch(ctx, snapshotModule.Options{Node: options.node, Height: height})
+				if err != nil {
+					return nil, err
+				}
+
+				return historyModule.NewPoint(snapshot, blockTime), nil
+			},
+		})
+		if len(points) == 0 {
+			return collectErr
+		}
+
+		logger.Info("writing history", "points", len(points), "validator_history_file", validatorHistoryOutputFile,
+			"delegator_history_file", delegatorHistoryOutputFile)
+
+		validatorHistoryFile, err := os.OpenFile(validatorHistoryOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer validatorHistoryFile.Close()
+		if err := historyModule.WriteValidatorHistory(points, csv.NewWriter(validatorHistoryFile)); err != nil {
+			return err
+		}
+
+		delegatorHistoryFile, err := os.OpenFile(delegatorHistoryOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer delegatorHistoryFile.Close()
+		if err := historyModule.WriteDelegatorHistory(points, csv.NewWriter(delegatorHistoryFile)); err != nil {
+			return err
+		}
+
+		return collectErr
+	}
+}
+
+// the heights of the first blocks at or after every step between two dates, searched between earliest and latest
+func dateHeights(ctx context.Context, node string, fromDate string, toDate string, every time.Duration,
+	earliest int64, latest int64,
+) ([]int64, error) {
+	first, err := parseDate("fromDate", fromDate)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.Config, err)
+	}
+
+	last, err := parseDate("toDate", toDate)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.Config, err)
+	}
+
+	times, err := historyModule.Times(first, last, every)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.Config, err)
+	}
+
+	if earliest <= 0 {
+		earliest = 1
+	}
+
+	heights, err := historyModule.HeightsAt(ctx, times, earliest, latest, func(ctx context.Context, height int64) (time.Time, error) {
+		return blocksModule.GetBlockTime(ctx, node, height)
+	})
+
+	return heights, failuresModule.Wrap(failuresModule.Network, err)
+}
@@ -0,0 +1,250 @@
+// Package history takes snapshots of a chain at many heights and turns them into time series of the stake of every
+// validator and delegator
+package history
+
+import (
+	"context"
+	"fmt"
+	big "math/big"
+	"sort"
+	"time"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+)
+
+// the stake of a validator at a height
+type ValidatorPoint struct {
+	Moniker    string   `json:"moniker"`
+	Tokens     *big.Int `json:"tokens"`
+	Delegators int      `json:"delegators"`
+}
+
+// what a snapshot at a height boils down to for the time series
+type Point struct {
+	Height int64 `json:"height"`
+	// the time of the block, zero if the source can't tell
+	Time time.Time `json:"time"`
+	// keyed by operator address
+	Validators map[string]ValidatorPoint `json:"validators"`
+	// the total stake of every delegator
+	Delegators map[string]*big.Int `json:"delegators"`
+}
+
+// the point of a snapshot pinned to a height
+func NewPoint(snapshot *snapshotModule.Snapshot, blockTime time.Time) *Point {
+	point := &Point{
+		Height:     snapshot.Height,
+		Time:       blockTime,
+		Validators: make(map[string]ValidatorPoint),
+		Delegators: make(map[string]*big.Int),
+	}
+
+	delegators := make(map[string]int)
+	if snapshot.DelegationResponses != nil {
+		for _, delegationResponse := range *snapshot.DelegationResponses {
+			delegators[delegationResponse.Delegation.ValidatorAddress]++
+		}
+	}
+
+	if snapshot.Validators != nil {
+		for _, validator := range *snapshot.Validators {
+			point.Validators[validator.OperatorAddress] = ValidatorPoint{
+				Moniker:    validator.Description.Moniker,
+				Tokens:     validator.Tokens.BigInt(),
+				Delegators: delegators[validator.OperatorAddress],
+			}
+		}
+	}
+
+	if snapshot.Delegations != nil {
+		for delegator, delegationsWithTotalBalance := range *snapshot.Delegations {
+			point.Delegators[delegator] = delegationsWithTotalBalance.TotalBalance
+		}
+	}
+
+	return point
+}
+
+// the heights from from to to every step blocks. to is the last height even if it isn't a step away
+func Heights(from int64, to int64, step int64) ([]int64, error) {
+	switch {
+	case from <= 0:
+		return nil, fmt.Errorf("the first height must be positive")
+	case to < from:
+		return nil, fmt.Errorf("the last height %d is before the first %d", to, from)
+	case step <= 0:
+		return nil, fmt.Errorf("the step must be positive")
+	}
+
+	heights := make([]int64, 0, (to-from)/step+1)
+	for height := from; height <= to; height += step {
+		heights = append(heights, height)
+	}
+
+	if heights[len(heights)-1] != to {
+		heights = append(heights, to)
+	}
+
+	return heights, nil
+}
+
+// the times from from to to every step. to is the last time even if it isn't a step away
+func Times(from time.Time, to time.Time, step time.Duration) ([]time.Time, error) {
+	switch {
+	case to.Before(from):
+		return nil, fmt.Errorf("the last date %s is before the first %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	case step <= 0:
+		return nil, fmt.Errorf("the step must be positive")
+	}
+
+	times := make([]time.Time, 0)
+	for t := from; !t.After(to); t = t.Add(step) {
+		times = append(times, t)
+	}
+
+	if !times[len(times)-1].Equal(to) {
+		times = append(times, to)
+	}
+
+	return times, nil
+}
+
+// tells the time of the block at a height
+type BlockTimeFunc func(ctx context.Context, height int64) (time.Time, error)
+
+// the height of the first block made at or after each time, found by a binary search on the block times between
+// earliest and latest. Times resolving to the same block give a single height
+func HeightsAt(ctx context.Context, times []time.Time, earliest int64, latest int64, blockTime BlockTimeFunc,
+) ([]int64, error) {
+	// the searches share most of their first steps
+	known := make(map[int64]time.Time)
+	timeOf := func(height int64) (time.Time, error) {
+		if t, ok := known[height]; ok {
+			return t, nil
+		}
+
+		t, err := blockTime(ctx, height)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("the time of block %d: %w", height, err)
+		}
+
+		known[height] = t
+		return t, nil
+	}
+
+	latestTime, err := timeOf(latest)
+	if err != nil {
+		return nil, err
+	}
+
+	heights := make([]int64, 0, len(times))
+	for _, t := range times {
+		if t.After(latestTime) {
+			return nil, failuresModule.Errorf(failuresModule.Config, "no block was made after %s yet, the latest is %d at %s",
+				t.Format(time.RFC3339), latest, latestTime.Format(time.RFC3339))
+		}
+
+		low, high := earliest, latest
+		for low < high {
+			middle := low + (high-low)/2
+
+			middleTime, err := timeOf(middle)
+			if err != nil {
+				return nil, err
+			}
+
+			if middleTime.Before(t) {
+				low = middle + 1
+			} else {
+				high = middle
+			}
+		}
+
+		if len(heights) == 0 || heights[len(heights)-1] != low {
+			heights = append(heights, low)
+		}
+
+		loggingModule.FromContext(ctx).Debug("found the height of a date", "date", t, "height", low)
+	}
+
+	return heights, nil
+}
+
+// the options of a history
+type Options struct {
+	Heights []int64
+	// takes the point at a height
+	Fetch func(ctx context.Context, height int64) (*Point, error)
+}
+
+// the point at every height, in the order of the heights. A height
+// that fails is logged and left out and the others are still collected. Once interrupted the rest are skipped
+func Collect(ctx context.Context, options Options) ([]*Point, error) {
+	logger := loggingModule.FromContext(ctx)
+	points := make([]*Point, 0, len(options.Heights))
+	var failed []int64
+	var firstErr error
+
+	for _, height := range options.Heights {
+		if err := ctx.Err(); err != nil {
+			return points, failuresModule.Wrap(failuresModule.PartialData, err)
+		}
+
+		point, err := collect(ctx, options, height)
+		if err != nil {
+			logger.Error("skipping height", "height", height, "class", failuresModule.ClassOf(err).String(), "err", err)
+			failed = append(failed, height)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		points = append(points, point)
+	}
+
+	switch {
+	case len(failed) == 0:
+		return points, nil
+	case len(points) > 0:
+		return points, failuresModule.Errorf(failuresModule.PartialData, "%d of %d heights failed, the first %d: %w",
+			len(failed), len(options.Heights), failed[0], firstErr)
+	default:
+		return points, failuresModule.Errorf(failuresModule.ClassOf(firstErr), "every height failed, the first %d: %w",
+			failed[0], firstErr)
+	}
+}
+
+func collect(ctx context.Context, options Options, height int64) (*Point, error) {
+	start := time.Now()
+	point, err := options.Fetch(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	loggingModule.FromContext(ctx).Info("fetched point", "height", height, "validators", len(point.Validators),
+		"delegators", len(point.Delegators), "elapsed", loggingModule.Since(start))
+
+	return point, nil
+}
+
+// the keys of every point's map, sorted
+func keys(points []*Point, of func(point *Point) []string) []string {
+	seen := make(map[string]bool)
+	sorted := make([]string, 0)
+
+	for _, point := range points {
+		for _, key := range of(point) {
+			if !seen[key] {
+				seen[key] = true
+				sorted = append(sorted, key)
+			}
+		}
+	}
+
+	sort.Strings(sorted)
+
+	return sorted
+}
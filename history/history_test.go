@@ -0,0 +1,155 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	big "math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const VALIDATOR = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+
+// a block every 6 seconds from the genesis time
+var genesis = time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func blockTime(ctx context.Context, height int64) (time.Time, error) {
+	return genesis.Add(time.Duration(height-1) * 6 * time.Second), nil
+}
+
+func TestHeights(t *testing.T) {
+	heights, err := Heights(100, 350, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{100, 200, 300, 350}, heights)
+
+	heights, err = Heights(100, 300, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{100, 200, 300}, heights)
+
+	_, err = Heights(0, 300, 100)
+	assert.Error(t, err)
+	_, err = Heights(300, 100, 100)
+	assert.Error(t, err)
+	_, err = Heights(100, 300, 0)
+	assert.Error(t, err)
+}
+
+func TestHeightsAt(t *testing.T) {
+	times, err := Times(genesis.Add(time.Hour), genesis.Add(75*time.Hour), 24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(times))
+
+	calls := 0
+	counted := func(ctx context.Context, height int64) (time.Time, error) {
+		calls++
+		return blockTime(ctx, height)
+	}
+
+	heights, err := HeightsAt(context.Background(), times, 1, 100000, counted)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{601, 15001, 29401, 43801, 45001}, heights)
+	assert.Less(t, calls, 5*17)
+
+	// a time between two blocks finds the next one and times before the earliest block find it
+	heights, err = HeightsAt(context.Background(), []time.Time{genesis.Add(-time.Hour), genesis.Add(time.Second)}, 1, 100,
+		blockTime)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2}, heights)
+
+	_, err = HeightsAt(context.Background(), []time.Time{genesis.Add(time.Hour)}, 1, 100, blockTime)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+
+	_, err = HeightsAt(context.Background(), times, 1, 100000, func(ctx context.Context, height int64) (time.Time, error) {
+		return time.Time{}, errors.New("pruned")
+	})
+	assert.ErrorContains(t, err, "pruned")
+}
+
+func snapshotAt(height int64, balances ...int64) *snapshotModule.Snapshot {
+	delegationResponses := stakingTypes.DelegationResponses{}
+	tokens := sdk.ZeroInt()
+	for i, balance := range balances {
+		delegationResponses = append(delegationResponses, stakingTypes.DelegationResponse{
+			Delegation: stakingTypes.Delegation{DelegatorAddress: "osmo1delegator" + string(rune('a'+i)),
+				ValidatorAddress: VALIDATOR, Shares: sdk.NewDec(balance)},
+			Balance: sdk.NewInt64Coin("uosmo", balance),
+		})
+		tokens = tokens.Add(sdk.NewInt(balance))
+	}
+
+	validators := stakingTypes.Validators{{OperatorAddress: VALIDATOR, Tokens: tokens,
+		Description: stakingTypes.Description{Moniker: "Inotel"}}}
+
+	return &snapshotModule.Snapshot{
+		Height:              height,
+		Validators:          &validators,
+		DelegationResponses: &delegationResponses,
+		Delegations:         delegationsModule.GetDelegationsWithTotalBalance(&delegationResponses),
+	}
+}
+
+func TestCollect(t *testing.T) {
+	fetched := make([]int64, 0)
+	options := Options{
+		Heights: []int64{100, 200, 300},
+		Fetch: func(ctx context.Context, height int64) (*Point, error) {
+			fetched = append(fetched, height)
+			blockTime, _ := blockTime(ctx, height)
+
+			switch height {
+			case 100:
+				return NewPoint(snapshotAt(height, 10, 20), blockTime), nil
+			case 200:
+				return nil, failuresModule.Errorf(failuresModule.Network, "unavailable")
+			default:
+				return NewPoint(snapshotAt(height, 15), blockTime), nil
+			}
+		},
+	}
+
+	points, err := Collect(context.Background(), options)
+	assert.Equal(t, failuresModule.PartialData, failuresModule.ClassOf(err))
+	assert.Equal(t, 2, len(points))
+	assert.Equal(t, []int64{100, 200, 300}, fetched)
+	assert.Equal(t, "30", points[0].Validators[VALIDATOR].Tokens.String())
+	assert.Equal(t, 2, points[0].Validators[VALIDATOR].Delegators)
+	assert.Equal(t, "20", points[0].Delegators["osmo1delegatorb"].String())
+
+	options.Heights = []int64{200}
+	_, err = Collect(context.Background(), options)
+	assert.Equal(t, failuresModule.Network, failuresModule.ClassOf(err))
+}
+
+func TestWriteHistory(t *testing.T) {
+	first := NewPoint(snapshotAt(100, 10, 20), genesis)
+	second := NewPoint(snapshotAt(200, 15), genesis.Add(time.Hour))
+	second.Validators["osmovaloper1new"] = ValidatorPoint{Moniker: "New", Tokens: big.NewInt(5)}
+
+	var validators bytes.Buffer
+	assert.Nil(t, WriteValidatorHistory([]*Point{first, second}, csv.NewWriter(&validators)))
+	assert.Equal(t, `validator,moniker,height,time,tokens,change,delegators
+osmovaloper1new,New,200,2022-06-01T01:00:00Z,5,5,0
+`+VALIDATOR+`,Inotel,100,2022-06-01T00:00:00Z,30,30,2
+`+VALIDATOR+`,Inotel,200,2022-06-01T01:00:00Z,15,-15,1
+`, validators.String())
+
+	var delegators bytes.Buffer
+	assert.Nil(t, WriteDelegatorHistory([]*Point{first, second}, csv.NewWriter(&delegators)))
+	assert.Equal(t, `delegator,height,time,stake,change
+osmo1delegatora,100,2022-06-01T00:00:00Z,10,10
+osmo1delegatora,200,2022-06-01T01:00:00Z,15,5
+osmo1delegatorb,100,2022-06-01T00:00:00Z,20,20
+osmo1delegatorb,200,2022-06-01T01:00:00Z,0,-20
+`, delegators.String())
+}
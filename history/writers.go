@@ -0,0 +1,112 @@
+package history
+
+import (
+	"encoding/csv"
+	big "math/big"
+	"strconv"
+	"time"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+// writes the time series of every validator, one row per validator and height in order of the validators' operator
+// addresses. change is against the height before, and a validator missing at a height has no row
+func WriteValidatorHistory(points []*Point, writer *csv.Writer) error {
+	if err := writer.Write([]string{"validator", "moniker", "height", "time", "tokens", "change", "delegators"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	operatorAddresses := keys(points, func(point *Point) []string {
+		addresses := make([]string, 0, len(point.Validators))
+		for operatorAddress := range point.Validators {
+			addresses = append(addresses, operatorAddress)
+		}
+		return addresses
+	})
+
+	for _, operatorAddress := range operatorAddresses {
+		previous := new(big.Int)
+
+		for _, point := range points {
+			validator, ok := point.Validators[operatorAddress]
+			if !ok {
+				previous = new(big.Int)
+				continue
+			}
+
+			if err := writer.Write([]string{
+				operatorAddress,
+				validator.Moniker,
+				strconv.FormatInt(point.Height, 10),
+				formatTime(point.Time),
+				validator.Tokens.String(),
+				new(big.Int).Sub(validator.Tokens, previous).String(),
+				strconv.Itoa(validator.Delegators),
+			}); err != nil {
+				return failuresModule.Wrap(failuresModule.IO, err)
+			}
+
+			previous = validator.Tokens
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// writes the time series of every delegator, one row per delegator and height in order of the delegators' addresses.
+// change is against the height before. A delegator without stake has no row, except at the height it left
+func WriteDelegatorHistory(points []*Point, writer *csv.Writer) error {
+	if err := writer.Write([]string{"delegator", "height", "time", "stake", "change"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	delegators := keys(points, func(point *Point) []string {
+		addresses := make([]string, 0, len(point.Delegators))
+		for delegator := range point.Delegators {
+			addresses = append(addresses, delegator)
+		}
+		return addresses
+	})
+
+	for _, delegator := range delegators {
+		previous := new(big.Int)
+
+		for _, point := range points {
+			stake, ok := point.Delegators[delegator]
+			if !ok {
+				stake = new(big.Int)
+			}
+
+			if stake.Sign() == 0 && previous.Sign() == 0 {
+				continue
+			}
+
+			if err := writer.Write([]string{
+				delegator,
+				strconv.FormatInt(point.Height, 10),
+				formatTime(point.Time),
+				stake.String(),
+				new(big.Int).Sub(stake, previous).String(),
+			}); err != nil {
+				return failuresModule.Wrap(failuresModule.IO, err)
+			}
+
+			previous = stake
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// empty if the source couldn't tell the time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+func TestHistoryCommand(t *testing.T) {
+	args := func(dir string) []string {
+		return []string{"-from", "6999800", "-step", "100",
+			"-validatorHistoryFile", filepath.Join(dir, "validatorHistory.csv"),
+			"-delegatorHistoryFile", filepath.Join(dir, "delegatorHistory.csv")}
+	}
+
+	dir := t.TempDir()
+	fixture, err := live(t, context.Background(), &stubSource{}, "history", args(dir)...)
+	assert.Nil(t, err)
+
+	replayDir := t.TempDir()
+	assert.Nil(t, replay(fixture, "history", args(replayDir)...))
+
+	for _, file := range []string{"validatorHistory.csv", "delegatorHistory.csv"} {
+		assert.Equal(t, readFile(t, filepath.Join(dir, file)), readFile(t, filepath.Join(replayDir, file)), file)
+	}
+
+	// the second delegator's stake shrinks towards the latest block
+	assert.Equal(t, `delegator,height,time,stake,change
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,6999800,2023-09-30T02:19:54Z,40,40
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,6999900,2023-09-30T02:29:54Z,40,0
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,7000000,2023-09-30T02:39:54Z,40,0
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,6999800,2023-09-30T02:19:54Z,22,22
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,6999900,2023-09-30T02:29:54Z,21,-1
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,7000000,2023-09-30T02:39:54Z,20,-1
+`, readFile(t, filepath.Join(replayDir, "delegatorHistory.csv")))
+
+	// with the query cache a second run doesn't ask the node for the heights or their block times again, even one
+	// that is down once the chain id and the last height are given
+	cacheDir := t.TempDir()
+	byDate := []string{"-fromDate", "2023-09-29", "-toDate", "2023-09-30", "-from", "6980000"}
+	for _, source := range []*stubSource{{}, {unavailable: true}} {
+		responseCache, err := cacheModule.Open(cacheDir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := cacheModule.NewContext(context.Background(), responseCache)
+		if source.unavailable {
+			ctx = cacheModule.WithChainID(ctx, "osmosis-1")
+		}
+
+		cachedDir := t.TempDir()
+		_, err = live(t, ctx, source, "history", append(args(cachedDir), "-to", "7000000")...)
+		assert.Nil(t, err)
+		assert.Equal(t, readFile(t, filepath.Join(dir, "delegatorHistory.csv")),
+			readFile(t, filepath.Join(cachedDir, "delegatorHistory.csv")))
+
+		_, err = live(t, ctx, source, "history", append(byDate, "-to", "7000000",
+			"-delegatorHistoryFile", filepath.Join(cachedDir, "delegatorHistoryByDate.csv"),
+			"-validatorHistoryFile", filepath.Join(cachedDir, "validatorHistoryByDate.csv"))...)
+		assert.Nil(t, err)
+		assert.Equal(t, `delegator,height,time,stake,change
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,6984001,2023-09-29T00:00:00Z,40,40
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,6998401,2023-09-30T00:00:00Z,40,0
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,6984001,2023-09-29T00:00:00Z,179,179
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,6998401,2023-09-30T00:00:00Z,35,-144
+`, readFile(t, filepath.Join(cachedDir, "delegatorHistoryByDate.csv")))
+	}
+
+	// without the cache the node being down fails the run
+	_, err = live(t, context.Background(), &stubSource{unavailable: true}, "history",
+		append(args(t.TempDir()), "-to", "7000000")...)
+	assert.Equal(t, failuresModule.Network, failuresModule.ClassOf(err))
+
+	err = replay(fixture, "history", "-height", "7000000", "-from", "1")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+	err = replay(fixture, "history", "-fromDate", "2022-06-01")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
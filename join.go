@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	addressesModule "github.com/brianosaurus/challenge1/addresses"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+)
+
+// reads the delegator and voting_power columns of a delegations csv written by WriteDelegations
+func ReadDelegationBalances(reader *csv.Reader) ([]addressesModule.Balance, error) {
+	balances := make([]addressesModule.Balance, 0)
+
+	// extra columns such as re-encoded addresses are allowed
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header) < 2 || header[0] != "delegator" || header[1] != "voting_power" {
+		return nil, fmt.Errorf("not a delegations csv, expected delegator,voting_power columns but got %v", header)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 2 {
+			return nil, fmt.Errorf("short record %v", record)
+		}
+
+		balances = append(balances, addressesModule.Balance{Address: record[0], Balance: record[1]})
+	}
+
+	return balances, nil
+}
+
+// writes delegations from two chains joined on the account bytes to a csv file
+func WriteJoinedBalances(joinedBalances []addressesModule.JoinedBalance, writer *csv.Writer) error {
+	if err := writer.Write([]string{"account", "left_delegator", "left_voting_power", "right_delegator", "right_voting_power"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, joinedBalance := range joinedBalances {
+		strBalance := []string{joinedBalance.AccountHex, "", "", "", ""}
+
+		if joinedBalance.Left != nil {
+			strBalance[1] = joinedBalance.Left.Address
+			strBalance[2] = joinedBalance.Left.Balance
+		}
+
+		if joinedBalance.Right != nil {
+			strBalance[3] = joinedBalance.Right.Address
+			strBalance[4] = joinedBalance.Right.Balance
+		}
+
+		if err := writer.Write(strBalance); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+func readDelegationBalancesFile(path string) ([]addressesModule.Balance, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.IO, err)
+	}
+	defer file.Close()
+
+	balances, err := ReadDelegationBalances(csv.NewReader(file))
+	if err != nil {
+		return nil, failuresModule.Errorf(failuresModule.IO, "%s: %w", path, err)
+	}
+
+	return balances, nil
+}
+
+// the join subcommand joins the delegations csv files of two chains on the underlying account bytes
+func joinCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var leftFile string
+	var rightFile string
+	var outputFile string
+	var outer bool
+
+	flags.StringVar(&leftFile, "left", "", "the delegations csv of the first chain (required)")
+	flags.StringVar(&rightFile, "right", "", "the delegations csv of the second chain (required)")
+	flags.StringVar(&outputFile, "output", "joined.csv", "the output file for the joined csv")
+	flags.BoolVar(&outer, "outer", false, "also write accounts that only delegate on one of the chains")
+
+	return func(ctx context.Context) error {
+		if leftFile == "" || rightFile == "" {
+			return failuresModule.Errorf(failuresModule.Config, "join: -left and -right are required")
+		}
+
+		leftBalances, err := readDelegationBalancesFile(leftFile)
+		if err != nil {
+			return err
+		}
+
+		rightBalances, err := readDelegationBalancesFile(rightFile)
+		if err != nil {
+			return err
+		}
+
+		joinedBalances, err := addressesModule.Join(leftBalances, rightBalances, outer)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+
+		loggingModule.FromContext(ctx).Info("writing joined delegations", "file", outputFile)
+
+		joinedFile, err := os.OpenFile(outputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer joinedFile.Close()
+
+		return WriteJoinedBalances(joinedBalances, csv.NewWriter(joinedFile))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	addressesModule "github.com/brianosaurus/challenge1/addresses"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+const (
+	// the delegations csv of osmosis, with a re-encoded column as written with -prefixes cosmos
+	LEFT_DELEGATIONS = `delegator,voting_power,delegator_cosmos
+osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw,30,cosmos1qyqszqgpqyqszqgpqyqszqgpqyqszqgpjnp7du
+osmo1qgpqyqszqgpqyqszqgpqyqszqgpqyqsztv5tsc,30,cosmos1qgpqyqszqgpqyqszqgpqyqszqgpqyqszrh8mx2
+`
+	// the delegations csv of juno, sharing the first account
+	RIGHT_DELEGATIONS = `delegator,voting_power
+juno1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr549pth,7
+juno1qyqszqgpqyqszqgpqyqszqgpqyqszqgpypz92q,5
+`
+)
+
+func TestWriteJoinedBalances(t *testing.T) {
+	left, err := ReadDelegationBalances(csv.NewReader(strings.NewReader(LEFT_DELEGATIONS)))
+	assert.Nil(t, err)
+	right, err := ReadDelegationBalances(csv.NewReader(strings.NewReader(RIGHT_DELEGATIONS)))
+	assert.Nil(t, err)
+
+	joined, err := addressesModule.Join(left, right, true)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteJoinedBalances(joined, csv.NewWriter(&buf)))
+
+	// by account, with the side an account doesn't delegate on left empty
+	assert.Equal(t, `account,left_delegator,left_voting_power,right_delegator,right_voting_power
+0101010101010101010101010101010101010101,osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw,30,juno1qyqszqgpqyqszqgpqyqszqgpqyqszqgpypz92q,5
+0202020202020202020202020202020202020202,osmo1qgpqyqszqgpqyqszqgpqyqszqgpqyqsztv5tsc,30,,
+0303030303030303030303030303030303030303,,,juno1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr549pth,7
+`, buf.String())
+
+	_, err = ReadDelegationBalances(csv.NewReader(strings.NewReader("moniker,voting_power\nInotel,5956506\n")))
+	assert.ErrorContains(t, err, "not a delegations csv")
+}
+
+func TestJoinCommand(t *testing.T) {
+	dir := t.TempDir()
+	leftFile, rightFile := filepath.Join(dir, "osmosis.csv"), filepath.Join(dir, "juno.csv")
+	outputFile := filepath.Join(dir, "joined.csv")
+	assert.Nil(t, os.WriteFile(leftFile, []byte(LEFT_DELEGATIONS), 0o644))
+	assert.Nil(t, os.WriteFile(rightFile, []byte(RIGHT_DELEGATIONS), 0o644))
+
+	join := func(args ...string) error {
+		_, err := runCommand(findCommand("join"), append([]string{"-quiet", "-output", outputFile}, args...))
+		return err
+	}
+
+	assert.Nil(t, join("-left", leftFile, "-right", rightFile))
+	assert.Equal(t, `account,left_delegator,left_voting_power,right_delegator,right_voting_power
+0101010101010101010101010101010101010101,osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw,30,juno1qyqszqgpqyqszqgpqyqszqgpqyqszqgpypz92q,5
+`, readFile(t, outputFile))
+
+	assert.Nil(t, join("-left", leftFile, "-right", rightFile, "-outer"))
+	assert.Equal(t, 4, strings.Count(readFile(t, outputFile), "\n"))
+
+	err := join("-left", leftFile)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+
+	err = join("-left", leftFile, "-right", filepath.Join(dir, "missing.csv"))
+	assert.Equal(t, failuresModule.IO, failuresModule.ClassOf(err))
+}
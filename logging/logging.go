@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	tmlog "github.com/tendermint/tendermint/libs/log"
+)
+
+// the log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// the log levels, from the most to the least output
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelError = "error"
+)
+
+// the logger used by every package. It is tendermint's leveled key/value logger, e.g.
+// logger.Info("fetched validators", "rows", 150, "elapsed", elapsed)
+type Logger = tmlog.Logger
+
+type contextKey struct{}
+
+// a text or json logger writing the events of level and above to writer
+func New(writer io.Writer, format string, level string) (Logger, error) {
+	var logger Logger
+
+	switch format {
+	case FormatText:
+		logger = tmlog.NewTMLogger(tmlog.NewSyncWriter(writer))
+	case FormatJSON:
+		logger = tmlog.NewTMJSONLogger(tmlog.NewSyncWriter(writer))
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatText, FormatJSON)
+	}
+
+	allowLevel, err := tmlog.AllowLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	return tmlog.NewFilter(logger, allowLevel), nil
+}
+
+// a logger dropping everything
+func NewNop() Logger {
+	return tmlog.NewNopLogger()
+}
+
+// a copy of ctx carrying logger so it reaches the packages fetching the data without changing their signatures
+func NewContext(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// the logger carried by ctx, or one dropping everything
+func FromContext(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(contextKey{}).(Logger); ok {
+		return logger
+	}
+
+	return NewNop()
+}
+
+// the time since start rounded to milliseconds, for elapsed fields
+func Since(start time.Time) time.Duration {
+	return time.Since(start).Round(time.Millisecond)
+}
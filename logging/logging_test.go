@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := New(&buf, FormatJSON, LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.With("validator", "osmovaloper1a").Info("fetched delegations", "page", 2, "rows", 100,
+		"elapsed", 1500*time.Millisecond)
+	logger.Debug("dropped below the level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 1, len(lines))
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "fetched delegations", event["_msg"])
+	assert.Equal(t, "info", event["level"])
+	assert.Equal(t, "osmovaloper1a", event["validator"])
+	assert.Equal(t, float64(2), event["page"])
+	assert.Equal(t, float64(100), event["rows"])
+	assert.Equal(t, "1.5s", event["elapsed"])
+}
+
+func TestNewText(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := New(&buf, FormatText, LevelError)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("dropped below the level")
+	logger.Error("run failed", "class", "network")
+
+	assert.Contains(t, buf.String(), "run failed")
+	assert.Contains(t, buf.String(), "class=network")
+	assert.NotContains(t, buf.String(), "dropped")
+
+	_, err = New(&buf, "xml", LevelInfo)
+	assert.Error(t, err)
+
+	_, err = New(&buf, FormatText, "loud")
+	assert.Error(t, err)
+}
+
+func TestContext(t *testing.T) {
+	// without a logger nothing is logged and nothing panics
+	FromContext(context.Background()).Info("nowhere")
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatText, LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	FromContext(NewContext(context.Background(), logger)).Info("somewhere")
+	assert.Contains(t, buf.String(), "somewhere")
+}
@@ -1,175 +1,367 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"flag"
-	"log"
-	"os"
-	"sort"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	configModule "github.com/brianosaurus/challenge1/config"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	fixtureModule "github.com/brianosaurus/challenge1/fixture"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	metricsModule "github.com/brianosaurus/challenge1/metrics"
+	progressModule "github.com/brianosaurus/challenge1/progress"
+)
 
-	sdk "github.com/cosmos/cosmos-sdk/types"
-	delegationsModule "github.com/brianosaurus/challenge1/delegations"
-	validatorsModule "github.com/brianosaurus/challenge1/validators"
+// stderr, shared by the log and the progress bar
+var stderr = progressModule.NewTerminal(os.Stderr)
 
-	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
-	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
-)
+// a subcommand of getData. setup registers the command's flags and returns the function running it once the flags
+// are parsed. The context is done on SIGINT, SIGTERM or once -timeout has passed
+type command struct {
+	name        string
+	description string
+	setup       func(flags *flag.FlagSet) func(ctx context.Context) error
+}
+
+var commands = []command{
+	{"snapshot", "fetch validators and delegations and write every export (the default)", snapshotCommand},
+	{"validators", "fetch and write only the validators", validatorsCommand},
+	{"delegations", "fetch the delegations of every validator and write the delegation exports", delegationsCommand},
+	{"multi", "snapshot every chain in a chains file", multiCommand},
+	{"diff", "compare the delegations csv files of two snapshots", diffCommand},
+	{"serve", "serve the files of a snapshot directory, or with -refresh a live snapshot api, over http", serveCommand},
+	{"daemon", "take snapshots on a schedule or every few blocks and prune old ones", daemonCommand},
+	{"watch", "alert on large stake changes of a watchlist between consecutive snapshots", watchCommand},
+	{"stream", "keep the delegations up to date from the node's staking events and log every change", streamCommand},
+	{"history", "snapshot many heights and write the stake of every validator and delegator over time", historyCommand},
+	{"purgeCache", "remove responses from the -queryCacheDir query cache", purgeCacheCommand},
+	{"airdrop", "build an airdrop allocation from the delegations at a pinned height", airdropCommand},
+	{"join", "join the delegations csv files of two chains on the account bytes", joinCommand},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+
+	return nil
+}
+
+// the flags of every command, by command name, so config files can be checked for unknown settings
+func commandFlagNames() map[string]map[string]bool {
+	flagNames := make(map[string]map[string]bool)
+
+	for _, command := range commands {
+		flags := flag.NewFlagSet(command.name, flag.ContinueOnError)
+		addRunFlags(flags, &runOptions{})
+		command.setup(flags)
+
+		flagNames[command.name] = make(map[string]bool)
+		flags.VisitAll(func(f *flag.Flag) {
+			flagNames[command.name][f.Name] = true
+		})
+	}
+
+	return flagNames
+}
+
+// the options every command shares for the run itself
+type runOptions struct {
+	timeout   time.Duration
+	logFormat string
+	quiet     bool
+	verbose   bool
 
-// writes delegations sorted by voting power to a csv file
-func WriteDelegations(delegationsMap *delegationsModule.DelegationsWithTotalBalance, writer *csv.Writer) {
-	fmt.Println("Writing delegations to csv file")
+	retries     int
+	metricsAddr string
+	pushGateway string
+	pushJob     string
 
-	delegations := make([]string, 0)
+	queryCacheDir   string
+	queryCacheMaxMB int64
+	chainID         string
 
-	for _, delegationWithTotalBalance := range *delegationsMap {
-		if len(delegationWithTotalBalance.DelegationResponses) == 0 {
-			continue
+	record string
+	replay string
+}
+
+// registers the flags shared by every command
+func addRunFlags(flags *flag.FlagSet, options *runOptions) {
+	flags.DurationVar(&options.timeout, "timeout", 0, "stop the run after this long (e.g. 30m), 0 for no limit")
+	flags.StringVar(&options.logFormat, "logFormat", loggingModule.FormatText, "the format of the log on stderr, text or json")
+	flags.BoolVar(&options.quiet, "quiet", false, "only log errors")
+	flags.BoolVar(&options.verbose, "verbose", false, "also log debug events such as every page fetched")
+	flags.IntVar(&options.retries, "retries", 0, "retry a query this many times when the node is unavailable or overloaded")
+	flags.StringVar(&options.metricsAddr, "metricsAddr", "", "serve prometheus metrics on this address during the run (e.g. :9100)")
+	flags.StringVar(&options.pushGateway, "pushGateway", "", "push the metrics to this Pushgateway url when the run ends")
+	flags.StringVar(&options.pushJob, "pushJob", "getData", "the job name the metrics are pushed under")
+	flags.StringVar(&options.queryCacheDir, "queryCacheDir", "",
+		"cache the responses of queries pinned to a height in this directory and answer from it when run again")
+	flags.Int64Var(&options.queryCacheMaxMB, "queryCacheMaxMB", 1024,
+		"the most megabytes the query cache holds before the least recently used responses are evicted, 0 for no limit")
+	flags.StringVar(&options.chainID, "chainId", "",
+		"the chain id of the node (e.g. osmosis-1) so the query cache is used without asking the node for it")
+	flags.StringVar(&options.record, "record", "", "record every query of the run and its response into this fixture file")
+	flags.StringVar(&options.replay, "replay", "",
+		"answer every query from this fixture file, recorded with -record, instead of the node")
+}
+
+// counts the queries of the run, retries the ones that failed transiently and carries the -queryCacheDir cache for
+// the fetches pinned to a height. The queries are recorded to -record or answered from -replay. The metrics are
+// served on -metricsAddr until the returned stop is called, which also records the end of the run, pushes the
+// metrics to -pushGateway and writes the -record fixture
+func (options *runOptions) instrument(ctx context.Context) (context.Context, func(err error), error) {
+	start := time.Now()
+	metrics := metricsModule.New()
+	logger := loggingModule.FromContext(ctx)
+
+	if options.record != "" && options.replay != "" {
+		return nil, nil, failuresModule.Errorf(failuresModule.Config, "-record and -replay can't be used together")
+	}
+
+	var recorder *fixtureModule.Recorder
+	if options.record != "" {
+		// first so retried queries are recorded once
+		recorder = fixtureModule.NewRecorder()
+		ctx = clientModule.WithInterceptors(ctx, recorder.Interceptor())
+	}
+
+	if options.replay != "" {
+		fixture, err := fixtureModule.Load(options.replay)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		source, err := fixtureModule.NewSource(fixture)
+		if err != nil {
+			return nil, nil, failuresModule.Errorf(failuresModule.Config, "fixture %s: %w", options.replay, err)
 		}
 
-		address := delegationWithTotalBalance.DelegationResponses[0].Delegation.DelegatorAddress
-		delegations = append(delegations, address)
+		logger.Debug("replaying queries", "file", options.replay, "calls", len(fixture.Calls))
+		ctx = clientModule.WithSource(ctx, source)
 	}
 
-	sort.Slice(delegations, func(i, j int) bool {
-		return (*delegationsMap)[delegations[i]].TotalBalance.Cmp(
-			(*delegationsMap)[delegations[j]].TotalBalance) == 1
-		})
+	if options.queryCacheDir != "" {
+		if options.queryCacheMaxMB < 0 {
+			return nil, nil, failuresModule.Errorf(failuresModule.Config, "-queryCacheMaxMB can't be negative")
+		}
 
-	writer.Write([]string{"delegator", "voting_power"})
+		responseCache, err := cacheModule.Open(options.queryCacheDir, options.queryCacheMaxMB<<20)
+		if err != nil {
+			return nil, nil, err
+		}
 
-	for key, delegation := range *delegationsMap {
-		strDelegaton := make([]string, 0)
-		strDelegaton = append(strDelegaton, key)
-		strDelegaton = append(strDelegaton, delegation.TotalBalance.String())
+		logger.Debug("using the query cache", "dir", options.queryCacheDir, "bytes", responseCache.Size())
+		ctx = cacheModule.NewContext(ctx, responseCache)
+	}
 
-		writer.Write(strDelegaton)
+	if options.chainID != "" {
+		ctx = cacheModule.WithChainID(ctx, options.chainID)
 	}
 
-	writer.Flush()
+	ctx = metricsModule.NewContext(ctx, metrics)
+	ctx = clientModule.WithInterceptors(ctx,
+		clientModule.RetryInterceptor(options.retries, func(method string, attempt int, err error) {
+			metrics.Retried(method)
+			logger.Info("retrying query", "method", method, "attempt", attempt, "err", err)
+		}),
+		metrics.Interceptor())
+
+	stopServer := func() {}
+
+	if options.metricsAddr != "" {
+		listener, err := net.Listen("tcp", options.metricsAddr)
+		if err != nil {
+			return nil, nil, failuresModule.Errorf(failuresModule.Config, "-metricsAddr: %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		server := &http.Server{Handler: mux}
+
+		go server.Serve(listener)
+		logger.Info("serving metrics", "addr", listener.Addr().String())
 
-	if err := writer.Error(); err != nil {
-		log.Fatal(err)
+		stopServer = func() { server.Close() }
 	}
-}
 
-// writes delegations who are delegated to multiple validators
-func WriteMultipleDelegations(validators *delegationTypes.Validators, delegationsMap *delegationsModule.DelegationsWithTotalBalance, 
-	delegationResponses *delegationTypes.DelegationResponses,
-	writer *csv.Writer,
-) {
-	fmt.Println("Writing multiple delegations to csv file")
-
-	validatorsMap := make(map[string]delegationTypes.Validator)
-	for _, validator := range *validators {
-		validatorsMap[validator.OperatorAddress] = validator
-	}
-
-	writer.Write([]string{"delegator", "validator", "bonded_tokens"})
-
-	for _, delegationWithTotalBalance := range *delegationsMap {
-		delegationResponses := delegationWithTotalBalance.DelegationResponses
-
-		if len(delegationResponses) > 1 {
-			for _, delegationResponse := range delegationWithTotalBalance.DelegationResponses {
-				strDelegaton := make([]string, 0)
-				strDelegaton = append(strDelegaton, delegationResponse.Delegation.DelegatorAddress)
-				strDelegaton = append(strDelegaton, delegationResponse.Delegation.ValidatorAddress)
-
-				// this is to get the delegator's bonded tokens. If a delegator is unbonding or redelegating
-				// the tokens are still bonded unless the validator itself is unbonded. In that case the delegator
-				// is by default unbonded. There is an issue here which is noted in this github issue:
-				// https://github.com/cosmos/cosmos-sdk/issues/11350
-				if validator, ok := validatorsMap[delegationResponse.Delegation.ValidatorAddress]; ok &&
-					validator.Status == delegationTypes.Bonded {
-					strDelegaton = append(strDelegaton, delegationResponse.Balance.Amount.String())
-				} else {
-					strDelegaton = append(strDelegaton, "0")
-				}
-
-				writer.Write(strDelegaton)
+	return ctx, func(err error) {
+		metrics.Finish(start, err)
+		stopServer()
+
+		if options.pushGateway != "" {
+			if pushErr := metrics.Push(options.pushGateway, options.pushJob); pushErr != nil {
+				logger.Error("pushing metrics failed", "url", options.pushGateway, "err", pushErr)
 			}
 		}
-	}
 
-	writer.Flush()
+		if recorder != nil {
+			fixture := recorder.Fixture()
+			if saveErr := fixture.Save(options.record); saveErr != nil {
+				logger.Error("writing the recorded queries failed", "file", options.record, "err", saveErr)
+			} else {
+				logger.Info("recorded queries", "file", options.record, "calls", len(fixture.Calls))
+			}
+		}
+	}, nil
+}
+
+// the stderr logger for the run
+func (options *runOptions) logger() (loggingModule.Logger, error) {
+	level := loggingModule.LevelInfo
+
+	switch {
+	case options.quiet && options.verbose:
+		return nil, failuresModule.Errorf(failuresModule.Config, "-quiet and -verbose can't be used together")
+	case options.quiet:
+		level = loggingModule.LevelError
+	case options.verbose:
+		level = loggingModule.LevelDebug
+	}
 
-	if err := writer.Error(); err != nil {
-		log.Fatal(err)
+	logger, err := loggingModule.New(stderr, options.logFormat, level)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.Config, err)
 	}
+
+	return logger, nil
 }
 
-// writes validators sorted by voting power to a csv file
-func WriteValidators(validators *validatorTypes.Validators, writer *csv.Writer) {
-	fmt.Println("Writing validators")
+// parses the command line for a command, fills in every flag not given on it from the environment and the config
+// file and runs it. Bad settings are returned as config errors before the command does any work. The logger for the
+// run is returned even when it fails so the error can be logged
+func runCommand(command *command, args []string) (loggingModule.Logger, error) {
+	var configFile string
+	var profile string
+	var options runOptions
+
+	flags := flag.NewFlagSet(command.name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s %s:\n  %s\n\n", os.Args[0], command.name, command.description)
+		flags.PrintDefaults()
+	}
 
-	sort.SliceStable(*validators, func(i, j int) bool {
-		return validatorTypes.ValidatorsByVotingPower(*validators).Less(i, j, sdk.DefaultPowerReduction)
-	})
+	flags.StringVar(&configFile, "config", os.Getenv(configModule.EnvName("config")),
+		"a yaml, toml or json config file with defaults for any flag")
+	flags.StringVar(&profile, "profile", os.Getenv(configModule.EnvName("profile")),
+		"the profile in the config file to use")
+	addRunFlags(flags, &options)
+	run := command.setup(flags)
+	flags.Parse(args)
 
-	// write headers
-	writer.Write([]string{"moniker", "voting_power", "self_delegation", "total_delegation"})
+	configErr := applyConfig(flags, command.name, configFile, profile)
 
-	for _, validator := range *validators {
-		strValidator := make([]string, 0)
-		strValidator = append(strValidator, validator.Description.Moniker)
-		strValidator = append(strValidator, fmt.Sprint(validator.ConsensusPower(sdk.DefaultPowerReduction)))
-		strValidator = append(strValidator, validator.MinSelfDelegation.String())
-		strValidator = append(strValidator, validator.DelegatorShares.String())
+	// the logger is set up from whatever was parsed so even bad settings are logged in the requested format
+	logger, err := options.logger()
+	if err != nil {
+		logger, _ = loggingModule.New(stderr, loggingModule.FormatText, loggingModule.LevelInfo)
+		if configErr == nil {
+			configErr = err
+		}
+	}
 
-		writer.Write(strValidator)
+	if configErr != nil {
+		return logger, configErr
 	}
 
-	writer.Flush()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	if err := writer.Error(); err != nil {
-		log.Fatal(err)
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
 	}
-}
 
-func main() {
-	var node string
-	var validatorOutputFile string
-	var delegationsOutputFile string
-	var multipleDelegationsOutputFile string
-	flag.StringVar(&node, "node", "grpc.osmosis.zone:9090", "the node to query")
-	flag.StringVar(&validatorOutputFile, "validatorFile", "validators.csv", "the output file for the validators csv")
-	flag.StringVar(&delegationsOutputFile, "delegationsFile", "delegations.csv", "the output file for the delegations csv")
-	flag.StringVar(&multipleDelegationsOutputFile, "multipleDelegationsFile", "multipleDelegations.csv",
-		"the output csv file for the delegations who delegated to more than one validator")
-	flag.Parse()
-
-	validators, err := validatorsModule.GetValidators(node)
+	ctx, finish, err := options.instrument(loggingModule.NewContext(ctx, logger.With("command", command.name)))
 	if err != nil {
-		panic(err)
+		return logger, err
 	}
 
-	// open validators output csv and overwrite if exists
-	validatorsFile, err := os.OpenFile(validatorOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
-	if err != nil {
-		log.Fatal(err)
+	err = run(ctx)
+	finish(err)
+
+	return logger, err
+}
+
+// sets every flag not given on the command line from the environment or the config file
+func applyConfig(flags *flag.FlagSet, commandName string, configFile string, profile string) error {
+	values := make(map[string]string)
+
+	if configFile != "" {
+		config, err := configModule.Load(configFile, commandFlagNames())
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.Config, err)
+		}
+
+		values, err = config.Resolve(commandName, profile)
+		if err != nil {
+			return failuresModule.Errorf(failuresModule.Config, "config file %s: %w", configFile, err)
+		}
+	} else if profile != "" {
+		return failuresModule.Errorf(failuresModule.Config, "-profile needs a -config file")
 	}
-	defer validatorsFile.Close()
-	WriteValidators(validators, csv.NewWriter(validatorsFile))
 
-	delegationResponses, err := delegationsModule.GetDelegationResponses(node, validators)
+	return failuresModule.Wrap(failuresModule.Config, configModule.Apply(flags, values))
+}
+
+// runs a command and exits with the exit code for the class of its error
+func exitWith(command *command, args []string) {
+	logger, err := runCommand(command, args)
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("run failed", "command", command.name, "class", failuresModule.ClassOf(err).String(), "err", err)
+		os.Exit(failuresModule.ExitCode(err))
 	}
+}
 
-	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", command.name, command.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command. Without a command the flags are passed to snapshot.\n",
+		os.Args[0])
+}
 
-	delegationsFile, err := os.OpenFile(delegationsOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
-	if err != nil {
-		log.Fatal(err)
+func main() {
+	args := os.Args[1:]
+
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		if len(args) > 1 {
+			if command := findCommand(args[1]); command != nil {
+				exitWith(command, []string{"-h"})
+				return
+			}
+		}
+
+		usage()
+		return
 	}
-	defer delegationsFile.Close()
-	WriteDelegations(delegationsMap, csv.NewWriter(delegationsFile))
 
-	multipleDelegationsFile, err := os.OpenFile(multipleDelegationsOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
-	if err != nil {
-		log.Fatal(err)
+	// keep the original flag only invocation working
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		exitWith(findCommand("snapshot"), args)
+		return
+	}
+
+	command := findCommand(args[0])
+	if command == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
 	}
-	defer multipleDelegationsFile.Close()
-	WriteMultipleDelegations(validators, delegationsMap, delegationResponses, csv.NewWriter(multipleDelegationsFile))
+
+	exitWith(command, args[1:])
 }
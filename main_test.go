@@ -1,330 +1,448 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
-	"encoding/json"
+	"context"
+	"flag"
 	"io"
-
-	context "context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	grpc1 "github.com/gogo/protobuf/grpc"
+	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	fixtureModule "github.com/brianosaurus/challenge1/fixture"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+
+	"github.com/cosmos/cosmos-sdk/client/grpc/tmservice"
+	codecTypes "github.com/cosmos/cosmos-sdk/codec/types"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/ed25519"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
 
-	delegationsModule "github.com/brianosaurus/challenge1/delegations"
-	validatorsModule "github.com/brianosaurus/challenge1/validators"
-
-	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
-	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
-
-	ed25519 "github.com/cosmos/cosmos-sdk/crypto/keys/ed25519"
-	// cryptotypes "github.com/cosmos/cosmos-sdk/crypto/types"
+const (
+	LATEST_HEIGHT = 7000000
 
-	codec "github.com/cosmos/cosmos-sdk/codec/types"
-	"github.com/stretchr/testify/assert"
+	FIRST_VALIDATOR  = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	SECOND_VALIDATOR = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb"
+	FIRST_DELEGATOR  = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a"
+	SECOND_DELEGATOR = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"
 )
 
-const (
-	DELEGATION_RESPONSES = 
-`[
-	{
-		"delegation": {
-			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
-			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
-			"shares":"20.000000000000000000"
-		},
-		"balance":{
-			"denom":"uosmo",
-			"amount":"20"
-		}
-	},
-	{
-		"delegation": {
-			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
-			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
-			"shares":"20.000000000000000000"
-		},
-		"balance":{
-			"denom":"uosmo",
-			"amount":"20"
-		}
-	},
-	{
-		"delegation": {
-			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
-			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
-			"shares":"10.000000000000000000"
-		},
-		"balance":{
-			"denom":"uosmo",
-			"amount":"10"
-		}
-	},
-	{
-		"delegation": {
-			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
-			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
-			"shares":"10.000000000000000000"
-		},
-		"balance":{
-			"denom":"uosmo",
-			"amount":"10"
-		}
-	}
-]`
-
-	VALIDATORS = 
-`[
-	{
-		"operator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
-		"consensus_pubkey": { 
-			"type": "tendermint/PubKeyEd25519",
-			"value": "Y2h1Y2tldC1wdWJrZXk="
-		},
-		"status":3,
-		"tokens":"5956506193276",
-		"delegator_shares":"5956506193276.000000000000000000",
-		"description":{
-			"moniker":"Inotel",
-			"identity":"975D494265B1AC25",
-			"website":"https://inotel.ro",
-			"details":"We do staking for a living"
-		},
-		"unbonding_time":"1970-01-01T00:00:00Z",
-		"commission":{
-			"commission_rates":{
-				"rate":"0.050000000000000000",
-				"max_rate":"0.300000000000000000",
-				"max_change_rate":"0.300000000000000000"
-				},
-			"update_time":"2022-03-29T11:54:26.447424547Z"
-		},
-		"min_self_delegation":"1"
-	},
-	{
-		"operator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
-		"consensus_pubkey": { 
-			"type": "tendermint/PubKeyEd25519",
-			"value": "Y2h1Y2tldC1wdWJrZXk="
-		},
-		"status":3,
-		"tokens":"5956506193276",
-		"delegator_shares":"5956506193276.000000000000000000",
-		"description":{
-			"moniker":"Inotel Second",
-			"identity":"975D494265B1AC25",
-			"website":"https://inotel.ro",
-			"details":"We do staking for a living"
-		},
-		"unbonding_time":"1970-01-01T00:00:00Z",
-		"commission":{
-			"commission_rates":{
-				"rate":"0.050000000000000000",
-				"max_rate":"0.300000000000000000",
-				"max_change_rate":"0.300000000000000000"
-				},
-			"update_time":"2022-03-29T11:54:26.447424547Z"
-		},
-		"min_self_delegation":"1"
-	}
- ]`
-	)
+// every block is 6 seconds after the previous one
+var genesisTime = time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// a source answering as a node of a chain with two validators and two delegators would. The second delegator's stake
+// with the second validator grows by one every 100 blocks back from the latest height. Every query goes through
+// clientModule.Invoke so it can be recorded into a fixture
+type stubSource struct {
+	// the delegations of this validator fail as if the node went away
+	failValidator string
+	// a block is made every time the latest one is asked for
+	moving bool
+	blocks int64
+	// every query fails as if the node were down
+	unavailable bool
+}
 
-var tt *testing.T
+func (source *stubSource) Staking(ctx context.Context, node string) (clientModule.StakingQuerier, func(), error) {
+	return &stubQuerier{source: source}, func() {}, nil
+}
 
-type queryClient struct {
-	validatorTypes.QueryClient
+func (source *stubSource) Distribution(ctx context.Context, node string) (clientModule.DistributionQuerier, func(), error) {
+	return &stubQuerier{source: source}, func() {}, nil
 }
 
-func (q *queryClient) Validators(ctx context.Context, in *validatorTypes.QueryValidatorsRequest, 
-	opts ...grpc.CallOption) (*validatorTypes.QueryValidatorsResponse, error) {
-	var responses validatorTypes.Validators
-	err := json.Unmarshal([]byte(VALIDATORS), &responses)
+func (source *stubSource) LatestHeight(ctx context.Context, node string) (int64, error) {
+	latest := &tmservice.GetLatestBlockResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock",
+		&tmservice.GetLatestBlockRequest{}, latest, source.invoke)
 	if err != nil {
-		tt.Error(err)
+		return 0, err
 	}
 
-	response := validatorTypes.QueryValidatorsResponse{
-		Validators: responses,
-		Pagination: nil,
-	}
-
-	return &response, nil
+	return latest.SdkBlock.Header.Height, nil
 }
 
-func stubValidatorResponses() {
-	// stub out the grpc connection for testing
-	validatorsModule.GrpcDial = func(node string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-		return nil, nil
+func (source *stubSource) ChainID(ctx context.Context, node string) (string, error) {
+	latest := &tmservice.GetLatestBlockResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock",
+		&tmservice.GetLatestBlockRequest{}, latest, source.invoke)
+	if err != nil {
+		return "", err
 	}
 
-	validatorsModule.ValidatorTypesNewQueryClient = func(conn grpc1.ClientConn) validatorTypes.QueryClient {
-		client := &queryClient{}
-		return client
-	}
+	return latest.SdkBlock.Header.ChainID, nil
 }
 
-func (q *queryClient) ValidatorDelegations(ctx context.Context, in *delegationTypes.QueryValidatorDelegationsRequest, 
-	opts ...grpc.CallOption) (*delegationTypes.QueryValidatorDelegationsResponse, error) {
-	var responses []delegationTypes.DelegationResponse
-	err := json.Unmarshal([]byte(DELEGATION_RESPONSES), &responses)
+func (source *stubSource) BlockTime(ctx context.Context, node string, height int64) (time.Time, error) {
+	block := &tmservice.GetBlockByHeightResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.base.tendermint.v1beta1.Service/GetBlockByHeight",
+		&tmservice.GetBlockByHeightRequest{Height: height}, block, source.invoke)
 	if err != nil {
-		tt.Error(err)
+		return time.Time{}, err
 	}
 
-	response := delegationTypes.QueryValidatorDelegationsResponse{
-		DelegationResponses: responses,
-		Pagination: nil,
+	return block.SdkBlock.Header.Time, nil
+}
+
+func stubValidators() stakingTypes.Validators {
+	validators := stakingTypes.Validators{}
+
+	for i, operatorAddress := range []string{FIRST_VALIDATOR, SECOND_VALIDATOR} {
+		pubKey, _ := codecTypes.NewAnyWithValue(ed25519.GenPrivKeyFromSecret([]byte(operatorAddress)).PubKey())
+		tokens := sdk.NewInt(5956506193276 - int64(i)*1000000000000)
+
+		validators = append(validators, stakingTypes.Validator{
+			OperatorAddress:   operatorAddress,
+			ConsensusPubkey:   pubKey,
+			Status:            stakingTypes.Bonded,
+			Tokens:            tokens,
+			DelegatorShares:   sdk.NewDecFromInt(tokens),
+			Description:       stakingTypes.Description{Moniker: []string{"Inotel", "Inotel Second"}[i]},
+			MinSelfDelegation: sdk.OneInt(),
+		})
 	}
 
-	var delegationsResponse delegationTypes.QueryValidatorDelegationsResponse
+	return validators
+}
 
-	// filter for this validataor
-	for _, delegation := range response.DelegationResponses {
-		if delegation.Delegation.ValidatorAddress == in.ValidatorAddr {
-			delegationsResponse.DelegationResponses = append(delegationsResponse.DelegationResponses, delegation)
+func stubDelegations(height int64) stakingTypes.DelegationResponses {
+	delegation := func(delegator string, validator string, balance int64) stakingTypes.DelegationResponse {
+		return stakingTypes.DelegationResponse{
+			Delegation: stakingTypes.Delegation{DelegatorAddress: delegator, ValidatorAddress: validator,
+				Shares: sdk.NewDec(balance)},
+			Balance: sdk.NewInt64Coin("uosmo", balance),
 		}
 	}
 
-	return &delegationsResponse, nil
+	return stakingTypes.DelegationResponses{
+		delegation(FIRST_DELEGATOR, FIRST_VALIDATOR, 20),
+		delegation(FIRST_DELEGATOR, SECOND_VALIDATOR, 20),
+		delegation(SECOND_DELEGATOR, FIRST_VALIDATOR, 10),
+		delegation(SECOND_DELEGATOR, SECOND_VALIDATOR, 10+(LATEST_HEIGHT-height)/100),
+	}
 }
 
-func stubDelegationResponses() {
-	// stub out the grpc connection for testing
-	delegationsModule.GrpcDial = func(node string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-		return nil, nil
+// answers a query at the height carried by ctx, the latest one if there is none
+func (source *stubSource) invoke(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+	opts ...grpc.CallOption,
+) error {
+	if source.unavailable {
+		return status.Error(codes.Unavailable, "connection refused")
 	}
 
-	delegationsModule.DelegationTypesNewQueryClient = func(conn grpc1.ClientConn) delegationTypes.QueryClient {
-		client := &queryClient{}
-		return client
+	height := int64(LATEST_HEIGHT)
+	if outgoing, _ := metadata.FromOutgoingContext(ctx); clientModule.BlockHeight(outgoing) > 0 {
+		height = clientModule.BlockHeight(outgoing)
 	}
-}
 
-func TestWriteValidators(t *testing.T) {
-	tt = t
-	stubValidatorResponses()
-	stubDelegationResponses()
-
-	validators, err := validatorsModule.GetValidators("node value not needed")
-	if err != nil {
-		t.Error(err)
+	for _, opt := range opts {
+		if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+			*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader, strconv.FormatInt(height, 10))
+		}
 	}
-	var buf bytes.Buffer
-  bufWriter := io.Writer(&buf)
-  writer := csv.NewWriter(bufWriter)
-
-  pk1 := ed25519.GenPrivKey().PubKey()
-	pk1Any, err := codec.NewAnyWithValue(pk1)
-  if err != nil {
-		t.Log(err)
-
-  }
-	for _, validator := range *validators {
-		validator.ConsensusPubkey = pk1Any
+
+	var filtered stakingTypes.DelegationResponses
+	filter := func(keep func(delegation stakingTypes.Delegation) bool) {
+		for _, delegationResponse := range stubDelegations(height) {
+			if keep(delegationResponse.Delegation) {
+				filtered = append(filtered, delegationResponse)
+			}
+		}
 	}
 
-  WriteValidators(validators, writer)
+	switch reply := reply.(type) {
+	case *stakingTypes.QueryValidatorsResponse:
+		*reply = stakingTypes.QueryValidatorsResponse{Validators: stubValidators(),
+			Pagination: &queryTypes.PageResponse{Total: 2}}
+	case *stakingTypes.QueryValidatorDelegationsResponse:
+		validator := req.(*stakingTypes.QueryValidatorDelegationsRequest).ValidatorAddr
+		if validator == source.failValidator {
+			return status.Error(codes.Unavailable, "connection reset")
+		}
+		filter(func(delegation stakingTypes.Delegation) bool { return delegation.ValidatorAddress == validator })
+		*reply = stakingTypes.QueryValidatorDelegationsResponse{DelegationResponses: filtered,
+			Pagination: &queryTypes.PageResponse{Total: uint64(len(filtered))}}
+	case *stakingTypes.QueryDelegatorDelegationsResponse:
+		delegator := req.(*stakingTypes.QueryDelegatorDelegationsRequest).DelegatorAddr
+		filter(func(delegation stakingTypes.Delegation) bool { return delegation.DelegatorAddress == delegator })
+		*reply = stakingTypes.QueryDelegatorDelegationsResponse{DelegationResponses: filtered,
+			Pagination: &queryTypes.PageResponse{Total: uint64(len(filtered))}}
+	case *stakingTypes.QueryDelegatorUnbondingDelegationsResponse:
+		*reply = stakingTypes.QueryDelegatorUnbondingDelegationsResponse{Pagination: &queryTypes.PageResponse{}}
+	case *distributionTypes.QueryDelegationTotalRewardsResponse:
+		*reply = distributionTypes.QueryDelegationTotalRewardsResponse{
+			Total: sdk.NewDecCoins(sdk.NewInt64DecCoin("uosmo", 5)),
+		}
+	case *tmservice.GetLatestBlockResponse:
+		latest := int64(LATEST_HEIGHT)
+		if source.moving {
+			latest += atomic.AddInt64(&source.blocks, 1)
+		}
+		*reply = tmservice.GetLatestBlockResponse{SdkBlock: &tmservice.Block{
+			Header: tmservice.Header{Height: latest, ChainID: "osmosis-1"},
+		}}
+	case *tmservice.GetBlockByHeightResponse:
+		blockHeight := req.(*tmservice.GetBlockByHeightRequest).Height
+		*reply = tmservice.GetBlockByHeightResponse{SdkBlock: &tmservice.Block{
+			Header: tmservice.Header{Height: blockHeight, ChainID: "osmosis-1",
+				Time: genesisTime.Add(time.Duration(blockHeight-1) * 6 * time.Second)},
+		}}
+	}
 
+	return nil
+}
 
-	// I have no idea why but this fixes tests
-	_ = buf.String()
-	
-	assert.Equal(t, 
-`moniker,voting_power,self_delegation,total_delegation
-Inotel,5956506,1,5956506193276.000000000000000000
-Inotel Second,5956506,1,5956506193276.000000000000000000
-`, buf.String()) 
+type stubQuerier struct {
+	source *stubSource
 }
 
-func TestWriteDelegations(t *testing.T) {
-	tt = t
-	stubValidatorResponses()
-	stubDelegationResponses()
+func (querier *stubQuerier) Validators(ctx context.Context, in *stakingTypes.QueryValidatorsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorsResponse, error) {
+	response := &stakingTypes.QueryValidatorsResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.staking.v1beta1.Query/Validators", in, response, querier.source.invoke,
+		opts...)
+	return response, err
+}
 
-	validators, err := validatorsModule.GetValidators("node value not needed")
-	if err != nil {
-		t.Error(err)
-	}
+func (querier *stubQuerier) ValidatorDelegations(ctx context.Context, in *stakingTypes.QueryValidatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryValidatorDelegationsResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.staking.v1beta1.Query/ValidatorDelegations", in, response,
+		querier.source.invoke, opts...)
+	return response, err
+}
 
-  pk1 := ed25519.GenPrivKey().PubKey()
-	pk1Any, err := codec.NewAnyWithValue(pk1)
-  if err != nil {
-		t.Log(err)
+func (querier *stubQuerier) DelegatorDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorDelegationsResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.staking.v1beta1.Query/DelegatorDelegations", in, response,
+		querier.source.invoke, opts...)
+	return response, err
+}
 
-  }
+func (querier *stubQuerier) DelegatorUnbondingDelegations(ctx context.Context,
+	in *stakingTypes.QueryDelegatorUnbondingDelegationsRequest, opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorUnbondingDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorUnbondingDelegationsResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.staking.v1beta1.Query/DelegatorUnbondingDelegations", in, response,
+		querier.source.invoke, opts...)
+	return response, err
+}
 
-	// missing value
-	for _, validator := range *validators {
-		validator.ConsensusPubkey = pk1Any
-	}
+func (querier *stubQuerier) DelegationTotalRewards(ctx context.Context,
+	in *distributionTypes.QueryDelegationTotalRewardsRequest, opts ...grpc.CallOption,
+) (*distributionTypes.QueryDelegationTotalRewardsResponse, error) {
+	response := &distributionTypes.QueryDelegationTotalRewardsResponse{}
+	err := clientModule.Invoke(ctx, "/cosmos.distribution.v1beta1.Query/DelegationTotalRewards", in, response,
+		querier.source.invoke, opts...)
+	return response, err
+}
 
-	delegationResponses, err := delegationsModule.GetDelegationResponses("node value not needed", validators)
+// runs a command against source with only its own flags, recording its queries into a fixture file. The path of the
+// fixture is returned with the command's error
+func live(t *testing.T, ctx context.Context, source clientModule.Source, name string, args ...string) (string, error) {
+	logger, err := loggingModule.New(io.Discard, loggingModule.FormatText, loggingModule.LevelError)
 	if err != nil {
-		t.Log(err)
-		t.FailNow()
+		t.Fatal(err)
 	}
 
-	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+	recorder := fixtureModule.NewRecorder()
+	ctx = loggingModule.NewContext(clientModule.WithSource(ctx, source), logger)
+	ctx = clientModule.WithInterceptors(ctx, recorder.Interceptor())
 
-	var buf bytes.Buffer
-  bufWriter := io.Writer(&buf)
-  writer := csv.NewWriter(bufWriter)
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	run := findCommand(name).setup(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
 
-  WriteDelegations(delegationsMap, writer)
+	runErr := run(ctx)
 
-	// t.Log("buf.String()", buf.String())
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := recorder.Fixture().Save(path); err != nil {
+		t.Fatal(err)
+	}
 
-	assert.Equal(t, 
-`delegator,voting_power
-osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,40
-osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,20
-`, buf.String())
+	return path, runErr
 }
 
-func TestWriteMultipleDelegations(t *testing.T) {
-	tt = t
-	stubValidatorResponses()
-	stubDelegationResponses()
+// runs a command from its command line as main does, answering its queries from fixture
+func replay(fixture string, name string, args ...string) error {
+	_, err := runCommand(findCommand(name), append([]string{"-quiet", "-replay", fixture}, args...))
+	return err
+}
 
-	validators, err := validatorsModule.GetValidators("node value not needed")
+func readFile(t *testing.T, path string) string {
+	contents, err := os.ReadFile(path)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-  pk1 := ed25519.GenPrivKey().PubKey()
-	pk1Any, err := codec.NewAnyWithValue(pk1)
-  if err != nil {
-		t.Log(err)
+	return string(contents)
+}
 
-  }
+// the flags writing every delegation export into dir
+func delegationFlags(dir string) []string {
+	return []string{
+		"-progress", "off",
+		"-delegationsFile", filepath.Join(dir, "delegations.csv"),
+		"-multipleDelegationsFile", filepath.Join(dir, "multipleDelegations.csv"),
+		"-distributionFile", filepath.Join(dir, "distribution.csv"),
+		"-validatorBreakdownFile", filepath.Join(dir, "validatorBreakdown.csv"),
+		"-checkpointFile", filepath.Join(dir, "checkpoint.json"),
+	}
+}
 
-	// missing value
-	for _, validator := range *validators {
-		validator.ConsensusPubkey = pk1Any
+// the flags writing every export of a snapshot into dir
+func exportFlags(dir string) []string {
+	return append(delegationFlags(dir), "-validatorFile", filepath.Join(dir, "validators.csv"))
+}
+
+var exportFiles = []string{"validators.csv", "delegations.csv", "multipleDelegations.csv", "distribution.csv",
+	"validatorBreakdown.csv"}
+
+func TestSnapshotCommand(t *testing.T) {
+	liveDir, replayDir := t.TempDir(), t.TempDir()
+
+	fixture, err := live(t, context.Background(), &stubSource{}, "snapshot",
+		append(exportFlags(liveDir), "-height", "7000000", "-prefixes", "cosmos")...)
+	assert.Nil(t, err)
+
+	assert.Nil(t, replay(fixture, "snapshot", append(exportFlags(replayDir), "-height", "7000000", "-prefixes", "cosmos")...))
+
+	// replaying the recorded run writes the same exports
+	for _, file := range exportFiles {
+		assert.Equal(t, readFile(t, filepath.Join(liveDir, file)), readFile(t, filepath.Join(replayDir, file)), file)
 	}
 
-	delegationResponses, err := delegationsModule.GetDelegationResponses("node value not needed", validators)
+	assert.Equal(t, `moniker,voting_power,self_delegation,total_delegation
+Inotel,5956506,1,5956506193276.000000000000000000
+Inotel Second,4956506,1,4956506193276.000000000000000000
+`, readFile(t, filepath.Join(replayDir, "validators.csv")))
+
+	// the first delegator's address has a bad checksum so it can't be re-encoded
+	assert.Equal(t, `delegator,voting_power,delegator_cosmos
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,40,
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,20,cosmos1qqrtqudvxhcan3fe2r98834ge8r8nffups22nd
+`, readFile(t, filepath.Join(replayDir, "delegations.csv")))
+
+	// the validators and delegations commands only need part of the same queries
+	validatorsDir := t.TempDir()
+	assert.Nil(t, replay(fixture, "validators", "-height", "7000000",
+		"-validatorFile", filepath.Join(validatorsDir, "validators.csv")))
+	assert.Equal(t, readFile(t, filepath.Join(liveDir, "validators.csv")),
+		readFile(t, filepath.Join(validatorsDir, "validators.csv")))
+
+	delegationsDir := t.TempDir()
+	assert.Nil(t, replay(fixture, "delegations", append(delegationFlags(delegationsDir), "-height", "7000000",
+		"-prefixes", "cosmos")...))
+	assert.Equal(t, readFile(t, filepath.Join(liveDir, "delegations.csv")),
+		readFile(t, filepath.Join(delegationsDir, "delegations.csv")))
+
+	// another height wasn't recorded
+	err = replay(fixture, "snapshot", append(exportFlags(t.TempDir()), "-height", "7000001")...)
+	assert.Equal(t, failuresModule.Network, failuresModule.ClassOf(err))
+}
+
+func TestSnapshotCommandByDelegators(t *testing.T) {
+	dir := t.TempDir()
+	delegatorsFile := filepath.Join(dir, "delegators.txt")
+	os.WriteFile(delegatorsFile, []byte(SECOND_DELEGATOR+"\n"), 0o644)
+
+	args := []string{"-height", "7000000", "-progress", "off", "-delegatorsFile", delegatorsFile,
+		"-validatorFile", filepath.Join(dir, "validators.csv"),
+		"-delegationsFile", filepath.Join(dir, "delegations.csv"),
+		"-multipleDelegationsFile", "",
+		"-holdingsFile", filepath.Join(dir, "holdings.csv")}
+
+	fixture, err := live(t, context.Background(), &stubSource{}, "snapshot", args...)
+	assert.Nil(t, err)
+	live := readFile(t, filepath.Join(dir, "holdings.csv"))
+
+	os.Remove(filepath.Join(dir, "holdings.csv"))
+	assert.Nil(t, replay(fixture, "snapshot", args...))
+	assert.Equal(t, live, readFile(t, filepath.Join(dir, "holdings.csv")))
+	assert.Contains(t, live, SECOND_DELEGATOR+",2,20,0,5")
+}
 
-	if err != nil {
-		t.Log(err)
-		t.FailNow()
+func TestInterruptedSnapshot(t *testing.T) {
+	source := &stubSource{failValidator: SECOND_VALIDATOR}
+
+	// a run at the latest block can't be resumed so it leaves no checkpoint
+	dir := t.TempDir()
+	_, err := live(t, context.Background(), source, "snapshot", exportFlags(dir)...)
+	assert.Error(t, err)
+	_, statErr := os.Stat(filepath.Join(dir, "checkpoint.json"))
+	assert.True(t, os.IsNotExist(statErr))
+	_, statErr = os.Stat(filepath.Join(dir, "delegations.csv.incomplete"))
+	assert.Nil(t, statErr)
+
+	dir = t.TempDir()
+	_, err = live(t, context.Background(), source, "snapshot", append(exportFlags(dir), "-height", "7000000")...)
+	assert.Error(t, err)
+	_, statErr = os.Stat(filepath.Join(dir, "checkpoint.json"))
+	assert.Nil(t, statErr)
+}
+
+func TestConfigAndEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	fixture, err := live(t, context.Background(), &stubSource{}, "snapshot",
+		append(exportFlags(t.TempDir()), "-height", "7000000")...)
+	assert.Nil(t, err)
+
+	configFile := filepath.Join(dir, "getData.yaml")
+	os.WriteFile(configFile, []byte(`height: 7000000
+progress: "off"
+multipleDelegationsFile: ""
+snapshot:
+  validatorFile: `+filepath.Join(dir, "configured.csv")+`
+  delegationsFile: `+filepath.Join(dir, "ignored.csv")+`
+`), 0o644)
+
+	// the environment wins over the config file
+	t.Setenv("GETDATA_DELEGATIONS_FILE", filepath.Join(dir, "environment.csv"))
+
+	assert.Nil(t, replay(fixture, "snapshot", "-config", configFile))
+
+	for file, exists := range map[string]bool{"configured.csv": true, "environment.csv": true, "ignored.csv": false} {
+		_, statErr := os.Stat(filepath.Join(dir, file))
+		assert.Equal(t, exists, statErr == nil, file)
 	}
 
-	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+	// unknown settings are config errors
+	os.WriteFile(configFile, []byte("validatorFiles: validators.csv\n"), 0o644)
+	err = replay(fixture, "snapshot", "-config", configFile)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
+
+func TestReplayFlags(t *testing.T) {
+	fixture, err := live(t, context.Background(), &stubSource{}, "validators", "-height", "7000000",
+		"-validatorFile", filepath.Join(t.TempDir(), "validators.csv"))
+	assert.Nil(t, err)
 
-	var buf bytes.Buffer
-  bufWriter := io.Writer(&buf)
-  writer := csv.NewWriter(bufWriter)
+	err = replay(fixture, "validators", "-record", filepath.Join(t.TempDir(), "fixture.json"))
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
 
-	WriteMultipleDelegations(validators, delegationsMap, delegationResponses, writer)
+	err = replay(fixture, "validators", "-source", "rest")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
 
-	assert.Equal(t, 
-`delegator,validator,bonded_tokens
-osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya,20
-osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb,20
-osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya,10
-osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb,10
-`, buf.String()) 
-}
\ No newline at end of file
+	err = replay(fixture, "stream", "-rpcNode", "http://localhost:26657")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// every metric name starts with this
+const Namespace = "getdata"
+
+// the metrics of a run. A nil *Metrics records nothing so callers don't have to check
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests          *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	cached            *prometheus.CounterVec
+	retries           *prometheus.CounterVec
+	rows              *prometheus.CounterVec
+	bytesWritten      *prometheus.CounterVec
+	runDuration       prometheus.Gauge
+	blockHeight       prometheus.Gauge
+	lastSuccessHeight prometheus.Gauge
+	lastSuccessTime   prometheus.Gauge
+
+	mutex sync.Mutex
+	// the highest block height a node answered at
+	height int64
+	// a snapshot of a long running command succeeded
+	succeeded bool
+}
+
+type contextKey struct{}
+
+func New() *Metrics {
+	metrics := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "grpc_requests_total",
+			Help:      "gRPC requests to the node by method and status code.",
+		}, []string{"method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Latency of the gRPC requests to the node by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		}, []string{"method"}),
+		cached: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "query_cache_hits_total",
+			Help:      "Queries answered from the query cache instead of the node by method.",
+		}, []string{"method"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "grpc_retries_total",
+			Help:      "gRPC requests retried after a transient failure by method.",
+		}, []string{"method"}),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "rows_fetched_total",
+			Help:      "Validators and delegations fetched from the node.",
+		}, []string{"kind"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "bytes_written_total",
+			Help:      "Bytes written to the exports by export.",
+		}, []string{"export"}),
+		runDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "run_duration_seconds",
+			Help:      "How long the run took, set when it ends.",
+		}),
+		blockHeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "block_height",
+			Help:      "The highest block height the node answered at.",
+		}),
+		lastSuccessHeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "last_success_height",
+			Help:      "The block height of the last successful snapshot.",
+		}),
+		lastSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "The unix time the last successful snapshot ended.",
+		}),
+	}
+
+	metrics.Registry.MustRegister(metrics.requests, metrics.requestDuration, metrics.cached, metrics.retries, metrics.rows,
+		metrics.bytesWritten, metrics.runDuration, metrics.blockHeight, metrics.lastSuccessHeight,
+		metrics.lastSuccessTime)
+
+	return metrics
+}
+
+// a copy of ctx carrying metrics
+func NewContext(ctx context.Context, metrics *Metrics) context.Context {
+	return context.WithValue(ctx, contextKey{}, metrics)
+}
+
+// the metrics carried by ctx, nil if there are none
+func FromContext(ctx context.Context) *Metrics {
+	metrics, _ := ctx.Value(contextKey{}).(*Metrics)
+	return metrics
+}
+
+// counts every request with its status code and latency, the rows it returned and the block height the node
+// answered at. Queries answered from the query cache are counted on their own, not as requests
+func (metrics *Metrics) Interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		var header metadata.MD
+		start := time.Now()
+		ctx, cached := clientModule.WithCachedMarker(ctx)
+
+		err := invoker(ctx, method, req, reply, conn, append(opts, grpc.Header(&header))...)
+
+		if metrics == nil {
+			return err
+		}
+
+		if cached() {
+			metrics.cached.WithLabelValues(method).Inc()
+		} else {
+			metrics.requests.WithLabelValues(method, status.Code(err).String()).Inc()
+			metrics.requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch response := reply.(type) {
+		case *stakingTypes.QueryValidatorsResponse:
+			metrics.rows.WithLabelValues("validators").Add(float64(len(response.Validators)))
+		case *stakingTypes.QueryValidatorDelegationsResponse:
+			metrics.rows.WithLabelValues("delegations").Add(float64(len(response.DelegationResponses)))
+		case *stakingTypes.QueryDelegatorDelegationsResponse:
+			metrics.rows.WithLabelValues("delegations").Add(float64(len(response.DelegationResponses)))
+		}
+
+		if height := clientModule.BlockHeight(header); height > 0 {
+			metrics.observeHeight(height)
+		}
+
+		return nil
+	}
+}
+
+func (metrics *Metrics) observeHeight(height int64) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if height > metrics.height {
+		metrics.height = height
+		metrics.blockHeight.Set(float64(height))
+	}
+}
+
+// counts a retry of method
+func (metrics *Metrics) Retried(method string) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.retries.WithLabelValues(method).Inc()
+}
+
+// counts the bytes written to writer for an export
+func (metrics *Metrics) CountBytes(writer io.Writer, export string) io.Writer {
+	if metrics == nil {
+		return writer
+	}
+
+	return countingWriter{writer: writer, counter: metrics.bytesWritten.WithLabelValues(export)}
+}
+
+type countingWriter struct {
+	writer  io.Writer
+	counter prometheus.Counter
+}
+
+func (writer countingWriter) Write(p []byte) (int, error) {
+	n, err := writer.writer.Write(p)
+	writer.counter.Add(float64(n))
+
+	return n, err
+}
+
+// records a successful snapshot at height, for the commands that keep taking snapshots
+func (metrics *Metrics) Succeeded(height int64) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	metrics.succeeded = true
+	metrics.mutex.Unlock()
+
+	metrics.setLastSuccess(height)
+}
+
+// records the end of a run that started at start. A successful run also sets the last success height to the highest
+// height the node answered at, unless its snapshots were recorded with Succeeded as they were taken
+func (metrics *Metrics) Finish(start time.Time, err error) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.runDuration.Set(time.Since(start).Seconds())
+
+	metrics.mutex.Lock()
+	height, succeeded := metrics.height, metrics.succeeded
+	metrics.mutex.Unlock()
+
+	if err != nil || succeeded {
+		return
+	}
+
+	metrics.setLastSuccess(height)
+}
+
+func (metrics *Metrics) setLastSuccess(height int64) {
+	if height > 0 {
+		metrics.lastSuccessHeight.Set(float64(height))
+	}
+	metrics.lastSuccessTime.Set(float64(time.Now().Unix()))
+}
+
+// serves the metrics in the prometheus text format
+func (metrics *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+}
+
+// pushes the metrics to a Pushgateway, replacing the metrics of job
+func (metrics *Metrics) Push(url string, job string) error {
+	return push.New(url, job).Gatherer(metrics.Registry).Push()
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const validatorsMethod = "/cosmos.staking.v1beta1.Query/Validators"
+
+// answers at height with the validators, like a node would
+func answer(height string, validators int, err error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		opts ...grpc.CallOption,
+	) error {
+		if err != nil {
+			return err
+		}
+
+		for _, opt := range opts {
+			if header, ok := opt.(grpc.HeaderCallOption); ok {
+				*header.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader, height)
+			}
+		}
+
+		reply.(*stakingTypes.QueryValidatorsResponse).Validators = make([]stakingTypes.Validator, validators)
+
+		return nil
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	metrics := New()
+	interceptor := metrics.Interceptor()
+
+	err := interceptor(context.Background(), validatorsMethod, nil, &stakingTypes.QueryValidatorsResponse{}, nil,
+		answer("12", 3, nil))
+	assert.Nil(t, err)
+
+	err = interceptor(context.Background(), validatorsMethod, nil, &stakingTypes.QueryValidatorsResponse{}, nil,
+		answer("11", 2, nil))
+	assert.Nil(t, err)
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	err = interceptor(context.Background(), validatorsMethod, nil, &stakingTypes.QueryValidatorsResponse{}, nil,
+		answer("", 0, unavailable))
+	assert.Equal(t, unavailable, err)
+
+	// answered from a cache, counted on its own
+	cached := answer("12", 4, nil)
+	err = interceptor(context.Background(), validatorsMethod, nil, &stakingTypes.QueryValidatorsResponse{}, nil,
+		func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+			clientModule.MarkCached(ctx)
+			return cached(ctx, method, req, reply, conn, opts...)
+		})
+	assert.Nil(t, err)
+
+	metrics.Retried(validatorsMethod)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.requests.WithLabelValues(validatorsMethod, "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues(validatorsMethod, "Unavailable")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.retries.WithLabelValues(validatorsMethod)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.cached.WithLabelValues(validatorsMethod)))
+	assert.Equal(t, 9.0, testutil.ToFloat64(metrics.rows.WithLabelValues("validators")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.requestDuration))
+
+	// the highest height answered at is kept
+	assert.Equal(t, 12.0, testutil.ToFloat64(metrics.blockHeight))
+
+	metrics.Finish(time.Now().Add(-time.Second), errors.New("interrupted"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.lastSuccessHeight))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(metrics.runDuration), 1.0)
+
+	metrics.Finish(time.Now(), nil)
+	assert.Equal(t, 12.0, testutil.ToFloat64(metrics.lastSuccessHeight))
+	assert.Greater(t, testutil.ToFloat64(metrics.lastSuccessTime), 0.0)
+}
+
+func TestSucceeded(t *testing.T) {
+	metrics := New()
+	interceptor := metrics.Interceptor()
+
+	err := interceptor(context.Background(), "/cosmos.staking.v1beta1.Query/DelegatorDelegations", nil,
+		&stakingTypes.QueryDelegatorDelegationsResponse{DelegationResponses: make(stakingTypes.DelegationResponses, 2)},
+		nil, func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+			opts ...grpc.CallOption,
+		) error {
+			return nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.rows.WithLabelValues("delegations")))
+
+	// every snapshot of a long running command is recorded as it is taken
+	metrics.Succeeded(100)
+	assert.Equal(t, 100.0, testutil.ToFloat64(metrics.lastSuccessHeight))
+	assert.Greater(t, testutil.ToFloat64(metrics.lastSuccessTime), 0.0)
+
+	metrics.observeHeight(150)
+	metrics.Succeeded(200)
+	assert.Equal(t, 200.0, testutil.ToFloat64(metrics.lastSuccessHeight))
+
+	// and the end of the run doesn't count as another one
+	metrics.observeHeight(250)
+	metrics.Finish(time.Now(), nil)
+	assert.Equal(t, 200.0, testutil.ToFloat64(metrics.lastSuccessHeight))
+}
+
+func TestCountBytes(t *testing.T) {
+	metrics := New()
+	var buf bytes.Buffer
+
+	writer := metrics.CountBytes(&buf, "validators")
+	io.WriteString(writer, "operator_address,moniker\n")
+	io.WriteString(writer, "osmovaloper1a,a\n")
+
+	assert.Equal(t, float64(buf.Len()), testutil.ToFloat64(metrics.bytesWritten.WithLabelValues("validators")))
+
+	// nil metrics count nothing
+	var none *Metrics
+	assert.Equal(t, io.Writer(&buf), none.CountBytes(&buf, "validators"))
+	none.Retried(validatorsMethod)
+	none.Succeeded(100)
+	none.Finish(time.Now(), nil)
+	assert.Nil(t, FromContext(context.Background()))
+}
+
+func TestHandlerAndPush(t *testing.T) {
+	metrics := New()
+	metrics.Retried(validatorsMethod)
+
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	assert.Contains(t, string(body), `getdata_grpc_retries_total{method="`+validatorsMethod+`"} 1`)
+
+	var pushedPath string
+	var pushed []byte
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushedPath = r.URL.Path
+		pushed, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	assert.Nil(t, metrics.Push(gateway.URL, "getData"))
+	assert.Equal(t, "/metrics/job/getData", pushedPath)
+	assert.True(t, strings.Contains(string(pushed), "getdata_grpc_retries_total"))
+}
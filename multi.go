@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	chainsModule "github.com/brianosaurus/challenge1/chains"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+)
+
+// the outcome of snapshotting one chain in a multi chain run
+type chainResult struct {
+	chain   chainsModule.Chain
+	summary *snapshotModule.Totals
+	err     error
+}
+
+// writes one row per chain with its totals, or the error if its snapshot failed
+func WriteChainSummaries(results []chainResult, writer *csv.Writer) error {
+	if err := writer.Write([]string{"chain", "node", "bech32_prefix", "denom", "output_dir", "status",
+		"validators", "delegators", "delegations", "total_stake", "error"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, result := range results {
+		strResult := []string{
+			result.chain.Name,
+			result.chain.Node,
+			result.chain.Bech32Prefix,
+			result.chain.Denom,
+			result.chain.OutputDir,
+		}
+
+		if result.err != nil {
+			strResult = append(strResult, "failed", "", "", "", "", result.err.Error())
+		} else {
+			strResult = append(strResult, "ok",
+				fmt.Sprint(result.summary.Validators),
+				fmt.Sprint(result.summary.Delegators),
+				fmt.Sprint(result.summary.Delegations),
+				result.summary.TotalStake.String(),
+				"")
+		}
+
+		if err := writer.Write(strResult); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// the options for a chain, with every output file moved into the chain's output directory
+func chainSnapshotOptions(options snapshotOptions, chain chainsModule.Chain, chains []chainsModule.Chain,
+) snapshotOptions {
+	options.node = chain.Node
+	options.source = chain.Source
+	if options.source == "" {
+		options.source = sourceGRPC
+	}
+	options.bech32Prefix = chain.Bech32Prefix
+	options.denom = chain.Denom
+
+	// the delegators are re-encoded for every other chain so the exports can be joined
+	if options.prefixes == "" {
+		prefixes := make([]string, 0, len(chains))
+		for _, other := range chains {
+			if other.Bech32Prefix != "" && other.Bech32Prefix != chain.Bech32Prefix {
+				prefixes = append(prefixes, other.Bech32Prefix)
+			}
+		}
+		options.prefixes = strings.Join(prefixes, ",")
+	}
+
+	return options.inDir(chain.OutputDir)
+}
+
+// the multi subcommand snapshots every chain in a chains file one after the other. A failing chain doesn't stop
+// the others, it is reported in the combined summary and the command exits non zero at the end
+func multiCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var chainsFile string
+	var summaryOutputFile string
+	options := snapshotOptions{}
+
+	flags.StringVar(&chainsFile, "chains", "chains.json",
+		"the chains json file, either a list of chains or a chain-registry chain.json")
+	flags.StringVar(&summaryOutputFile, "summaryFile", "summary.csv", "the output file for the combined summary csv")
+	addSnapshotFlags(flags, &options)
+
+	return func(ctx context.Context) error {
+		if cacheModule.ChainIDFromContext(ctx) != "" {
+			return failuresModule.Errorf(failuresModule.Config, "-chainId can't be used with multi, every chain's id is asked of its node")
+		}
+
+		chains, err := chainsModule.LoadChains(chainsFile)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.Config, err)
+		}
+
+		logger := loggingModule.FromContext(ctx)
+		results := make([]chainResult, 0, len(chains))
+		failed := 0
+
+		for _, chain := range chains {
+			chainLogger := logger.With("chain", chain.Name)
+			chainLogger.Info("snapshotting chain", "node", chain.Node, "output_dir", chain.OutputDir)
+
+			result := chainResult{chain: chain}
+
+			// once interrupted the remaining chains are marked failed so the summary still lists them
+			if err := ctx.Err(); err != nil {
+				result.err = failuresModule.Wrap(failuresModule.PartialData, err)
+			} else if err := os.MkdirAll(chain.OutputDir, 0o755); err != nil {
+				result.err = failuresModule.Wrap(failuresModule.IO, err)
+			} else {
+				result.summary, result.err = runSnapshot(loggingModule.NewContext(ctx, chainLogger),
+					chainSnapshotOptions(options, chain, chains))
+			}
+
+			if result.err != nil {
+				chainLogger.Error("snapshot failed", "err", result.err)
+				failed++
+			}
+
+			results = append(results, result)
+		}
+
+		logger.Info("writing chain summary", "file", summaryOutputFile, "chains", len(results), "failed", failed)
+
+		summaryFile, err := os.OpenFile(summaryOutputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer summaryFile.Close()
+		if err := WriteChainSummaries(results, csv.NewWriter(summaryFile)); err != nil {
+			return err
+		}
+
+		return chainsError(results, failed)
+	}
+}
+
+// nil if every chain succeeded. If every chain failed the first chain's error decides the exit code, otherwise the
+// run is partial
+func chainsError(results []chainResult, failed int) error {
+	if failed == 0 {
+		return nil
+	}
+
+	if failed < len(results) {
+		return failuresModule.Errorf(failuresModule.PartialData, "%d of %d chains failed", failed, len(results))
+	}
+
+	return failuresModule.Errorf(failuresModule.ClassOf(results[0].err), "%d of %d chains failed: %s: %w",
+		failed, len(results), results[0].chain.Name, results[0].err)
+}
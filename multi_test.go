@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chainsModule "github.com/brianosaurus/challenge1/chains"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+)
+
+// a chains file of osmosis and a chain the node doesn't answer for, writing into dir
+func writeChains(t *testing.T, dir string) string {
+	chains, _ := json.Marshal(chainsModule.Config{Chains: []chainsModule.Chain{
+		{Name: "osmosis", Node: "grpc.osmosis.zone:9090", Bech32Prefix: "osmo", Denom: "uosmo",
+			OutputDir: filepath.Join(dir, "osmosis")},
+		{Name: "juno", Node: "grpc.osmosis.zone:9090", Bech32Prefix: "juno", Denom: "ujuno",
+			OutputDir: filepath.Join(dir, "juno")},
+	}})
+
+	path := filepath.Join(dir, "chains.json")
+	if err := os.WriteFile(path, chains, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestMultiCommand(t *testing.T) {
+	args := func(dir string) []string {
+		return []string{"-chains", writeChains(t, dir), "-summaryFile", filepath.Join(dir, "summary.csv"),
+			"-progress", "off", "-multipleDelegationsFile", ""}
+	}
+
+	dir := t.TempDir()
+	fixture, err := live(t, context.Background(), &stubSource{}, "multi", args(dir)...)
+	// the node answers with osmosis delegations for juno too
+	assert.Equal(t, failuresModule.PartialData, failuresModule.ClassOf(err))
+
+	replayDir := t.TempDir()
+	err = replay(fixture, "multi", args(replayDir)...)
+	assert.Equal(t, failuresModule.PartialData, failuresModule.ClassOf(err))
+
+	assert.Equal(t, readFile(t, filepath.Join(dir, "osmosis", "delegations.csv")),
+		readFile(t, filepath.Join(replayDir, "osmosis", "delegations.csv")))
+
+	// the delegators are re-encoded for the other chains of the file
+	assert.Equal(t, `delegator,voting_power,delegator_juno
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,40,
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,20,juno1qqrtqudvxhcan3fe2r98834ge8r8nffuhzf353
+`, readFile(t, filepath.Join(replayDir, "osmosis", "delegations.csv")))
+
+	_, err = os.Stat(filepath.Join(replayDir, "juno", "delegations.csv"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, `chain,node,bech32_prefix,denom,output_dir,status,validators,delegators,delegations,total_stake,error
+osmosis,grpc.osmosis.zone:9090,osmo,uosmo,`+filepath.Join(replayDir, "osmosis")+`,ok,2,2,4,60,
+juno,grpc.osmosis.zone:9090,juno,ujuno,`+filepath.Join(replayDir, "juno")+`,failed,,,,,"grpc.osmosis.zone:9090 answered with the delegator osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a, not a juno address"
+`, readFile(t, filepath.Join(replayDir, "summary.csv")))
+}
+
+func TestMultiCommandByDelegators(t *testing.T) {
+	dir := t.TempDir()
+
+	// the delegators of the file are re-encoded for each chain
+	delegatorsFile := filepath.Join(dir, "delegators.txt")
+	os.WriteFile(delegatorsFile, []byte("cosmos1qqrtqudvxhcan3fe2r98834ge8r8nffups22nd\n"), 0o644)
+
+	_, err := live(t, context.Background(), &stubSource{}, "multi", "-chains", writeChains(t, dir),
+		"-summaryFile", filepath.Join(dir, "summary.csv"), "-delegatorsFile", delegatorsFile,
+		"-multipleDelegationsFile", "", "-prefixes", "cosmos")
+	assert.Nil(t, err)
+
+	assert.Equal(t, `delegator,voting_power,delegator_cosmos
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,20,cosmos1qqrtqudvxhcan3fe2r98834ge8r8nffups22nd
+`, readFile(t, filepath.Join(dir, "osmosis", "delegations.csv")))
+	assert.Equal(t, "delegator,voting_power,delegator_cosmos\n", readFile(t, filepath.Join(dir, "juno", "delegations.csv")))
+
+	os.WriteFile(delegatorsFile, []byte("not an address\n"), 0o644)
+	_, err = live(t, context.Background(), &stubSource{}, "multi", "-chains", writeChains(t, dir),
+		"-summaryFile", filepath.Join(dir, "summary.csv"), "-delegatorsFile", delegatorsFile)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
+
+func TestMultiCommandSources(t *testing.T) {
+	dir := t.TempDir()
+	chains, _ := json.Marshal(chainsModule.Config{Chains: []chainsModule.Chain{
+		{Name: "osmosis", Node: "grpc.osmosis.zone:9090", Bech32Prefix: "osmo", Denom: "uosmo",
+			OutputDir: filepath.Join(dir, "osmosis")},
+		// read from a data directory, which this one isn't
+		{Name: "local", Node: t.TempDir(), Bech32Prefix: "osmo", Denom: "uosmo", OutputDir: filepath.Join(dir, "local"),
+			Source: "appdb"},
+	}})
+	chainsFile := filepath.Join(dir, "chains.json")
+	os.WriteFile(chainsFile, chains, 0o644)
+
+	_, err := live(t, context.Background(), &stubSource{}, "multi", "-chains", chainsFile,
+		"-summaryFile", filepath.Join(dir, "summary.csv"), "-progress", "off")
+	assert.Equal(t, failuresModule.PartialData, failuresModule.ClassOf(err))
+
+	summary := readFile(t, filepath.Join(dir, "summary.csv"))
+	assert.Contains(t, summary, ",ok,2,2,4,60,\n")
+	assert.Contains(t, summary, "is not the data directory of a node")
+}
+
+func TestCheckChain(t *testing.T) {
+	delegationResponses := stubDelegations(LATEST_HEIGHT)
+	snapshot := &snapshotModule.Snapshot{DelegationResponses: &delegationResponses}
+
+	assert.Nil(t, (&snapshotOptions{}).checkChain(snapshot))
+	assert.Nil(t, (&snapshotOptions{bech32Prefix: "osmo", denom: "uosmo"}).checkChain(snapshot))
+
+	// a node of another chain with the same prefix
+	err := (&snapshotOptions{bech32Prefix: "osmo", denom: "ujuno"}).checkChain(snapshot)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+
+	// osmosis addresses aren't osmovaloper ones
+	err = (&snapshotOptions{bech32Prefix: "osmovaloper"}).checkChain(snapshot)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
@@ -0,0 +1,95 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func validatorWithShares(operatorAddress string, shares int64) validatorTypes.Validator {
+	return validatorTypes.Validator{OperatorAddress: operatorAddress, DelegatorShares: sdk.NewDec(shares)}
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now }
+
+	assert.False(t, tracker.Status().Started)
+
+	big := validatorWithShares("osmovaloper1big", 2999)
+	small := validatorWithShares("osmovaloper1small", 999)
+	tracker.Start(validatorTypes.Validators{big, small})
+
+	status := tracker.Status()
+	assert.True(t, status.Started)
+	assert.Equal(t, time.Duration(-1), status.ETA)
+
+	now = now.Add(30 * time.Second)
+	tracker.Page(100)
+	tracker.Page(50)
+	tracker.ValidatorDone(big)
+
+	// the big validator is three quarters of the work, so the small one should take a third of the time so far
+	status = tracker.Status()
+	assert.Equal(t, 1, status.Completed)
+	assert.Equal(t, 2, status.Validators)
+	assert.Equal(t, 150, status.Delegations)
+	assert.InDelta(t, 2.0/30, status.PagesPerSecond, 1e-9)
+	assert.Equal(t, 0.75, status.Done)
+	assert.Equal(t, 10*time.Second, status.ETA)
+	assert.Equal(t, "1/2 validators, 150 delegations, 0.1 pages/s, 75% done, ETA 10s", status.String())
+	assert.True(t, strings.HasPrefix(status.Bar(), "[######################--------] "))
+
+	tracker.ValidatorDone(small)
+	status = tracker.Status()
+	assert.Equal(t, 1.0, status.Done)
+	assert.Equal(t, time.Duration(0), status.ETA)
+}
+
+func TestTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	terminal := NewTerminal(&buf)
+
+	terminal.Write([]byte("before\n"))
+	terminal.SetBar("[#-] 1/2")
+	terminal.Write([]byte("during\n"))
+	terminal.SetBar("")
+
+	assert.Equal(t, "before\n\r\033[K[#-] 1/2\r\033[Kduring\n[#-] 1/2\r\033[K", buf.String())
+	assert.False(t, terminal.IsTerminal())
+}
+
+func TestRunLog(t *testing.T) {
+	LogInterval = 10 * time.Millisecond
+	defer func() { LogInterval = 30 * time.Second }()
+
+	var buf bytes.Buffer
+	logger, err := loggingModule.New(&buf, loggingModule.FormatText, loggingModule.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewTracker()
+	tracker.Start(validatorTypes.Validators{validatorWithShares("osmovaloper1a", 10)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// not a tty so auto falls back to log lines
+	Run(ctx, tracker, ModeAuto, NewTerminal(&buf), logger)
+
+	assert.Contains(t, buf.String(), "progress")
+	assert.Contains(t, buf.String(), "validators=1")
+	assert.Contains(t, buf.String(), "eta=unknown")
+
+	assert.NoError(t, ValidateMode(ModeBar))
+	assert.Error(t, ValidateMode("spinner"))
+}
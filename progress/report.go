@@ -0,0 +1,171 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	isatty "github.com/mattn/go-isatty"
+)
+
+// how progress is shown
+const (
+	// a bar if stderr is a terminal, otherwise log lines
+	ModeAuto = "auto"
+	ModeBar  = "bar"
+	ModeLog  = "log"
+	ModeOff  = "off"
+)
+
+// how often the bar is redrawn and how often a progress line is logged
+var (
+	BarInterval = 200 * time.Millisecond
+	LogInterval = 30 * time.Second
+)
+
+const barWidth = 30
+
+// checks a mode given on the command line
+func ValidateMode(mode string) error {
+	switch mode {
+	case ModeAuto, ModeBar, ModeLog, ModeOff:
+		return nil
+	default:
+		return fmt.Errorf("unknown progress mode %q, expected %s, %s, %s or %s", mode, ModeAuto, ModeBar, ModeLog, ModeOff)
+	}
+}
+
+// a writer shared by the log and a progress bar. Every write clears the bar first and redraws it after, so log lines
+// scroll above the bar instead of being mixed into it
+type Terminal struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	bar    string
+}
+
+func NewTerminal(writer io.Writer) *Terminal {
+	return &Terminal{writer: writer}
+}
+
+// true if the terminal writes to a tty
+func (terminal *Terminal) IsTerminal() bool {
+	file, ok := terminal.writer.(interface{ Fd() uintptr })
+	return ok && (isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd()))
+}
+
+func (terminal *Terminal) Write(p []byte) (int, error) {
+	terminal.mutex.Lock()
+	defer terminal.mutex.Unlock()
+
+	if terminal.bar == "" {
+		return terminal.writer.Write(p)
+	}
+
+	if _, err := io.WriteString(terminal.writer, "\r\033[K"); err != nil {
+		return 0, err
+	}
+
+	n, err := terminal.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	_, err = io.WriteString(terminal.writer, terminal.bar)
+
+	return n, err
+}
+
+// draws the bar on the last line, an empty bar removes it
+func (terminal *Terminal) SetBar(bar string) {
+	terminal.mutex.Lock()
+	defer terminal.mutex.Unlock()
+
+	if terminal.bar != "" || bar != "" {
+		io.WriteString(terminal.writer, "\r\033[K"+bar)
+	}
+	terminal.bar = bar
+}
+
+// shows the progress of tracker until ctx is done, as a bar on terminal or as log lines. Nothing is shown until the
+// tracker is started
+func Run(ctx context.Context, tracker *Tracker, mode string, terminal *Terminal, logger loggingModule.Logger) {
+	if mode == ModeAuto {
+		mode = ModeLog
+		if terminal != nil && terminal.IsTerminal() {
+			mode = ModeBar
+		}
+	}
+
+	switch mode {
+	case ModeBar:
+		ticker := time.NewTicker(BarInterval)
+		defer ticker.Stop()
+		defer terminal.SetBar("")
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if status := tracker.Status(); status.Started {
+					terminal.SetBar(status.Bar())
+				}
+			}
+		}
+	case ModeLog:
+		ticker := time.NewTicker(LogInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if status := tracker.Status(); status.Started {
+					logger.Info("progress", status.Keyvals()...)
+				}
+			}
+		}
+	}
+}
+
+// e.g. 45/150 validators, 123456 delegations, 3.2 pages/s, 31% done, ETA 4m12s
+func (status Status) String() string {
+	return fmt.Sprintf("%d/%d validators, %d delegations, %.1f pages/s, %.0f%% done, ETA %s", status.Completed,
+		status.Validators, status.Delegations, status.PagesPerSecond, status.Done*100, status.formatETA())
+}
+
+// the status behind a bar filled by the share of work done
+func (status Status) Bar() string {
+	filled := int(status.Done * barWidth)
+	if filled > barWidth {
+		filled = barWidth
+	}
+
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled) + "] " + status.String()
+}
+
+// the status as log fields
+func (status Status) Keyvals() []interface{} {
+	return []interface{}{
+		"completed", status.Completed,
+		"validators", status.Validators,
+		"delegations", status.Delegations,
+		"pages_per_second", fmt.Sprintf("%.1f", status.PagesPerSecond),
+		"done", fmt.Sprintf("%.1f%%", status.Done*100),
+		"elapsed", status.Elapsed.Round(time.Second),
+		"eta", status.formatETA(),
+	}
+}
+
+func (status Status) formatETA() string {
+	if status.ETA < 0 {
+		return "unknown"
+	}
+
+	return status.ETA.Round(time.Second).String()
+}
@@ -0,0 +1,134 @@
+package progress
+
+import (
+	"sync"
+	"time"
+
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// tracks a crawl of the delegations of every validator. The work of a validator is expected to grow with its
+// DelegatorShares, so the share of the work done and the ETA are weighted by them instead of counting validators
+type Tracker struct {
+	mutex sync.Mutex
+	// so tests can move the clock
+	now func() time.Time
+
+	started         bool
+	start           time.Time
+	validators      int
+	completed       int
+	totalWeight     float64
+	completedWeight float64
+	delegations     int
+	pages           int
+}
+
+// a snapshot of a crawl's progress
+type Status struct {
+	// false until the validators to crawl are known
+	Started     bool
+	Validators  int
+	Completed   int
+	Delegations int
+	Pages       int
+	Elapsed     time.Duration
+	// pages fetched per second since the start
+	PagesPerSecond float64
+	// the share of the expected work done, between 0 and 1
+	Done float64
+	// the expected time left, negative while it is unknown
+	ETA time.Duration
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{now: time.Now}
+}
+
+// starts tracking a crawl of validators
+func (tracker *Tracker) Start(validators validatorTypes.Validators) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.started = true
+	tracker.start = tracker.now()
+	tracker.validators = len(validators)
+	tracker.completed = 0
+	tracker.totalWeight = 0
+	tracker.completedWeight = 0
+	tracker.delegations = 0
+	tracker.pages = 0
+
+	for _, validator := range validators {
+		tracker.totalWeight += weight(validator)
+	}
+}
+
+// records a fetched page of delegations
+func (tracker *Tracker) Page(rows int) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.pages++
+	tracker.delegations += rows
+}
+
+// records that every delegation of validator has been fetched
+func (tracker *Tracker) ValidatorDone(validator validatorTypes.Validator) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.completed++
+	tracker.completedWeight += weight(validator)
+}
+
+func (tracker *Tracker) Status() Status {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	status := Status{
+		Started:     tracker.started,
+		Validators:  tracker.validators,
+		Completed:   tracker.completed,
+		Delegations: tracker.delegations,
+		Pages:       tracker.pages,
+		ETA:         -1,
+	}
+
+	if !tracker.started {
+		return status
+	}
+
+	status.Elapsed = tracker.now().Sub(tracker.start)
+
+	if status.Elapsed > 0 {
+		status.PagesPerSecond = float64(tracker.pages) / status.Elapsed.Seconds()
+	}
+
+	if tracker.totalWeight > 0 {
+		status.Done = tracker.completedWeight / tracker.totalWeight
+	}
+
+	if status.Done >= 1 {
+		status.Done = 1
+		status.ETA = 0
+	} else if status.Done > 0 {
+		status.ETA = time.Duration(float64(status.Elapsed) * (1 - status.Done) / status.Done)
+	}
+
+	return status
+}
+
+// the expected work of a validator. Every validator costs at least one query, so one is added to its shares
+func weight(validator validatorTypes.Validator) float64 {
+	if validator.DelegatorShares.IsNil() {
+		return 1
+	}
+
+	shares, err := validator.DelegatorShares.Float64()
+	if err != nil || shares < 0 {
+		return 1
+	}
+
+	return shares + 1
+}
@@ -0,0 +1,395 @@
+// Package rest reads the staking state through the LCD REST api (the grpc-gateway) of a node, for providers that only
+// expose that. The responses are decoded into the same types as the grpc queries so everything downstream is the same
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the grpc methods the REST calls stand in for, so metrics and the rest of the client layer see the same names
+const (
+	validatorsMethod           = "/cosmos.staking.v1beta1.Query/Validators"
+	validatorDelegationsMethod = "/cosmos.staking.v1beta1.Query/ValidatorDelegations"
+	delegatorDelegationsMethod = "/cosmos.staking.v1beta1.Query/DelegatorDelegations"
+	unbondingDelegationsMethod = "/cosmos.staking.v1beta1.Query/DelegatorUnbondingDelegations"
+	totalRewardsMethod         = "/cosmos.distribution.v1beta1.Query/DelegationTotalRewards"
+	latestBlockMethod          = "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock"
+	blockByHeightMethod        = "/cosmos.base.tendermint.v1beta1.Service/GetBlockByHeight"
+)
+
+// the header the grpc-gateway returns the block height of a response in
+const heightResponseHeader = "Grpc-Metadata-" + grpcTypes.GRPCBlockHeightHeader
+
+// a source reading from the LCD REST api. The node is its base url, e.g. https://lcd.osmosis.zone. Without a scheme
+// https is used
+type Source struct {
+	Client *http.Client
+}
+
+func NewSource() *Source {
+	return &Source{Client: &http.Client{}}
+}
+
+func (source *Source) Staking(ctx context.Context, node string) (clientModule.StakingQuerier, func(), error) {
+	base, err := baseURL(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &querier{source: source, base: base}, func() {}, nil
+}
+
+func (source *Source) Distribution(ctx context.Context, node string) (clientModule.DistributionQuerier, func(), error) {
+	base, err := baseURL(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &querier{source: source, base: base}, func() {}, nil
+}
+
+func (source *Source) LatestHeight(ctx context.Context, node string) (int64, error) {
+	header, err := source.latestHeader(ctx, node)
+	if err != nil {
+		return 0, err
+	}
+
+	height, err := strconv.ParseInt(header.Height, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("the node returned no latest block height")
+	}
+
+	return height, nil
+}
+
+func (source *Source) ChainID(ctx context.Context, node string) (string, error) {
+	header, err := source.latestHeader(ctx, node)
+	if err != nil {
+		return "", err
+	}
+
+	if header.ChainID == "" {
+		return "", fmt.Errorf("the node returned no chain id")
+	}
+
+	return header.ChainID, nil
+}
+
+// the parts of a block header getData reads
+type blockHeader struct {
+	Height  string `json:"height"`
+	ChainID string `json:"chain_id"`
+}
+
+// the header of the latest block. Only the header is needed so the block isn't decoded into its proto types
+func (source *Source) latestHeader(ctx context.Context, node string) (blockHeader, error) {
+	base, err := baseURL(node)
+	if err != nil {
+		return blockHeader{}, err
+	}
+
+	var latest struct {
+		Block *struct {
+			Header blockHeader `json:"header"`
+		} `json:"block"`
+		SdkBlock *struct {
+			Header blockHeader `json:"header"`
+		} `json:"sdk_block"`
+	}
+
+	err = clientModule.Invoke(ctx, latestBlockMethod, nil, &latest,
+		func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+			body, _, err := source.get(ctx, base+"/cosmos/base/tendermint/v1beta1/blocks/latest", nil)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(body, reply)
+		})
+	if err != nil {
+		return blockHeader{}, err
+	}
+
+	// newer nodes only fill in the sdk block
+	switch {
+	case latest.SdkBlock != nil:
+		return latest.SdkBlock.Header, nil
+	case latest.Block != nil:
+		return latest.Block.Header, nil
+	default:
+		return blockHeader{}, nil
+	}
+}
+
+func (source *Source) BlockTime(ctx context.Context, node string, height int64) (time.Time, error) {
+	base, err := baseURL(node)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// only the time is needed so the block isn't decoded into its proto types
+	var block struct {
+		Block *struct {
+			Header struct {
+				Time time.Time `json:"time"`
+			} `json:"header"`
+		} `json:"block"`
+		SdkBlock *struct {
+			Header struct {
+				Time time.Time `json:"time"`
+			} `json:"header"`
+		} `json:"sdk_block"`
+	}
+
+	err = clientModule.Invoke(ctx, blockByHeightMethod, nil, &block,
+		func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+			body, _, err := source.get(ctx, base+"/cosmos/base/tendermint/v1beta1/blocks/"+strconv.FormatInt(height, 10), nil)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(body, reply)
+		})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	switch {
+	case block.SdkBlock != nil:
+		return block.SdkBlock.Header.Time, nil
+	case block.Block != nil:
+		return block.Block.Header.Time, nil
+	default:
+		return time.Time{}, fmt.Errorf("the node returned no block at height %d", height)
+	}
+}
+
+func baseURL(node string) (string, error) {
+	if !strings.Contains(node, "://") {
+		node = "https://" + node
+	}
+
+	parsed, err := url.Parse(node)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("%q is not the url of a REST api", node)
+	}
+
+	return strings.TrimSuffix(parsed.String(), "/"), nil
+}
+
+type querier struct {
+	source *Source
+	base   string
+}
+
+func (querier *querier) Validators(ctx context.Context, in *stakingTypes.QueryValidatorsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorsResponse, error) {
+	query := pagination(in.Pagination)
+	if in.Status != "" {
+		query.Set("status", in.Status)
+	}
+
+	response := &stakingTypes.QueryValidatorsResponse{}
+	err := clientModule.Invoke(ctx, validatorsMethod, in, response,
+		querier.invoker(querier.base+"/cosmos/staking/v1beta1/validators", query), opts...)
+
+	return response, err
+}
+
+func (querier *querier) ValidatorDelegations(ctx context.Context, in *stakingTypes.QueryValidatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryValidatorDelegationsResponse{}
+	err := clientModule.Invoke(ctx, validatorDelegationsMethod, in, response,
+		querier.invoker(querier.base+"/cosmos/staking/v1beta1/validators/"+url.PathEscape(in.ValidatorAddr)+"/delegations",
+			pagination(in.Pagination)), opts...)
+
+	return response, err
+}
+
+func (querier *querier) DelegatorDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorDelegationsResponse{}
+	err := clientModule.Invoke(ctx, delegatorDelegationsMethod, in, response,
+		querier.invoker(querier.base+"/cosmos/staking/v1beta1/delegations/"+url.PathEscape(in.DelegatorAddr),
+			pagination(in.Pagination)), opts...)
+
+	return response, err
+}
+
+func (querier *querier) DelegatorUnbondingDelegations(ctx context.Context,
+	in *stakingTypes.QueryDelegatorUnbondingDelegationsRequest, opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorUnbondingDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorUnbondingDelegationsResponse{}
+	err := clientModule.Invoke(ctx, unbondingDelegationsMethod, in, response,
+		querier.invoker(querier.base+"/cosmos/staking/v1beta1/delegators/"+url.PathEscape(in.DelegatorAddr)+
+			"/unbonding_delegations", pagination(in.Pagination)), opts...)
+
+	return response, err
+}
+
+func (querier *querier) DelegationTotalRewards(ctx context.Context,
+	in *distributionTypes.QueryDelegationTotalRewardsRequest, opts ...grpc.CallOption,
+) (*distributionTypes.QueryDelegationTotalRewardsResponse, error) {
+	response := &distributionTypes.QueryDelegationTotalRewardsResponse{}
+	err := clientModule.Invoke(ctx, totalRewardsMethod, in, response,
+		querier.invoker(querier.base+"/cosmos/distribution/v1beta1/delegators/"+url.PathEscape(in.DelegatorAddress)+
+			"/rewards", nil), opts...)
+
+	return response, err
+}
+
+// gets a url and decodes the json response into the reply. The block height header of the response is handed to
+// grpc.Header call options as a grpc response's would be
+func (querier *querier) invoker(endpoint string, query url.Values) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn,
+		opts ...grpc.CallOption,
+	) error {
+		body, header, err := querier.source.get(ctx, endpoint, query)
+		if err != nil {
+			return err
+		}
+
+		message := reply.(proto.Message)
+		message.Reset()
+
+		// newer nodes add fields, they are of no use here
+		unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true, AnyResolver: clientModule.Registry}
+		if err := unmarshaler.Unmarshal(bytes.NewReader(body), message); err != nil {
+			return status.Errorf(codes.Internal, "decoding %s: %v", endpoint, err)
+		}
+
+		if validatorsResponse, ok := message.(*stakingTypes.QueryValidatorsResponse); ok {
+			if err := clientModule.UnpackValidators(validatorsResponse.Validators); err != nil {
+				return status.Errorf(codes.Internal, "decoding %s: %v", endpoint, err)
+			}
+		}
+
+		if height := header.Get(heightResponseHeader); height != "" {
+			for _, opt := range opts {
+				if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+					*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader, height)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// the pagination query parameters of a page request
+func pagination(page *queryTypes.PageRequest) url.Values {
+	query := url.Values{}
+	if page == nil {
+		return query
+	}
+
+	if len(page.Key) > 0 {
+		query.Set("pagination.key", base64.StdEncoding.EncodeToString(page.Key))
+	}
+	if page.Offset > 0 {
+		query.Set("pagination.offset", strconv.FormatUint(page.Offset, 10))
+	}
+	if page.Limit > 0 {
+		query.Set("pagination.limit", strconv.FormatUint(page.Limit, 10))
+	}
+	if page.CountTotal {
+		query.Set("pagination.count_total", "true")
+	}
+
+	return query
+}
+
+// gets a url at the height carried by ctx. Failures are returned as grpc status errors so the client layer retries
+// and counts them as it would grpc's
+func (source *Source) get(ctx context.Context, endpoint string, query url.Values) ([]byte, http.Header, error) {
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	request.Header.Set("Accept", "application/json")
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if heights := md.Get(grpcTypes.GRPCBlockHeightHeader); len(heights) > 0 {
+			request.Header.Set(grpcTypes.GRPCBlockHeightHeader, heights[0])
+		}
+	}
+
+	response, err := source.Client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, nil, status.Error(codes.Unavailable, err.Error())
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, statusError(response.StatusCode, body)
+	}
+
+	return body, response.Header, nil
+}
+
+// the grpc status of a failed REST call. The gateway returns the grpc code and message in the body, anything else
+// in front of it (a proxy or a rate limiter) is mapped from the http status
+func statusError(httpStatus int, body []byte) error {
+	var gatewayError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(body, &gatewayError) == nil && gatewayError.Code > 0 {
+		return status.Error(codes.Code(gatewayError.Code), gatewayError.Message)
+	}
+
+	message := fmt.Sprintf("%d %s", httpStatus, http.StatusText(httpStatus))
+
+	switch httpStatus {
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, message)
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return status.Error(codes.Unavailable, message)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, message)
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, message)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, message)
+	default:
+		return status.Error(codes.Unknown, message)
+	}
+}
@@ -0,0 +1,223 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	validatorsModule "github.com/brianosaurus/challenge1/validators"
+
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	VALIDATORS = `{
+  "validators": [
+    {
+      "operator_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+      "consensus_pubkey": {
+        "@type": "/cosmos.crypto.ed25519.PubKey",
+        "key": "Y2h1Y2tldC1wdWJrZXktY2h1Y2tldC1wdWJrZXk="
+      },
+      "jailed": false,
+      "status": "BOND_STATUS_BONDED",
+      "tokens": "5956506193276",
+      "delegator_shares": "5956506193276.000000000000000000",
+      "description": {
+        "moniker": "Inotel",
+        "identity": "975D494265B1AC25",
+        "website": "https://inotel.ro",
+        "security_contact": "",
+        "details": "We do staking for a living"
+      },
+      "unbonding_height": "0",
+      "unbonding_time": "1970-01-01T00:00:00Z",
+      "commission": {
+        "commission_rates": {
+          "rate": "0.050000000000000000",
+          "max_rate": "0.300000000000000000",
+          "max_change_rate": "0.300000000000000000"
+        },
+        "update_time": "2022-03-29T11:54:26.447424547Z"
+      },
+      "min_self_delegation": "1",
+      "unbonding_on_hold_ref_count": "0",
+      "unbonding_ids": []
+    }
+  ],
+  "pagination": {
+    "next_key": null,
+    "total": "1"
+  }
+}`
+
+	DELEGATIONS_PAGE_1 = `{
+  "delegation_responses": [
+    {
+      "delegation": {
+        "delegator_address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+        "validator_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+        "shares": "20.000000000000000000"
+      },
+      "balance": {
+        "denom": "uosmo",
+        "amount": "20"
+      }
+    }
+  ],
+  "pagination": {
+    "next_key": "AQID",
+    "total": "0"
+  }
+}`
+
+	DELEGATIONS_PAGE_2 = `{
+  "delegation_responses": [
+    {
+      "delegation": {
+        "delegator_address": "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+        "validator_address": "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+        "shares": "10.000000000000000000"
+      },
+      "balance": {
+        "denom": "uosmo",
+        "amount": "10"
+      }
+    }
+  ],
+  "pagination": {
+    "next_key": null,
+    "total": "0"
+  }
+}`
+)
+
+// a stand in for the LCD of a node at height 7000000
+func lcd(t *testing.T, requests *[]*http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		w.Header().Set(heightResponseHeader, "7000000")
+
+		switch {
+		case r.URL.Path == "/cosmos/staking/v1beta1/validators":
+			w.Write([]byte(VALIDATORS))
+		case strings.HasSuffix(r.URL.Path, "/delegations") && r.URL.Query().Get("pagination.key") == "":
+			w.Write([]byte(DELEGATIONS_PAGE_1))
+		case strings.HasSuffix(r.URL.Path, "/delegations") && r.URL.Query().Get("pagination.key") == "AQID":
+			w.Write([]byte(DELEGATIONS_PAGE_2))
+		case r.URL.Path == "/cosmos/base/tendermint/v1beta1/blocks/latest":
+			w.Write([]byte(`{"block_id": {}, "block": {"header": {"height": "7000001"}}}`))
+		case r.URL.Path == "/cosmos/base/tendermint/v1beta1/blocks/7000000":
+			w.Write([]byte(`{"block_id": {}, "sdk_block": {"header": {"height": "7000000", "time": "2022-11-01T12:00:00.5Z"}}}`))
+		case r.URL.Path == "/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 5, "message": "not found", "details": []}`))
+		}
+	}))
+}
+
+func TestSource(t *testing.T) {
+	var requests []*http.Request
+	server := lcd(t, &requests)
+	defer server.Close()
+
+	ctx := clientModule.WithSource(context.Background(), NewSource())
+
+	var heights []int64
+	ctx = clientModule.WithInterceptors(ctx, clientModule.HeightInterceptor(func(height int64) {
+		heights = append(heights, height)
+	}))
+
+	validators, err := validatorsModule.GetValidatorsAtHeight(ctx, server.URL, 7000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(*validators))
+	assert.Equal(t, "Inotel", (*validators)[0].Description.Moniker)
+	assert.Equal(t, "5956506193276", (*validators)[0].Tokens.String())
+	assert.Equal(t, "10000", requests[0].URL.Query().Get("pagination.limit"))
+	assert.Equal(t, "7000000", requests[0].Header.Get(grpcTypes.GRPCBlockHeightHeader))
+
+	pubKey, err := (*validators)[0].ConsPubKey()
+	assert.Nil(t, err)
+	assert.NotNil(t, pubKey)
+
+	delegationResponses, err := delegationsModule.GetDelegationResponsesAtHeight(ctx, server.URL, validators, 7000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, len(*delegationResponses))
+	assert.Equal(t, "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l", (*delegationResponses)[1].Delegation.DelegatorAddress)
+	assert.Equal(t, "10", (*delegationResponses)[1].Balance.Amount.String())
+	assert.Equal(t, "/cosmos/staking/v1beta1/validators/osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya/delegations",
+		requests[2].URL.Path)
+
+	// every response reported its height through the client layer
+	assert.Equal(t, []int64{7000000, 7000000, 7000000}, heights)
+
+	height, err := NewSource().LatestHeight(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7000001), height)
+
+	blockTime, err := NewSource().BlockTime(context.Background(), server.URL, 7000000)
+	assert.Nil(t, err)
+	assert.Equal(t, "2022-11-01T12:00:00.5Z", blockTime.Format(time.RFC3339Nano))
+}
+
+func TestErrors(t *testing.T) {
+	var requests []*http.Request
+	server := lcd(t, &requests)
+	defer server.Close()
+
+	source := NewSource()
+
+	_, _, err := source.get(context.Background(), server.URL+"/missing", nil)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "not found", status.Convert(err).Message())
+
+	_, _, err = source.get(context.Background(), server.URL+"/busy", nil)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, _, err = source.get(context.Background(), "http://127.0.0.1:1/validators", nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// a header option gets the height as from grpc
+	querier, _, err := source.Staking(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header metadata.MD
+	_, err = querier.Validators(context.Background(), &stakingTypes.QueryValidatorsRequest{}, grpc.Header(&header))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"7000000"}, header.Get(grpcTypes.GRPCBlockHeightHeader))
+}
+
+func TestBaseURL(t *testing.T) {
+	base, err := baseURL("lcd.osmosis.zone")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://lcd.osmosis.zone", base)
+
+	base, err = baseURL("http://localhost:1317/")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:1317", base)
+
+	_, err = baseURL("http://")
+	assert.Error(t, err)
+}
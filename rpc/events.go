@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	coreTypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+// the name the node sees the subscriptions under
+const subscriber = "getData"
+
+// how many events the client buffers for each subscription before dropping them
+const subscriptionCapacity = 1000
+
+// subscribes to the events matching each query on the websocket of a node's Tendermint RPC and merges them into one
+// channel. The channel is closed and the connection dropped once ctx is done. A slow reader loses events, so the
+// channel should be drained as it goes
+func Subscribe(ctx context.Context, node string, queries ...string) (<-chan coreTypes.ResultEvent, error) {
+	client, err := newClient(node)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Start(); err != nil {
+		return nil, err
+	}
+
+	merged := make(chan coreTypes.ResultEvent)
+	var group sync.WaitGroup
+
+	for _, query := range queries {
+		events, err := client.Subscribe(ctx, subscriber, query, subscriptionCapacity)
+		if err != nil {
+			client.Stop()
+			return nil, err
+		}
+
+		group.Add(1)
+		go func() {
+			defer group.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event, ok := <-events:
+					if !ok {
+						return
+					}
+
+					select {
+					case merged <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		group.Wait()
+		client.Stop()
+		close(merged)
+	}()
+
+	return merged, nil
+}
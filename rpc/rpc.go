@@ -0,0 +1,294 @@
+// Package rpc reads the staking state through the Tendermint RPC of a node, running the staking queries as abci_query
+// calls at an explicit height. Archive nodes often only expose this port, so it is how historic snapshots are taken
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+
+	sdkErrors "github.com/cosmos/cosmos-sdk/types/errors"
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
+	distributionTypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	rpcClient "github.com/tendermint/tendermint/rpc/client"
+	rpcHTTP "github.com/tendermint/tendermint/rpc/client/http"
+)
+
+// the abci query paths, which are the names of the grpc methods
+const (
+	validatorsPath           = "/cosmos.staking.v1beta1.Query/Validators"
+	validatorDelegationsPath = "/cosmos.staking.v1beta1.Query/ValidatorDelegations"
+	delegatorDelegationsPath = "/cosmos.staking.v1beta1.Query/DelegatorDelegations"
+	unbondingDelegationsPath = "/cosmos.staking.v1beta1.Query/DelegatorUnbondingDelegations"
+	totalRewardsPath         = "/cosmos.distribution.v1beta1.Query/DelegationTotalRewards"
+	statusMethod             = "/tendermint.rpc/status"
+	blockMethod              = "/tendermint.rpc/block"
+)
+
+// a source querying the Tendermint RPC of a node. The node is its url, e.g. http://archive.example.com:26657. Without
+// a scheme http is used
+type Source struct{}
+
+func NewSource() *Source {
+	return &Source{}
+}
+
+func (source *Source) Staking(ctx context.Context, node string) (clientModule.StakingQuerier, func(), error) {
+	client, err := newClient(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &querier{client: client}, func() {}, nil
+}
+
+func (source *Source) Distribution(ctx context.Context, node string) (clientModule.DistributionQuerier, func(), error) {
+	client, err := newClient(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &querier{client: client}, func() {}, nil
+}
+
+func (source *Source) LatestHeight(ctx context.Context, node string) (int64, error) {
+	client, err := newClient(node)
+	if err != nil {
+		return 0, err
+	}
+
+	var height int64
+	err = clientModule.Invoke(ctx, statusMethod, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+			result, err := client.Status(ctx)
+			if err != nil {
+				return callError(ctx, err)
+			}
+
+			height = result.SyncInfo.LatestBlockHeight
+			return nil
+		})
+
+	return height, err
+}
+
+func (source *Source) ChainID(ctx context.Context, node string) (string, error) {
+	client, err := newClient(node)
+	if err != nil {
+		return "", err
+	}
+
+	var chainID string
+	err = clientModule.Invoke(ctx, statusMethod, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+			result, err := client.Status(ctx)
+			if err != nil {
+				return callError(ctx, err)
+			}
+
+			chainID = result.NodeInfo.Network
+			return nil
+		})
+
+	return chainID, err
+}
+
+func (source *Source) BlockTime(ctx context.Context, node string, height int64) (time.Time, error) {
+	client, err := newClient(node)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var blockTime time.Time
+	err = clientModule.Invoke(ctx, blockMethod, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+			result, err := client.Block(ctx, &height)
+			if err != nil {
+				return callError(ctx, err)
+			}
+			if result.Block == nil {
+				return status.Errorf(codes.NotFound, "no block at height %d", height)
+			}
+
+			blockTime = result.Block.Header.Time
+			return nil
+		})
+
+	return blockTime, err
+}
+
+func newClient(node string) (*rpcHTTP.HTTP, error) {
+	if !strings.Contains(node, "://") {
+		node = "http://" + node
+	}
+
+	if parsed, err := url.Parse(node); err != nil || parsed.Host == "" {
+		return nil, errors.New(strconv.Quote(node) + " is not the url of a Tendermint RPC")
+	}
+
+	return rpcHTTP.New(node, "/websocket")
+}
+
+type querier struct {
+	client *rpcHTTP.HTTP
+}
+
+func (querier *querier) Validators(ctx context.Context, in *stakingTypes.QueryValidatorsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorsResponse, error) {
+	response := &stakingTypes.QueryValidatorsResponse{}
+	if err := clientModule.Invoke(ctx, validatorsPath, in, response, querier.invoke, opts...); err != nil {
+		return nil, err
+	}
+
+	if err := clientModule.UnpackValidators(response.Validators); err != nil {
+		return nil, status.Errorf(codes.Internal, "decoding %s: %v", validatorsPath, err)
+	}
+
+	return response, nil
+}
+
+func (querier *querier) ValidatorDelegations(ctx context.Context, in *stakingTypes.QueryValidatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryValidatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryValidatorDelegationsResponse{}
+	if err := clientModule.Invoke(ctx, validatorDelegationsPath, in, response, querier.invoke, opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (querier *querier) DelegatorDelegations(ctx context.Context, in *stakingTypes.QueryDelegatorDelegationsRequest,
+	opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorDelegationsResponse{}
+	if err := clientModule.Invoke(ctx, delegatorDelegationsPath, in, response, querier.invoke, opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (querier *querier) DelegatorUnbondingDelegations(ctx context.Context,
+	in *stakingTypes.QueryDelegatorUnbondingDelegationsRequest, opts ...grpc.CallOption,
+) (*stakingTypes.QueryDelegatorUnbondingDelegationsResponse, error) {
+	response := &stakingTypes.QueryDelegatorUnbondingDelegationsResponse{}
+	if err := clientModule.Invoke(ctx, unbondingDelegationsPath, in, response, querier.invoke, opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (querier *querier) DelegationTotalRewards(ctx context.Context,
+	in *distributionTypes.QueryDelegationTotalRewardsRequest, opts ...grpc.CallOption,
+) (*distributionTypes.QueryDelegationTotalRewardsResponse, error) {
+	response := &distributionTypes.QueryDelegationTotalRewardsResponse{}
+	if err := clientModule.Invoke(ctx, totalRewardsPath, in, response, querier.invoke, opts...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// runs an abci query at the height carried by ctx and decodes the protobuf response into reply. The height the node
+// answered at is handed to grpc.Header call options as a grpc response's would be
+func (querier *querier) invoke(ctx context.Context, path string, req, reply interface{}, conn *grpc.ClientConn,
+	opts ...grpc.CallOption,
+) error {
+	data, err := proto.Marshal(req.(proto.Message))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "encoding %s: %v", path, err)
+	}
+
+	result, err := querier.client.ABCIQueryWithOptions(ctx, path, data,
+		rpcClient.ABCIQueryOptions{Height: height(ctx)})
+	if err != nil {
+		return callError(ctx, err)
+	}
+
+	response := result.Response
+	if !response.IsOK() {
+		return status.Error(abciCode(response.Codespace, response.Code), response.Log)
+	}
+
+	message := reply.(proto.Message)
+	message.Reset()
+
+	if err := proto.Unmarshal(response.Value, message); err != nil {
+		return status.Errorf(codes.Internal, "decoding %s: %v", path, err)
+	}
+
+	for _, opt := range opts {
+		if headerOption, ok := opt.(grpc.HeaderCallOption); ok {
+			*headerOption.HeaderAddr = metadata.Pairs(grpcTypes.GRPCBlockHeightHeader,
+				strconv.FormatInt(response.Height, 10))
+		}
+	}
+
+	return nil
+}
+
+// the height carried by ctx, 0 for the latest block
+func height(ctx context.Context) int64 {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		return 0
+	}
+
+	heights := md.Get(grpcTypes.GRPCBlockHeightHeader)
+	if len(heights) == 0 {
+		return 0
+	}
+
+	height, _ := strconv.ParseInt(heights[len(heights)-1], 10, 64)
+
+	return height
+}
+
+// the grpc status of a failed RPC call, so the client layer retries and counts it as it would grpc's. The node
+// couldn't be reached unless it answered with a JSON-RPC error
+func callError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// the grpc code of a failed abci query. The sdk errors a pruned or future height and a bad request return are the
+// ones worth telling apart
+func abciCode(codespace string, code uint32) codes.Code {
+	if codespace != sdkErrors.RootCodespace {
+		return codes.Unknown
+	}
+
+	switch code {
+	case sdkErrors.ErrInvalidHeight.ABCICode():
+		return codes.OutOfRange
+	case sdkErrors.ErrInvalidRequest.ABCICode(), sdkErrors.ErrInvalidAddress.ABCICode():
+		return codes.InvalidArgument
+	case sdkErrors.ErrKeyNotFound.ABCICode(), sdkErrors.ErrUnknownRequest.ABCICode():
+		return codes.NotFound
+	default:
+		return codes.Unknown
+	}
+}
@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	clientModule "github.com/brianosaurus/challenge1/client"
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	validatorsModule "github.com/brianosaurus/challenge1/validators"
+
+	codecTypes "github.com/cosmos/cosmos-sdk/codec/types"
+	ed25519 "github.com/cosmos/cosmos-sdk/crypto/keys/ed25519"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	validatorA = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	delegator1 = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a"
+	delegator2 = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"
+)
+
+type call struct {
+	path   string
+	height string
+}
+
+func delegation(delegatorAddress string, amount int64) stakingTypes.DelegationResponse {
+	return stakingTypes.DelegationResponse{
+		Delegation: stakingTypes.Delegation{DelegatorAddress: delegatorAddress, ValidatorAddress: validatorA,
+			Shares: sdk.NewDec(amount)},
+		Balance: sdk.NewInt64Coin("uosmo", amount),
+	}
+}
+
+// answers an abci query like an archive node would
+func answer(t *testing.T, path string, data []byte) proto.Message {
+	switch path {
+	case validatorsPath:
+		pubKey, err := codecTypes.NewAnyWithValue(ed25519.GenPrivKey().PubKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &stakingTypes.QueryValidatorsResponse{Validators: []stakingTypes.Validator{{
+			OperatorAddress:   validatorA,
+			ConsensusPubkey:   pubKey,
+			Description:       stakingTypes.Description{Moniker: "Inotel"},
+			Tokens:            sdk.NewInt(30),
+			DelegatorShares:   sdk.NewDec(30),
+			MinSelfDelegation: sdk.OneInt(),
+		}}}
+	case validatorDelegationsPath:
+		var request stakingTypes.QueryValidatorDelegationsRequest
+		if err := proto.Unmarshal(data, &request); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(request.Pagination.Key) == 0 {
+			return &stakingTypes.QueryValidatorDelegationsResponse{
+				DelegationResponses: stakingTypes.DelegationResponses{delegation(delegator1, 20)},
+				Pagination:          &queryTypes.PageResponse{NextKey: []byte{1, 2, 3}},
+			}
+		}
+
+		return &stakingTypes.QueryValidatorDelegationsResponse{
+			DelegationResponses: stakingTypes.DelegationResponses{delegation(delegator2, 10)},
+		}
+	}
+
+	return nil
+}
+
+// a stand in for the Tendermint RPC of an archive node that has pruned everything below height 100
+func archive(t *testing.T, calls *[]call) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID     json.RawMessage            `json:"id"`
+			Method string                     `json:"method"`
+			Params map[string]json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+
+		var result interface{}
+
+		switch request.Method {
+		case "status":
+			result = map[string]interface{}{"sync_info": map[string]interface{}{"latest_block_height": "7000001"}}
+		case "block":
+			result = map[string]interface{}{"block_id": map[string]interface{}{}, "block": map[string]interface{}{
+				"header": map[string]interface{}{"height": "1000", "time": "2022-11-01T12:00:00Z"},
+			}}
+		case "abci_query":
+			var path, data, height string
+			json.Unmarshal(request.Params["path"], &path)
+			json.Unmarshal(request.Params["data"], &data)
+			json.Unmarshal(request.Params["height"], &height)
+			*calls = append(*calls, call{path, height})
+
+			if height == "42" {
+				result = map[string]interface{}{"response": map[string]interface{}{
+					"code": 26, "codespace": "sdk", "log": "height 42 is not available",
+				}}
+				break
+			}
+
+			bytes := []byte{}
+			fmt.Sscanf(data, "%X", &bytes)
+			value, err := proto.Marshal(answer(t, path, bytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result = map[string]interface{}{"response": map[string]interface{}{
+				"value": base64.StdEncoding.EncodeToString(value), "height": height,
+			}}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+	}))
+}
+
+func TestSource(t *testing.T) {
+	var calls []call
+	server := archive(t, &calls)
+	defer server.Close()
+
+	ctx := clientModule.WithSource(context.Background(), NewSource())
+
+	var heights []int64
+	ctx = clientModule.WithInterceptors(ctx, clientModule.HeightInterceptor(func(height int64) {
+		heights = append(heights, height)
+	}))
+
+	validators, err := validatorsModule.GetValidatorsAtHeight(ctx, server.URL, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(*validators))
+	assert.Equal(t, "Inotel", (*validators)[0].Description.Moniker)
+
+	pubKey, err := (*validators)[0].ConsPubKey()
+	assert.Nil(t, err)
+	assert.NotNil(t, pubKey)
+
+	delegationResponses, err := delegationsModule.GetDelegationResponsesAtHeight(ctx, server.URL, validators, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, len(*delegationResponses))
+	assert.Equal(t, delegator2, (*delegationResponses)[1].Delegation.DelegatorAddress)
+
+	assert.Equal(t, []call{{validatorsPath, "1000"}, {validatorDelegationsPath, "1000"}, {validatorDelegationsPath, "1000"}},
+		calls)
+	assert.Equal(t, []int64{1000, 1000, 1000}, heights)
+
+	height, err := NewSource().LatestHeight(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7000001), height)
+
+	blockTime, err := NewSource().BlockTime(context.Background(), server.URL, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC), blockTime.UTC())
+}
+
+func TestErrors(t *testing.T) {
+	var calls []call
+	server := archive(t, &calls)
+	defer server.Close()
+
+	ctx := clientModule.WithSource(context.Background(), NewSource())
+
+	// the validators module wraps the status error with its failure class
+	_, err := validatorsModule.GetValidatorsAtHeight(ctx, server.URL, 42)
+	assert.Equal(t, codes.OutOfRange, status.Code(errors.Unwrap(err)))
+	assert.Contains(t, err.Error(), "height 42 is not available")
+
+	_, err = NewSource().LatestHeight(context.Background(), "http://127.0.0.1:1")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, _, err = NewSource().Staking(context.Background(), "http://")
+	assert.Error(t, err)
+}
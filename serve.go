@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"time"
+
+	addressesModule "github.com/brianosaurus/challenge1/addresses"
+	apiModule "github.com/brianosaurus/challenge1/api"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+	statsModule "github.com/brianosaurus/challenge1/stats"
+)
+
+// the serve subcommand serves the files of a snapshot output directory over http so other teams can download
+// the csv files. With -refresh it instead fetches a snapshot itself every -refresh and serves it through the api
+func serveCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var addr string
+	var dir string
+	var refresh time.Duration
+	var tiers string
+	var prefixes string
+	connection := connectionOptions{}
+
+	flags.StringVar(&addr, "addr", ":8080", "the address to listen on")
+	flags.StringVar(&dir, "dir", ".", "the directory holding the snapshot files to serve")
+	flags.DurationVar(&refresh, "refresh", 0,
+		"fetch a snapshot from -node this often (e.g. 1h) and serve it through the api instead of the files in -dir")
+	addConnectionFlags(flags, &connection)
+	flags.StringVar(&tiers, "tiers", "dust=0,retail=1000000,whale=100000000000",
+		"comma separated name=threshold stake tiers in the base denom used to label distribution buckets")
+	flags.StringVar(&prefixes, "prefixes", "",
+		"comma separated bech32 prefixes (e.g. cosmos,juno) to add re-encoded delegator address columns for")
+
+	return func(ctx context.Context) error {
+		logger := loggingModule.FromContext(ctx)
+
+		if refresh <= 0 {
+			logger.Info("serving", "dir", dir, "addr", addr)
+
+			return listenAndServe(ctx, &http.Server{Addr: addr, Handler: http.FileServer(http.Dir(dir))})
+		}
+
+		distributionTiers, err := statsModule.ParseTiers(tiers)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.Config, err)
+		}
+
+		sourceCtx, err := connection.withSource(ctx)
+		if err != nil {
+			return err
+		}
+
+		server := apiModule.NewServer(snapshotModule.Options{Node: connection.node, Height: connection.height},
+			distributionTiers, addressesModule.ParsePrefixes(prefixes))
+
+		logger.Info("serving the api", "node", connection.node, "refresh", refresh, "addr", addr)
+
+		go server.Run(sourceCtx, refresh)
+
+		return listenAndServe(ctx, &http.Server{Addr: addr, Handler: server.Handler()})
+	}
+}
+
+// runs server until ctx is done and then shuts it down, letting requests in flight finish for a few seconds
+func listenAndServe(ctx context.Context, server *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return failuresModule.Wrap(failuresModule.Network, err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return failuresModule.Wrap(failuresModule.Network, server.Shutdown(shutdownCtx))
+}
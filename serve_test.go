@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// an address nothing listens on
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// gets url once the server answers, giving up after a few seconds
+func get(t *testing.T, url string) (int, string) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		response, err := http.Get(url)
+		if err != nil {
+			continue
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+
+		if response.StatusCode == http.StatusServiceUnavailable {
+			continue
+		}
+		return response.StatusCode, string(body)
+	}
+
+	t.Fatalf("%s never answered", url)
+	return 0, ""
+}
+
+func TestServeFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "delegations.csv"), []byte("delegator,voting_power\n"), 0o644)
+	addr := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := live(t, ctx, &stubSource{}, "serve", "-addr", addr, "-dir", dir)
+		done <- err
+	}()
+
+	code, body := get(t, "http://"+addr+"/delegations.csv")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "delegator,voting_power\n", body)
+
+	cancel()
+	assert.Nil(t, <-done)
+}
+
+func TestServeRefresh(t *testing.T) {
+	addr := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := live(t, ctx, &stubSource{}, "serve", "-addr", addr, "-refresh", "1h", "-height", "7000000")
+		done <- err
+	}()
+
+	code, body := get(t, "http://"+addr+"/validators")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "Inotel Second")
+
+	code, body = get(t, "http://"+addr+"/delegators/"+SECOND_DELEGATOR)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, SECOND_VALIDATOR)
+
+	cancel()
+	assert.Nil(t, <-done)
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	addressesModule "github.com/brianosaurus/challenge1/addresses"
+	appdbModule "github.com/brianosaurus/challenge1/appdb"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	fixtureModule "github.com/brianosaurus/challenge1/fixture"
+	genesisModule "github.com/brianosaurus/challenge1/genesis"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	metricsModule "github.com/brianosaurus/challenge1/metrics"
+	progressModule "github.com/brianosaurus/challenge1/progress"
+	restModule "github.com/brianosaurus/challenge1/rest"
+	rpcModule "github.com/brianosaurus/challenge1/rpc"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+	statsModule "github.com/brianosaurus/challenge1/stats"
+)
+
+// added to the file names of the exports written by an interrupted run
+const incompleteSuffix = ".incomplete"
+
+// the options for connecting to a node, shared by every command querying one
+type connectionOptions struct {
+	node   string
+	height int64
+	source string
+}
+
+// the sources a node can be queried through
+const (
+	sourceGRPC    = "grpc"
+	sourceREST    = "rest"
+	sourceRPC     = "rpc"
+	sourceGenesis = "genesis"
+	sourceAppDB   = "appdb"
+)
+
+// the options for a single snapshot of a chain. Exports with an empty file name are skipped
+type snapshotOptions struct {
+	connectionOptions
+	validatorOutputFile              string
+	delegationsOutputFile            string
+	multipleDelegationsOutputFile    string
+	distributionOutputFile           string
+	distributionJSONOutputFile       string
+	validatorBreakdownOutputFile     string
+	validatorBreakdownJSONOutputFile string
+	holdingsOutputFile               string
+	delegatorsFile                   string
+	tiers                            string
+	prefixes                         string
+	checkpointFile                   string
+	resume                           bool
+	progress                         string
+	// the chain the node should answer for, set by multi from the chains file. Empty isn't checked
+	bech32Prefix string
+	denom        string
+}
+
+// an export of a snapshot and the file it is written to
+type snapshotExport struct {
+	file        string
+	description string
+	writer      snapshotModule.Writer
+}
+
+// registers the flags for connecting to a node
+func addConnectionFlags(flags *flag.FlagSet, options *connectionOptions) {
+	flags.StringVar(&options.node, "node", "grpc.osmosis.zone:9090", "the node to query")
+	flags.Int64Var(&options.height, "height", 0, "the block height to query at, 0 for the latest block")
+	flags.StringVar(&options.source, "source", sourceGRPC,
+		"how the node is queried: grpc, rest for its LCD api or rpc for abci queries on its Tendermint RPC, with -node set to its url, "+
+			"genesis to read a genesis or exported state file, with -node set to its path, or appdb to read the application "+
+			"database of a stopped node, with -node set to its data directory")
+}
+
+// a copy of ctx whose queries go through the -source
+func (options connectionOptions) withSource(ctx context.Context) (context.Context, error) {
+	// -replay answers every query from its fixture
+	if _, replaying := clientModule.SourceFrom(ctx).(*fixtureModule.Source); replaying {
+		if options.source != sourceGRPC {
+			return nil, failuresModule.Errorf(failuresModule.Config, "-source can't be used with -replay")
+		}
+
+		return ctx, nil
+	}
+
+	switch options.source {
+	case sourceGRPC:
+		return ctx, nil
+	case sourceREST:
+		return clientModule.WithSource(ctx, restModule.NewSource()), nil
+	case sourceRPC:
+		return clientModule.WithSource(ctx, rpcModule.NewSource()), nil
+	case sourceGenesis:
+		return clientModule.WithSource(ctx, genesisModule.NewSource()), nil
+	case sourceAppDB:
+		return clientModule.WithSource(ctx, appdbModule.NewSource()), nil
+	default:
+		return nil, failuresModule.Errorf(failuresModule.Config, "unknown source %q, expected %s, %s, %s, %s or %s",
+			options.source, sourceGRPC, sourceREST, sourceRPC, sourceGenesis, sourceAppDB)
+	}
+}
+
+// registers the flags for the validators export
+func addValidatorFlags(flags *flag.FlagSet, options *snapshotOptions) {
+	flags.StringVar(&options.validatorOutputFile, "validatorFile", "validators.csv", "the output file for the validators csv")
+}
+
+// registers the flags for the delegation exports
+func addDelegationFlags(flags *flag.FlagSet, options *snapshotOptions) {
+	flags.StringVar(&options.delegationsOutputFile, "delegationsFile", "delegations.csv", "the output file for the delegations csv")
+	flags.StringVar(&options.multipleDelegationsOutputFile, "multipleDelegationsFile", "multipleDelegations.csv",
+		"the output csv file for the delegations who delegated to more than one validator")
+	flags.StringVar(&options.distributionOutputFile, "distributionFile", "",
+		"the output csv file for the delegator distribution (percentiles, buckets, tiers), skipped if empty")
+	flags.StringVar(&options.distributionJSONOutputFile, "distributionJsonFile", "",
+		"the output json file for the delegator distribution, skipped if empty")
+	flags.StringVar(&options.validatorBreakdownOutputFile, "validatorBreakdownFile", "",
+		"the output csv file for the per validator delegator breakdown, skipped if empty")
+	flags.StringVar(&options.validatorBreakdownJSONOutputFile, "validatorBreakdownJsonFile", "",
+		"the output json file for the per validator delegator breakdown, skipped if empty")
+	flags.StringVar(&options.delegatorsFile, "delegatorsFile", "",
+		"a file of delegator addresses, one per line, to only fetch the delegations of instead of crawling every validator")
+	flags.StringVar(&options.holdingsOutputFile, "holdingsFile", "",
+		"the output csv file for the staked, unbonding and reward tokens of every delegator of -delegatorsFile, skipped if empty")
+	flags.StringVar(&options.tiers, "tiers", "dust=0,retail=1000000,whale=100000000000",
+		"comma separated name=threshold stake tiers in the base denom used to label distribution buckets")
+	flags.StringVar(&options.prefixes, "prefixes", "",
+		"comma separated bech32 prefixes (e.g. cosmos,juno) to add re-encoded delegator address columns for")
+	flags.StringVar(&options.checkpointFile, "checkpointFile", "checkpoint.json",
+		"where an interrupted run writes its progress, skipped if empty")
+	flags.BoolVar(&options.resume, "resume", false,
+		"continue the run saved in -checkpointFile instead of starting over, needs the same -height")
+	flags.StringVar(&options.progress, "progress", progressModule.ModeAuto,
+		"how the progress of the delegations crawl is shown: auto (a bar on a terminal, log lines otherwise), bar, log or off")
+}
+
+// registers the flags for every export of a snapshot
+func addSnapshotFlags(flags *flag.FlagSet, options *snapshotOptions) {
+	addValidatorFlags(flags, options)
+	addDelegationFlags(flags, options)
+}
+
+// true if any export needs the delegations
+func (options *snapshotOptions) needsDelegations() bool {
+	return options.delegationsOutputFile != "" ||
+		options.multipleDelegationsOutputFile != "" ||
+		options.distributionOutputFile != "" ||
+		options.distributionJSONOutputFile != "" ||
+		options.validatorBreakdownOutputFile != "" ||
+		options.validatorBreakdownJSONOutputFile != "" ||
+		options.holdingsOutputFile != ""
+}
+
+// the options with every export and the checkpoint written into dir
+func (options snapshotOptions) inDir(dir string) snapshotOptions {
+	inDir := func(file string) string {
+		if file == "" {
+			return ""
+		}
+		return filepath.Join(dir, file)
+	}
+
+	options.validatorOutputFile = inDir(options.validatorOutputFile)
+	options.delegationsOutputFile = inDir(options.delegationsOutputFile)
+	options.multipleDelegationsOutputFile = inDir(options.multipleDelegationsOutputFile)
+	options.distributionOutputFile = inDir(options.distributionOutputFile)
+	options.distributionJSONOutputFile = inDir(options.distributionJSONOutputFile)
+	options.validatorBreakdownOutputFile = inDir(options.validatorBreakdownOutputFile)
+	options.validatorBreakdownJSONOutputFile = inDir(options.validatorBreakdownJSONOutputFile)
+	options.holdingsOutputFile = inDir(options.holdingsOutputFile)
+	options.checkpointFile = inDir(options.checkpointFile)
+
+	return options
+}
+
+// fetches the validators and, if any export needs them, the delegations from the node and writes every requested
+// export. Every error is returned with its failure class. If the run is interrupted while fetching the delegations a
+// checkpoint and the exports of what was fetched are written, with .incomplete added to the file names
+func runSnapshot(ctx context.Context, options snapshotOptions) (*snapshotModule.Totals, error) {
+	distributionTiers, err := statsModule.ParseTiers(options.tiers)
+	if err != nil && options.needsDelegations() {
+		return nil, failuresModule.Wrap(failuresModule.Config, err)
+	}
+
+	prefixes := addressesModule.ParsePrefixes(options.prefixes)
+
+	ctx, err = options.withSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := progressModule.ValidateMode(options.progress); err != nil && options.needsDelegations() {
+		return nil, failuresModule.Wrap(failuresModule.Config, err)
+	}
+
+	delegators, err := options.readDelegators()
+	if err != nil {
+		return nil, err
+	}
+
+	var resume *snapshotModule.Checkpoint
+	if options.resume && options.needsDelegations() {
+		if resume, err = snapshotModule.LoadCheckpoint(options.checkpointFile); err != nil {
+			return nil, err
+		}
+	}
+
+	exports := []snapshotExport{
+		{options.validatorOutputFile, "validators", snapshotModule.ValidatorsCSV()},
+		{options.delegationsOutputFile, "delegations", snapshotModule.DelegationsCSV(prefixes...)},
+		{options.multipleDelegationsOutputFile, "multiple delegations", snapshotModule.MultipleDelegationsCSV(prefixes...)},
+		{options.validatorBreakdownOutputFile, "validator breakdowns", snapshotModule.ValidatorBreakdownsCSV()},
+		{options.validatorBreakdownJSONOutputFile, "validator breakdowns", snapshotModule.ValidatorBreakdownsJSON()},
+		{options.distributionOutputFile, "distribution", snapshotModule.DistributionCSV(distributionTiers)},
+		{options.distributionJSONOutputFile, "distribution", snapshotModule.DistributionJSON(distributionTiers)},
+		{options.holdingsOutputFile, "holdings", snapshotModule.HoldingsCSV(prefixes...)},
+	}
+
+	var tracker *progressModule.Tracker
+	stopProgress := func() {}
+
+	// a fetch by delegator is short and has no validators to show the progress of
+	if options.needsDelegations() && options.progress != progressModule.ModeOff && delegators == nil {
+		tracker = progressModule.NewTracker()
+
+		progressCtx, cancel := context.WithCancel(ctx)
+		progressDone := make(chan struct{})
+		go func() {
+			progressModule.Run(progressCtx, tracker, options.progress, stderr, loggingModule.FromContext(ctx))
+			close(progressDone)
+		}()
+
+		stopProgress = func() {
+			cancel()
+			<-progressDone
+		}
+	}
+
+	snapshot, err := snapshotModule.Fetch(ctx, snapshotModule.Options{
+		Node:            options.node,
+		Height:          options.height,
+		SkipDelegations: !options.needsDelegations(),
+		Resume:          resume,
+		Progress:        tracker,
+		Delegators:      delegators,
+	})
+	// the bar is gone before the exports are logged
+	stopProgress()
+
+	if err != nil {
+		if snapshot != nil && snapshot.Incomplete {
+			writeIncompleteSnapshot(ctx, snapshot, err, options.checkpointFile, exports)
+		}
+		return nil, err
+	}
+
+	if err := options.checkChain(snapshot); err != nil {
+		return nil, err
+	}
+
+	if err := writeSnapshotExports(ctx, snapshot, exports, ""); err != nil {
+		return nil, err
+	}
+
+	if resume != nil {
+		if err := os.Remove(options.checkpointFile); err != nil {
+			return nil, failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	totals := snapshot.Totals()
+
+	return &totals, nil
+}
+
+// the addresses of -delegatorsFile, nil without one
+func (options *snapshotOptions) readDelegators() ([]string, error) {
+	if options.delegatorsFile == "" {
+		if options.holdingsOutputFile != "" {
+			return nil, failuresModule.Errorf(failuresModule.Config, "-holdingsFile needs -delegatorsFile")
+		}
+		return nil, nil
+	}
+
+	file, err := os.Open(options.delegatorsFile)
+	if err != nil {
+		return nil, failuresModule.Errorf(failuresModule.Config, "-delegatorsFile: %w", err)
+	}
+	defer file.Close()
+
+	delegators, err := addressesModule.ReadAddresses(file)
+	if err != nil {
+		return nil, failuresModule.Errorf(failuresModule.Config, "-delegatorsFile %s: %w", options.delegatorsFile, err)
+	}
+
+	if len(delegators) == 0 {
+		return nil, failuresModule.Errorf(failuresModule.Config, "-delegatorsFile %s has no addresses", options.delegatorsFile)
+	}
+
+	// one file serves every chain, its addresses are re-encoded for the chain's prefix
+	if options.bech32Prefix != "" {
+		for i, delegator := range delegators {
+			if delegators[i], err = addressesModule.Convert(delegator, options.bech32Prefix); err != nil {
+				return nil, failuresModule.Errorf(failuresModule.Config, "-delegatorsFile %s: %w", options.delegatorsFile, err)
+			}
+		}
+	}
+
+	return delegators, nil
+}
+
+// fails if the node answered with delegations of another chain than the bech32 prefix and denom of the options, as
+// when a chains file points a chain at the wrong node
+func (options *snapshotOptions) checkChain(snapshot *snapshotModule.Snapshot) error {
+	if snapshot.DelegationResponses == nil {
+		return nil
+	}
+
+	for _, delegationResponse := range *snapshot.DelegationResponses {
+		delegator := delegationResponse.Delegation.DelegatorAddress
+		if options.bech32Prefix != "" && !strings.HasPrefix(delegator, options.bech32Prefix+"1") {
+			return failuresModule.Errorf(failuresModule.Config, "%s answered with the delegator %s, not a %s address",
+				options.node, delegator, options.bech32Prefix)
+		}
+
+		denom := delegationResponse.Balance.Denom
+		if options.denom != "" && denom != options.denom {
+			return failuresModule.Errorf(failuresModule.Config, "%s answered with a balance in %s, not %s",
+				options.node, denom, options.denom)
+		}
+	}
+
+	return nil
+}
+
+// writes every export with a file name, adding suffix to the names
+func writeSnapshotExports(ctx context.Context, snapshot *snapshotModule.Snapshot, exports []snapshotExport,
+	suffix string,
+) error {
+	for _, export := range exports {
+		if export.file == "" {
+			continue
+		}
+
+		loggingModule.FromContext(ctx).Info("writing export", "export", export.description, "file", export.file+suffix)
+
+		if err := snapshotModule.WriteFile(ctx, export.file+suffix, snapshot, countBytes(ctx, export)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the writer of an export, counting what it writes in the metrics of the run
+func countBytes(ctx context.Context, export snapshotExport) snapshotModule.Writer {
+	metrics := metricsModule.FromContext(ctx)
+
+	return snapshotModule.WriterFunc(func(ctx context.Context, snapshot *snapshotModule.Snapshot, writer io.Writer) error {
+		return export.writer.WriteSnapshot(ctx, snapshot, metrics.CountBytes(writer, export.description))
+	})
+}
+
+// flushes the checkpoint and the partial exports of an interrupted snapshot. The run's context is already done so
+// they are written with a new one carrying its logger and metrics. Failures are only logged since the interruption
+// is the error that matters
+func writeIncompleteSnapshot(runCtx context.Context, snapshot *snapshotModule.Snapshot, err error,
+	checkpointFile string, exports []snapshotExport,
+) {
+	logger := loggingModule.FromContext(runCtx)
+	logger.Error("interrupted", "completed_validators", len(snapshot.CompletedValidators),
+		"validators", len(*snapshot.Validators), "err", err)
+
+	// the latest block moves on, a checkpoint of it could never be resumed
+	if checkpointFile != "" && snapshot.Height <= 0 {
+		logger.Info("not writing a checkpoint, only a run pinned to a -height can be resumed",
+			"block_height", snapshot.BlockHeight)
+	} else if checkpointFile != "" {
+		logger.Info("writing checkpoint", "file", checkpointFile)
+
+		if err := snapshotModule.WriteCheckpoint(checkpointFile, snapshot.Checkpoint(err)); err != nil {
+			logger.Error("writing the checkpoint failed", "file", checkpointFile, "err", err)
+		}
+	}
+
+	ctx := metricsModule.NewContext(loggingModule.NewContext(context.Background(), logger),
+		metricsModule.FromContext(runCtx))
+	if err := writeSnapshotExports(ctx, snapshot, exports, incompleteSuffix); err != nil {
+		logger.Error("writing the incomplete exports failed", "err", err)
+	}
+}
+
+// the snapshot subcommand fetches validators and delegations and writes every export. This is also what runs when
+// getData is called without a subcommand
+func snapshotCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	options := snapshotOptions{}
+	addConnectionFlags(flags, &options.connectionOptions)
+	addSnapshotFlags(flags, &options)
+
+	return func(ctx context.Context) error {
+		_, err := runSnapshot(ctx, options)
+		return err
+	}
+}
+
+// the validators subcommand only fetches and writes the validators
+func validatorsCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	options := snapshotOptions{}
+	addConnectionFlags(flags, &options.connectionOptions)
+	addValidatorFlags(flags, &options)
+
+	return func(ctx context.Context) error {
+		_, err := runSnapshot(ctx, options)
+		return err
+	}
+}
+
+// the delegations subcommand fetches the delegations of every validator and writes the delegation exports
+func delegationsCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	options := snapshotOptions{}
+	addConnectionFlags(flags, &options.connectionOptions)
+	addDelegationFlags(flags, &options)
+
+	return func(ctx context.Context) error {
+		_, err := runSnapshot(ctx, options)
+		return err
+	}
+}
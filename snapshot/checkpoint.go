@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the state of an interrupted fetch, written so a later run can pick up where it stopped
+type Checkpoint struct {
+	Node      string    `json:"node"`
+	Height    int64     `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+	// why the fetch stopped
+	Error string `json:"error"`
+	// the operator addresses of the validators whose delegations were all fetched, out of TotalValidators
+	CompletedValidators []string                            `json:"completed_validators"`
+	TotalValidators     int                                 `json:"total_validators"`
+	DelegationResponses delegationTypes.DelegationResponses `json:"delegation_responses"`
+}
+
+// the checkpoint of an incomplete snapshot
+func (snapshot *Snapshot) Checkpoint(err error) *Checkpoint {
+	checkpoint := &Checkpoint{
+		Node:                snapshot.Node,
+		Height:              snapshot.Height,
+		CreatedAt:           time.Now().UTC(),
+		CompletedValidators: snapshot.CompletedValidators,
+	}
+
+	if err != nil {
+		checkpoint.Error = err.Error()
+	}
+
+	if snapshot.Validators != nil {
+		checkpoint.TotalValidators = len(*snapshot.Validators)
+	}
+
+	if snapshot.DelegationResponses != nil {
+		checkpoint.DelegationResponses = *snapshot.DelegationResponses
+	}
+
+	return checkpoint
+}
+
+// writes a checkpoint as json, overwriting the file if it exists
+func WriteCheckpoint(path string, checkpoint *Checkpoint) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	if err := json.NewEncoder(file).Encode(checkpoint); err != nil {
+		file.Close()
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	return failuresModule.Wrap(failuresModule.IO, file.Close())
+}
+
+// reads a checkpoint written by WriteCheckpoint
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(contents, checkpoint); err != nil {
+		return nil, failuresModule.Errorf(failuresModule.Config, "checkpoint %s: %w", path, err)
+	}
+
+	return checkpoint, nil
+}
+
+// a checkpoint can only be resumed at the same height, otherwise the delegations would mix blocks. The latest block
+// moves on between runs so it can't be resumed at all. Another node of the same chain is fine
+func (checkpoint *Checkpoint) matches(options Options) error {
+	if checkpoint.Height <= 0 || options.Height != checkpoint.Height {
+		return failuresModule.Errorf(failuresModule.Config,
+			"the checkpoint was taken at height %d, resuming needs the same pinned -height", checkpoint.Height)
+	}
+
+	return nil
+}
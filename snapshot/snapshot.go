@@ -0,0 +1,229 @@
+// Package snapshot fetches the staking state of a cosmos chain (validators, their delegations and the per delegator
+// totals) and writes it out in the formats getData exports. It is the library behind the getData command line and
+// can be embedded by other services
+package snapshot
+
+import (
+	"context"
+	big "math/big"
+	"time"
+
+	blocksModule "github.com/brianosaurus/challenge1/blocks"
+	cacheModule "github.com/brianosaurus/challenge1/cache"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	progressModule "github.com/brianosaurus/challenge1/progress"
+	validatorsModule "github.com/brianosaurus/challenge1/validators"
+
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the options for fetching a snapshot
+type Options struct {
+	// the grpc endpoint of the node, e.g. grpc.osmosis.zone:9090
+	Node string
+	// the block height to query at, 0 for the latest block
+	Height int64
+	// only fetch the validators
+	SkipDelegations bool
+	// a checkpoint of an interrupted fetch at the same height. The validators it completed are not queried again
+	Resume *Checkpoint
+	// where the progress is logged. If nil the logger carried by the context is used, if any
+	Logger loggingModule.Logger
+	// if set, started with the validators whose delegations are fetched and updated after every page
+	Progress *progressModule.Tracker
+	// if set, only the delegations of these delegators are fetched, with their unbonding delegations and rewards,
+	// instead of crawling every validator. Such a fetch can't be resumed
+	Delegators []string
+}
+
+// the staking state of a chain. Delegations and DelegationResponses are nil if the delegations were skipped
+type Snapshot struct {
+	// where and when the snapshot was taken. Height is the requested height, 0 for the latest block
+	Node      string
+	Height    int64
+	FetchedAt time.Time
+	// the block height the node answered at, the highest one if the queries spanned several blocks. 0 if the node
+	// didn't say
+	BlockHeight int64
+	// set when the fetch stopped early. Only the delegations of CompletedValidators are in the snapshot
+	Incomplete          bool
+	CompletedValidators []string
+
+	Validators          *validatorTypes.Validators
+	DelegationResponses *delegationTypes.DelegationResponses
+	// the delegations of every delegator with their total balance, keyed by delegator address
+	Delegations *delegationsModule.DelegationsWithTotalBalance
+	// what each of Options.Delegators holds, in their order. nil unless fetched by delegator
+	Holdings []delegationsModule.DelegatorHoldings
+}
+
+// the totals of a snapshot
+type Totals struct {
+	Validators  int
+	Delegators  int
+	Delegations int
+	TotalStake  *big.Int
+}
+
+// fetches the validators and, unless skipped, the delegations of every validator, or of Options.Delegators, from the
+// node. The returned errors are classified with the failures package. If ctx is done while the delegations of every
+// validator are fetched the snapshot of the validators completed so far is returned with the error and marked
+// Incomplete so it can be checkpointed
+func Fetch(ctx context.Context, options Options) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Node:      options.Node,
+		Height:    options.Height,
+		FetchedAt: time.Now().UTC(),
+	}
+
+	if options.Logger != nil {
+		ctx = loggingModule.NewContext(ctx, options.Logger)
+	}
+
+	ctx = clientModule.WithInterceptors(ctx, clientModule.HeightInterceptor(func(height int64) {
+		if height > snapshot.BlockHeight {
+			snapshot.BlockHeight = height
+		}
+	}))
+
+	// added after the height interceptor so the height of cached responses is recorded too
+	if responseCache := cacheModule.FromContext(ctx); responseCache != nil && options.Height > 0 {
+		// a chain id given up front lets a cached fetch run without the node
+		chainID := cacheModule.ChainIDFromContext(ctx)
+		if chainID == "" {
+			var err error
+			if chainID, err = blocksModule.GetChainID(ctx, options.Node); err != nil {
+				return nil, err
+			}
+		}
+
+		if chainID != "" {
+			ctx = clientModule.WithInterceptors(ctx, responseCache.Interceptor(chainID))
+		}
+	}
+
+	if options.Resume != nil {
+		if len(options.Delegators) > 0 {
+			return nil, failuresModule.Errorf(failuresModule.Config, "a fetch by delegator can't be resumed")
+		}
+		if err := options.Resume.matches(options); err != nil {
+			return nil, err
+		}
+	}
+
+	validators, err := validatorsModule.GetValidatorsAtHeight(ctx, options.Node, options.Height)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Validators = validators
+
+	if options.SkipDelegations {
+		return snapshot, nil
+	}
+
+	// a fetch by delegator is short, if it fails there is nothing worth keeping
+	if len(options.Delegators) > 0 {
+		holdings, err := delegationsModule.GetDelegatorHoldings(ctx, options.Node, options.Delegators, options.Height, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.Holdings = holdings
+		snapshot.DelegationResponses = delegationsModule.HoldingsDelegationResponses(holdings)
+		snapshot.Delegations = delegationsModule.GetDelegationsWithTotalBalance(snapshot.DelegationResponses)
+
+		return snapshot, nil
+	}
+
+	delegationResponses := delegationTypes.DelegationResponses{}
+	remaining := *validators
+
+	if options.Resume != nil {
+		completed := make(map[string]bool)
+		for _, operatorAddress := range options.Resume.CompletedValidators {
+			completed[operatorAddress] = true
+		}
+
+		remaining = make(validatorTypes.Validators, 0, len(*validators))
+		for _, validator := range *validators {
+			if !completed[validator.OperatorAddress] {
+				remaining = append(remaining, validator)
+			}
+		}
+
+		loggingModule.FromContext(ctx).Info("resuming from checkpoint", "validators",
+			len(options.Resume.CompletedValidators), "rows", len(options.Resume.DelegationResponses))
+
+		delegationResponses = append(delegationResponses, options.Resume.DelegationResponses...)
+		snapshot.CompletedValidators = append(snapshot.CompletedValidators, options.Resume.CompletedValidators...)
+	}
+
+	if options.Progress != nil {
+		options.Progress.Start(remaining)
+	}
+
+	// only whole validators are kept so a checkpoint never holds half of a validator's delegations
+	_, err = delegationsModule.GetDelegationResponsesWithProgress(ctx, options.Node, &remaining, options.Height,
+		delegationsModule.Progress{
+			Page: func(validator validatorTypes.Validator, page int, rows int) {
+				if options.Progress != nil {
+					options.Progress.Page(rows)
+				}
+			},
+			Validator: func(validator validatorTypes.Validator, validatorResponses delegationTypes.DelegationResponses) {
+				delegationResponses = append(delegationResponses, validatorResponses...)
+				snapshot.CompletedValidators = append(snapshot.CompletedValidators, validator.OperatorAddress)
+
+				if options.Progress != nil {
+					options.Progress.ValidatorDone(validator)
+				}
+			},
+		})
+
+	snapshot.DelegationResponses = &delegationResponses
+	snapshot.Delegations = delegationsModule.GetDelegationsWithTotalBalance(&delegationResponses)
+
+	if err != nil {
+		snapshot.Incomplete = true
+
+		// the delegations module only knows about this run, a resumed fetch may have data from before
+		if len(snapshot.CompletedValidators) > 0 && failuresModule.ClassOf(err) != failuresModule.PartialData {
+			err = failuresModule.Errorf(failuresModule.PartialData, "%w", err)
+		}
+
+		return snapshot, err
+	}
+
+	return snapshot, nil
+}
+
+// true if the delegations were fetched
+func (snapshot *Snapshot) HasDelegations() bool {
+	return snapshot.Delegations != nil
+}
+
+// counts the validators, delegators and delegations and sums the stake of every delegator
+func (snapshot *Snapshot) Totals() Totals {
+	totals := Totals{TotalStake: new(big.Int)}
+
+	if snapshot.Validators != nil {
+		totals.Validators = len(*snapshot.Validators)
+	}
+
+	if snapshot.DelegationResponses != nil {
+		totals.Delegations = len(*snapshot.DelegationResponses)
+	}
+
+	if snapshot.Delegations != nil {
+		totals.Delegators = len(*snapshot.Delegations)
+		for _, delegationWithTotalBalance := range *snapshot.Delegations {
+			totals.TotalStake.Add(totals.TotalStake, delegationWithTotalBalance.TotalBalance)
+		}
+	}
+
+	return totals
+}
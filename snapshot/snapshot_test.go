@@ -0,0 +1,669 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	context "context"
+	"testing"
+
+	grpc1 "github.com/gogo/protobuf/grpc"
+	"google.golang.org/grpc"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	progressModule "github.com/brianosaurus/challenge1/progress"
+	validatorsModule "github.com/brianosaurus/challenge1/validators"
+	statsModule "github.com/brianosaurus/challenge1/stats"
+
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+
+	ed25519 "github.com/cosmos/cosmos-sdk/crypto/keys/ed25519"
+	// cryptotypes "github.com/cosmos/cosmos-sdk/crypto/types"
+
+	codec "github.com/cosmos/cosmos-sdk/codec/types"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	DELEGATION_RESPONSES = 
+`[
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+			"shares":"20.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"20"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
+			"shares":"20.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"20"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+			"shares":"10.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"10"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
+			"shares":"10.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"10"
+		}
+	}
+]`
+
+	VALIDATORS = 
+`[
+	{
+		"operator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+		"consensus_pubkey": { 
+			"type": "tendermint/PubKeyEd25519",
+			"value": "Y2h1Y2tldC1wdWJrZXk="
+		},
+		"status":3,
+		"tokens":"5956506193276",
+		"delegator_shares":"5956506193276.000000000000000000",
+		"description":{
+			"moniker":"Inotel",
+			"identity":"975D494265B1AC25",
+			"website":"https://inotel.ro",
+			"details":"We do staking for a living"
+		},
+		"unbonding_time":"1970-01-01T00:00:00Z",
+		"commission":{
+			"commission_rates":{
+				"rate":"0.050000000000000000",
+				"max_rate":"0.300000000000000000",
+				"max_change_rate":"0.300000000000000000"
+				},
+			"update_time":"2022-03-29T11:54:26.447424547Z"
+		},
+		"min_self_delegation":"1"
+	},
+	{
+		"operator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
+		"consensus_pubkey": { 
+			"type": "tendermint/PubKeyEd25519",
+			"value": "Y2h1Y2tldC1wdWJrZXk="
+		},
+		"status":3,
+		"tokens":"5956506193276",
+		"delegator_shares":"5956506193276.000000000000000000",
+		"description":{
+			"moniker":"Inotel Second",
+			"identity":"975D494265B1AC25",
+			"website":"https://inotel.ro",
+			"details":"We do staking for a living"
+		},
+		"unbonding_time":"1970-01-01T00:00:00Z",
+		"commission":{
+			"commission_rates":{
+				"rate":"0.050000000000000000",
+				"max_rate":"0.300000000000000000",
+				"max_change_rate":"0.300000000000000000"
+				},
+			"update_time":"2022-03-29T11:54:26.447424547Z"
+		},
+		"min_self_delegation":"1"
+	}
+ ]`
+	)
+
+var tt *testing.T
+
+type queryClient struct {
+	validatorTypes.QueryClient
+}
+
+func (q *queryClient) Validators(ctx context.Context, in *validatorTypes.QueryValidatorsRequest, 
+	opts ...grpc.CallOption) (*validatorTypes.QueryValidatorsResponse, error) {
+	var responses validatorTypes.Validators
+	err := json.Unmarshal([]byte(VALIDATORS), &responses)
+	if err != nil {
+		tt.Error(err)
+	}
+
+	response := validatorTypes.QueryValidatorsResponse{
+		Validators: responses,
+		Pagination: nil,
+	}
+
+	return &response, nil
+}
+
+func stubValidatorResponses() {
+	// stub out the grpc connection for testing
+	validatorsModule.GrpcDial = func(node string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		return nil, nil
+	}
+
+	validatorsModule.ValidatorTypesNewQueryClient = func(conn grpc1.ClientConn) validatorTypes.QueryClient {
+		client := &queryClient{}
+		return client
+	}
+}
+
+func (q *queryClient) ValidatorDelegations(ctx context.Context, in *delegationTypes.QueryValidatorDelegationsRequest, 
+	opts ...grpc.CallOption) (*delegationTypes.QueryValidatorDelegationsResponse, error) {
+	var responses []delegationTypes.DelegationResponse
+	err := json.Unmarshal([]byte(DELEGATION_RESPONSES), &responses)
+	if err != nil {
+		tt.Error(err)
+	}
+
+	response := delegationTypes.QueryValidatorDelegationsResponse{
+		DelegationResponses: responses,
+		Pagination: nil,
+	}
+
+	var delegationsResponse delegationTypes.QueryValidatorDelegationsResponse
+
+	// filter for this validataor
+	for _, delegation := range response.DelegationResponses {
+		if delegation.Delegation.ValidatorAddress == in.ValidatorAddr {
+			delegationsResponse.DelegationResponses = append(delegationsResponse.DelegationResponses, delegation)
+		}
+	}
+
+	return &delegationsResponse, nil
+}
+
+func stubDelegationResponses() {
+	// stub out the grpc connection for testing
+	delegationsModule.GrpcDial = func(node string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		return nil, nil
+	}
+
+	delegationsModule.DelegationTypesNewQueryClient = func(conn grpc1.ClientConn) delegationTypes.QueryClient {
+		client := &queryClient{}
+		return client
+	}
+}
+
+func TestWriteValidators(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	validators, err := validatorsModule.GetValidators(context.Background(), "node value not needed")
+	if err != nil {
+		t.Error(err)
+	}
+	var buf bytes.Buffer
+  bufWriter := io.Writer(&buf)
+  writer := csv.NewWriter(bufWriter)
+
+  pk1 := ed25519.GenPrivKey().PubKey()
+	pk1Any, err := codec.NewAnyWithValue(pk1)
+  if err != nil {
+		t.Log(err)
+
+  }
+	for _, validator := range *validators {
+		validator.ConsensusPubkey = pk1Any
+	}
+
+  WriteValidators(validators, writer)
+
+
+	// I have no idea why but this fixes tests
+	_ = buf.String()
+	
+	assert.Equal(t, 
+`moniker,voting_power,self_delegation,total_delegation
+Inotel,5956506,1,5956506193276.000000000000000000
+Inotel Second,5956506,1,5956506193276.000000000000000000
+`, buf.String()) 
+}
+
+func TestWriteDelegations(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	validators, err := validatorsModule.GetValidators(context.Background(), "node value not needed")
+	if err != nil {
+		t.Error(err)
+	}
+
+  pk1 := ed25519.GenPrivKey().PubKey()
+	pk1Any, err := codec.NewAnyWithValue(pk1)
+  if err != nil {
+		t.Log(err)
+
+  }
+
+	// missing value
+	for _, validator := range *validators {
+		validator.ConsensusPubkey = pk1Any
+	}
+
+	delegationResponses, err := delegationsModule.GetDelegationResponses(context.Background(), "node value not needed", validators)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+
+	var buf bytes.Buffer
+  bufWriter := io.Writer(&buf)
+  writer := csv.NewWriter(bufWriter)
+
+  WriteDelegations(delegationsMap, writer)
+
+	// t.Log("buf.String()", buf.String())
+
+	assert.Equal(t, 
+`delegator,voting_power
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,40
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,20
+`, buf.String())
+}
+
+func TestWriteMultipleDelegations(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	validators, err := validatorsModule.GetValidators(context.Background(), "node value not needed")
+	if err != nil {
+		t.Error(err)
+	}
+
+  pk1 := ed25519.GenPrivKey().PubKey()
+	pk1Any, err := codec.NewAnyWithValue(pk1)
+  if err != nil {
+		t.Log(err)
+
+  }
+
+	// missing value
+	for _, validator := range *validators {
+		validator.ConsensusPubkey = pk1Any
+	}
+
+	delegationResponses, err := delegationsModule.GetDelegationResponses(context.Background(), "node value not needed", validators)
+
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+
+	var buf bytes.Buffer
+  bufWriter := io.Writer(&buf)
+  writer := csv.NewWriter(bufWriter)
+
+	WriteMultipleDelegations(validators, delegationsMap, delegationResponses, writer)
+
+	assert.Equal(t, 
+`delegator,validator,bonded_tokens
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya,20
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb,20
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya,10
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb,10
+`, buf.String()) 
+}
+func TestWriteDistribution(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	validators, err := validatorsModule.GetValidators(context.Background(), "node value not needed")
+	if err != nil {
+		t.Error(err)
+	}
+
+	delegationResponses, err := delegationsModule.GetDelegationResponses(context.Background(), "node value not needed", validators)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+	tiers, err := statsModule.ParseTiers("dust=0,retail=30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	WriteDistribution(statsModule.GetDistribution(delegationsMap, tiers), writer)
+
+	assert.Equal(t,
+`section,label,min,max,delegators,stake,stake_share
+total,all,,,2,60,1.000000
+percentile,p10,,,,20,
+percentile,p25,,,,20,
+percentile,p50,,,,20,
+percentile,p75,,,,40,
+percentile,p90,,,,40,
+percentile,p95,,,,40,
+percentile,p99,,,,40,
+bucket,dust,10,30,1,20,0.333333
+bucket,retail,30,100,1,40,0.666667
+tier,dust,0,,1,20,0.333333
+tier,retail,30,,1,40,0.666667
+validators_per_delegator,2,,,2,,
+`, buf.String())
+}
+
+func TestWriteValidatorBreakdowns(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	validators, err := validatorsModule.GetValidators(context.Background(), "node value not needed")
+	if err != nil {
+		t.Error(err)
+	}
+
+	delegationResponses, err := delegationsModule.GetDelegationResponses(context.Background(), "node value not needed", validators)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	err = WriteValidatorBreakdowns(statsModule.GetValidatorBreakdowns(validators, delegationsMap), writer)
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+`validator,moniker,delegators,total_stake,median_stake,top10_stake,top10_stake_share,self_stake,self_stake_share,multi_validator_stake,multi_validator_stake_share,top_delegators
+osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya,Inotel,2,30,15,30,1.000000,0,0.000000,30,1.000000,osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a:20;osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l:10
+osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb,Inotel Second,2,30,15,30,1.000000,0,0.000000,30,1.000000,osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a:20;osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l:10
+`, buf.String())
+}
+
+func TestWriteHoldings(t *testing.T) {
+	holdings := []delegationsModule.DelegatorHoldings{
+		{
+			DelegatorAddress: "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+			DelegationResponses: delegationTypes.DelegationResponses{
+				{Balance: sdk.NewInt64Coin("uosmo", 20)},
+				{Balance: sdk.NewInt64Coin("uosmo", 10)},
+			},
+			UnbondingDelegations: delegationTypes.UnbondingDelegations{
+				{Entries: []delegationTypes.UnbondingDelegationEntry{{Balance: sdk.NewInt(5)}}},
+			},
+			Rewards: sdk.NewDecCoins(sdk.NewDecCoinFromDec("uosmo", sdk.NewDecWithPrec(125, 1))),
+		},
+		{DelegatorAddress: "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"},
+	}
+
+	var buf bytes.Buffer
+	err := WriteHoldings(holdings, csv.NewWriter(&buf))
+	assert.NoError(t, err)
+
+	assert.Equal(t, `delegator,delegations,staked,unbonding,rewards
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a,2,30,5,12.500000000000000000uosmo
+osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l,0,0,0,
+`, buf.String())
+
+	// a snapshot crawled by validator has no holdings
+	err = HoldingsCSV().WriteSnapshot(context.Background(), &Snapshot{}, &buf)
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteDelegationsWriteError(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	validators, err := validatorsModule.GetValidators(context.Background(), "node value not needed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delegationResponses, err := delegationsModule.GetDelegationResponses(context.Background(), "node value not needed", validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+
+	err = WriteDelegations(delegationsMap, csv.NewWriter(failingWriter{}))
+	assert.Error(t, err)
+	assert.Equal(t, failuresModule.IO, failuresModule.ClassOf(err))
+	assert.Equal(t, 5, failuresModule.ExitCode(err))
+
+	err = WriteValidatorBreakdownsJSON(statsModule.GetValidatorBreakdowns(validators, delegationsMap), failingWriter{})
+	assert.Equal(t, failuresModule.IO, failuresModule.ClassOf(err))
+}
+
+func TestFetch(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	tracker := progressModule.NewTracker()
+
+	snapshot, err := Fetch(context.Background(), Options{Node: "node value not needed", Height: 42, Progress: tracker})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := tracker.Status()
+	assert.Equal(t, 2, status.Completed)
+	assert.Equal(t, 4, status.Delegations)
+	assert.Equal(t, 1.0, status.Done)
+
+	assert.Equal(t, int64(42), snapshot.Height)
+	assert.True(t, snapshot.HasDelegations())
+
+	totals := snapshot.Totals()
+	assert.Equal(t, 2, totals.Validators)
+	assert.Equal(t, 2, totals.Delegators)
+	assert.Equal(t, 4, totals.Delegations)
+	assert.Equal(t, "60", totals.TotalStake.String())
+
+	snapshot, err = Fetch(context.Background(), Options{Node: "node value not needed", SkipDelegations: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.False(t, snapshot.HasDelegations())
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(DelegationsCSV().WriteSnapshot(context.Background(), snapshot, io.Discard)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Fetch(ctx, Options{Node: "node value not needed"})
+	assert.Error(t, err)
+}
+
+func TestWriteFile(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	snapshot, err := Fetch(context.Background(), Options{Node: "node value not needed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a writer plugged in by a caller
+	delegatorCount := WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		_, err := fmt.Fprintln(writer, snapshot.Totals().Delegators)
+		return err
+	})
+
+	path := filepath.Join(t.TempDir(), "delegators.txt")
+	if err := WriteFile(context.Background(), path, snapshot, delegatorCount); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "2\n", string(contents))
+
+	err = WriteFile(context.Background(), filepath.Join(t.TempDir(), "missing", "delegations.csv"), snapshot, DelegationsCSV())
+	assert.Equal(t, failuresModule.IO, failuresModule.ClassOf(err))
+}
+
+// cancels the fetch once the first validator's delegations came back
+type cancellingQueryClient struct {
+	queryClient
+	cancel context.CancelFunc
+}
+
+func (q *cancellingQueryClient) ValidatorDelegations(ctx context.Context,
+	in *delegationTypes.QueryValidatorDelegationsRequest, opts ...grpc.CallOption,
+) (*delegationTypes.QueryValidatorDelegationsResponse, error) {
+	q.cancel()
+	return q.queryClient.ValidatorDelegations(ctx, in, opts...)
+}
+
+func TestFetchInterruptedAndResumed(t *testing.T) {
+	tt = t
+	stubValidatorResponses()
+	stubDelegationResponses()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	delegationsModule.DelegationTypesNewQueryClient = func(conn grpc1.ClientConn) delegationTypes.QueryClient {
+		return &cancellingQueryClient{cancel: cancel}
+	}
+
+	snapshot, err := Fetch(ctx, Options{Node: "node value not needed", Height: 42})
+	assert.Equal(t, failuresModule.PartialData, failuresModule.ClassOf(err))
+	assert.True(t, snapshot.Incomplete)
+	assert.Equal(t, []string{"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"}, snapshot.CompletedValidators)
+	assert.Equal(t, 2, snapshot.Totals().Delegations)
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := WriteCheckpoint(path, snapshot.Checkpoint(err)); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, checkpoint.TotalValidators)
+	assert.Equal(t, 2, len(checkpoint.DelegationResponses))
+
+	// resuming needs the height the checkpoint was taken at
+	_, err = Fetch(context.Background(), Options{Node: "node value not needed", Resume: checkpoint})
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+
+	stubDelegationResponses()
+
+	snapshot, err = Fetch(context.Background(), Options{Node: "node value not needed", Height: 42, Resume: checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.False(t, snapshot.Incomplete)
+	totals := snapshot.Totals()
+	assert.Equal(t, 4, totals.Delegations)
+	assert.Equal(t, "60", totals.TotalStake.String())
+}
+
+// delegations of three delegators, the first two tied, to a bonded and an unbonded validator
+func orderedDelegationResponses() *delegationTypes.DelegationResponses {
+	delegation := func(delegator string, validator string, amount int64) delegationTypes.DelegationResponse {
+		return delegationTypes.DelegationResponse{
+			Delegation: delegationTypes.Delegation{DelegatorAddress: delegator, ValidatorAddress: validator,
+				Shares: sdk.NewDec(amount)},
+			Balance: sdk.NewInt64Coin("uosmo", amount),
+		}
+	}
+
+	return &delegationTypes.DelegationResponses{
+		delegation("osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw", "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya", 10),
+		delegation("osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw", "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb", 20),
+		delegation("osmo1qgpqyqszqgpqyqszqgpqyqszqgpqyqsztv5tsc", "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya", 30),
+		delegation("osmo1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr2u426e", "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb", 25),
+		delegation("osmo1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr2u426e", "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya", 25),
+	}
+}
+
+func TestWriteDelegationsOrder(t *testing.T) {
+	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(orderedDelegationResponses())
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDelegations(delegationsMap, csv.NewWriter(&buf)))
+
+	// by voting power, the tie by address
+	assert.Equal(t, `delegator,voting_power
+osmo1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr2u426e,50
+osmo1qgpqyqszqgpqyqszqgpqyqszqgpqyqsztv5tsc,30
+osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw,30
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, WriteDelegations(delegationsMap, csv.NewWriter(&buf), "cosmos", "juno"))
+
+	assert.Equal(t, `delegator,voting_power,delegator_cosmos,delegator_juno
+osmo1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr2u426e,50,cosmos1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcrz8x6vt,juno1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr549pth
+osmo1qgpqyqszqgpqyqszqgpqyqszqgpqyqsztv5tsc,30,cosmos1qgpqyqszqgpqyqszqgpqyqszqgpqyqszrh8mx2,juno1qgpqyqszqgpqyqszqgpqyqszqgpqyqsz49yqpk
+osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw,30,cosmos1qyqszqgpqyqszqgpqyqszqgpqyqszqgpjnp7du,juno1qyqszqgpqyqszqgpqyqszqgpqyqszqgpypz92q
+`, buf.String())
+}
+
+func TestWriteMultipleDelegationsOrder(t *testing.T) {
+	delegationResponses := orderedDelegationResponses()
+	delegationsMap := delegationsModule.GetDelegationsWithTotalBalance(delegationResponses)
+	validators := delegationTypes.Validators{
+		{OperatorAddress: "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya", Status: delegationTypes.Bonded},
+		{OperatorAddress: "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb", Status: delegationTypes.Unbonded},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteMultipleDelegations(&validators, delegationsMap, delegationResponses, csv.NewWriter(&buf), "cosmos"))
+
+	// by delegator, each in the order of its delegations. A single delegation is left out and the unbonded
+	// validator's tokens aren't bonded
+	assert.Equal(t, `delegator,validator,bonded_tokens,delegator_cosmos
+osmo1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr2u426e,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb,0,cosmos1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcrz8x6vt
+osmo1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcr2u426e,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya,25,cosmos1qvpsxqcrqvpsxqcrqvpsxqcrqvpsxqcrz8x6vt
+osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya,10,cosmos1qyqszqgpqyqszqgpqyqszqgpqyqszqgpjnp7du
+osmo1qyqszqgpqyqszqgpqyqszqgpqyqszqgp6gjwmw,osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb,0,cosmos1qyqszqgpqyqszqgpqyqszqgpqyqszqgpjnp7du
+`, buf.String())
+}
@@ -0,0 +1,438 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	big "math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	addressesModule "github.com/brianosaurus/challenge1/addresses"
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	statsModule "github.com/brianosaurus/challenge1/stats"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// an export of a snapshot. The built in exports are below and callers can plug in their own formats
+type Writer interface {
+	WriteSnapshot(ctx context.Context, snapshot *Snapshot, writer io.Writer) error
+}
+
+// adapts a function to a Writer
+type WriterFunc func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error
+
+func (f WriterFunc) WriteSnapshot(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+	return f(ctx, snapshot, writer)
+}
+
+// writes an export of the snapshot to a file, overwriting it if it exists. Writing stops with an error once ctx is
+// done
+func WriteFile(ctx context.Context, path string, snapshot *Snapshot, writer Writer) error {
+	if err := ctx.Err(); err != nil {
+		return failuresModule.Wrap(failuresModule.PartialData, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	if err := writer.WriteSnapshot(ctx, snapshot, contextWriter{ctx: ctx, writer: file}); err != nil {
+		file.Close()
+		return err
+	}
+
+	return failuresModule.Wrap(failuresModule.IO, file.Close())
+}
+
+// fails every write once ctx is done so long exports can be cancelled
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
+
+func (writer contextWriter) Write(p []byte) (int, error) {
+	if err := writer.ctx.Err(); err != nil {
+		return 0, failuresModule.Wrap(failuresModule.PartialData, err)
+	}
+
+	return writer.writer.Write(p)
+}
+
+// the validators csv, sorted by voting power
+func ValidatorsCSV() Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		return WriteValidators(snapshot.Validators, csv.NewWriter(writer))
+	})
+}
+
+// the delegators csv, sorted by voting power. Every prefix adds a column with the delegator address re-encoded for
+// that bech32 prefix
+func DelegationsCSV(prefixes ...string) Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		if err := needsDelegations(snapshot); err != nil {
+			return err
+		}
+		return WriteDelegations(snapshot.Delegations, csv.NewWriter(writer), prefixes...)
+	})
+}
+
+// the csv of the delegators delegating to more than one validator
+func MultipleDelegationsCSV(prefixes ...string) Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		if err := needsDelegations(snapshot); err != nil {
+			return err
+		}
+		return WriteMultipleDelegations(snapshot.Validators, snapshot.Delegations, snapshot.DelegationResponses,
+			csv.NewWriter(writer), prefixes...)
+	})
+}
+
+// the csv of what each delegator of a fetch by delegator holds: the number of delegations, the staked and unbonding
+// tokens and the pending rewards. Every prefix adds a column with the delegator address re-encoded for that prefix
+func HoldingsCSV(prefixes ...string) Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		if snapshot.Holdings == nil {
+			return failuresModule.Errorf(failuresModule.Config, "the snapshot wasn't fetched by delegator")
+		}
+		return WriteHoldings(snapshot.Holdings, csv.NewWriter(writer), prefixes...)
+	})
+}
+
+// the delegator distribution csv with buckets labelled by tiers, statsModule.DefaultTiers if empty
+func DistributionCSV(tiers []statsModule.Tier) Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		if err := needsDelegations(snapshot); err != nil {
+			return err
+		}
+		return WriteDistribution(statsModule.GetDistribution(snapshot.Delegations, tiers), csv.NewWriter(writer))
+	})
+}
+
+// the delegator distribution as json
+func DistributionJSON(tiers []statsModule.Tier) Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		if err := needsDelegations(snapshot); err != nil {
+			return err
+		}
+		return WriteDistributionJSON(statsModule.GetDistribution(snapshot.Delegations, tiers), writer)
+	})
+}
+
+// the per validator delegator breakdown csv
+func ValidatorBreakdownsCSV() Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		if err := needsDelegations(snapshot); err != nil {
+			return err
+		}
+		return WriteValidatorBreakdowns(statsModule.GetValidatorBreakdowns(snapshot.Validators, snapshot.Delegations),
+			csv.NewWriter(writer))
+	})
+}
+
+// the per validator delegator breakdown as json
+func ValidatorBreakdownsJSON() Writer {
+	return WriterFunc(func(ctx context.Context, snapshot *Snapshot, writer io.Writer) error {
+		if err := needsDelegations(snapshot); err != nil {
+			return err
+		}
+		return WriteValidatorBreakdownsJSON(statsModule.GetValidatorBreakdowns(snapshot.Validators, snapshot.Delegations),
+			writer)
+	})
+}
+
+func needsDelegations(snapshot *Snapshot) error {
+	if !snapshot.HasDelegations() {
+		return failuresModule.Errorf(failuresModule.Config, "the snapshot was fetched without delegations")
+	}
+
+	return nil
+}
+
+// writes delegations sorted by voting power to a csv file. Every prefix adds a column with the delegator
+// address re-encoded for that bech32 prefix
+func WriteDelegations(delegationsMap *delegationsModule.DelegationsWithTotalBalance, writer *csv.Writer,
+	prefixes ...string,
+) error {
+	delegations := make([]string, 0)
+
+	for _, delegationWithTotalBalance := range *delegationsMap {
+		if len(delegationWithTotalBalance.DelegationResponses) == 0 {
+			continue
+		}
+
+		address := delegationWithTotalBalance.DelegationResponses[0].Delegation.DelegatorAddress
+		delegations = append(delegations, address)
+	}
+
+	// ties are broken by address so the output is stable
+	sort.Slice(delegations, func(i, j int) bool {
+		if cmp := (*delegationsMap)[delegations[i]].TotalBalance.Cmp(
+			(*delegationsMap)[delegations[j]].TotalBalance); cmp != 0 {
+			return cmp == 1
+		}
+		return delegations[i] < delegations[j]
+	})
+
+	if err := writer.Write(append([]string{"delegator", "voting_power"}, prefixColumns("delegator", prefixes)...)); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, key := range delegations {
+		strDelegaton := make([]string, 0)
+		strDelegaton = append(strDelegaton, key)
+		strDelegaton = append(strDelegaton, (*delegationsMap)[key].TotalBalance.String())
+		strDelegaton = append(strDelegaton, addressesModule.ConvertAll(key, prefixes)...)
+
+		if err := writer.Write(strDelegaton); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// writes delegations who are delegated to multiple validators. Every prefix adds a column with the delegator
+// address re-encoded for that bech32 prefix
+func WriteMultipleDelegations(validators *delegationTypes.Validators, delegationsMap *delegationsModule.DelegationsWithTotalBalance,
+	delegationResponses *delegationTypes.DelegationResponses,
+	writer *csv.Writer,
+	prefixes ...string,
+) error {
+	validatorsMap := make(map[string]delegationTypes.Validator)
+	for _, validator := range *validators {
+		validatorsMap[validator.OperatorAddress] = validator
+	}
+
+	delegators := make([]string, 0, len(*delegationsMap))
+	for delegator := range *delegationsMap {
+		delegators = append(delegators, delegator)
+	}
+	sort.Strings(delegators)
+
+	if err := writer.Write(append([]string{"delegator", "validator", "bonded_tokens"}, prefixColumns("delegator", prefixes)...)); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, delegator := range delegators {
+		delegationWithTotalBalance := (*delegationsMap)[delegator]
+		delegationResponses := delegationWithTotalBalance.DelegationResponses
+
+		if len(delegationResponses) > 1 {
+			convertedAddresses := addressesModule.ConvertAll(delegator, prefixes)
+
+			for _, delegationResponse := range delegationWithTotalBalance.DelegationResponses {
+				strDelegaton := make([]string, 0)
+				strDelegaton = append(strDelegaton, delegationResponse.Delegation.DelegatorAddress)
+				strDelegaton = append(strDelegaton, delegationResponse.Delegation.ValidatorAddress)
+
+				// this is to get the delegator's bonded tokens. If a delegator is unbonding or redelegating
+				// the tokens are still bonded unless the validator itself is unbonded. In that case the delegator
+				// is by default unbonded. There is an issue here which is noted in this github issue:
+				// https://github.com/cosmos/cosmos-sdk/issues/11350
+				if validator, ok := validatorsMap[delegationResponse.Delegation.ValidatorAddress]; ok &&
+					validator.Status == delegationTypes.Bonded {
+					strDelegaton = append(strDelegaton, delegationResponse.Balance.Amount.String())
+				} else {
+					strDelegaton = append(strDelegaton, "0")
+				}
+
+				strDelegaton = append(strDelegaton, convertedAddresses...)
+
+				if err := writer.Write(strDelegaton); err != nil {
+					return failuresModule.Wrap(failuresModule.IO, err)
+				}
+			}
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// writes the holdings of every delegator in the order they were fetched. The rewards column is empty if the node
+// couldn't tell them
+func WriteHoldings(holdings []delegationsModule.DelegatorHoldings, writer *csv.Writer, prefixes ...string) error {
+	header := append([]string{"delegator", "delegations", "staked", "unbonding", "rewards"},
+		prefixColumns("delegator", prefixes)...)
+	if err := writer.Write(header); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, delegatorHoldings := range holdings {
+		staked := new(big.Int)
+		for _, delegationResponse := range delegatorHoldings.DelegationResponses {
+			staked.Add(staked, delegationResponse.Balance.Amount.BigInt())
+		}
+
+		rewards := ""
+		if delegatorHoldings.Rewards != nil {
+			rewards = delegatorHoldings.Rewards.String()
+		}
+
+		row := append([]string{
+			delegatorHoldings.DelegatorAddress,
+			strconv.Itoa(len(delegatorHoldings.DelegationResponses)),
+			staked.String(),
+			delegatorHoldings.Unbonding().String(),
+			rewards,
+		}, addressesModule.ConvertAll(delegatorHoldings.DelegatorAddress, prefixes)...)
+
+		if err := writer.Write(row); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// the header columns for addresses re-encoded with other prefixes, e.g. delegator_cosmos
+func prefixColumns(column string, prefixes []string) []string {
+	columns := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		columns = append(columns, column+"_"+prefix)
+	}
+
+	return columns
+}
+
+// writes validators sorted by voting power to a csv file
+func WriteValidators(validators *validatorTypes.Validators, writer *csv.Writer) error {
+	sort.SliceStable(*validators, func(i, j int) bool {
+		return validatorTypes.ValidatorsByVotingPower(*validators).Less(i, j, sdk.DefaultPowerReduction)
+	})
+
+	// write headers
+	if err := writer.Write([]string{"moniker", "voting_power", "self_delegation", "total_delegation"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, validator := range *validators {
+		strValidator := make([]string, 0)
+		strValidator = append(strValidator, validator.Description.Moniker)
+		strValidator = append(strValidator, fmt.Sprint(validator.ConsensusPower(sdk.DefaultPowerReduction)))
+		strValidator = append(strValidator, validator.MinSelfDelegation.String())
+		strValidator = append(strValidator, validator.DelegatorShares.String())
+
+		if err := writer.Write(strValidator); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// writes the delegator distribution to a csv file. Every row is tagged with the section it belongs to
+// (total, percentile, bucket, tier or validators_per_delegator) so the tables can live in a single file
+func WriteDistribution(distribution *statsModule.Distribution, writer *csv.Writer) error {
+	if err := writer.Write([]string{"section", "label", "min", "max", "delegators", "stake", "stake_share"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	if err := writer.Write([]string{"total", "all", "", "", fmt.Sprint(distribution.Delegators),
+		distribution.TotalStake.String(), statsModule.Share(distribution.TotalStake, distribution.TotalStake)}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, percentile := range distribution.Percentiles {
+		if err := writer.Write([]string{"percentile", fmt.Sprintf("p%d", percentile.Percentile), "", "", "",
+			percentile.Balance.String(), ""}); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	for _, bucket := range distribution.Buckets {
+		if err := writer.Write([]string{"bucket", bucket.Tier, bucket.Min.String(), bucket.Max.String(),
+			fmt.Sprint(bucket.Delegators), bucket.Stake.String(), bucket.StakeShare}); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	for _, tier := range distribution.Tiers {
+		if err := writer.Write([]string{"tier", tier.Name, tier.Threshold.String(), "",
+			fmt.Sprint(tier.Delegators), tier.Stake.String(), tier.StakeShare}); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	for _, validatorCount := range distribution.ValidatorsPerDelegator {
+		if err := writer.Write([]string{"validators_per_delegator", fmt.Sprint(validatorCount.Validators), "", "",
+			fmt.Sprint(validatorCount.Delegators), "", ""}); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// writes the delegator distribution as indented json
+func WriteDistributionJSON(distribution *statsModule.Distribution, writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return failuresModule.Wrap(failuresModule.IO, encoder.Encode(distribution))
+}
+
+// writes the per validator delegator breakdown to a csv file. The top delegators are written to a single
+// column as delegator:stake pairs separated by semicolons
+func WriteValidatorBreakdowns(breakdowns []statsModule.ValidatorBreakdown, writer *csv.Writer) error {
+	if err := writer.Write([]string{"validator", "moniker", "delegators", "total_stake", "median_stake",
+		fmt.Sprintf("top%d_stake", statsModule.TopDelegatorsCount), fmt.Sprintf("top%d_stake_share", statsModule.TopDelegatorsCount),
+		"self_stake", "self_stake_share", "multi_validator_stake", "multi_validator_stake_share", "top_delegators"}); err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	for _, breakdown := range breakdowns {
+		topDelegators := make([]string, 0, len(breakdown.TopDelegators))
+		for _, topDelegator := range breakdown.TopDelegators {
+			topDelegators = append(topDelegators, topDelegator.Delegator+":"+topDelegator.Stake.String())
+		}
+
+		if err := writer.Write([]string{
+			breakdown.OperatorAddress,
+			breakdown.Moniker,
+			fmt.Sprint(breakdown.Delegators),
+			breakdown.TotalStake.String(),
+			breakdown.MedianStake.String(),
+			breakdown.TopStake.String(),
+			breakdown.TopStakeShare,
+			breakdown.SelfStake.String(),
+			breakdown.SelfStakeShare,
+			breakdown.MultiValidatorStake.String(),
+			breakdown.MultiValidatorStakeShare,
+			strings.Join(topDelegators, ";"),
+		}); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	writer.Flush()
+
+	return failuresModule.Wrap(failuresModule.IO, writer.Error())
+}
+
+// writes the per validator delegator breakdown as indented json
+func WriteValidatorBreakdownsJSON(breakdowns []statsModule.ValidatorBreakdown, writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return failuresModule.Wrap(failuresModule.IO, encoder.Encode(breakdowns))
+}
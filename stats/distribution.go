@@ -0,0 +1,274 @@
+package stats
+
+import (
+	"fmt"
+	big "math/big"
+	"sort"
+	"strings"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+)
+
+// the precision used when formatting stake shares
+const SharePrecision = 6
+
+// percentiles reported for the delegator balances
+var DefaultPercentiles = []int{10, 25, 50, 75, 90, 95, 99}
+
+// a named stake tier. A delegator belongs to the highest tier whose threshold is not above its balance
+type Tier struct {
+	Name      string   `json:"name"`
+	Threshold *big.Int `json:"threshold"`
+}
+
+// default tiers in base denom units (e.g. uosmo), so dust is anything below 1 token and whales hold at least 100k tokens
+var DefaultTiers = []Tier{
+	{Name: "dust", Threshold: big.NewInt(0)},
+	{Name: "retail", Threshold: big.NewInt(1_000_000)},
+	{Name: "whale", Threshold: big.NewInt(100_000_000_000)},
+}
+
+// the balance at a given percentile of delegators
+type Percentile struct {
+	Percentile int      `json:"percentile"`
+	Balance    *big.Int `json:"balance"`
+}
+
+// a log10 bucket of delegator balances, holding balances in [Min, Max). Buckets are split at the tier thresholds so
+// every balance of a bucket is in its tier
+type Bucket struct {
+	Min        *big.Int `json:"min"`
+	Max        *big.Int `json:"max"`
+	Tier       string   `json:"tier"`
+	Delegators int      `json:"delegators"`
+	Stake      *big.Int `json:"stake"`
+	StakeShare string   `json:"stake_share"`
+}
+
+// totals for all delegators in a tier
+type TierSummary struct {
+	Name       string   `json:"name"`
+	Threshold  *big.Int `json:"threshold"`
+	Delegators int      `json:"delegators"`
+	Stake      *big.Int `json:"stake"`
+	StakeShare string   `json:"stake_share"`
+}
+
+// how many delegators delegate to a given number of validators
+type ValidatorCount struct {
+	Validators int `json:"validators"`
+	Delegators int `json:"delegators"`
+}
+
+// the shape of the delegator base
+type Distribution struct {
+	Delegators             int              `json:"delegators"`
+	TotalStake             *big.Int         `json:"total_stake"`
+	Percentiles            []Percentile     `json:"percentiles"`
+	Buckets                []Bucket         `json:"buckets"`
+	Tiers                  []TierSummary    `json:"tiers"`
+	ValidatorsPerDelegator []ValidatorCount `json:"validators_per_delegator"`
+}
+
+// parses tiers of the form "dust=0,retail=1000000,whale=100000000000". The lowest threshold has to be 0 so every
+// delegator is in a tier, and names and thresholds can't repeat
+func ParseTiers(value string) ([]Tier, error) {
+	tiers := make([]Tier, 0)
+	names := make(map[string]bool)
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, threshold, ok := strings.Cut(part, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid tier %q, expected name=threshold", part)
+		}
+
+		amount, ok := new(big.Int).SetString(threshold, 10)
+		if !ok || amount.Sign() < 0 {
+			return nil, fmt.Errorf("invalid threshold %q for tier %s", threshold, name)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("tier %s is given more than once", name)
+		}
+		names[name] = true
+
+		tiers = append(tiers, Tier{Name: name, Threshold: amount})
+	}
+
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("no tiers given")
+	}
+
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].Threshold.Cmp(tiers[j].Threshold) < 0
+	})
+
+	if tiers[0].Threshold.Sign() != 0 {
+		return nil, fmt.Errorf("the lowest tier %s starts at %s, it has to start at 0 so every delegator is in a tier",
+			tiers[0].Name, tiers[0].Threshold)
+	}
+
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Threshold.Cmp(tiers[i-1].Threshold) == 0 {
+			return nil, fmt.Errorf("tiers %s and %s have the same threshold %s", tiers[i-1].Name, tiers[i].Name,
+				tiers[i].Threshold)
+		}
+	}
+
+	return tiers, nil
+}
+
+// computes percentiles, log10 buckets, tiers and validators per delegator for all delegators
+func GetDistribution(delegationsMap *delegationsModule.DelegationsWithTotalBalance, tiers []Tier) *Distribution {
+	if len(tiers) == 0 {
+		tiers = DefaultTiers
+	}
+
+	balances := make([]*big.Int, 0, len(*delegationsMap))
+	totalStake := new(big.Int)
+	validatorCounts := make(map[int]int)
+
+	for _, delegationWithTotalBalance := range *delegationsMap {
+		balances = append(balances, delegationWithTotalBalance.TotalBalance)
+		totalStake.Add(totalStake, delegationWithTotalBalance.TotalBalance)
+
+		// a delegator can show up more than once for the same validator in paged results, so count unique validators
+		validators := make(map[string]struct{})
+		for _, delegationResponse := range delegationWithTotalBalance.DelegationResponses {
+			validators[delegationResponse.Delegation.ValidatorAddress] = struct{}{}
+		}
+		validatorCounts[len(validators)]++
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Cmp(balances[j]) < 0
+	})
+
+	distribution := &Distribution{
+		Delegators:             len(balances),
+		TotalStake:             totalStake,
+		Percentiles:            getPercentiles(balances),
+		Buckets:                getBuckets(balances, tiers, totalStake),
+		Tiers:                  getTierSummaries(balances, tiers, totalStake),
+		ValidatorsPerDelegator: make([]ValidatorCount, 0, len(validatorCounts)),
+	}
+
+	for validators, delegators := range validatorCounts {
+		distribution.ValidatorsPerDelegator = append(distribution.ValidatorsPerDelegator,
+			ValidatorCount{Validators: validators, Delegators: delegators})
+	}
+
+	sort.Slice(distribution.ValidatorsPerDelegator, func(i, j int) bool {
+		return distribution.ValidatorsPerDelegator[i].Validators < distribution.ValidatorsPerDelegator[j].Validators
+	})
+
+	return distribution
+}
+
+// formats part / total as a decimal fraction
+func Share(part *big.Int, total *big.Int) string {
+	if total.Sign() == 0 {
+		return new(big.Rat).FloatString(SharePrecision)
+	}
+
+	return new(big.Rat).SetFrac(part, total).FloatString(SharePrecision)
+}
+
+// nearest rank percentiles over sorted balances
+func getPercentiles(balances []*big.Int) []Percentile {
+	percentiles := make([]Percentile, 0, len(DefaultPercentiles))
+	if len(balances) == 0 {
+		return percentiles
+	}
+
+	for _, percentile := range DefaultPercentiles {
+		rank := (percentile*len(balances) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+
+		percentiles = append(percentiles, Percentile{Percentile: percentile, Balance: balances[rank-1]})
+	}
+
+	return percentiles
+}
+
+// groups sorted balances into [10^k, 10^(k+1)) buckets, split at the tier thresholds. Zero balances go into a [0, 1)
+// bucket
+func getBuckets(balances []*big.Int, tiers []Tier, totalStake *big.Int) []Bucket {
+	buckets := make([]Bucket, 0)
+	ten := big.NewInt(10)
+
+	for _, balance := range balances {
+		if len(buckets) == 0 || balance.Cmp(buckets[len(buckets)-1].Max) >= 0 {
+			min := new(big.Int)
+			max := big.NewInt(1)
+			if balance.Sign() > 0 {
+				min.Exp(ten, big.NewInt(int64(len(balance.String())-1)), nil)
+				max.Mul(min, ten)
+			}
+
+			// tiers are sorted by threshold
+			for _, tier := range tiers {
+				if tier.Threshold.Cmp(balance) <= 0 && tier.Threshold.Cmp(min) > 0 {
+					min = tier.Threshold
+				}
+				if tier.Threshold.Cmp(balance) > 0 && tier.Threshold.Cmp(max) < 0 {
+					max = tier.Threshold
+					break
+				}
+			}
+
+			buckets = append(buckets, Bucket{Min: new(big.Int).Set(min), Max: new(big.Int).Set(max),
+				Tier: tierFor(min, tiers), Stake: new(big.Int)})
+		}
+
+		bucket := &buckets[len(buckets)-1]
+		bucket.Delegators++
+		bucket.Stake.Add(bucket.Stake, balance)
+	}
+
+	for i := range buckets {
+		buckets[i].StakeShare = Share(buckets[i].Stake, totalStake)
+	}
+
+	return buckets
+}
+
+func getTierSummaries(balances []*big.Int, tiers []Tier, totalStake *big.Int) []TierSummary {
+	summaries := make([]TierSummary, len(tiers))
+	for i, tier := range tiers {
+		summaries[i] = TierSummary{Name: tier.Name, Threshold: tier.Threshold, Stake: new(big.Int)}
+	}
+
+	for _, balance := range balances {
+		for i := len(tiers) - 1; i >= 0; i-- {
+			if balance.Cmp(tiers[i].Threshold) >= 0 {
+				summaries[i].Delegators++
+				summaries[i].Stake.Add(summaries[i].Stake, balance)
+				break
+			}
+		}
+	}
+
+	for i := range summaries {
+		summaries[i].StakeShare = Share(summaries[i].Stake, totalStake)
+	}
+
+	return summaries
+}
+
+func tierFor(balance *big.Int, tiers []Tier) string {
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if balance.Cmp(tiers[i].Threshold) >= 0 {
+			return tiers[i].Name
+		}
+	}
+
+	return ""
+}
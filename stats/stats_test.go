@@ -0,0 +1,194 @@
+package stats
+
+import (
+	"encoding/json"
+	big "math/big"
+	"testing"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	DELEGATION_RESPONSES =
+`[
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69a",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+			"shares":"500000.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"500000"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya",
+			"shares":"2000000.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"2000000"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
+			"shares":"5000000.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"5000000"
+		}
+	},
+	{
+		"delegation": {
+			"delegator_address":"osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69m",
+			"validator_address":"osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb",
+			"shares":"2500000.000000000000000000"
+		},
+		"balance":{
+			"denom":"uosmo",
+			"amount":"2500000"
+		}
+	}
+]`
+	)
+
+func getDelegationsMap(t *testing.T) *delegationsModule.DelegationsWithTotalBalance {
+	var delegationResponses delegationTypes.DelegationResponses
+	err := json.Unmarshal([]byte(DELEGATION_RESPONSES), &delegationResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return delegationsModule.GetDelegationsWithTotalBalance(&delegationResponses)
+}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("whale=1000, dust=0,retail=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, len(tiers))
+	assert.Equal(t, "dust", tiers[0].Name)
+	assert.Equal(t, "retail", tiers[1].Name)
+	assert.Equal(t, "whale", tiers[2].Name)
+	assert.Equal(t, "1000", tiers[2].Threshold.String())
+
+	_, err = ParseTiers("whale")
+	assert.Error(t, err)
+
+	_, err = ParseTiers("whale=-1")
+	assert.Error(t, err)
+
+	// the delegators below the lowest threshold would be in no tier
+	_, err = ParseTiers("retail=10,whale=1000")
+	assert.ErrorContains(t, err, "has to start at 0")
+
+	_, err = ParseTiers("dust=0,whale=10,whale=1000")
+	assert.ErrorContains(t, err, "tier whale is given more than once")
+
+	_, err = ParseTiers("dust=0,retail=10,whale=10")
+	assert.ErrorContains(t, err, "the same threshold 10")
+}
+
+func TestGetDistribution(t *testing.T) {
+	tiers := []Tier{
+		{Name: "dust", Threshold: big.NewInt(0)},
+		{Name: "retail", Threshold: big.NewInt(1_000_000)},
+		{Name: "whale", Threshold: big.NewInt(5_000_000)},
+	}
+
+	distribution := GetDistribution(getDelegationsMap(t), tiers)
+
+	assert.Equal(t, 3, distribution.Delegators)
+	assert.Equal(t, "10000000", distribution.TotalStake.String())
+
+	// balances are 500000, 2500000 and 7000000
+	assert.Equal(t, 50, distribution.Percentiles[2].Percentile)
+	assert.Equal(t, "2500000", distribution.Percentiles[2].Balance.String())
+	assert.Equal(t, "7000000", distribution.Percentiles[len(distribution.Percentiles)-1].Balance.String())
+
+	// the [1000000, 10000000) bucket is split at the whale threshold
+	assert.Equal(t, 3, len(distribution.Buckets))
+	assert.Equal(t, "100000", distribution.Buckets[0].Min.String())
+	assert.Equal(t, "1000000", distribution.Buckets[0].Max.String())
+	assert.Equal(t, "dust", distribution.Buckets[0].Tier)
+	assert.Equal(t, 1, distribution.Buckets[0].Delegators)
+	assert.Equal(t, "0.050000", distribution.Buckets[0].StakeShare)
+	assert.Equal(t, "1000000", distribution.Buckets[1].Min.String())
+	assert.Equal(t, "5000000", distribution.Buckets[1].Max.String())
+	assert.Equal(t, "retail", distribution.Buckets[1].Tier)
+	assert.Equal(t, 1, distribution.Buckets[1].Delegators)
+	assert.Equal(t, "2500000", distribution.Buckets[1].Stake.String())
+	assert.Equal(t, "5000000", distribution.Buckets[2].Min.String())
+	assert.Equal(t, "10000000", distribution.Buckets[2].Max.String())
+	assert.Equal(t, "whale", distribution.Buckets[2].Tier)
+	assert.Equal(t, "7000000", distribution.Buckets[2].Stake.String())
+
+	assert.Equal(t, 1, distribution.Tiers[0].Delegators)
+	assert.Equal(t, 1, distribution.Tiers[1].Delegators)
+	assert.Equal(t, 1, distribution.Tiers[2].Delegators)
+	assert.Equal(t, "0.700000", distribution.Tiers[2].StakeShare)
+
+	assert.Equal(t, []ValidatorCount{{Validators: 1, Delegators: 2}, {Validators: 2, Delegators: 1}},
+		distribution.ValidatorsPerDelegator)
+}
+
+func TestGetValidatorBreakdowns(t *testing.T) {
+	validators := delegationTypes.Validators{
+		{OperatorAddress: "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya", Description: delegationTypes.Description{Moniker: "Inotel"}},
+		{OperatorAddress: "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fyb", Description: delegationTypes.Description{Moniker: "Inotel Second"}},
+		{OperatorAddress: "osmovaloper1unused", Description: delegationTypes.Description{Moniker: "Empty"}},
+	}
+
+	breakdowns := GetValidatorBreakdowns(&validators, getDelegationsMap(t))
+
+	assert.Equal(t, 3, len(breakdowns))
+
+	first := breakdowns[0]
+	assert.Equal(t, "Inotel", first.Moniker)
+	assert.Equal(t, 2, first.Delegators)
+	assert.Equal(t, "2500000", first.TotalStake.String())
+	assert.Equal(t, "1250000", first.MedianStake.String())
+	assert.Equal(t, "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l", first.TopDelegators[0].Delegator)
+	assert.Equal(t, "0.800000", first.TopDelegators[0].StakeShare)
+	assert.Equal(t, "1.000000", first.TopStakeShare)
+	assert.Equal(t, "0.800000", first.MultiValidatorStakeShare)
+
+	second := breakdowns[1]
+	assert.Equal(t, 2, second.Delegators)
+	assert.Equal(t, "7500000", second.TotalStake.String())
+	assert.Equal(t, "0.666667", second.MultiValidatorStakeShare)
+
+	empty := breakdowns[2]
+	assert.Equal(t, 0, empty.Delegators)
+	assert.Equal(t, "0", empty.MedianStake.String())
+	assert.Equal(t, "0.000000", empty.TopStakeShare)
+}
+
+func TestIsSelfDelegation(t *testing.T) {
+	addressBytes := []byte("01234567890123456789")
+
+	delegator, err := bech32.ConvertAndEncode("osmo", addressBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operator, err := bech32.ConvertAndEncode("osmovaloper", addressBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, isSelfDelegation(delegator, operator))
+	assert.False(t, isSelfDelegation("osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l", operator))
+}
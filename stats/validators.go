@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"bytes"
+	big "math/big"
+	"sort"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the number of largest delegators reported for each validator
+const TopDelegatorsCount = 10
+
+// a delegator's stake with one validator
+type DelegatorStake struct {
+	Delegator  string   `json:"delegator"`
+	Stake      *big.Int `json:"stake"`
+	StakeShare string   `json:"stake_share"`
+}
+
+// how concentrated a validator's stake is
+type ValidatorBreakdown struct {
+	OperatorAddress string           `json:"operator_address"`
+	Moniker         string           `json:"moniker"`
+	Delegators      int              `json:"delegators"`
+	TotalStake      *big.Int         `json:"total_stake"`
+	MedianStake     *big.Int         `json:"median_stake"`
+	TopDelegators   []DelegatorStake `json:"top_delegators"`
+	TopStake        *big.Int         `json:"top_stake"`
+	TopStakeShare   string           `json:"top_stake_share"`
+	SelfStake       *big.Int         `json:"self_stake"`
+	SelfStakeShare  string           `json:"self_stake_share"`
+
+	// the stake coming from delegators who also delegate to another validator
+	MultiValidatorStake      *big.Int `json:"multi_validator_stake"`
+	MultiValidatorStakeShare string   `json:"multi_validator_stake_share"`
+}
+
+// breaks down the delegations of every validator, in the order the validators are given
+func GetValidatorBreakdowns(validators *validatorTypes.Validators,
+	delegationsMap *delegationsModule.DelegationsWithTotalBalance,
+) []ValidatorBreakdown {
+	stakesByValidator := make(map[string]map[string]*big.Int)
+
+	for delegator, delegationWithTotalBalance := range *delegationsMap {
+		for _, delegationResponse := range delegationWithTotalBalance.DelegationResponses {
+			validatorAddress := delegationResponse.Delegation.ValidatorAddress
+
+			stakes, ok := stakesByValidator[validatorAddress]
+			if !ok {
+				stakes = make(map[string]*big.Int)
+				stakesByValidator[validatorAddress] = stakes
+			}
+
+			if stake, ok := stakes[delegator]; ok {
+				stake.Add(stake, delegationResponse.Balance.Amount.BigInt())
+			} else {
+				stakes[delegator] = delegationResponse.Balance.Amount.BigInt()
+			}
+		}
+	}
+
+	breakdowns := make([]ValidatorBreakdown, 0, len(*validators))
+	for _, validator := range *validators {
+		breakdowns = append(breakdowns,
+			getValidatorBreakdown(validator, stakesByValidator[validator.OperatorAddress], delegationsMap))
+	}
+
+	return breakdowns
+}
+
+func getValidatorBreakdown(validator validatorTypes.Validator, stakes map[string]*big.Int,
+	delegationsMap *delegationsModule.DelegationsWithTotalBalance,
+) ValidatorBreakdown {
+	breakdown := ValidatorBreakdown{
+		OperatorAddress:     validator.OperatorAddress,
+		Moniker:             validator.Description.Moniker,
+		Delegators:          len(stakes),
+		TotalStake:          new(big.Int),
+		MedianStake:         new(big.Int),
+		TopDelegators:       make([]DelegatorStake, 0, TopDelegatorsCount),
+		TopStake:            new(big.Int),
+		SelfStake:           new(big.Int),
+		MultiValidatorStake: new(big.Int),
+	}
+
+	delegators := make([]string, 0, len(stakes))
+	for delegator, stake := range stakes {
+		delegators = append(delegators, delegator)
+		breakdown.TotalStake.Add(breakdown.TotalStake, stake)
+
+		if isSelfDelegation(delegator, validator.OperatorAddress) {
+			breakdown.SelfStake.Add(breakdown.SelfStake, stake)
+		}
+
+		if delegatesToMultipleValidators((*delegationsMap)[delegator]) {
+			breakdown.MultiValidatorStake.Add(breakdown.MultiValidatorStake, stake)
+		}
+	}
+
+	// largest first, ties broken by address so the output is stable
+	sort.Slice(delegators, func(i, j int) bool {
+		if cmp := stakes[delegators[i]].Cmp(stakes[delegators[j]]); cmp != 0 {
+			return cmp > 0
+		}
+		return delegators[i] < delegators[j]
+	})
+
+	if len(delegators) > 0 {
+		middle := len(delegators) / 2
+		if len(delegators)%2 == 1 {
+			breakdown.MedianStake.Set(stakes[delegators[middle]])
+		} else {
+			breakdown.MedianStake.Add(stakes[delegators[middle-1]], stakes[delegators[middle]])
+			breakdown.MedianStake.Quo(breakdown.MedianStake, big.NewInt(2))
+		}
+	}
+
+	for i := 0; i < len(delegators) && i < TopDelegatorsCount; i++ {
+		stake := stakes[delegators[i]]
+		breakdown.TopStake.Add(breakdown.TopStake, stake)
+		breakdown.TopDelegators = append(breakdown.TopDelegators, DelegatorStake{
+			Delegator:  delegators[i],
+			Stake:      stake,
+			StakeShare: Share(stake, breakdown.TotalStake),
+		})
+	}
+
+	breakdown.TopStakeShare = Share(breakdown.TopStake, breakdown.TotalStake)
+	breakdown.SelfStakeShare = Share(breakdown.SelfStake, breakdown.TotalStake)
+	breakdown.MultiValidatorStakeShare = Share(breakdown.MultiValidatorStake, breakdown.TotalStake)
+
+	return breakdown
+}
+
+// a self delegation is a delegation from the account with the same bytes as the operator address.
+// Comparing the decoded bytes works for any bech32 prefix
+func isSelfDelegation(delegator string, operatorAddress string) bool {
+	_, delegatorBytes, err := bech32.DecodeAndConvert(delegator)
+	if err != nil {
+		return false
+	}
+
+	_, operatorBytes, err := bech32.DecodeAndConvert(operatorAddress)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(delegatorBytes, operatorBytes)
+}
+
+func delegatesToMultipleValidators(delegationWithTotalBalance delegationsModule.DelegationResponsesWithTotalBalance) bool {
+	for _, delegationResponse := range delegationWithTotalBalance.DelegationResponses {
+		if delegationResponse.Delegation.ValidatorAddress !=
+			delegationWithTotalBalance.DelegationResponses[0].Delegation.ValidatorAddress {
+			return true
+		}
+	}
+
+	return false
+}
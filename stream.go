@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	coreTypes "github.com/tendermint/tendermint/rpc/core/types"
+
+	blocksModule "github.com/brianosaurus/challenge1/blocks"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	fixtureModule "github.com/brianosaurus/challenge1/fixture"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	rpcModule "github.com/brianosaurus/challenge1/rpc"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+	streamModule "github.com/brianosaurus/challenge1/stream"
+)
+
+// the stream subcommand takes a snapshot at the latest height and keeps its delegations up to date with the staking
+// events of the node's Tendermint websocket, appending a json line to -changesFile for every change
+func streamCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var rpcNode string
+	var changesOutputFile string
+	var reseed time.Duration
+	options := connectionOptions{}
+
+	addConnectionFlags(flags, &options)
+	flags.StringVar(&rpcNode, "rpcNode", "",
+		"the url of the node's Tendermint RPC the events are subscribed on, e.g. http://localhost:26657. Defaults to -node with -source rpc")
+	flags.StringVar(&changesOutputFile, "changesFile", "changes.ndjson", "the file the change records are appended to")
+	flags.DurationVar(&reseed, "reseed", 0,
+		"how often the delegations are seeded again to catch up with what the events don't tell (e.g. 1h), 0 for never")
+
+	return func(ctx context.Context) error {
+		if options.height > 0 {
+			return failuresModule.Errorf(failuresModule.Config, "-height can't be used with stream, it starts at the latest height")
+		}
+		if options.source == sourceGenesis || options.source == sourceAppDB {
+			return failuresModule.Errorf(failuresModule.Config, "stream needs a running node, not -source %s", options.source)
+		}
+		if _, replaying := clientModule.SourceFrom(ctx).(*fixtureModule.Source); replaying {
+			return failuresModule.Errorf(failuresModule.Config, "stream needs a running node, events can't be replayed")
+		}
+		if rpcNode == "" && options.source == sourceRPC {
+			rpcNode = options.node
+		}
+		if rpcNode == "" {
+			return failuresModule.Errorf(failuresModule.Config, "stream: -rpcNode is required unless -source is rpc")
+		}
+
+		ctx, err := options.withSource(ctx)
+		if err != nil {
+			return err
+		}
+
+		changesFile, err := os.OpenFile(changesOutputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+		defer changesFile.Close()
+
+		logger := loggingModule.FromContext(ctx)
+
+		state, err := streamModule.Run(ctx, streamModule.Options{
+			Subscribe: func(ctx context.Context) (<-chan coreTypes.ResultEvent, error) {
+				events, err := rpcModule.Subscribe(ctx, rpcNode, streamModule.Queries...)
+				if err != nil {
+					return nil, err
+				}
+
+				logger.Info("subscribed to staking events", "rpc_node", rpcNode)
+				return events, nil
+			},
+			Seed: func(ctx context.Context) (*snapshotModule.Snapshot, error) {
+				height, err := blocksModule.GetLatestHeight(ctx, options.node)
+				if err != nil {
+					return nil, err
+				}
+
+				logger.Info("seeding the delegations", "node", options.node, "height", height)
+				return snapshotModule.Fetch(ctx, snapshotModule.Options{Node: options.node, Height: height})
+			},
+			Reseed:  reseed,
+			Changes: changesFile,
+		})
+		if state != nil {
+			logger.Info("stopped streaming", "delegators", len(state.Delegations))
+		}
+
+		return err
+	}
+}
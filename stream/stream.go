@@ -0,0 +1,591 @@
+// Package stream keeps the delegations of a chain up to date between snapshots by applying the staking events a node
+// publishes on its Tendermint websocket to a snapshot, and records every change as a line of json
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	big "math/big"
+	"sort"
+	"time"
+
+	abciTypes "github.com/tendermint/tendermint/abci/types"
+	coreTypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmTypes "github.com/tendermint/tendermint/types"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+// the subscriptions the staking events come through. Delegations, unbondings and redelegations are messages of a
+// transaction, completed unbondings happen at the end of a block and slashes at its beginning
+var Queries = []string{
+	"tm.event = 'Tx' AND message.module = 'staking'",
+	"tm.event = 'NewBlockHeader' AND complete_unbonding.delegator EXISTS",
+	"tm.event = 'NewBlockHeader' AND slash.address EXISTS",
+}
+
+// the event of a slash. It names the consensus address of the validator but not the delegations it took from
+const EventTypeSlash = "slash"
+
+// the events recorded for the changes of seeding the delegations again, next to the staking events
+const (
+	// a validator was slashed
+	EventSlashed = "slashed"
+	// the -reseed interval passed
+	EventReseeded = "reseeded"
+	// the subscription dropped and events may have been missed
+	EventResubscribed = "resubscribed"
+)
+
+// a staking event decoded from a transaction or the end of a block
+type Event struct {
+	Type   string
+	Height int64
+	// empty for the events of the beginning and end of a block
+	TxHash    string
+	Delegator string
+	// the source validator of a redelegation, the consensus address of a slashed one
+	Validator            string
+	DestinationValidator string
+	Amount               sdk.Coin
+	// the shares a delegation got, nil for every other event
+	NewShares *sdk.Dec
+}
+
+// decodes the staking events of a transaction or of the end of a block. Failed transactions and other events have
+// none
+func Decode(result coreTypes.ResultEvent) ([]Event, error) {
+	switch data := result.Data.(type) {
+	case tmTypes.EventDataTx:
+		if data.Result.Code != abciTypes.CodeTypeOK {
+			return nil, nil
+		}
+
+		hash := fmt.Sprintf("%X", tmTypes.Tx(data.Tx).Hash())
+		return decodeEvents(data.Height, hash, data.Result.Events)
+	case tmTypes.EventDataNewBlockHeader:
+		events := append(append([]abciTypes.Event{}, data.ResultBeginBlock.Events...), data.ResultEndBlock.Events...)
+		return decodeEvents(data.Header.Height, "", events)
+	default:
+		return nil, nil
+	}
+}
+
+func decodeEvents(height int64, hash string, events []abciTypes.Event) ([]Event, error) {
+	decoded := make([]Event, 0)
+
+	for i, event := range events {
+		switch event.Type {
+		case stakingTypes.EventTypeDelegate, stakingTypes.EventTypeUnbond, stakingTypes.EventTypeRedelegate,
+			stakingTypes.EventTypeCompleteUnbonding:
+		case EventTypeSlash:
+			decoded = append(decoded, Event{Type: EventTypeSlash, Height: height, TxHash: hash,
+				Validator: attributesOf(event)["address"]})
+			continue
+		default:
+			continue
+		}
+
+		attributes := attributesOf(event)
+		coins, err := sdk.ParseCoinsNormalized(attributes[sdk.AttributeKeyAmount])
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount of a %s event at height %d: %w", event.Type, height, err)
+		}
+
+		// an unbonding of a slashed away delegation completes with nothing
+		if coins.IsZero() {
+			continue
+		}
+		if len(coins) > 1 {
+			return nil, fmt.Errorf("a %s event at height %d moved several denoms: %s", event.Type, height, coins)
+		}
+
+		decodedEvent := Event{
+			Type:      event.Type,
+			Height:    height,
+			TxHash:    hash,
+			Delegator: attributes[stakingTypes.AttributeKeyDelegator],
+			Validator: attributes[stakingTypes.AttributeKeyValidator],
+			Amount:    coins[0],
+		}
+
+		if event.Type == stakingTypes.EventTypeRedelegate {
+			decodedEvent.Validator = attributes[stakingTypes.AttributeKeySrcValidator]
+			decodedEvent.DestinationValidator = attributes[stakingTypes.AttributeKeyDstValidator]
+		}
+
+		if shares, ok := attributes[stakingTypes.AttributeKeyNewShares]; ok {
+			newShares, err := sdk.NewDecFromStr(shares)
+			if err != nil {
+				return nil, fmt.Errorf("invalid new shares of a %s event at height %d: %w", event.Type, height, err)
+			}
+			decodedEvent.NewShares = &newShares
+		}
+
+		// the staking messages only name the delegator as the sender of the message event they emit next
+		if decodedEvent.Delegator == "" {
+			decodedEvent.Delegator = stakingSender(events[i+1:])
+		}
+
+		if decodedEvent.Delegator == "" || decodedEvent.Validator == "" {
+			return nil, fmt.Errorf("a %s event at height %d doesn't name its delegator and validator", event.Type, height)
+		}
+
+		decoded = append(decoded, decodedEvent)
+	}
+
+	return decoded, nil
+}
+
+func attributesOf(event abciTypes.Event) map[string]string {
+	attributes := make(map[string]string, len(event.Attributes))
+
+	for _, attribute := range event.Attributes {
+		attributes[string(attribute.Key)] = string(attribute.Value)
+	}
+
+	return attributes
+}
+
+// the sender of the first message event of the staking module
+func stakingSender(events []abciTypes.Event) string {
+	for _, event := range events {
+		if event.Type != sdk.EventTypeMessage {
+			continue
+		}
+
+		attributes := attributesOf(event)
+		if attributes[sdk.AttributeKeyModule] == stakingTypes.AttributeValueCategory {
+			return attributes[sdk.AttributeKeySender]
+		}
+	}
+
+	return ""
+}
+
+// the record of how an event changed the stake of a delegator
+type Change struct {
+	Height int64  `json:"height"`
+	TxHash string `json:"tx_hash,omitempty"`
+	Event  string `json:"event"`
+	// the source validator of a redelegation
+	Validator            string   `json:"validator"`
+	DestinationValidator string   `json:"destination_validator,omitempty"`
+	Delegator            string   `json:"delegator"`
+	Amount               *big.Int `json:"amount"`
+	Denom                string   `json:"denom"`
+	// the delegator's total delegated balance before and after the event
+	OldTotal *big.Int `json:"old_total"`
+	NewTotal *big.Int `json:"new_total"`
+}
+
+// the delegations of a chain as of the last event applied
+type State struct {
+	// the height of the snapshot the state was seeded from. Its events are already in it
+	Height      int64
+	Delegations delegationsModule.DelegationsWithTotalBalance
+}
+
+// a state seeded from a snapshot, which should be pinned to a height so no event is counted twice
+func NewState(snapshot *snapshotModule.Snapshot) *State {
+	state := &State{Height: snapshot.Height, Delegations: make(delegationsModule.DelegationsWithTotalBalance)}
+	if state.Height == 0 {
+		state.Height = snapshot.BlockHeight
+	}
+
+	if snapshot.Delegations != nil {
+		for delegator, delegationsWithTotalBalance := range *snapshot.Delegations {
+			delegationResponses := make(stakingTypes.DelegationResponses, len(delegationsWithTotalBalance.DelegationResponses))
+			copy(delegationResponses, delegationsWithTotalBalance.DelegationResponses)
+
+			state.Delegations[delegator] = delegationsModule.DelegationResponsesWithTotalBalance{
+				DelegationResponses: delegationResponses,
+				TotalBalance:        new(big.Int).Set(delegationsWithTotalBalance.TotalBalance),
+			}
+		}
+	}
+
+	return state
+}
+
+// applies an event to the delegations. Delegating adds to a delegation, unbonding takes from it and redelegating
+// moves the amount between validators. A completed unbonding pays out what was already taken when unbonding started,
+// so it changes nothing but is still recorded
+func (state *State) Apply(event Event) Change {
+	change := Change{
+		Height:               event.Height,
+		TxHash:               event.TxHash,
+		Event:                event.Type,
+		Validator:            event.Validator,
+		DestinationValidator: event.DestinationValidator,
+		Delegator:            event.Delegator,
+		Amount:               event.Amount.Amount.BigInt(),
+		Denom:                event.Amount.Denom,
+		OldTotal:             state.total(event.Delegator),
+	}
+
+	switch event.Type {
+	case stakingTypes.EventTypeDelegate:
+		state.add(event.Delegator, event.Validator, event.Amount, event.NewShares)
+	case stakingTypes.EventTypeUnbond:
+		state.add(event.Delegator, event.Validator, sdk.Coin{Denom: event.Amount.Denom, Amount: event.Amount.Amount.Neg()},
+			nil)
+	case stakingTypes.EventTypeRedelegate:
+		state.add(event.Delegator, event.Validator, sdk.Coin{Denom: event.Amount.Denom, Amount: event.Amount.Amount.Neg()},
+			nil)
+		state.add(event.Delegator, event.DestinationValidator, event.Amount, nil)
+	}
+
+	change.NewTotal = state.total(event.Delegator)
+
+	return change
+}
+
+func (state *State) total(delegator string) *big.Int {
+	if delegationsWithTotalBalance, ok := state.Delegations[delegator]; ok {
+		return new(big.Int).Set(delegationsWithTotalBalance.TotalBalance)
+	}
+
+	return new(big.Int)
+}
+
+// adds a signed amount to the delegation of a delegator with a validator. The shares follow the balance unless the
+// event said what they became. A delegation is dropped once nothing is left and so is a delegator without any
+func (state *State) add(delegator string, validator string, amount sdk.Coin, newShares *sdk.Dec) {
+	delegationsWithTotalBalance := state.Delegations[delegator]
+	delegationResponses := delegationsWithTotalBalance.DelegationResponses
+
+	index := -1
+	for i, delegationResponse := range delegationResponses {
+		if delegationResponse.Delegation.ValidatorAddress == validator {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		delegationResponses = append(delegationResponses, stakingTypes.DelegationResponse{
+			Delegation: stakingTypes.Delegation{DelegatorAddress: delegator, ValidatorAddress: validator,
+				Shares: sdk.ZeroDec()},
+			Balance: sdk.NewCoin(amount.Denom, sdk.ZeroInt()),
+		})
+		index = len(delegationResponses) - 1
+	}
+
+	delegationResponse := &delegationResponses[index]
+	oldBalance := delegationResponse.Balance.Amount
+	newBalance := oldBalance.Add(amount.Amount)
+	if newBalance.IsNegative() {
+		newBalance = sdk.ZeroInt()
+	}
+
+	switch {
+	case newShares != nil:
+		delegationResponse.Delegation.Shares = delegationResponse.Delegation.Shares.Add(*newShares)
+	case oldBalance.IsPositive():
+		delegationResponse.Delegation.Shares = delegationResponse.Delegation.Shares.MulInt(newBalance).QuoInt(oldBalance)
+	default:
+		delegationResponse.Delegation.Shares = sdk.NewDecFromInt(newBalance)
+	}
+	delegationResponse.Balance = sdk.NewCoin(amount.Denom, newBalance)
+
+	if newBalance.IsZero() {
+		delegationResponses = append(delegationResponses[:index], delegationResponses[index+1:]...)
+	}
+
+	if len(delegationResponses) == 0 {
+		delete(state.Delegations, delegator)
+		return
+	}
+
+	total := new(big.Int)
+	for _, delegationResponse := range delegationResponses {
+		total.Add(total, delegationResponse.Balance.Amount.BigInt())
+	}
+
+	state.Delegations[delegator] = delegationsModule.DelegationResponsesWithTotalBalance{
+		DelegationResponses: delegationResponses,
+		TotalBalance:        total,
+	}
+}
+
+// the changes of every delegator whose total differs in seeded, recorded as event at the height of seeded. Sorted by
+// delegator
+func (state *State) Changes(seeded *State, event string) []Change {
+	delegators := make([]string, 0, len(seeded.Delegations))
+	for delegator := range seeded.Delegations {
+		delegators = append(delegators, delegator)
+	}
+	for delegator := range state.Delegations {
+		if _, ok := seeded.Delegations[delegator]; !ok {
+			delegators = append(delegators, delegator)
+		}
+	}
+	sort.Strings(delegators)
+
+	changes := make([]Change, 0)
+	for _, delegator := range delegators {
+		oldTotal, newTotal := state.total(delegator), seeded.total(delegator)
+		if oldTotal.Cmp(newTotal) == 0 {
+			continue
+		}
+
+		denom := state.denom(delegator)
+		if denom == "" {
+			denom = seeded.denom(delegator)
+		}
+
+		changes = append(changes, Change{
+			Height:    seeded.Height,
+			Event:     event,
+			Delegator: delegator,
+			Amount:    new(big.Int).Sub(newTotal, oldTotal),
+			Denom:     denom,
+			OldTotal:  oldTotal,
+			NewTotal:  newTotal,
+		})
+	}
+
+	return changes
+}
+
+// the denom of a delegator's delegations, empty without any
+func (state *State) denom(delegator string) string {
+	for _, delegationResponse := range state.Delegations[delegator].DelegationResponses {
+		return delegationResponse.Balance.Denom
+	}
+
+	return ""
+}
+
+// the first wait before subscribing again once the subscription dropped, doubled for every further attempt
+var ResubscribeBackoff = time.Second
+
+// the subscriptions in a row that may drop before any event came through them before the stream fails
+const resubscribeAttempts = 5
+
+// the options of a stream
+type Options struct {
+	// subscribes to the staking events of the chain. It is subscribed before the seed is taken so none are missed, and
+	// again when the subscription drops
+	Subscribe func(ctx context.Context) (<-chan coreTypes.ResultEvent, error)
+	// takes the snapshot the delegations are seeded from, pinned to a height
+	Seed func(ctx context.Context) (*snapshotModule.Snapshot, error)
+	// how often the delegations are seeded again to catch up with what the events don't tell, 0 for never
+	Reseed time.Duration
+	// where a change record is written for every event
+	Changes io.Writer
+}
+
+// seeds the delegations and applies every event after the seed's height to them until ctx is done, writing a change
+// record for each. The events coming in while the seed is taken are kept and applied once it is. An event that can't
+// be decoded is logged and skipped. A slash doesn't say which delegations it took from, so the delegations are seeded
+// again after one and the change of every delegator whose total moved is recorded, as they are every Reseed and
+// after subscribing again to a dropped subscription. It fails if the subscription keeps dropping or a record can't be
+// written
+func Run(ctx context.Context, options Options) (*State, error) {
+	logger := loggingModule.FromContext(ctx)
+	encoder := json.NewEncoder(options.Changes)
+
+	events, err := options.Subscribe(ctx)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.Network, err)
+	}
+
+	state, backlog, events, err := seedState(ctx, options.Seed, events)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("streaming delegation changes", "height", state.Height, "delegators", len(state.Delegations),
+		"backlog", len(backlog))
+
+	// applies the events of a result, telling if one was a slash
+	apply := func(result coreTypes.ResultEvent) (bool, error) {
+		decoded, err := Decode(result)
+		if err != nil {
+			logger.Error("skipping an event that can't be decoded", "query", result.Query, "err", err)
+			return false, nil
+		}
+
+		slashed := false
+		for _, event := range decoded {
+			if event.Height <= state.Height {
+				continue
+			}
+
+			if event.Type == EventTypeSlash {
+				logger.Info("a validator was slashed", "height", event.Height, "address", event.Validator)
+				slashed = true
+				continue
+			}
+
+			change := state.Apply(event)
+			logger.Debug("applied event", "event", change.Event, "height", change.Height,
+				"delegator", change.Delegator, "new_total", change.NewTotal)
+
+			if err := encoder.Encode(change); err != nil {
+				return false, failuresModule.Wrap(failuresModule.IO, err)
+			}
+		}
+
+		return slashed, nil
+	}
+
+	var reseedTicks <-chan time.Time
+	if options.Reseed > 0 {
+		ticker := time.NewTicker(options.Reseed)
+		defer ticker.Stop()
+		reseedTicks = ticker.C
+	}
+
+	// why the delegations are seeded again, empty while they aren't
+	var reason string
+	drops := 0
+
+	for {
+		if events == nil {
+			reason = EventResubscribed
+		}
+
+		for _, result := range backlog {
+			slashed, err := apply(result)
+			if err != nil {
+				return state, err
+			}
+			if slashed && reason == "" {
+				reason = EventSlashed
+			}
+		}
+		backlog = nil
+
+		for reason == "" {
+			select {
+			case <-ctx.Done():
+				return state, nil
+			case <-reseedTicks:
+				reason = EventReseeded
+			case result, ok := <-events:
+				if !ok {
+					events = nil
+					reason = EventResubscribed
+					continue
+				}
+
+				drops = 0
+				slashed, err := apply(result)
+				if err != nil {
+					return state, err
+				}
+				if slashed {
+					reason = EventSlashed
+				}
+			}
+		}
+
+		if events == nil {
+			if drops++; drops > resubscribeAttempts {
+				return state, failuresModule.Errorf(failuresModule.Network, "the event subscription ended %d times in a row",
+					drops-1)
+			}
+
+			if events, err = resubscribe(ctx, options.Subscribe, drops); err != nil {
+				if ctx.Err() != nil {
+					return state, nil
+				}
+				return state, err
+			}
+		}
+
+		logger.Info("seeding the delegations again", "reason", reason, "height", state.Height)
+
+		seeded, seedBacklog, seedEvents, err := seedState(ctx, options.Seed, events)
+		events, backlog = seedEvents, seedBacklog
+
+		switch {
+		case ctx.Err() != nil:
+			return state, nil
+		case err != nil:
+			// the old seed is still right but for what the events don't tell
+			logger.Error("seeding again failed, streaming on from the last seed", "err", err)
+		default:
+			for _, change := range state.Changes(seeded, reason) {
+				if err := encoder.Encode(change); err != nil {
+					return state, failuresModule.Wrap(failuresModule.IO, err)
+				}
+			}
+
+			logger.Info("seeded the delegations again", "height", seeded.Height, "delegators", len(seeded.Delegations))
+			state = seeded
+		}
+		reason = ""
+	}
+}
+
+// takes a seed, keeping the events that come in meanwhile. The events are nil once the subscription ended
+func seedState(ctx context.Context, take func(ctx context.Context) (*snapshotModule.Snapshot, error),
+	events <-chan coreTypes.ResultEvent,
+) (*State, []coreTypes.ResultEvent, <-chan coreTypes.ResultEvent, error) {
+	type result struct {
+		snapshot *snapshotModule.Snapshot
+		err      error
+	}
+
+	seeded := make(chan result, 1)
+	go func() {
+		snapshot, err := take(ctx)
+		seeded <- result{snapshot: snapshot, err: err}
+	}()
+
+	backlog := make([]coreTypes.ResultEvent, 0)
+
+	for {
+		select {
+		case seed := <-seeded:
+			if seed.err != nil {
+				return nil, backlog, events, seed.err
+			}
+			return NewState(seed.snapshot), backlog, events, nil
+		case event, ok := <-events:
+			if !ok {
+				// the seed fails too if ctx is done
+				events = nil
+				continue
+			}
+			backlog = append(backlog, event)
+		}
+	}
+}
+
+// subscribes again after a wait that doubles with every drop in a row
+func resubscribe(ctx context.Context, subscribe func(ctx context.Context) (<-chan coreTypes.ResultEvent, error),
+	drops int,
+) (<-chan coreTypes.ResultEvent, error) {
+	wait := ResubscribeBackoff << (drops - 1)
+	loggingModule.FromContext(ctx).Error("the event subscription ended, subscribing again", "in", wait,
+		"attempt", drops)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+	}
+
+	events, err := subscribe(ctx)
+	if err != nil {
+		return nil, failuresModule.Wrap(failuresModule.Network, err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,455 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	abciTypes "github.com/tendermint/tendermint/abci/types"
+	coreTypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmTypes "github.com/tendermint/tendermint/types"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	stakingTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	VALIDATOR       = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	OTHER_VALIDATOR = "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v"
+	DELEGATOR       = "osmo1qqrtqudvxhcan3fe2r98834ge8r8nffufte69l"
+	BONDED_POOL     = "osmo1fl48vsnmsdzcv85q5d2q4z5ajdha8yu3aq6l09"
+)
+
+func event(kind string, attributes ...string) abciTypes.Event {
+	event := abciTypes.Event{Type: kind}
+	for i := 0; i < len(attributes); i += 2 {
+		event.Attributes = append(event.Attributes,
+			abciTypes.EventAttribute{Key: []byte(attributes[i]), Value: []byte(attributes[i+1])})
+	}
+	return event
+}
+
+// the events of a transaction as the sdk emits them for each message: the message event of its action, the bank
+// transfers, the staking event and the message event of the staking module naming the delegator
+func tx(height int64, code uint32, events ...abciTypes.Event) coreTypes.ResultEvent {
+	return coreTypes.ResultEvent{
+		Query: Queries[0],
+		Data: tmTypes.EventDataTx{TxResult: abciTypes.TxResult{
+			Height: height,
+			Tx:     []byte("tx"),
+			Result: abciTypes.ResponseDeliverTx{Code: code, Events: events},
+		}},
+	}
+}
+
+func delegate(delegator string, validator string, amount string, shares string) []abciTypes.Event {
+	return []abciTypes.Event{
+		event("message", "action", "/cosmos.staking.v1beta1.MsgDelegate", "sender", delegator, "module", "staking"),
+		event("coin_spent", "spender", delegator, "amount", amount),
+		event("delegate", "validator", validator, "amount", amount, "new_shares", shares),
+		event("message", "module", "staking", "sender", delegator),
+	}
+}
+
+func undelegate(delegator string, validator string, amount string) []abciTypes.Event {
+	return []abciTypes.Event{
+		event("message", "action", "/cosmos.staking.v1beta1.MsgUndelegate", "sender", delegator, "module", "staking"),
+		event("transfer", "recipient", "osmo1notbonded", "sender", BONDED_POOL, "amount", amount),
+		event("message", "sender", BONDED_POOL),
+		event("unbond", "validator", validator, "amount", amount, "completion_time", "2022-11-15T00:00:00Z"),
+		event("message", "module", "staking", "sender", delegator),
+	}
+}
+
+func redelegate(delegator string, from string, to string, amount string) []abciTypes.Event {
+	return []abciTypes.Event{
+		event("redelegate", "source_validator", from, "destination_validator", to, "amount", amount),
+		event("message", "module", "staking", "sender", delegator),
+	}
+}
+
+func endBlock(height int64, events ...abciTypes.Event) coreTypes.ResultEvent {
+	return coreTypes.ResultEvent{
+		Query: Queries[1],
+		Data: tmTypes.EventDataNewBlockHeader{
+			Header:         tmTypes.Header{Height: height},
+			ResultEndBlock: abciTypes.ResponseEndBlock{Events: events},
+		},
+	}
+}
+
+func beginBlock(height int64, events ...abciTypes.Event) coreTypes.ResultEvent {
+	return coreTypes.ResultEvent{
+		Query: Queries[2],
+		Data: tmTypes.EventDataNewBlockHeader{
+			Header:           tmTypes.Header{Height: height},
+			ResultBeginBlock: abciTypes.ResponseBeginBlock{Events: events},
+		},
+	}
+}
+
+func seed(height int64) *snapshotModule.Snapshot {
+	return seedOf(height, 100)
+}
+
+// a seed of DELEGATOR delegating balance to VALIDATOR for 100 shares
+func seedOf(height int64, balance int64) *snapshotModule.Snapshot {
+	delegationResponses := stakingTypes.DelegationResponses{{
+		Delegation: stakingTypes.Delegation{DelegatorAddress: DELEGATOR, ValidatorAddress: VALIDATOR,
+			Shares: sdk.NewDec(100)},
+		Balance: sdk.NewInt64Coin("uosmo", balance),
+	}}
+
+	return &snapshotModule.Snapshot{
+		Height:              height,
+		DelegationResponses: &delegationResponses,
+		Delegations:         delegationsModule.GetDelegationsWithTotalBalance(&delegationResponses),
+	}
+}
+
+func TestDecode(t *testing.T) {
+	events := append(delegate(DELEGATOR, VALIDATOR, "10uosmo", "10.000000000000000000"),
+		undelegate("osmo1other", OTHER_VALIDATOR, "5uosmo")...)
+
+	decoded, err := Decode(tx(7, 0, events...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, len(decoded))
+	assert.Equal(t, "delegate", decoded[0].Type)
+	assert.Equal(t, int64(7), decoded[0].Height)
+	assert.Equal(t, DELEGATOR, decoded[0].Delegator)
+	assert.Equal(t, VALIDATOR, decoded[0].Validator)
+	assert.Equal(t, "10uosmo", decoded[0].Amount.String())
+	assert.Equal(t, "10.000000000000000000", decoded[0].NewShares.String())
+	assert.Equal(t, 64, len(decoded[0].TxHash))
+	assert.Equal(t, "unbond", decoded[1].Type)
+	assert.Equal(t, "osmo1other", decoded[1].Delegator)
+	assert.Nil(t, decoded[1].NewShares)
+
+	decoded, err = Decode(tx(7, 5, events...))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(decoded))
+
+	decoded, err = Decode(endBlock(8,
+		event("complete_unbonding", "amount", "5uosmo", "validator", VALIDATOR, "delegator", DELEGATOR),
+		event("complete_unbonding", "amount", "", "validator", VALIDATOR, "delegator", DELEGATOR)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(decoded))
+	assert.Equal(t, "complete_unbonding", decoded[0].Type)
+	assert.Equal(t, "", decoded[0].TxHash)
+	assert.Equal(t, DELEGATOR, decoded[0].Delegator)
+
+	decoded, err = Decode(beginBlock(9,
+		event("slash", "address", "osmovalcons1slashed", "power", "100", "reason", "double_sign", "jailed",
+			"osmovalcons1slashed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, len(decoded))
+	assert.Equal(t, "slash", decoded[0].Type)
+	assert.Equal(t, int64(9), decoded[0].Height)
+	assert.Equal(t, "osmovalcons1slashed", decoded[0].Validator)
+
+	_, err = Decode(tx(7, 0, event("delegate", "validator", VALIDATOR, "amount", "10uosmo")))
+	assert.ErrorContains(t, err, "doesn't name its delegator")
+
+	_, err = Decode(tx(7, 0, event("unbond", "validator", VALIDATOR, "amount", "ten")))
+	assert.ErrorContains(t, err, "invalid amount")
+}
+
+func TestApply(t *testing.T) {
+	state := NewState(seed(10))
+	assert.Equal(t, int64(10), state.Height)
+
+	apply := func(result coreTypes.ResultEvent) []Change {
+		decoded, err := Decode(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changes := make([]Change, 0)
+		for _, event := range decoded {
+			changes = append(changes, state.Apply(event))
+		}
+		return changes
+	}
+
+	changes := apply(tx(11, 0, redelegate(DELEGATOR, VALIDATOR, OTHER_VALIDATOR, "40uosmo")...))
+	assert.Equal(t, "100", changes[0].OldTotal.String())
+	assert.Equal(t, "100", changes[0].NewTotal.String())
+	responses := state.Delegations[DELEGATOR].DelegationResponses
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, "60uosmo", responses[0].Balance.String())
+	assert.Equal(t, "60.000000000000000000", responses[0].Delegation.Shares.String())
+	assert.Equal(t, OTHER_VALIDATOR, responses[1].Delegation.ValidatorAddress)
+	assert.Equal(t, "40uosmo", responses[1].Balance.String())
+
+	changes = apply(tx(12, 0, delegate(DELEGATOR, VALIDATOR, "20uosmo", "19.000000000000000000")...))
+	assert.Equal(t, "120", changes[0].NewTotal.String())
+	assert.Equal(t, "79.000000000000000000", state.Delegations[DELEGATOR].DelegationResponses[0].Delegation.Shares.String())
+
+	changes = apply(tx(13, 0, undelegate(DELEGATOR, VALIDATOR, "80uosmo")...))
+	assert.Equal(t, "40", changes[0].NewTotal.String())
+	assert.Equal(t, 1, len(state.Delegations[DELEGATOR].DelegationResponses))
+
+	changes = apply(endBlock(14,
+		event("complete_unbonding", "amount", "80uosmo", "validator", VALIDATOR, "delegator", DELEGATOR)))
+	assert.Equal(t, "40", changes[0].OldTotal.String())
+	assert.Equal(t, "40", changes[0].NewTotal.String())
+
+	apply(tx(15, 0, undelegate(DELEGATOR, OTHER_VALIDATOR, "40uosmo")...))
+	_, ok := state.Delegations[DELEGATOR]
+	assert.False(t, ok)
+
+	changes = apply(tx(16, 0, delegate("osmo1new", VALIDATOR, "7uosmo", "7.000000000000000000")...))
+	assert.Equal(t, "0", changes[0].OldTotal.String())
+	assert.Equal(t, "7", state.Delegations["osmo1new"].TotalBalance.String())
+}
+
+func TestChanges(t *testing.T) {
+	state := NewState(seed(10))
+
+	assert.Equal(t, 0, len(state.Changes(NewState(seed(20)), EventReseeded)))
+
+	changes := state.Changes(NewState(seedOf(20, 90)), EventSlashed)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, int64(20), changes[0].Height)
+	assert.Equal(t, "slashed", changes[0].Event)
+	assert.Equal(t, DELEGATOR, changes[0].Delegator)
+	assert.Equal(t, "-10", changes[0].Amount.String())
+	assert.Equal(t, "uosmo", changes[0].Denom)
+	assert.Equal(t, "100", changes[0].OldTotal.String())
+	assert.Equal(t, "90", changes[0].NewTotal.String())
+
+	empty := &snapshotModule.Snapshot{Height: 20}
+	changes = state.Changes(NewState(empty), EventResubscribed)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "-100", changes[0].Amount.String())
+	assert.Equal(t, "0", changes[0].NewTotal.String())
+	assert.Equal(t, "uosmo", changes[0].Denom)
+}
+
+// a change writer handing every change record to the test as it is written
+type changeWriter chan Change
+
+func (writer changeWriter) Write(p []byte) (int, error) {
+	var change Change
+	if err := json.Unmarshal(p, &change); err != nil {
+		return 0, err
+	}
+
+	writer <- change
+	return len(p), nil
+}
+
+// runs a stream subscribing to the subscriptions in turn and seeding from seeds, until the returned stop is called
+func runStream(t *testing.T, reseed time.Duration, subscriptions []chan coreTypes.ResultEvent,
+	seeds chan *snapshotModule.Snapshot,
+) (changeWriter, func() (*State, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(changeWriter, 10)
+	subscribed := 0
+
+	done := make(chan struct{})
+	var state *State
+	var err error
+	go func() {
+		state, err = Run(ctx, Options{
+			Subscribe: func(ctx context.Context) (<-chan coreTypes.ResultEvent, error) {
+				if subscribed == len(subscriptions) {
+					return nil, errors.New("no more subscriptions")
+				}
+				subscribed++
+				return subscriptions[subscribed-1], nil
+			},
+			Seed: func(ctx context.Context) (*snapshotModule.Snapshot, error) {
+				select {
+				case snapshot := <-seeds:
+					return snapshot, nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			},
+			Reseed:  reseed,
+			Changes: changes,
+		})
+		close(done)
+	}()
+
+	return changes, func() (*State, error) {
+		cancel()
+		<-done
+		return state, err
+	}
+}
+
+func TestRunSlashed(t *testing.T) {
+	events := make(chan coreTypes.ResultEvent)
+	seeds := make(chan *snapshotModule.Snapshot)
+	changes, stop := runStream(t, 0, []chan coreTypes.ResultEvent{events}, seeds)
+
+	seeds <- seed(10)
+	events <- beginBlock(11, event("slash", "address", "osmovalcons1slashed", "reason", "double_sign"))
+	seeds <- seedOf(11, 90)
+
+	change := <-changes
+	assert.Equal(t, "slashed", change.Event)
+	assert.Equal(t, int64(11), change.Height)
+	assert.Equal(t, "-10", change.Amount.String())
+	assert.Equal(t, "90", change.NewTotal.String())
+
+	events <- tx(12, 0, delegate(DELEGATOR, VALIDATOR, "10uosmo", "11.111111111111111111")...)
+	change = <-changes
+	assert.Equal(t, "delegate", change.Event)
+	assert.Equal(t, "100", change.NewTotal.String())
+
+	state, err := stop()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), state.Height)
+}
+
+func TestRunReseeded(t *testing.T) {
+	events := make(chan coreTypes.ResultEvent)
+	seeds := make(chan *snapshotModule.Snapshot)
+	changes, stop := runStream(t, time.Millisecond, []chan coreTypes.ResultEvent{events}, seeds)
+
+	seeds <- seed(10)
+	// unchanged, nothing is recorded
+	seeds <- seed(11)
+	seeds <- seedOf(12, 120)
+
+	change := <-changes
+	assert.Equal(t, "reseeded", change.Event)
+	assert.Equal(t, int64(12), change.Height)
+	assert.Equal(t, "20", change.Amount.String())
+
+	state, err := stop()
+	assert.NoError(t, err)
+	assert.Equal(t, "120", state.Delegations[DELEGATOR].TotalBalance.String())
+}
+
+func TestRunResubscribed(t *testing.T) {
+	defer func(backoff time.Duration) { ResubscribeBackoff = backoff }(ResubscribeBackoff)
+	ResubscribeBackoff = time.Millisecond
+
+	first, second := make(chan coreTypes.ResultEvent), make(chan coreTypes.ResultEvent)
+	seeds := make(chan *snapshotModule.Snapshot)
+	changes, stop := runStream(t, 0, []chan coreTypes.ResultEvent{first, second}, seeds)
+
+	seeds <- seed(10)
+	close(first)
+	seeds <- seedOf(15, 150)
+
+	change := <-changes
+	assert.Equal(t, "resubscribed", change.Event)
+	assert.Equal(t, int64(15), change.Height)
+	assert.Equal(t, "50", change.Amount.String())
+
+	// already in the seed
+	second <- tx(15, 0, delegate(DELEGATOR, VALIDATOR, "1uosmo", "1.000000000000000000")...)
+	second <- tx(16, 0, delegate(DELEGATOR, VALIDATOR, "1uosmo", "1.000000000000000000")...)
+	change = <-changes
+	assert.Equal(t, int64(16), change.Height)
+	assert.Equal(t, "151", change.NewTotal.String())
+
+	_, err := stop()
+	assert.NoError(t, err)
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan coreTypes.ResultEvent)
+	seeding := make(chan struct{})
+	var changes bytes.Buffer
+
+	done := make(chan struct{})
+	var state *State
+	var err error
+	go func() {
+		state, err = Run(ctx, Options{
+			Subscribe: func(ctx context.Context) (<-chan coreTypes.ResultEvent, error) { return events, nil },
+			Seed: func(ctx context.Context) (*snapshotModule.Snapshot, error) {
+				<-seeding
+				return seed(10), nil
+			},
+			Changes: &changes,
+		})
+		close(done)
+	}()
+
+	// taken while seeding, the first is already in the seed
+	events <- tx(10, 0, delegate(DELEGATOR, VALIDATOR, "1uosmo", "1.000000000000000000")...)
+	events <- tx(11, 0, delegate(DELEGATOR, VALIDATOR, "2uosmo", "2.000000000000000000")...)
+	close(seeding)
+
+	events <- tx(12, 0, event("delegate", "validator", VALIDATOR, "amount", "3uosmo"))
+	events <- tx(13, 0, undelegate(DELEGATOR, VALIDATOR, "50uosmo")...)
+	cancel()
+	<-done
+
+	assert.NoError(t, err)
+	assert.Equal(t, "52", state.Delegations[DELEGATOR].TotalBalance.String())
+
+	lines := strings.Split(strings.TrimSpace(changes.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var change Change
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &change))
+	assert.Equal(t, int64(13), change.Height)
+	assert.Equal(t, "unbond", change.Event)
+	assert.Equal(t, "102", change.OldTotal.String())
+	assert.Equal(t, "52", change.NewTotal.String())
+}
+
+func TestRunEnded(t *testing.T) {
+	defer func(backoff time.Duration) { ResubscribeBackoff = backoff }(ResubscribeBackoff)
+	ResubscribeBackoff = time.Microsecond
+
+	subscriptions := 0
+	_, err := Run(context.Background(), Options{
+		Subscribe: func(ctx context.Context) (<-chan coreTypes.ResultEvent, error) {
+			subscriptions++
+			events := make(chan coreTypes.ResultEvent)
+			close(events)
+			return events, nil
+		},
+		Seed:    func(ctx context.Context) (*snapshotModule.Snapshot, error) { return seed(10), nil },
+		Changes: &bytes.Buffer{},
+	})
+	assert.Equal(t, failuresModule.Network, failuresModule.ClassOf(err))
+	assert.ErrorContains(t, err, "the event subscription ended")
+	assert.Equal(t, resubscribeAttempts+1, subscriptions)
+
+	_, err = Run(context.Background(), Options{
+		Subscribe: func(ctx context.Context) (<-chan coreTypes.ResultEvent, error) { return nil, errors.New("refused") },
+		Seed:      func(ctx context.Context) (*snapshotModule.Snapshot, error) { return seed(10), nil },
+		Changes:   &bytes.Buffer{},
+	})
+	assert.Equal(t, failuresModule.Network, failuresModule.ClassOf(err))
+
+	_, err = Run(context.Background(), Options{
+		Subscribe: func(ctx context.Context) (<-chan coreTypes.ResultEvent, error) {
+			return make(chan coreTypes.ResultEvent), nil
+		},
+		Seed:    func(ctx context.Context) (*snapshotModule.Snapshot, error) { return nil, errors.New("unavailable") },
+		Changes: &bytes.Buffer{},
+	})
+	assert.ErrorContains(t, err, "unavailable")
+}
@@ -3,16 +3,24 @@ package validators
 import (
 	"context"
 	// "encoding/json"
-	"fmt"
+	"strconv"
+	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 
-	"github.com/cosmos/cosmos-sdk/codec"
+	clientModule "github.com/brianosaurus/challenge1/client"
+	"github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+
+	grpcTypes "github.com/cosmos/cosmos-sdk/types/grpc"
 	queryTypes "github.com/cosmos/cosmos-sdk/types/query"
 	validatorTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
 )
 
+// the validators fetched per query, the same page size as the delegation crawls
+const pageLimit = 10000
+
 // these are so we can mock out the grpc connection for testing
 var (
 	GrpcDial = grpc.Dial
@@ -20,50 +28,55 @@ var (
 )
 
 // get all validators
-func GetValidators(node string) (*validatorTypes.Validators, error) {
-	fmt.Println("Getting validators")
+func GetValidators(ctx context.Context, node string) (*validatorTypes.Validators, error) {
+	return GetValidatorsAtHeight(ctx, node, 0)
+}
+
+// get all validators at the given block height. A height of 0 queries the latest block. The queries stop when ctx
+// is done
+func GetValidatorsAtHeight(ctx context.Context, node string, height int64) (*validatorTypes.Validators, error) {
+	logger := loggingModule.FromContext(ctx)
+	logger.Info("getting validators", "height", height)
+	start := time.Now()
+
 	validators := make(validatorTypes.Validators, 0)
 
-	// Create a connection to the gRPC server.
-	grpcConn, err := GrpcDial(
-		node, // your gRPC server address.
-		grpc.WithTransportCredentials(insecure.NewCredentials()), // The Cosmos SDK doesn't support any transport security mechanism.
-		// This instantiates a general gRPC codec which handles proto bytes. We pass in a nil interface registry
-		// if the request/response types contain interface instead of 'nil' you should pass the application specific codec.
-		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec.NewProtoCodec(nil).GRPCCodec())),
-	)
+	// Create a connection to the gRPC server, or whichever source ctx carries.
+	validatorsClient, closeClient, err := clientModule.Staking(ctx, node, GrpcDial, ValidatorTypesNewQueryClient)
 	if err != nil {
-		return &validators, err
+		return &validators, failures.Wrap(failures.Network, err)
 	}
+	defer closeClient()
 
-	// this is a hack to make testing work. I'm sure there is a better solution but I had to punt due to time
-	if grpcConn != nil {
-		defer grpcConn.Close()
+	if height > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, grpcTypes.GRPCBlockHeightHeader, strconv.FormatInt(height, 10))
 	}
 
-	// This creates a gRPC client to query the x/bank service.
-	validatorsClient := ValidatorTypesNewQueryClient(grpcConn)
 	validatorsResult, err := validatorsClient.Validators(
-		context.Background(),
-		&validatorTypes.QueryValidatorsRequest{Pagination: &queryTypes.PageRequest{Limit: 1000}},
+		ctx,
+		&validatorTypes.QueryValidatorsRequest{Pagination: &queryTypes.PageRequest{Limit: pageLimit}},
 	)
 	if err != nil {
-		return &validators, err
+		return &validators, failures.Wrap(failures.Network, err)
 	}
 
 	validators = append(validators, validatorsResult.GetValidators()...)
+	logger.Debug("fetched validators page", "page", 1, "rows", len(validatorsResult.GetValidators()))
 
-	for validatorsResult.Pagination != nil && validatorsResult.Pagination.NextKey != nil && false {
+	for page := 2; validatorsResult.Pagination != nil && len(validatorsResult.Pagination.NextKey) > 0; page++ {
 		validatorsResult, err = validatorsClient.Validators(
-			context.Background(),
-			&validatorTypes.QueryValidatorsRequest{Pagination: &queryTypes.PageRequest{Limit: 100, Key: validatorsResult.Pagination.NextKey}},
+			ctx,
+			&validatorTypes.QueryValidatorsRequest{Pagination: &queryTypes.PageRequest{Limit: pageLimit, Key: validatorsResult.Pagination.NextKey}},
 		)
 		if err != nil {
-			return &validators, err
+			return &validators, failures.Wrap(failures.PartialData, err)
 		}
 		validators = append(validators, validatorsResult.GetValidators()...)
+		logger.Debug("fetched validators page", "page", page, "rows", len(validatorsResult.GetValidators()))
 	}
 
+	logger.Info("fetched validators", "rows", len(validators), "elapsed", loggingModule.Since(start))
+
 	return &validators, nil
 }
 
@@ -86,7 +86,7 @@ func TestGetValidatorResponses(t *testing.T) {
 	tt = t
 	stubValidatorResponses()
 
-	validators, err := GetValidators("node value not needed")
+	validators, err := GetValidators(context.Background(), "node value not needed")
 	if err != nil {
 		t.Error(err)
 	}
@@ -110,3 +110,47 @@ func TestGetValidatorResponses(t *testing.T) {
 	assert.Equal(t, "5956506193276.000000000000000000", validator.DelegatorShares.String())
 	assert.Equal(t, stakingTypes.Bonded, validator.Status)
 }
+
+// answers two pages of one validator each, recording the page requests
+type pagedQueryClient struct {
+	validatorTypes.QueryClient
+	requests []*query.PageRequest
+}
+
+func (q *pagedQueryClient) Validators(ctx context.Context, in *validatorTypes.QueryValidatorsRequest,
+	opts ...grpc.CallOption,
+) (*validatorTypes.QueryValidatorsResponse, error) {
+	q.requests = append(q.requests, in.Pagination)
+
+	if len(in.Pagination.Key) == 0 {
+		return &validatorTypes.QueryValidatorsResponse{
+			Validators: validatorTypes.Validators{{OperatorAddress: "osmovaloper1first"}},
+			Pagination: &query.PageResponse{NextKey: []byte("second"), Total: 2},
+		}, nil
+	}
+
+	return &validatorTypes.QueryValidatorsResponse{
+		Validators: validatorTypes.Validators{{OperatorAddress: "osmovaloper1second"}},
+		Pagination: &query.PageResponse{},
+	}, nil
+}
+
+func TestGetValidatorsPages(t *testing.T) {
+	client := &pagedQueryClient{}
+	GrpcDial = func(node string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		return nil, nil
+	}
+	ValidatorTypesNewQueryClient = func(conn grpc1.ClientConn) validatorTypes.QueryClient {
+		return client
+	}
+
+	validators, err := GetValidators(context.Background(), "node value not needed")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*validators))
+	assert.Equal(t, "osmovaloper1second", (*validators)[1].OperatorAddress)
+
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, []byte("second"), client.requests[1].Key)
+	assert.Equal(t, uint64(pageLimit), client.requests[0].Limit)
+	assert.Equal(t, uint64(pageLimit), client.requests[1].Limit)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+	watchModule "github.com/brianosaurus/challenge1/watch"
+)
+
+// the watch subcommand keeps taking snapshots and sends an alert to every sink when the stake of a delegator or
+// validator of the watchlist moves by more than its thresholds between two of them
+func watchCommand(flags *flag.FlagSet) func(ctx context.Context) error {
+	var watchlistFile string
+	var interval time.Duration
+	var sinks string
+	options := connectionOptions{}
+
+	addConnectionFlags(flags, &options)
+	flags.StringVar(&watchlistFile, "watchlist", "", "the json watchlist of delegators, validators and thresholds (required)")
+	flags.DurationVar(&interval, "interval", 10*time.Minute, "how long to wait between snapshots")
+	flags.StringVar(&sinks, "sinks", "stdout",
+		"comma separated sinks the alerts are sent to: stdout, file:<path> for json lines or webhook:<url> to post them")
+
+	return func(ctx context.Context) error {
+		if watchlistFile == "" {
+			return failuresModule.Errorf(failuresModule.Config, "watch: -watchlist is required")
+		}
+		if options.height > 0 {
+			return failuresModule.Errorf(failuresModule.Config, "-height can't be used with watch, every snapshot is taken at the latest height")
+		}
+		if interval <= 0 {
+			return failuresModule.Errorf(failuresModule.Config, "-interval must be positive")
+		}
+
+		watchlist, err := watchModule.LoadWatchlist(watchlistFile)
+		if err != nil {
+			return failuresModule.Wrap(failuresModule.Config, err)
+		}
+
+		watchSinks, err := watchModule.ParseSinks(sinks, os.Stdout)
+		if err != nil {
+			return failuresModule.Errorf(failuresModule.Config, "-sinks: %w", err)
+		}
+
+		ctx, err = options.withSource(ctx)
+		if err != nil {
+			return err
+		}
+
+		// watching a few delegators doesn't need every validator crawled
+		var delegators []string
+		if watchlist.DelegatorsOnly() {
+			delegators = watchlist.Delegators
+		}
+
+		loggingModule.FromContext(ctx).Info("starting watch", "node", options.node, "interval", interval,
+			"sinks", sinks)
+
+		watchModule.Run(ctx, watchModule.Options{
+			Watchlist: watchlist,
+			Interval:  interval,
+			Fetch: func(ctx context.Context) (*snapshotModule.Snapshot, error) {
+				return snapshotModule.Fetch(ctx, snapshotModule.Options{Node: options.node, Delegators: delegators})
+			},
+			Sinks: watchSinks,
+		})
+
+		return nil
+	}
+}
@@ -0,0 +1,153 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+// where alerts are sent. The built in sinks are below and callers can plug in their own
+type Sink interface {
+	Send(ctx context.Context, alerts []Alert) error
+}
+
+// writes every alert as a line of json
+type WriterSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	name   string
+}
+
+func NewWriterSink(writer io.Writer, name string) *WriterSink {
+	return &WriterSink{writer: writer, name: name}
+}
+
+func (sink *WriterSink) Send(ctx context.Context, alerts []Alert) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	return writeLines(sink.writer, alerts)
+}
+
+func (sink *WriterSink) String() string {
+	return sink.name
+}
+
+// appends every alert as a line of json to a file, created if it doesn't exist
+type FileSink struct {
+	Path string
+}
+
+func (sink *FileSink) Send(ctx context.Context, alerts []Alert) error {
+	file, err := os.OpenFile(sink.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return failuresModule.Wrap(failuresModule.IO, err)
+	}
+
+	if err := writeLines(file, alerts); err != nil {
+		file.Close()
+		return err
+	}
+
+	return failuresModule.Wrap(failuresModule.IO, file.Close())
+}
+
+func (sink *FileSink) String() string {
+	return "file:" + sink.Path
+}
+
+func writeLines(writer io.Writer, alerts []Alert) error {
+	encoder := json.NewEncoder(writer)
+
+	for _, alert := range alerts {
+		if err := encoder.Encode(alert); err != nil {
+			return failuresModule.Wrap(failuresModule.IO, err)
+		}
+	}
+
+	return nil
+}
+
+// the body a webhook is posted
+type WebhookPayload struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+// posts the alerts of every comparison to a url as a WebhookPayload. Any status but 2xx is a failure
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (sink *WebhookSink) Send(ctx context.Context, alerts []Alert) error {
+	body, err := json.Marshal(WebhookPayload{Alerts: alerts})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return failuresModule.Wrap(failuresModule.Config, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := sink.Client.Do(request)
+	if err != nil {
+		return failuresModule.Wrap(failuresModule.Network, err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return failuresModule.Errorf(failuresModule.Network, "webhook %s answered %s", sink.URL, response.Status)
+	}
+
+	return nil
+}
+
+func (sink *WebhookSink) String() string {
+	return "webhook:" + sink.URL
+}
+
+// parses a comma separated list of sinks: stdout, file:<path> or webhook:<url>. stdout is the writer stdout stands
+// for
+func ParseSinks(value string, stdout io.Writer) ([]Sink, error) {
+	sinks := make([]Sink, 0)
+
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		kind, target, _ := strings.Cut(spec, ":")
+
+		switch {
+		case spec == "":
+			continue
+		case spec == "stdout":
+			sinks = append(sinks, NewWriterSink(stdout, "stdout"))
+		case kind == "file" && target != "":
+			sinks = append(sinks, &FileSink{Path: target})
+		case kind == "webhook" && (strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")):
+			sinks = append(sinks, NewWebhookSink(target))
+		default:
+			return nil, fmt.Errorf("invalid sink %q, expected stdout, file:<path> or webhook:<url>", spec)
+		}
+	}
+
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no sinks")
+	}
+
+	return sinks, nil
+}
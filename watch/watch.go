@@ -0,0 +1,324 @@
+// Package watch compares consecutive snapshots for the delegators and validators of a watchlist and sends an alert
+// to every sink when their stake moves by more than the watchlist's thresholds
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	big "math/big"
+	"os"
+	"sort"
+	"time"
+
+	diffModule "github.com/brianosaurus/challenge1/diff"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	loggingModule "github.com/brianosaurus/challenge1/logging"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+)
+
+// why an alert was raised
+const (
+	ReasonDelegator    = "delegator"
+	ReasonWhale        = "whale"
+	ReasonTopDelegator = "top_delegator"
+)
+
+// what is watched and how large a change must be to be alerted on. With no threshold every change is alerted on,
+// with both either is enough
+type Watchlist struct {
+	// delegators whose total stake is watched
+	Delegators []string `json:"delegators"`
+	// validators whose delegators' stake with them is watched
+	Validators []string `json:"validators"`
+	// only watch the largest delegators of each validator, in either snapshot. 0 watches all of them
+	TopDelegators int `json:"top_delegators"`
+	// also watch every delegator with at least this much total stake in either snapshot
+	Whale *big.Int `json:"whale"`
+	// the smallest change of stake alerted on, in the base denom
+	MinChange *big.Int `json:"min_change"`
+	// the smallest change alerted on as a share of the old stake, e.g. 0.1 for 10%
+	MinChangeShare float64 `json:"min_change_share"`
+}
+
+// a change of stake worth telling someone about
+type Alert struct {
+	Reason    string `json:"reason"`
+	Delegator string `json:"delegator"`
+	// set for the stake of a delegator with a watched validator
+	Validator string   `json:"validator,omitempty"`
+	Status    string   `json:"status"`
+	Old       *big.Int `json:"old"`
+	New       *big.Int `json:"new"`
+	Change    *big.Int `json:"change"`
+	// the block heights of the snapshots compared, 0 if the node didn't say
+	OldHeight int64     `json:"old_height"`
+	Height    int64     `json:"height"`
+	Time      time.Time `json:"time"`
+}
+
+// reads a json watchlist file
+func LoadWatchlist(path string) (*Watchlist, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	watchlist := &Watchlist{}
+	if err := json.Unmarshal(contents, watchlist); err != nil {
+		return nil, fmt.Errorf("invalid watchlist %s: %w", path, err)
+	}
+
+	if err := watchlist.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid watchlist %s: %w", path, err)
+	}
+
+	return watchlist, nil
+}
+
+// checks that something is watched and the thresholds make sense
+func (watchlist *Watchlist) Validate() error {
+	switch {
+	case len(watchlist.Delegators) == 0 && len(watchlist.Validators) == 0 && watchlist.Whale == nil:
+		return fmt.Errorf("nothing to watch, list delegators, validators or a whale threshold")
+	case watchlist.TopDelegators < 0:
+		return fmt.Errorf("top_delegators can't be negative")
+	case watchlist.Whale != nil && watchlist.Whale.Sign() <= 0:
+		return fmt.Errorf("whale must be positive")
+	case watchlist.MinChange != nil && watchlist.MinChange.Sign() < 0:
+		return fmt.Errorf("min_change can't be negative")
+	case watchlist.MinChangeShare < 0:
+		return fmt.Errorf("min_change_share can't be negative")
+	default:
+		return nil
+	}
+}
+
+// true if only listed delegators are watched, so fetching just their delegations is enough
+func (watchlist *Watchlist) DelegatorsOnly() bool {
+	return len(watchlist.Validators) == 0 && watchlist.Whale == nil
+}
+
+// the alerts for the watched stake that changed between two snapshots of the same chain, largest change first.
+// Delegator and whale alerts come before the validators', which are in the order of the watchlist
+func Compare(watchlist *Watchlist, old *snapshotModule.Snapshot, new *snapshotModule.Snapshot) []Alert {
+	alerts := make([]Alert, 0)
+	alert := func(reason string, validator string, change diffModule.Change) {
+		if !watchlist.exceeds(change) {
+			return
+		}
+
+		alerts = append(alerts, Alert{
+			Reason:    reason,
+			Delegator: change.Delegator,
+			Validator: validator,
+			Status:    change.Status,
+			Old:       change.Old,
+			New:       change.New,
+			Change:    change.Change,
+			OldHeight: height(old),
+			Height:    height(new),
+			Time:      new.FetchedAt,
+		})
+	}
+
+	oldTotals, newTotals := totals(old), totals(new)
+	reasons := make(map[string]string)
+
+	for _, delegator := range watchlist.Delegators {
+		reasons[delegator] = ReasonDelegator
+	}
+
+	if watchlist.Whale != nil {
+		for _, amounts := range []map[string]*big.Int{oldTotals, newTotals} {
+			for delegator, amount := range amounts {
+				if _, ok := reasons[delegator]; !ok && amount.Cmp(watchlist.Whale) >= 0 {
+					reasons[delegator] = ReasonWhale
+				}
+			}
+		}
+	}
+
+	for _, change := range diffModule.Diff(pick(oldTotals, reasons), pick(newTotals, reasons)) {
+		alert(reasons[change.Delegator], "", change)
+	}
+
+	oldStakes, newStakes := validatorStakes(old), validatorStakes(new)
+
+	for _, validator := range watchlist.Validators {
+		watched := make(map[string]string)
+		for _, stakes := range []map[string]*big.Int{oldStakes[validator], newStakes[validator]} {
+			for _, delegator := range top(stakes, watchlist.TopDelegators) {
+				watched[delegator] = ReasonTopDelegator
+			}
+		}
+
+		for _, change := range diffModule.Diff(pick(oldStakes[validator], watched), pick(newStakes[validator], watched)) {
+			alert(ReasonTopDelegator, validator, change)
+		}
+	}
+
+	return alerts
+}
+
+// true if a change reaches either threshold, or there are none
+func (watchlist *Watchlist) exceeds(change diffModule.Change) bool {
+	if watchlist.MinChange == nil && watchlist.MinChangeShare == 0 {
+		return true
+	}
+
+	size := new(big.Int).Abs(change.Change)
+	if watchlist.MinChange != nil && size.Cmp(watchlist.MinChange) >= 0 {
+		return true
+	}
+
+	if watchlist.MinChangeShare > 0 {
+		// a new delegator changed by all of its stake
+		if change.Old.Sign() == 0 {
+			return true
+		}
+
+		share, _ := new(big.Float).Quo(new(big.Float).SetInt(size), new(big.Float).SetInt(change.Old)).Float64()
+		return share >= watchlist.MinChangeShare
+	}
+
+	return false
+}
+
+// the block height of a snapshot, the requested one if the node didn't say
+func height(snapshot *snapshotModule.Snapshot) int64 {
+	if snapshot.BlockHeight > 0 {
+		return snapshot.BlockHeight
+	}
+
+	return snapshot.Height
+}
+
+// the total stake of every delegator
+func totals(snapshot *snapshotModule.Snapshot) map[string]*big.Int {
+	amounts := make(map[string]*big.Int)
+	if snapshot.Delegations == nil {
+		return amounts
+	}
+
+	for delegator, delegationWithTotalBalance := range *snapshot.Delegations {
+		amounts[delegator] = delegationWithTotalBalance.TotalBalance
+	}
+
+	return amounts
+}
+
+// the stake of every delegator with each validator, by validator
+func validatorStakes(snapshot *snapshotModule.Snapshot) map[string]map[string]*big.Int {
+	stakes := make(map[string]map[string]*big.Int)
+	if snapshot.DelegationResponses == nil {
+		return stakes
+	}
+
+	for _, delegationResponse := range *snapshot.DelegationResponses {
+		delegation := delegationResponse.Delegation
+		if stakes[delegation.ValidatorAddress] == nil {
+			stakes[delegation.ValidatorAddress] = make(map[string]*big.Int)
+		}
+
+		amount, ok := stakes[delegation.ValidatorAddress][delegation.DelegatorAddress]
+		if !ok {
+			amount = new(big.Int)
+			stakes[delegation.ValidatorAddress][delegation.DelegatorAddress] = amount
+		}
+		amount.Add(amount, delegationResponse.Balance.Amount.BigInt())
+	}
+
+	return stakes
+}
+
+// the amounts of the delegators in keys
+func pick(amounts map[string]*big.Int, keys map[string]string) map[string]*big.Int {
+	picked := make(map[string]*big.Int)
+
+	for delegator, amount := range amounts {
+		if _, ok := keys[delegator]; ok {
+			picked[delegator] = amount
+		}
+	}
+
+	return picked
+}
+
+// the n delegators with the most stake, all of them if n is 0. Ties are broken by address
+func top(stakes map[string]*big.Int, n int) []string {
+	delegators := make([]string, 0, len(stakes))
+	for delegator := range stakes {
+		delegators = append(delegators, delegator)
+	}
+
+	sort.Slice(delegators, func(i, j int) bool {
+		if cmp := stakes[delegators[i]].Cmp(stakes[delegators[j]]); cmp != 0 {
+			return cmp > 0
+		}
+		return delegators[i] < delegators[j]
+	})
+
+	if n > 0 && len(delegators) > n {
+		delegators = delegators[:n]
+	}
+
+	return delegators
+}
+
+// the options of a watch
+type Options struct {
+	Watchlist *Watchlist
+	// how long to wait between snapshots
+	Interval time.Duration
+	// takes a snapshot with the delegations
+	Fetch func(ctx context.Context) (*snapshotModule.Snapshot, error)
+	// where the alerts are sent
+	Sinks []Sink
+}
+
+// takes a snapshot every interval and sends the alerts between each and the one before to every sink, until ctx is
+// done. A failed snapshot is logged and the next one is compared with the last good one. A failed sink is logged and
+// the others still get the alerts
+func Run(ctx context.Context, options Options) {
+	logger := loggingModule.FromContext(ctx)
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+
+	var last *snapshotModule.Snapshot
+
+	for {
+		start := time.Now()
+		snapshot, err := options.Fetch(ctx)
+
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return
+		case err != nil:
+			logger.Error("snapshot failed", "class", failuresModule.ClassOf(err).String(), "err", err)
+		case last == nil:
+			logger.Info("watching", "block_height", height(snapshot), "elapsed", loggingModule.Since(start))
+			last = snapshot
+		default:
+			alerts := Compare(options.Watchlist, last, snapshot)
+			logger.Info("compared snapshots", "old_height", height(last), "block_height", height(snapshot),
+				"alerts", len(alerts), "elapsed", loggingModule.Since(start))
+
+			if len(alerts) > 0 {
+				for _, sink := range options.Sinks {
+					if err := sink.Send(ctx, alerts); err != nil {
+						logger.Error("sending alerts failed", "sink", fmt.Sprint(sink), "err", err)
+					}
+				}
+			}
+
+			last = snapshot
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	big "math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	delegationsModule "github.com/brianosaurus/challenge1/delegations"
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+	snapshotModule "github.com/brianosaurus/challenge1/snapshot"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	delegationTypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+)
+
+const (
+	VALIDATOR       = "osmovaloper1z89utvygweg5l56fsk8ak7t6hh88fd0axx2fya"
+	OTHER_VALIDATOR = "osmovaloper1qqrtqudvxhcan3fe2r98834ge8r8nffuqnrl2v"
+)
+
+type delegation struct {
+	delegator string
+	validator string
+	amount    int64
+}
+
+func snapshotOf(height int64, delegations ...delegation) *snapshotModule.Snapshot {
+	delegationResponses := delegationTypes.DelegationResponses{}
+	for _, d := range delegations {
+		delegationResponses = append(delegationResponses, delegationTypes.DelegationResponse{
+			Delegation: delegationTypes.Delegation{DelegatorAddress: d.delegator, ValidatorAddress: d.validator,
+				Shares: sdk.NewDec(d.amount)},
+			Balance: sdk.NewInt64Coin("uosmo", d.amount),
+		})
+	}
+
+	return &snapshotModule.Snapshot{
+		BlockHeight:         height,
+		FetchedAt:           time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC),
+		DelegationResponses: &delegationResponses,
+		Delegations:         delegationsModule.GetDelegationsWithTotalBalance(&delegationResponses),
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old := snapshotOf(100,
+		delegation{"alice", VALIDATOR, 100},
+		delegation{"bob", VALIDATOR, 1000},
+		delegation{"carol", OTHER_VALIDATOR, 10},
+		delegation{"dave", VALIDATOR, 5},
+	)
+	new := snapshotOf(110,
+		delegation{"alice", VALIDATOR, 150},
+		delegation{"bob", VALIDATOR, 400},
+		delegation{"bob", OTHER_VALIDATOR, 600},
+		delegation{"carol", OTHER_VALIDATOR, 20},
+		delegation{"dave", VALIDATOR, 6},
+	)
+
+	alerts := Compare(&Watchlist{Delegators: []string{"alice", "carol"}}, old, new)
+	assert.Equal(t, 2, len(alerts))
+	assert.Equal(t, "alice", alerts[0].Delegator)
+	assert.Equal(t, ReasonDelegator, alerts[0].Reason)
+	assert.Equal(t, "50", alerts[0].Change.String())
+	assert.Equal(t, int64(100), alerts[0].OldHeight)
+	assert.Equal(t, int64(110), alerts[0].Height)
+	assert.Equal(t, "carol", alerts[1].Delegator)
+
+	// bob's total didn't change, the whale threshold only finds him
+	alerts = Compare(&Watchlist{Whale: big.NewInt(1000)}, old, new)
+	assert.Equal(t, 0, len(alerts))
+
+	alerts = Compare(&Watchlist{Validators: []string{VALIDATOR}, TopDelegators: 2}, old, new)
+	assert.Equal(t, 2, len(alerts))
+	assert.Equal(t, ReasonTopDelegator, alerts[0].Reason)
+	assert.Equal(t, VALIDATOR, alerts[0].Validator)
+	assert.Equal(t, "bob", alerts[0].Delegator)
+	assert.Equal(t, "-600", alerts[0].Change.String())
+	assert.Equal(t, "alice", alerts[1].Delegator)
+
+	alerts = Compare(&Watchlist{Validators: []string{OTHER_VALIDATOR}}, old, new)
+	assert.Equal(t, 2, len(alerts))
+	assert.Equal(t, "bob", alerts[0].Delegator)
+	assert.Equal(t, "added", alerts[0].Status)
+}
+
+func TestCompareThresholds(t *testing.T) {
+	old := snapshotOf(0, delegation{"alice", VALIDATOR, 100}, delegation{"bob", VALIDATOR, 10000})
+	new := snapshotOf(0, delegation{"alice", VALIDATOR, 150}, delegation{"bob", VALIDATOR, 10200},
+		delegation{"carol", VALIDATOR, 1})
+
+	delegators := []string{"alice", "bob", "carol"}
+	reported := func(watchlist *Watchlist) []string {
+		watchlist.Delegators = delegators
+		names := make([]string, 0)
+		for _, alert := range Compare(watchlist, old, new) {
+			names = append(names, alert.Delegator)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"bob", "alice", "carol"}, reported(&Watchlist{}))
+	assert.Equal(t, []string{"bob"}, reported(&Watchlist{MinChange: big.NewInt(100)}))
+	assert.Equal(t, []string{"alice", "carol"}, reported(&Watchlist{MinChangeShare: 0.1}))
+	assert.Equal(t, []string{"bob", "alice", "carol"},
+		reported(&Watchlist{MinChange: big.NewInt(100), MinChangeShare: 0.1}))
+}
+
+func TestLoadWatchlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchlist.json")
+	os.WriteFile(path, []byte(`{"validators": ["`+VALIDATOR+`"], "top_delegators": 20,
+		"whale": 100000000000000000000, "min_change_share": 0.1}`), 0o644)
+
+	watchlist, err := LoadWatchlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{VALIDATOR}, watchlist.Validators)
+	assert.Equal(t, 20, watchlist.TopDelegators)
+	assert.Equal(t, "100000000000000000000", watchlist.Whale.String())
+	assert.False(t, watchlist.DelegatorsOnly())
+	assert.True(t, (&Watchlist{Delegators: []string{"alice"}}).DelegatorsOnly())
+
+	os.WriteFile(path, []byte(`{"min_change": 10}`), 0o644)
+	_, err = LoadWatchlist(path)
+	assert.ErrorContains(t, err, "nothing to watch")
+
+	assert.Error(t, (&Watchlist{Delegators: []string{"alice"}, TopDelegators: -1}).Validate())
+	assert.Error(t, (&Watchlist{Whale: big.NewInt(0)}).Validate())
+	assert.Error(t, (&Watchlist{Delegators: []string{"alice"}, MinChangeShare: -0.5}).Validate())
+}
+
+func TestParseSinks(t *testing.T) {
+	sinks, err := ParseSinks("stdout, file:/tmp/alerts.json,webhook:https://hooks.example.com/stake", os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, len(sinks))
+	assert.Equal(t, "stdout", sinks[0].(*WriterSink).String())
+	assert.Equal(t, "/tmp/alerts.json", sinks[1].(*FileSink).Path)
+	assert.Equal(t, "https://hooks.example.com/stake", sinks[2].(*WebhookSink).URL)
+
+	for _, value := range []string{"", "stderr", "file:", "webhook:hooks.example.com"} {
+		_, err := ParseSinks(value, os.Stdout)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received WebhookPayload
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		contentType = request.Header.Get("Content-Type")
+		if err := json.NewDecoder(request.Body).Decode(&received); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	alerts := Compare(&Watchlist{Delegators: []string{"alice"}},
+		snapshotOf(1, delegation{"alice", VALIDATOR, 1}), snapshotOf(2, delegation{"alice", VALIDATOR, 3}))
+
+	err := NewWebhookSink(server.URL).Send(context.Background(), alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, 1, len(received.Alerts))
+	assert.Equal(t, "alice", received.Alerts[0].Delegator)
+	assert.Equal(t, "2", received.Alerts[0].Change.String())
+
+	failing := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	err = NewWebhookSink(failing.URL).Send(context.Background(), alerts)
+	assert.ErrorContains(t, err, "500")
+	assert.Equal(t, failuresModule.Network, failuresModule.ClassOf(err))
+}
+
+func TestFileSink(t *testing.T) {
+	sink := &FileSink{Path: filepath.Join(t.TempDir(), "alerts.json")}
+	alerts := []Alert{{Reason: ReasonWhale, Delegator: "alice"}, {Reason: ReasonWhale, Delegator: "bob"}}
+
+	assert.NoError(t, sink.Send(context.Background(), alerts))
+	assert.NoError(t, sink.Send(context.Background(), alerts[:1]))
+
+	contents, err := os.ReadFile(sink.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Contains(t, lines[1], `"delegator":"bob"`)
+}
+
+type failingSink struct{}
+
+func (failingSink) Send(ctx context.Context, alerts []Alert) error {
+	return errors.New("unreachable")
+}
+
+func TestRun(t *testing.T) {
+	snapshots := []*snapshotModule.Snapshot{
+		snapshotOf(1, delegation{"alice", VALIDATOR, 1}),
+		nil,
+		snapshotOf(3, delegation{"alice", VALIDATOR, 5}),
+		snapshotOf(4, delegation{"alice", VALIDATOR, 5}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	fetches := 0
+	var output bytes.Buffer
+
+	Run(ctx, Options{
+		Watchlist: &Watchlist{Delegators: []string{"alice"}},
+		Interval:  time.Millisecond,
+		Fetch: func(ctx context.Context) (*snapshotModule.Snapshot, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			snapshot := snapshots[fetches]
+			fetches++
+			if fetches == len(snapshots) {
+				cancel()
+			}
+			if snapshot == nil {
+				return nil, errors.New("node unavailable")
+			}
+			return snapshot, nil
+		},
+		Sinks: []Sink{failingSink{}, NewWriterSink(&output, "buffer")},
+	})
+
+	assert.Equal(t, len(snapshots), fetches)
+
+	// the failed snapshot is skipped and the unchanged last one alerts nothing
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Equal(t, 1, len(lines))
+
+	var alert Alert
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &alert))
+	assert.Equal(t, int64(1), alert.OldHeight)
+	assert.Equal(t, int64(3), alert.Height)
+	assert.Equal(t, "4", alert.Change.String())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	failuresModule "github.com/brianosaurus/challenge1/failures"
+)
+
+func TestWatchCommand(t *testing.T) {
+	dir := t.TempDir()
+	watchlistFile := filepath.Join(dir, "watchlist.json")
+	os.WriteFile(watchlistFile, []byte(`{"delegators": ["`+SECOND_DELEGATOR+`"], "min_change": 1}`), 0o644)
+	alertsFile := filepath.Join(dir, "alerts.jsonl")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	fixture, err := live(t, ctx, &stubSource{}, "watch", "-watchlist", watchlistFile, "-interval", "10ms",
+		"-sinks", "file:"+alertsFile)
+	assert.Nil(t, err)
+
+	assert.Nil(t, replay(fixture, "watch", "-timeout", "300ms", "-watchlist", watchlistFile, "-interval", "10ms",
+		"-sinks", "file:"+alertsFile))
+
+	// the stake never moves so nothing is alerted
+	alerts, _ := os.ReadFile(alertsFile)
+	assert.Equal(t, "", string(alerts))
+
+	err = replay(fixture, "watch")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+	err = replay(fixture, "watch", "-watchlist", watchlistFile, "-sinks", "pager:duty")
+	assert.Equal(t, failuresModule.Config, failuresModule.ClassOf(err))
+}